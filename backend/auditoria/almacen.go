@@ -0,0 +1,284 @@
+// backend/auditoria/almacen.go
+package auditoria
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+   Almacén de archivos de auditoría
+   --------------------------------
+   AUDITORIA_ARCHIVO indica dónde guardar los archivos de retención. Sin
+   ella no hay retención: nada se archiva ni se poda.
+
+     /ruta o file:///ruta             disco local
+     s3://bucket/prefijo               almacenamiento compatible con S3
+
+   Para S3 se usan AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION
+   (us-east-1 por defecto) y AUDITORIA_S3_ENDPOINT (p. ej. http://minio:9000;
+   por defecto el de AWS). Las peticiones van firmadas con SigV4 en estilo
+   de ruta, que aceptan tanto AWS como MinIO o Ceph.
+*/
+
+// almacenArchivos guarda un archivo y devuelve su ubicación (file:// o s3://).
+type almacenArchivos interface {
+	guardar(ctx context.Context, nombre string, r io.Reader, tam int64) (string, error)
+}
+
+func nuevoAlmacen(destino string) (almacenArchivos, error) {
+	if destino == "" {
+		return nil, fmt.Errorf("destino de archivo vacío")
+	}
+	u, err := url.Parse(destino)
+	if err != nil {
+		return nil, fmt.Errorf("destino de archivo inválido: %w", err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		dir := destino
+		if u.Scheme == "file" {
+			dir = u.Path
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("no se pudo crear %s: %w", dir, err)
+		}
+		return almacenLocal{dir}, nil
+	case "s3":
+		c, err := clienteS3DesdeEntorno()
+		if err != nil {
+			return nil, err
+		}
+		return almacenS3{c: c, bucket: u.Host, prefijo: strings.Trim(u.Path, "/")}, nil
+	}
+	return nil, fmt.Errorf("destino de archivo inválido %q: use una ruta, file:// o s3://", destino)
+}
+
+// abrirArchivo lee un archivo por su ubicación, sea cual sea el almacén actual.
+func abrirArchivo(ctx context.Context, ubicacion string) (io.ReadCloser, error) {
+	u, err := url.Parse(ubicacion)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return os.Open(u.Path)
+	case "s3":
+		c, err := clienteS3DesdeEntorno()
+		if err != nil {
+			return nil, err
+		}
+		return c.obtener(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+	}
+	return nil, fmt.Errorf("ubicación de archivo desconocida: %q", ubicacion)
+}
+
+type almacenLocal struct{ dir string }
+
+func (a almacenLocal) guardar(_ context.Context, nombre string, r io.Reader, _ int64) (string, error) {
+	ruta := filepath.Join(a.dir, filepath.FromSlash(nombre))
+	if err := os.MkdirAll(filepath.Dir(ruta), 0700); err != nil {
+		return "", err
+	}
+	// Se escribe aparte y se renombra: nunca queda un archivo a medias
+	tmp, err := os.CreateTemp(filepath.Dir(ruta), ".parcial-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), ruta); err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(ruta)}).String(), nil
+}
+
+type almacenS3 struct {
+	c       *clienteS3
+	bucket  string
+	prefijo string
+}
+
+func (a almacenS3) guardar(ctx context.Context, nombre string, r io.Reader, tam int64) (string, error) {
+	clave := nombre
+	if a.prefijo != "" {
+		clave = a.prefijo + "/" + nombre
+	}
+	if err := a.c.poner(ctx, a.bucket, clave, r, tam); err != nil {
+		return "", err
+	}
+	return "s3://" + a.bucket + "/" + clave, nil
+}
+
+// clienteS3 es un cliente mínimo (PUT y GET de objetos) con firma SigV4.
+type clienteS3 struct {
+	endpoint *url.URL
+	region   string
+	clave    string
+	secreto  string
+	http     *http.Client
+}
+
+func clienteS3DesdeEntorno() (*clienteS3, error) {
+	c := &clienteS3{
+		region:  os.Getenv("AWS_REGION"),
+		clave:   os.Getenv("AWS_ACCESS_KEY_ID"),
+		secreto: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		http:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if c.clave == "" || c.secreto == "" {
+		return nil, fmt.Errorf("faltan AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY para el archivo en S3")
+	}
+	endpoint := os.Getenv("AUDITORIA_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("AUDITORIA_S3_ENDPOINT inválido: %q", endpoint)
+	}
+	c.endpoint = u
+	return c, nil
+}
+
+func (c *clienteS3) peticion(ctx context.Context, metodo, bucket, clave string, cuerpo io.Reader, tam int64) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = "/" + bucket + "/" + clave
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, metodo, u.String(), cuerpo)
+	if err != nil {
+		return nil, err
+	}
+	if cuerpo != nil {
+		req.ContentLength = tam
+	}
+	// El cuerpo no se firma (UNSIGNED-PAYLOAD): así se sube en streaming
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	firmarSigV4(req, c.clave, c.secreto, c.region, "UNSIGNED-PAYLOAD", time.Now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		detalle, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s: %s %s", metodo, clave, resp.Status, strings.TrimSpace(string(detalle)))
+	}
+	return resp, nil
+}
+
+func (c *clienteS3) poner(ctx context.Context, bucket, clave string, r io.Reader, tam int64) error {
+	resp, err := c.peticion(ctx, http.MethodPut, bucket, clave, r, tam)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *clienteS3) obtener(ctx context.Context, bucket, clave string) (io.ReadCloser, error) {
+	resp, err := c.peticion(ctx, http.MethodGet, bucket, clave, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// firmarSigV4 añade x-amz-date y Authorization (AWS Signature Version 4)
+// firmando host y todas las cabeceras presentes.
+func firmarSigV4(req *http.Request, clave, secreto, region, hashCuerpo string, t time.Time) {
+	fecha := t.UTC().Format("20060102T150405Z")
+	dia := fecha[:8]
+	req.Header.Set("X-Amz-Date", fecha)
+
+	cabeceras := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		cabeceras[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	nombres := make([]string, 0, len(cabeceras))
+	for k := range cabeceras {
+		nombres = append(nombres, k)
+	}
+	sort.Strings(nombres)
+	var canonicas strings.Builder
+	for _, k := range nombres {
+		canonicas.WriteString(k + ":" + cabeceras[k] + "\n")
+	}
+	firmadas := strings.Join(nombres, ";")
+
+	q := req.URL.Query()
+	params := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, codificarURIS3(k, true)+"="+codificarURIS3(v, true))
+		}
+	}
+	sort.Strings(params)
+
+	peticionCanonica := strings.Join([]string{
+		req.Method, codificarURIS3(req.URL.Path, false), strings.Join(params, "&"),
+		canonicas.String(), firmadas, hashCuerpo,
+	}, "\n")
+	ambito := dia + "/" + region + "/s3/aws4_request"
+	h := sha256.Sum256([]byte(peticionCanonica))
+	aFirmar := "AWS4-HMAC-SHA256\n" + fecha + "\n" + ambito + "\n" + hex.EncodeToString(h[:])
+
+	k := hmacSHA256([]byte("AWS4"+secreto), dia)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	firma := hex.EncodeToString(hmacSHA256(k, aFirmar))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+clave+"/"+ambito+
+		", SignedHeaders="+firmadas+", Signature="+firma)
+}
+
+func hmacSHA256(clave []byte, datos string) []byte {
+	m := hmac.New(sha256.New, clave)
+	m.Write([]byte(datos))
+	return m.Sum(nil)
+}
+
+// codificarURIS3 codifica como exige SigV4: todo salvo A-Z a-z 0-9 - _ . ~
+// (y "/" en las rutas).
+func codificarURIS3(s string, barra bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !barra:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
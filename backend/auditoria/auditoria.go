@@ -0,0 +1,273 @@
+// backend/auditoria/auditoria.go
+package auditoria
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+/*
+   Auditoría unificada
+   -------------------
+   Todo registro de auditoría pasa por aquí: un Evento tipado que se escribe
+   en la tabla de su flujo (auditoria_eventos, accesos, auditoria_politicas o
+   auditoria_login) con las mismas columnas comunes. Registrar encola el
+   evento para un grupo de escritores en segundo plano; si la cola está llena
+   el llamador espera (contrapresión) y, pasado esperaCola, lo escribe él
+   mismo: un evento de auditoría nunca se descarta. Los accesos esperan a
+   quedar escritos porque las cuotas y las condiciones del titular los
+   cuentan. RegistrarEnTx escribe dentro de una transacción del llamador
+   cuando la operación no debe confirmarse sin su auditoría.
+*/
+
+// Flujo es la tabla de auditoría de destino.
+type Flujo string
+
+const (
+	FlujoEventos   Flujo = "auditoria_eventos"
+	FlujoAccesos   Flujo = "accesos"
+	FlujoPoliticas Flujo = "auditoria_politicas"
+	FlujoLogin     Flujo = "auditoria_login"
+)
+
+// Evento es un registro de auditoría.
+type Evento struct {
+	Flujo        Flujo // FlujoEventos si se omite
+	IDActor      int
+	Rol          int    // si es 0 se toma de usuarios_roles
+	Accion       string // p. ej. 'REIDENTIFICAR'; en políticas, la operación
+	Recurso      string // tabla afectada
+	IDRecurso    int
+	Exito        bool
+	CodigoMotivo string // obligatorio si !Exito: uno del catálogo (motivos.go)
+	Descripcion  string // en accesos, el motivo
+	Error        string
+	IDSolicitud  string // por defecto, el de la solicitud HTTP del contexto
+	IP           string
+	Fecha        time.Time
+	Acceso       *Acceso // sólo FlujoAccesos
+}
+
+// Acceso son las columnas propias de una fila de accesos.
+type Acceso struct {
+	IDTitular        int
+	IDConsentimiento int
+	Consentimientos  []int                     // todos los consentimientos que autorizan el acceso
+	Niveles          map[string]string         // nivel aplicado por campo entregado
+	Linaje           map[string]CampoDivulgado // quién autorizó cada campo; por defecto, sólo los Niveles
+	Finalidad        string
+	Justificacion    string
+	TipoAcceso       string // "ordinario" (por defecto) o "emergencia"
+	IDEmergencia     int
+}
+
+// CampoDivulgado es el linaje de un campo entregado: con qué nivel y bajo
+// qué versión de qué política (sin política en los accesos de emergencia).
+type CampoDivulgado struct {
+	Nivel            string `json:"nivel"`
+	IDPolitica       int    `json:"id_politica,omitempty"`
+	Version          int    `json:"version,omitempty"`
+	IDConsentimiento int    `json:"id_consentimiento,omitempty"`
+}
+
+const (
+	capacidadCola  = 1024
+	escritores     = 2
+	esperaCola     = 2 * time.Second
+	plazoEscritura = 5 * time.Second
+)
+
+type pendiente struct {
+	ev    Evento
+	hecho chan struct{}
+}
+
+var (
+	cola    chan pendiente
+	enLinea atomic.Int64 // eventos escritos por el llamador por cola llena
+
+	// muCola protege cola frente a Detener: Registrar encola con el cerrojo
+	// de lectura y Detener cierra la cola con el de escritura
+	muCola      sync.RWMutex
+	escribiendo sync.WaitGroup
+)
+
+// Iniciar arranca los escritores en segundo plano. Sin Iniciar (comandos,
+// pruebas) cada evento se escribe en el momento.
+func Iniciar() {
+	cola = make(chan pendiente, capacidadCola)
+	for i := 0; i < escritores; i++ {
+		escribiendo.Add(1)
+		go func(c chan pendiente) {
+			defer escribiendo.Done()
+			for p := range c {
+				escribirYAvisar(p.ev)
+				if p.hecho != nil {
+					close(p.hecho)
+				}
+			}
+		}(cola)
+	}
+}
+
+// Detener cierra la cola y espera a que los escritores vacíen lo pendiente.
+// Se llama al apagar el servidor, después de http.Server.Shutdown; los
+// eventos que lleguen luego se escriben en el momento.
+func Detener() {
+	muCola.Lock()
+	if cola != nil {
+		close(cola)
+		cola = nil
+	}
+	muCola.Unlock()
+	escribiendo.Wait()
+}
+
+// Registrar encola un evento de auditoría.
+func Registrar(ctx context.Context, ev Evento) {
+	completar(ctx, &ev)
+	muCola.RLock()
+	if cola == nil {
+		muCola.RUnlock()
+		escribirYAvisar(ev)
+		return
+	}
+
+	p := pendiente{ev: ev}
+	if ev.Flujo == FlujoAccesos {
+		p.hecho = make(chan struct{})
+	}
+	select {
+	case cola <- p:
+	default:
+		t := time.NewTimer(esperaCola)
+		select {
+		case cola <- p:
+			t.Stop()
+		case <-t.C:
+			muCola.RUnlock()
+			if n := enLinea.Add(1); n%100 == 1 {
+				log.Printf("Auditoría: cola llena, %d eventos escritos en línea", n)
+			}
+			escribirYAvisar(ev)
+			return
+		}
+	}
+	muCola.RUnlock()
+	if p.hecho != nil {
+		<-p.hecho
+	}
+}
+
+// RegistrarEnTx escribe el evento dentro de la transacción del llamador.
+func RegistrarEnTx(ctx context.Context, tx pgx.Tx, ev Evento) error {
+	completar(ctx, &ev)
+	return escribir(ctx, tx, ev)
+}
+
+func completar(ctx context.Context, ev *Evento) {
+	if ev.Flujo == "" {
+		ev.Flujo = FlujoEventos
+	}
+	if ev.Fecha.IsZero() {
+		ev.Fecha = time.Now()
+	}
+	normalizarMotivo(ev)
+	if s, ok := ctx.Value(claveSolicitud).(solicitud); ok {
+		if ev.IDSolicitud == "" {
+			ev.IDSolicitud = s.id
+		}
+		if ev.IP == "" {
+			ev.IP = s.ip
+		}
+	}
+}
+
+func escribirYAvisar(ev Evento) {
+	ctx, cancel := context.WithTimeout(context.Background(), plazoEscritura)
+	defer cancel()
+	if err := escribir(ctx, db.Pool, ev); err != nil {
+		log.Printf("Error auditando %s/%s (usuario=%d): %v", ev.Flujo, ev.Accion, ev.IDActor, err)
+	}
+}
+
+type ejecutor interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// rolActor resuelve el rol del actor si el evento no lo trae ($1 actor, $2 rol).
+const rolActor = `COALESCE(NULLIF($2, 0), (SELECT MIN(id_rol) FROM usuarios_roles WHERE id_usuario = $1))`
+
+func escribir(ctx context.Context, ex ejecutor, ev Evento) error {
+	comunes := []any{ev.IDActor, ev.Rol, ev.CodigoMotivo, ev.IDSolicitud, ev.IP, ev.Fecha, ev.Exito}
+	var err error
+	switch ev.Flujo {
+	case FlujoEventos:
+		_, err = ex.Exec(ctx, `
+			INSERT INTO auditoria_eventos
+			  (id_usuario, rol_actor, codigo_motivo, id_solicitud, ip_origen, fecha_evento, exito,
+			   accion, tabla_afectada, registro_id, descripcion, error_mensaje)
+			VALUES (NULLIF($1, 0), `+rolActor+`, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7,
+			        $8, $9, NULLIF($10, 0), $11, NULLIF($12, ''))
+		`, append(comunes, ev.Accion, ev.Recurso, ev.IDRecurso, ev.Descripcion, ev.Error)...)
+
+	case FlujoAccesos:
+		a := ev.Acceso
+		if a == nil {
+			a = &Acceso{}
+		}
+		var niveles, linaje []byte
+		if a.Niveles != nil {
+			niveles, _ = json.Marshal(a.Niveles)
+		}
+		if lin := a.Linaje; lin != nil || a.Niveles != nil {
+			if lin == nil {
+				lin = map[string]CampoDivulgado{}
+				for campo, nivel := range a.Niveles {
+					lin[campo] = CampoDivulgado{Nivel: nivel}
+				}
+			}
+			linaje, _ = json.Marshal(lin)
+		}
+		_, err = ex.Exec(ctx, `
+			INSERT INTO accesos
+			  (id_solicitante, rol_actor, codigo_motivo, id_solicitud, ip_origen, fecha_evento, exito,
+			   motivo, id_consentimiento, consentimientos_autorizantes, id_titular, niveles_aplicados,
+			   finalidad, justificacion, tipo_acceso, id_emergencia, linaje)
+			VALUES ($1, `+rolActor+`, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7,
+			        $8, $9, $10, NULLIF($11, 0), $12,
+			        NULLIF($13, ''), NULLIF($14, ''), COALESCE(NULLIF($15, ''), 'ordinario'), NULLIF($16, 0), $17)
+		`, append(comunes, ev.Descripcion, a.IDConsentimiento, a.Consentimientos, a.IDTitular, niveles,
+			a.Finalidad, a.Justificacion, a.TipoAcceso, a.IDEmergencia, linaje)...)
+
+	case FlujoPoliticas:
+		_, err = ex.Exec(ctx, `
+			INSERT INTO auditoria_politicas
+			  (usuario, rol_actor, codigo_motivo, id_solicitud, ip_origen, fecha, exito,
+			   operacion, id_politica, titulo, descripcion, error_mensaje)
+			VALUES (NULLIF($1, 0)::text, `+rolActor+`, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7,
+			        $8, $9, (SELECT titulo FROM politicas_privacidad WHERE id_politica = $9), $10, NULLIF($11, ''))
+		`, append(comunes, ev.Accion, ev.IDRecurso, ev.Descripcion, ev.Error)...)
+
+	case FlujoLogin:
+		_, err = ex.Exec(ctx, `
+			INSERT INTO auditoria_login
+			  (id_usuario, rol_actor, codigo_motivo, id_solicitud, ip_origen, fecha_intento, exito)
+			VALUES (NULLIF($1, 0), `+rolActor+`, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+		`, comunes...)
+
+	default:
+		err = fmt.Errorf("flujo de auditoría desconocido: %q", ev.Flujo)
+	}
+	return err
+}
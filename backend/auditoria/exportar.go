@@ -0,0 +1,256 @@
+// backend/auditoria/exportar.go
+package auditoria
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/db"
+	"backend/models"
+)
+
+// Registro es una fila de cualquier flujo de auditoría con las columnas
+// comunes; es la unidad de la exportación y del reenvío a syslog.
+type Registro struct {
+	Flujo        Flujo     `json:"flujo"`
+	Pos          int64     `json:"cadena_pos"`
+	Fecha        time.Time `json:"fecha"`
+	Actor        *string   `json:"actor"`
+	Rol          *int      `json:"rol"`
+	Accion       string    `json:"accion"`
+	Recurso      *string   `json:"recurso"`
+	IDRecurso    *int      `json:"id_recurso"`
+	Politicas    []int     `json:"politicas"`
+	Exito        bool      `json:"exito"`
+	CodigoMotivo *string   `json:"codigo_motivo"`
+	// EtiquetaMotivo la rellena quien exporta, en el idioma pedido
+	EtiquetaMotivo string  `json:"etiqueta_motivo,omitempty"`
+	Descripcion    *string `json:"descripcion"`
+	Error          *string `json:"error"`
+	IDSolicitud    *string `json:"id_solicitud"`
+	IP             *string `json:"ip"`
+	IDTitular      *int    `json:"id_titular"`
+	Finalidad      *string `json:"finalidad"`
+}
+
+// FiltroExportacion acota la exportación; los campos vacíos no filtran.
+type FiltroExportacion struct {
+	Desde      *time.Time
+	Hasta      *time.Time
+	Actor      string
+	IDPolitica int
+	Exito      *bool
+	Motivo     string // código del catálogo de motivos
+}
+
+// Proyección común de cada flujo: mismas columnas, mismo orden.
+var consultasFlujo = map[Flujo]string{
+	FlujoAccesos: `
+		SELECT a.cadena_pos, a.fecha_evento, a.id_solicitante::text, a.rol_actor::int,
+		       'ACCESO-' || UPPER(COALESCE(a.tipo_acceso, 'ordinario')), 'consentimientos'::text, a.id_consentimiento,
+		       ARRAY(SELECT DISTINCT c.id_politica FROM consentimientos c
+		              WHERE c.id_consentimiento = a.id_consentimiento
+		                 OR c.id_consentimiento = ANY(a.consentimientos_autorizantes)),
+		       a.exito, ` + fmt.Sprintf(MotivoEfectivoSQL, "a", FlujoAccesos) + `, a.motivo, NULL::text, a.id_solicitud, a.ip_origen,
+		       a.id_titular, a.finalidad
+		  FROM accesos a`,
+	FlujoEventos: `
+		SELECT e.cadena_pos, e.fecha_evento, e.id_usuario::text, e.rol_actor::int,
+		       e.accion, e.tabla_afectada, e.registro_id,
+		       CASE e.tabla_afectada
+		            WHEN 'politicas_privacidad' THEN ARRAY[e.registro_id]
+		            WHEN 'consentimientos' THEN ARRAY(SELECT c.id_politica FROM consentimientos c
+		                                               WHERE c.id_consentimiento = e.registro_id)
+		            ELSE '{}'::int[] END,
+		       e.exito, ` + fmt.Sprintf(MotivoEfectivoSQL, "e", FlujoEventos) + `, e.descripcion, e.error_mensaje, e.id_solicitud, e.ip_origen,
+		       NULL::int, NULL::text
+		  FROM auditoria_eventos e`,
+	FlujoPoliticas: `
+		SELECT p.cadena_pos, p.fecha, p.usuario::text, p.rol_actor::int,
+		       p.operacion, 'politicas_privacidad'::text, p.id_politica,
+		       CASE WHEN p.id_politica > 0 THEN ARRAY[p.id_politica] ELSE '{}'::int[] END,
+		       COALESCE(p.exito, TRUE), ` + fmt.Sprintf(MotivoEfectivoSQL, "p", FlujoPoliticas) + `,
+		       p.descripcion, p.error_mensaje, p.id_solicitud, p.ip_origen,
+		       NULL::int, NULL::text
+		  FROM auditoria_politicas p`,
+	FlujoLogin: `
+		SELECT l.cadena_pos, l.fecha_intento, l.id_usuario::text, l.rol_actor::int,
+		       'LOGIN', 'usuarios'::text, l.id_usuario, '{}'::int[],
+		       l.exito, ` + fmt.Sprintf(MotivoEfectivoSQL, "l", FlujoLogin) + `, NULL::text, NULL::text, l.id_solicitud, l.ip_origen,
+		       NULL::int, NULL::text
+		  FROM auditoria_login l`,
+}
+
+// Alias de la proyección común y filtros de FiltroExportacion ($1-$6).
+const (
+	columnasFlujo = `x (pos, fecha, actor, rol, accion, recurso, id_recurso, politicas,
+		                                 exito, codigo_motivo, descripcion, error, id_solicitud, ip,
+		                                 id_titular, finalidad)`
+	filtroFlujo = `($1::timestamp IS NULL OR x.fecha >= $1)
+		   AND ($2::timestamp IS NULL OR x.fecha <  $2)
+		   AND ($3 = '' OR x.actor = $3)
+		   AND ($4 = 0 OR $4 = ANY(x.politicas))
+		   AND ($5::boolean IS NULL OR x.exito = $5)
+		   AND ($6 = '' OR x.codigo_motivo = $6)`
+)
+
+// FlujoValido indica si el nombre corresponde a un flujo de auditoría.
+func FlujoValido(f Flujo) bool {
+	_, ok := consultasFlujo[f]
+	return ok
+}
+
+// Exportar recorre un flujo en orden cronológico y llama a emitir por cada
+// fila, sin cargar el resultado en memoria.
+func Exportar(ctx context.Context, flujo Flujo, f FiltroExportacion, emitir func(Registro) error) error {
+	return leerRegistros(ctx, flujo, f, -1, 0, emitir)
+}
+
+// leerRegistros es Exportar con dos opciones más: con despuesDe >= 0 sólo
+// lee eslabones posteriores y en orden de cadena; limite > 0 acota las filas.
+func leerRegistros(ctx context.Context, flujo Flujo, f FiltroExportacion, despuesDe int64, limite int, emitir func(Registro) error) error {
+	consulta, ok := consultasFlujo[flujo]
+	if !ok {
+		return fmt.Errorf("flujo de auditoría desconocido: %q", flujo)
+	}
+	orden := "x.fecha, x.pos"
+	if despuesDe >= 0 {
+		orden = "x.pos"
+	}
+	rows, err := db.Pool.Query(ctx, `
+		SELECT * FROM (`+consulta+`) `+columnasFlujo+`
+		 WHERE `+filtroFlujo+`
+		   AND x.pos > $7
+		 ORDER BY `+orden+`
+		 LIMIT NULLIF($8, 0)
+	`, f.Desde, f.Hasta, f.Actor, f.IDPolitica, f.Exito, f.Motivo, despuesDe, limite)
+	if err != nil {
+		return fmt.Errorf("error consultando %s: %w", flujo, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reg := Registro{Flujo: flujo}
+		if err := rows.Scan(&reg.Pos, &reg.Fecha, &reg.Actor, &reg.Rol, &reg.Accion, &reg.Recurso,
+			&reg.IDRecurso, &reg.Politicas, &reg.Exito, &reg.CodigoMotivo, &reg.Descripcion,
+			&reg.Error, &reg.IDSolicitud, &reg.IP, &reg.IDTitular, &reg.Finalidad); err != nil {
+			return fmt.Errorf("error leyendo %s: %w", flujo, err)
+		}
+		if err := emitir(reg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ColumnasCSV es la cabecera de la exportación CSV.
+var ColumnasCSV = []string{
+	"flujo", "cadena_pos", "fecha", "actor", "rol", "accion", "recurso", "id_recurso", "politicas",
+	"exito", "codigo_motivo", "descripcion", "error", "id_solicitud", "ip", "id_titular", "finalidad",
+	"etiqueta_motivo",
+}
+
+// FilaCSV convierte un registro en los campos de ColumnasCSV.
+func FilaCSV(reg Registro) []string {
+	texto := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	entero := func(n *int) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(*n)
+	}
+	politicas := make([]string, len(reg.Politicas))
+	for i, p := range reg.Politicas {
+		politicas[i] = strconv.Itoa(p)
+	}
+	return []string{
+		string(reg.Flujo), strconv.FormatInt(reg.Pos, 10), reg.Fecha.UTC().Format(time.RFC3339Nano),
+		texto(reg.Actor), entero(reg.Rol), reg.Accion, texto(reg.Recurso), entero(reg.IDRecurso),
+		strings.Join(politicas, ";"), strconv.FormatBool(reg.Exito), texto(reg.CodigoMotivo),
+		texto(reg.Descripcion), texto(reg.Error), texto(reg.IDSolicitud), texto(reg.IP),
+		entero(reg.IDTitular), texto(reg.Finalidad), reg.EtiquetaMotivo,
+	}
+}
+
+// EscritorExportacion serializa registros en CSV o NDJSON.
+type EscritorExportacion interface {
+	Escribir(Registro) error
+	Terminar() error
+}
+
+// NuevoEscritorExportacion crea el escritor para el formato "csv" o "ndjson".
+func NuevoEscritorExportacion(w io.Writer, formato string) (EscritorExportacion, error) {
+	switch formato {
+	case "csv":
+		c := csv.NewWriter(w)
+		if err := c.Write(ColumnasCSV); err != nil {
+			return nil, err
+		}
+		return escritorCSV{c}, nil
+	case "ndjson":
+		return escritorNDJSON{json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("formato desconocido: %q (csv o ndjson)", formato)
+}
+
+type escritorCSV struct{ w *csv.Writer }
+
+func (e escritorCSV) Escribir(reg Registro) error { return e.w.Write(FilaCSV(reg)) }
+func (e escritorCSV) Terminar() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type escritorNDJSON struct{ enc *json.Encoder }
+
+func (e escritorNDJSON) Escribir(reg Registro) error { return e.enc.Encode(reg) }
+func (e escritorNDJSON) Terminar() error             { return nil }
+
+// ConteoMotivo es el número de fallos de un flujo con un mismo motivo.
+type ConteoMotivo struct {
+	Codigo   string `json:"codigo"`
+	Etiqueta string `json:"etiqueta"`
+	Total    int64  `json:"total"`
+}
+
+// ContarMotivos agrupa por código los fallos del flujo que pasan el filtro
+// (f.Exito se ignora), de más a menos frecuente.
+func ContarMotivos(ctx context.Context, flujo Flujo, f FiltroExportacion, idioma string) ([]ConteoMotivo, error) {
+	consulta, ok := consultasFlujo[flujo]
+	if !ok {
+		return nil, fmt.Errorf("flujo de auditoría desconocido: %q", flujo)
+	}
+	falso := false
+	rows, err := db.Pool.Query(ctx, `
+		SELECT COALESCE(x.codigo_motivo, $7), COUNT(*)
+		  FROM (`+consulta+`) `+columnasFlujo+`
+		 WHERE `+filtroFlujo+`
+		 GROUP BY 1
+		 ORDER BY 2 DESC, 1
+	`, f.Desde, f.Hasta, f.Actor, f.IDPolitica, &falso, f.Motivo, models.MotivoOtro)
+	if err != nil {
+		return nil, fmt.Errorf("error contando motivos de %s: %w", flujo, err)
+	}
+	defer rows.Close()
+	conteos := []ConteoMotivo{}
+	for rows.Next() {
+		var c ConteoMotivo
+		if err := rows.Scan(&c.Codigo, &c.Total); err != nil {
+			return nil, err
+		}
+		c.Etiqueta = EtiquetaMotivo(c.Codigo, idioma)
+		conteos = append(conteos, c)
+	}
+	return conteos, rows.Err()
+}
@@ -0,0 +1,119 @@
+// backend/auditoria/middleware.go
+package auditoria
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type claveCtx int
+
+const claveSolicitud claveCtx = iota
+
+type solicitud struct {
+	id string
+	ip string
+}
+
+// Middleware asigna un ID a cada solicitud (o respeta el X-Request-ID
+// recibido), lo devuelve en la respuesta y lo deja en el contexto junto con
+// la IP de origen para que los eventos de auditoría lo incluyan.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+		if id == "" || len(id) > 64 {
+			id = nuevoIDSolicitud()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), claveSolicitud, solicitud{id: id, ip: ipCliente(r)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// IDSolicitud devuelve el ID de la solicitud en curso, o "".
+func IDSolicitud(ctx context.Context) string {
+	s, _ := ctx.Value(claveSolicitud).(solicitud)
+	return s.id
+}
+
+func nuevoIDSolicitud() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// proxiesConfiables son las redes de los proxies inversos cuyo
+// X-Forwarded-For se acepta (ConfigurarProxies).
+var proxiesConfiables []*net.IPNet
+
+// ConfigurarProxies fija los proxies confiables a partir de una lista de IPs
+// o redes CIDR separadas por comas (AUDITORIA_PROXIES). Sin proxies, la IP
+// de origen es siempre la de la conexión: cualquier cliente puede escribir
+// X-Forwarded-For y la IP queda en la cadena de auditoría.
+func ConfigurarProxies(lista string) error {
+	var redes []*net.IPNet
+	for _, parte := range strings.Split(lista, ",") {
+		parte = strings.TrimSpace(parte)
+		if parte == "" {
+			continue
+		}
+		if !strings.Contains(parte, "/") {
+			ip := net.ParseIP(parte)
+			if ip == nil {
+				return fmt.Errorf("proxy inválido: %q", parte)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			parte = fmt.Sprintf("%s/%d", parte, bits)
+		}
+		_, red, err := net.ParseCIDR(parte)
+		if err != nil {
+			return fmt.Errorf("proxy inválido: %q", parte)
+		}
+		redes = append(redes, red)
+	}
+	proxiesConfiables = redes
+	return nil
+}
+
+func esProxyConfiable(ip net.IP) bool {
+	for _, red := range proxiesConfiables {
+		if red.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipCliente devuelve la IP de la conexión. Si viene de un proxy confiable,
+// recorre X-Forwarded-For de derecha a izquierda y toma el primer salto que
+// no sea otro proxy confiable; lo que haya a su izquierda lo escribió el
+// cliente y no se usa.
+func ipCliente(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remota := net.ParseIP(host)
+	if remota == nil || !esProxyConfiable(remota) {
+		return host
+	}
+	saltos := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(saltos) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(saltos[i]))
+		if ip == nil {
+			break
+		}
+		if !esProxyConfiable(ip) {
+			return ip.String()
+		}
+	}
+	return host
+}
@@ -0,0 +1,73 @@
+package auditoria
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareSolicitud(t *testing.T) {
+	var ev Evento
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		completar(r.Context(), &ev)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ev.IDSolicitud != "abc-123" || rec.Header().Get("X-Request-ID") != "abc-123" {
+		t.Errorf("X-Request-ID recibido no respetado: evento %q, respuesta %q", ev.IDSolicitud, rec.Header().Get("X-Request-ID"))
+	}
+	if ev.IP != "10.0.0.7" {
+		t.Errorf("IP = %q, want 10.0.0.7", ev.IP)
+	}
+	if ev.Flujo != FlujoEventos || ev.Fecha.IsZero() {
+		t.Errorf("completar no aplicó los valores por defecto: %+v", ev)
+	}
+
+	ev = Evento{}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if ev.IP != "192.0.2.1" {
+		t.Errorf("sin proxies confiables X-Forwarded-For no debe usarse: IP = %q", ev.IP)
+	}
+	if len(ev.IDSolicitud) != 24 {
+		t.Errorf("ID de solicitud generado = %q", ev.IDSolicitud)
+	}
+
+	if IDSolicitud(context.Background()) != "" {
+		t.Error("sin middleware no debería haber ID de solicitud")
+	}
+}
+
+func TestIPClienteProxiesConfiables(t *testing.T) {
+	if err := ConfigurarProxies("192.0.2.0/24, 10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigurarProxies("")
+	casos := []struct {
+		remota, reenviado, esperada string
+	}{
+		{"192.0.2.1:80", "203.0.113.9, 10.0.0.1", "203.0.113.9"},
+		{"192.0.2.1:80", "1.2.3.4, 203.0.113.9", "203.0.113.9"}, // 1.2.3.4 lo inventó el cliente
+		{"192.0.2.1:80", "", "192.0.2.1"},
+		{"198.51.100.5:80", "203.0.113.9", "198.51.100.5"}, // conexión directa: se ignora la cabecera
+	}
+	for _, c := range casos {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remota
+		if c.reenviado != "" {
+			req.Header.Set("X-Forwarded-For", c.reenviado)
+		}
+		if ip := ipCliente(req); ip != c.esperada {
+			t.Errorf("%s con %q: IP = %q, want %q", c.remota, c.reenviado, ip, c.esperada)
+		}
+	}
+	if err := ConfigurarProxies("10.0.0.300"); err == nil {
+		t.Error("se esperaba error con una IP inválida")
+	}
+}
@@ -0,0 +1,121 @@
+// backend/auditoria/motivos.go
+package auditoria
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"backend/models"
+)
+
+/*
+   Catálogo de motivos
+   -------------------
+   Toda denegación o fallo auditado lleva en codigo_motivo uno de los
+   códigos de models/motivos.go (compartidos con utils); la descripción
+   libre queda para el detalle. Los paneles y las
+   exportaciones agrupan por código y muestran la etiqueta en el idioma
+   pedido. Las filas anteriores al catálogo no se modifican (alteraría su
+   hash, 014): la migración 021 guarda aparte el código inferido de su texto
+   y MotivoEfectivoSQL lo combina con el propio.
+*/
+
+// EtiquetasMotivo son las etiquetas de un motivo por idioma.
+type EtiquetasMotivo struct {
+	ES string
+	EN string
+}
+
+var catalogoMotivos = map[string]EtiquetasMotivo{
+	models.MotivoFinalidadAusente:        {"Finalidad o justificación ausente", "Missing purpose or justification"},
+	models.MotivoSinConsentimiento:       {"Sin consentimiento activo", "No active consent"},
+	models.MotivoPoliticaNoCoincide:      {"Política no coincide", "Policy does not match"},
+	models.MotivoFinalidadNoDeclarada:    {"Finalidad no declarada en la política", "Purpose not declared in the policy"},
+	models.MotivoCondicionTitular:        {"Condición del titular", "Data subject condition"},
+	models.MotivoCuotaExcedida:           {"Cuota excedida", "Quota exceeded"},
+	models.MotivoSinAprobacion:           {"Sin aprobación del titular", "Not approved by the data subject"},
+	models.MotivoTitularNoEncontrado:     {"Titular no encontrado", "Data subject not found"},
+	models.MotivoEmergenciaNoVigente:     {"Emergencia expirada o revocada", "Emergency access expired or revoked"},
+	models.MotivoSeudonimoRequerido:      {"Titular seudonimizado pedido por su id", "Pseudonymized data subject requested by id"},
+	models.MotivoAtributoNoValido:        {"Atributo no válido", "Invalid attribute"},
+	models.MotivoPoliticaRevocada:        {"Política revocada", "Policy revoked"},
+	models.MotivoAtributoExpirado:        {"Atributo expirado", "Attribute expired"},
+	models.MotivoExpiracionFueraVigencia: {"Expiración fuera de la vigencia de la política", "Expiry beyond the policy term"},
+	models.MotivoSeudonimoDesconocido:    {"Seudónimo desconocido", "Unknown pseudonym"},
+	models.MotivoArchivoAlterado:         {"Archivo de auditoría alterado", "Tampered audit archive"},
+	models.MotivoUsuarioDesconocido:      {"Usuario desconocido", "Unknown user"},
+	models.MotivoCredencialesInvalidas:   {"Credenciales inválidas", "Invalid credentials"},
+	models.MotivoErrorBD:                 {"Error de base de datos", "Database error"},
+	models.MotivoErrorInterno:            {"Error interno", "Internal error"},
+	models.MotivoOtro:                    {"Otro", "Other"},
+}
+
+// MotivoValido indica si el código pertenece al catálogo.
+func MotivoValido(codigo string) bool {
+	_, ok := catalogoMotivos[codigo]
+	return ok
+}
+
+// Idioma elige "es" o "en" a partir de un parámetro lang o de una cabecera
+// Accept-Language; por defecto, español.
+func Idioma(valor string) string {
+	for _, parte := range strings.Split(valor, ",") {
+		etiqueta := strings.ToLower(strings.TrimSpace(strings.SplitN(parte, ";", 2)[0]))
+		switch {
+		case strings.HasPrefix(etiqueta, "es"):
+			return "es"
+		case strings.HasPrefix(etiqueta, "en"):
+			return "en"
+		}
+	}
+	return "es"
+}
+
+// EtiquetaMotivo devuelve la etiqueta del código en el idioma indicado; los
+// códigos fuera del catálogo se devuelven tal cual.
+func EtiquetaMotivo(codigo, idioma string) string {
+	e, ok := catalogoMotivos[codigo]
+	if !ok {
+		return codigo
+	}
+	if idioma == "en" {
+		return e.EN
+	}
+	return e.ES
+}
+
+// MotivoCatalogo es una entrada del catálogo con su etiqueta ya localizada.
+type MotivoCatalogo struct {
+	Codigo   string `json:"codigo"`
+	Etiqueta string `json:"etiqueta"`
+}
+
+// CatalogoMotivos lista el catálogo ordenado por código.
+func CatalogoMotivos(idioma string) []MotivoCatalogo {
+	lista := make([]MotivoCatalogo, 0, len(catalogoMotivos))
+	for codigo := range catalogoMotivos {
+		lista = append(lista, MotivoCatalogo{codigo, EtiquetaMotivo(codigo, idioma)})
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Codigo < lista[j].Codigo })
+	return lista
+}
+
+// MotivoEfectivoSQL es el código de motivo de una fila de la tabla de
+// auditoría (alias %[1]s, flujo %[2]s): el propio o, en las filas
+// anteriores al catálogo, el inferido por la migración 021.
+const MotivoEfectivoSQL = `COALESCE(%[1]s.codigo_motivo,
+	         (SELECT mi.codigo_motivo FROM auditoria_motivos_inferidos mi
+	           WHERE mi.flujo = '%[2]s' AND mi.cadena_pos = %[1]s.cadena_pos))`
+
+// normalizarMotivo exige un código del catálogo en cada denegación o fallo.
+// Los éxitos pueden llevar otros códigos (p. ej. la base legal de una
+// emergencia).
+func normalizarMotivo(ev *Evento) {
+	if ev.Exito || MotivoValido(ev.CodigoMotivo) {
+		return
+	}
+	log.Printf("Auditoría: motivo %q fuera del catálogo en %s/%s; se registra como %q",
+		ev.CodigoMotivo, ev.Flujo, ev.Accion, models.MotivoOtro)
+	ev.CodigoMotivo = models.MotivoOtro
+}
@@ -0,0 +1,60 @@
+package auditoria
+
+import (
+	"testing"
+
+	"backend/models"
+)
+
+func TestIdioma(t *testing.T) {
+	casos := map[string]string{
+		"":                        "es",
+		"en":                      "en",
+		",en-US,en;q=0.9":         "en", // sin ?lang=, sólo Accept-Language
+		"fr-FR,en;q=0.8,es;q=0.5": "en",
+		"es-MX":                   "es",
+		"de":                      "es",
+	}
+	for valor, want := range casos {
+		if got := Idioma(valor); got != want {
+			t.Errorf("Idioma(%q) = %q, want %q", valor, got, want)
+		}
+	}
+}
+
+func TestEtiquetaMotivo(t *testing.T) {
+	if got := EtiquetaMotivo(models.MotivoCuotaExcedida, "en"); got != "Quota exceeded" {
+		t.Errorf("en = %q", got)
+	}
+	if got := EtiquetaMotivo(models.MotivoCuotaExcedida, "es"); got != "Cuota excedida" {
+		t.Errorf("es = %q", got)
+	}
+	if got := EtiquetaMotivo("rgpd_6_1_d", "es"); got != "rgpd_6_1_d" {
+		t.Errorf("código fuera del catálogo = %q", got)
+	}
+	for _, m := range CatalogoMotivos("en") {
+		if m.Etiqueta == "" || m.Etiqueta == m.Codigo {
+			t.Errorf("%s sin etiqueta", m.Codigo)
+		}
+	}
+}
+
+func TestNormalizarMotivo(t *testing.T) {
+	casos := []struct {
+		ev   Evento
+		want string
+	}{
+		{Evento{Exito: true}, ""},
+		{Evento{Exito: true, CodigoMotivo: "rgpd_6_1_d"}, "rgpd_6_1_d"}, // base legal de una emergencia
+		{Evento{CodigoMotivo: models.MotivoSinAprobacion}, models.MotivoSinAprobacion},
+		{Evento{}, models.MotivoOtro},
+		{Evento{CodigoMotivo: "política no coincide"}, models.MotivoOtro},
+	}
+	for _, c := range casos {
+		ev := c.ev
+		normalizarMotivo(&ev)
+		if ev.CodigoMotivo != c.want {
+			t.Errorf("normalizarMotivo(%+v) = %q, want %q", c.ev, ev.CodigoMotivo, c.want)
+		}
+	}
+}
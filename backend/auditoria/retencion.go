@@ -0,0 +1,575 @@
+// backend/auditoria/retencion.go
+package auditoria
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/db"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+/*
+   Retención y archivo
+   -------------------
+   Cada flujo (los cuatro de auditoría y notificaciones) guarda sus filas en
+   línea durante los días de auditoria_retencion. Una vez al día, cada mes
+   ya vencido se vuelca a <flujo>/<flujo>_<mes>_<desde>-<hasta>.ndjson.gz
+   (una fila to_jsonb por línea) con un manifiesto firmado al lado
+   (.firma.json), se registra en auditoria_archivos y se poda de la tabla.
+
+   En las tablas encadenadas se archiva siempre un prefijo de la cadena:
+   antes de escribir se comprueban los hashes del tramo y, en la misma
+   transacción que poda, se firma un checkpoint del último eslabón podado,
+   que es el ancla que exige la verificación de lo que queda. Si el tramo
+   no verifica (o tiene huecos de transacciones aún sin confirmar) no se
+   poda nada y se reintenta en la siguiente pasada.
+
+   Restaurar un archivo comprueba su SHA-256 y su firma y carga sus filas
+   en auditoria_restaurada, fuera de las tablas en línea.
+*/
+
+const retencionCada = 24 * time.Hour
+
+// ErrArchivoNoVerifica indica que el archivo no coincide con su SHA-256 o su firma.
+var ErrArchivoNoVerifica = errors.New("el archivo no coincide con su huella firmada")
+
+// tablaRetencion describe cómo recorrer un flujo para archivarlo.
+type tablaRetencion struct {
+	fecha      string // columna de fecha
+	pos        string // columna de orden (cadena_pos o id)
+	encadenada bool
+}
+
+var tablasRetencion = map[string]tablaRetencion{
+	string(FlujoAccesos):   {"fecha_evento", "cadena_pos", true},
+	string(FlujoEventos):   {"fecha_evento", "cadena_pos", true},
+	string(FlujoPoliticas): {"fecha", "cadena_pos", true},
+	string(FlujoLogin):     {"fecha_intento", "cadena_pos", true},
+	"notificaciones":       {"fecha_creacion", "id_notificacion", false},
+}
+
+// Archivo es un archivo de retención registrado en auditoria_archivos.
+type Archivo struct {
+	ID                int        `json:"id_archivo"`
+	Flujo             string     `json:"flujo"`
+	Mes               string     `json:"mes"`
+	Ubicacion         string     `json:"ubicacion"`
+	PosDesde          int64      `json:"pos_desde"`
+	PosHasta          int64      `json:"pos_hasta"`
+	FechaDesde        time.Time  `json:"fecha_desde"`
+	FechaHasta        time.Time  `json:"fecha_hasta"`
+	Filas             int64      `json:"filas"`
+	SHA256            []byte     `json:"sha256"`
+	HashUltimo        []byte     `json:"hash_ultimo,omitempty"`
+	Firma             []byte     `json:"firma"`
+	FechaArchivo      time.Time  `json:"fecha_archivo"`
+	FechaRestauracion *time.Time `json:"fecha_restauracion,omitempty"`
+	RestauradoPor     *int       `json:"restaurado_por,omitempty"`
+}
+
+// mensajeArchivo es lo que firma el manifiesto de un archivo.
+func mensajeArchivo(a Archivo) []byte {
+	return []byte(strings.Join([]string{"archivo-auditoria", a.Flujo, a.Mes,
+		strconv.FormatInt(a.PosDesde, 10), strconv.FormatInt(a.PosHasta, 10),
+		strconv.FormatInt(a.Filas, 10), hex.EncodeToString(a.SHA256), hex.EncodeToString(a.HashUltimo)}, "|"))
+}
+
+// IniciarRetencion arranca la pasada diaria de retención sobre el almacén
+// indicado (ver almacen.go). Con destino vacío la retención queda apagada:
+// podar es irreversible y sólo se hace si se ha configurado dónde archivar.
+func IniciarRetencion(destino string) error {
+	if destino == "" {
+		log.Println("Retención de auditoría desactivada: AUDITORIA_ARCHIVO no está definida")
+		return nil
+	}
+	al, err := nuevoAlmacen(destino)
+	if err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(retencionCada)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if err := aplicarRetencion(context.Background(), al); err != nil {
+				log.Printf("Error aplicando la retención de auditoría: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func aplicarRetencion(ctx context.Context, al almacenArchivos) error {
+	rows, err := db.Pool.Query(ctx, `SELECT flujo, dias FROM auditoria_retencion ORDER BY flujo`)
+	if err != nil {
+		return err
+	}
+	dias := map[string]int{}
+	for rows.Next() {
+		var f string
+		var d int
+		if err := rows.Scan(&f, &d); err != nil {
+			rows.Close()
+			return err
+		}
+		dias[f] = d
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for flujo, d := range dias {
+		if _, ok := tablasRetencion[flujo]; !ok {
+			continue
+		}
+		// Se archivan meses completos anteriores al mes del corte
+		t := time.Now().UTC().AddDate(0, 0, -d)
+		corte := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for {
+			mes, hay, err := primerMes(ctx, flujo)
+			if err != nil || !hay || !mes.Before(corte) {
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", flujo, err))
+				}
+				break
+			}
+			a, err := archivarMes(ctx, al, flujo, mes)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", flujo, mes.Format("2006-01"), err))
+				break
+			}
+			if a == nil {
+				break
+			}
+			log.Printf("Auditoría: %s %s archivado en %s (%d filas)", flujo, a.Mes, a.Ubicacion, a.Filas)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// primerMes es el mes de la fila más antigua que sigue en línea; en las
+// tablas encadenadas, la del primer eslabón, para que una fila con la fecha
+// algo desordenada no deje el inicio de la cadena sin archivar.
+func primerMes(ctx context.Context, flujo string) (time.Time, bool, error) {
+	t := tablasRetencion[flujo]
+	consulta := fmt.Sprintf(`SELECT MIN(%s) FROM %s`, t.fecha, flujo)
+	if t.encadenada {
+		consulta = fmt.Sprintf(`SELECT (SELECT %s FROM %s ORDER BY cadena_pos LIMIT 1)`, t.fecha, flujo)
+	}
+	var min *time.Time
+	if err := db.Pool.QueryRow(ctx, consulta).Scan(&min); err != nil {
+		return time.Time{}, false, err
+	}
+	if min == nil {
+		return time.Time{}, false, nil
+	}
+	return time.Date(min.Year(), min.Month(), 1, 0, 0, 0, 0, time.UTC), true, nil
+}
+
+// archivarMes archiva y poda las filas de un mes. Devuelve nil sin error si
+// no había nada que archivar.
+func archivarMes(ctx context.Context, al almacenArchivos, flujo string, mes time.Time) (*Archivo, error) {
+	t := tablasRetencion[flujo]
+	fin := mes.AddDate(0, 1, 0)
+	a := &Archivo{Flujo: flujo, Mes: mes.Format("2006-01")}
+
+	var consulta string
+	var args []any
+	var prev []byte // hash que debe encadenar el primer eslabón
+	var ultimoArchivado int64
+	if t.encadenada {
+		// Prefijo de la cadena: hasta el eslabón anterior al primero del mes siguiente
+		var hasta int64
+		if err := db.Pool.QueryRow(ctx, fmt.Sprintf(`
+			SELECT COALESCE((SELECT MIN(cadena_pos) - 1 FROM %[1]s WHERE %[2]s >= $1),
+			                (SELECT MAX(cadena_pos) FROM %[1]s), 0)
+		`, flujo, t.fecha), fin).Scan(&hasta); err != nil {
+			return nil, err
+		}
+		consulta = fmt.Sprintf(`
+			SELECT cadena_pos, %[2]s, to_jsonb(t)::text, hash_anterior, hash, auditoria_canonica(to_jsonb(t))
+			  FROM %[1]s t WHERE cadena_pos <= $1 ORDER BY cadena_pos`, flujo, t.fecha)
+		args = []any{hasta}
+
+		err := db.Pool.QueryRow(ctx, `
+			SELECT pos_hasta, hash_ultimo FROM auditoria_archivos
+			 WHERE flujo = $1 ORDER BY pos_hasta DESC LIMIT 1
+		`, flujo).Scan(&ultimoArchivado, &prev)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	} else {
+		consulta = fmt.Sprintf(`
+			SELECT %[3]s::bigint, %[2]s, to_jsonb(t)::text, NULL::bytea, NULL::bytea, NULL::text
+			  FROM %[1]s t WHERE %[2]s < $1 ORDER BY %[3]s`, flujo, t.fecha, t.pos)
+		args = []any{fin}
+	}
+
+	tmp, err := os.CreateTemp("", "archivo-auditoria-*.ndjson.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sum := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, sum))
+
+	rows, err := db.Pool.Query(ctx, consulta, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var pos int64
+		var fecha time.Time
+		var fila string
+		var hashAnterior, hashFila []byte
+		var canonica *string
+		if err := rows.Scan(&pos, &fecha, &fila, &hashAnterior, &hashFila, &canonica); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if t.encadenada {
+			if a.Filas == 0 && ultimoArchivado > 0 && pos != ultimoArchivado+1 {
+				rows.Close()
+				return nil, fmt.Errorf("la cadena en línea empieza en %d pero lo archivado acaba en %d", pos, ultimoArchivado)
+			}
+			if err := comprobarEslabon(a, prev, pos, hashAnterior, hashFila, *canonica); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			prev = hashFila
+			a.HashUltimo = hashFila
+		}
+		if a.Filas == 0 {
+			a.PosDesde, a.FechaDesde = pos, fecha
+		}
+		a.PosHasta = pos
+		if fecha.Before(a.FechaDesde) {
+			a.FechaDesde = fecha
+		}
+		if fecha.After(a.FechaHasta) {
+			a.FechaHasta = fecha
+		}
+		a.Filas++
+		if _, err := gz.Write(append([]byte(fila), '\n')); err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if a.Filas == 0 {
+		return nil, nil
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	a.SHA256 = sum.Sum(nil)
+	if a.Firma, err = utils.FirmarAuditoria(mensajeArchivo(*a)); err != nil {
+		return nil, err
+	}
+
+	// Primero el archivo y su manifiesto; sólo después se poda
+	nombre := fmt.Sprintf("%s/%s_%s_%d-%d.ndjson.gz", flujo, flujo, a.Mes, a.PosDesde, a.PosHasta)
+	tam, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if a.Ubicacion, err = al.guardar(ctx, nombre, tmp, tam); err != nil {
+		return nil, fmt.Errorf("error guardando el archivo: %w", err)
+	}
+	manifiesto, _ := json.MarshalIndent(a, "", "  ")
+	if _, err := al.guardar(ctx, nombre+".firma.json", bytes.NewReader(manifiesto), int64(len(manifiesto))); err != nil {
+		return nil, fmt.Errorf("error guardando el manifiesto: %w", err)
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO auditoria_archivos
+		  (flujo, mes, ubicacion, pos_desde, pos_hasta, fecha_desde, fecha_hasta, filas, sha256, hash_ultimo, firma)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id_archivo, fecha_archivo
+	`, a.Flujo, mes, a.Ubicacion, a.PosDesde, a.PosHasta, a.FechaDesde, a.FechaHasta, a.Filas,
+		a.SHA256, a.HashUltimo, a.Firma).Scan(&a.ID, &a.FechaArchivo); err != nil {
+		return nil, err
+	}
+	var tag pgconn.CommandTag
+	if t.encadenada {
+		// El checkpoint ancla lo que queda de la cadena a lo podado
+		if err := utils.FirmarCheckpointAuditoria(ctx, tx, flujo, a.PosHasta, a.HashUltimo); err != nil {
+			return nil, err
+		}
+		tag, err = tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE cadena_pos <= $1`, flujo), a.PosHasta)
+	} else {
+		tag, err = tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s < $1 AND %s <= $2`, flujo, t.fecha, t.pos),
+			fin, a.PosHasta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() != a.Filas {
+		return nil, fmt.Errorf("se archivaron %d filas pero se podarían %d", a.Filas, tag.RowsAffected())
+	}
+	if t.encadenada {
+		// Los motivos inferidos de filas antiguas se van con ellas (021)
+		if _, err := tx.Exec(ctx, `DELETE FROM auditoria_motivos_inferidos WHERE flujo = $1 AND cadena_pos <= $2`,
+			flujo, a.PosHasta); err != nil {
+			return nil, err
+		}
+	}
+	if err := RegistrarEnTx(ctx, tx, Evento{
+		Accion: "ARCHIVAR-AUDITORIA", Recurso: flujo, IDRecurso: a.ID, Exito: true,
+		Descripcion: fmt.Sprintf("%s: %d filas (%d-%d) en %s", a.Mes, a.Filas, a.PosDesde, a.PosHasta, a.Ubicacion),
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// comprobarEslabon valida un eslabón del tramo que se va a archivar.
+func comprobarEslabon(a *Archivo, prev []byte, pos int64, hashAnterior, hashFila []byte, canonica string) error {
+	switch {
+	case a.Filas > 0 && pos != a.PosHasta+1:
+		return fmt.Errorf("falta el eslabón %d: no se poda un tramo con huecos", a.PosHasta+1)
+	case prev == nil && pos == 1 && !bytes.Equal(hashAnterior, make([]byte, sha256.Size)):
+		return fmt.Errorf("el eslabón 1 no parte del hash génesis")
+	case prev != nil && !bytes.Equal(hashAnterior, prev):
+		return fmt.Errorf("el eslabón %d no encadena con el anterior", pos)
+	case !bytes.Equal(utils.HashEslabon(hashAnterior, canonica), hashFila):
+		return fmt.Errorf("el contenido del eslabón %d no coincide con su hash", pos)
+	}
+	return nil
+}
+
+// ListarArchivos devuelve los archivos de un flujo ("" = todos), del más reciente al más antiguo.
+func ListarArchivos(ctx context.Context, flujo string) ([]Archivo, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id_archivo, flujo, to_char(mes, 'YYYY-MM'), ubicacion, pos_desde, pos_hasta,
+		       fecha_desde, fecha_hasta, filas, sha256, hash_ultimo, firma, fecha_archivo,
+		       fecha_restauracion, restaurado_por
+		  FROM auditoria_archivos
+		 WHERE $1 = '' OR flujo = $1
+		 ORDER BY mes DESC, flujo, id_archivo DESC
+	`, flujo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	archivos := []Archivo{}
+	for rows.Next() {
+		var a Archivo
+		if err := rows.Scan(&a.ID, &a.Flujo, &a.Mes, &a.Ubicacion, &a.PosDesde, &a.PosHasta,
+			&a.FechaDesde, &a.FechaHasta, &a.Filas, &a.SHA256, &a.HashUltimo, &a.Firma, &a.FechaArchivo,
+			&a.FechaRestauracion, &a.RestauradoPor); err != nil {
+			return nil, err
+		}
+		archivos = append(archivos, a)
+	}
+	return archivos, rows.Err()
+}
+
+// Restauracion resume la carga de un archivo en auditoria_restaurada.
+type Restauracion struct {
+	IDArchivo     int    `json:"id_archivo"`
+	Flujo         string `json:"flujo"`
+	Filas         int64  `json:"filas"`
+	CadenaIntegra *bool  `json:"cadena_integra,omitempty"` // sólo flujos encadenados
+	Ruptura       string `json:"ruptura,omitempty"`
+}
+
+// RestaurarArchivo verifica un archivo y carga sus filas para consultarlas.
+// Devuelve pgx.ErrNoRows si no existe y ErrArchivoNoVerifica si está alterado.
+func RestaurarArchivo(ctx context.Context, id, idActor int) (*Restauracion, error) {
+	archivos, err := db.Pool.Query(ctx, `
+		SELECT id_archivo, flujo, to_char(mes, 'YYYY-MM'), ubicacion, pos_desde, pos_hasta,
+		       filas, sha256, hash_ultimo, firma
+		  FROM auditoria_archivos WHERE id_archivo = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	a, err := pgx.CollectExactlyOneRow(archivos, func(row pgx.CollectableRow) (Archivo, error) {
+		var a Archivo
+		err := row.Scan(&a.ID, &a.Flujo, &a.Mes, &a.Ubicacion, &a.PosDesde, &a.PosHasta,
+			&a.Filas, &a.SHA256, &a.HashUltimo, &a.Firma)
+		return a, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !utils.VerificarFirmaAuditoria(mensajeArchivo(a), a.Firma) {
+		return nil, ErrArchivoNoVerifica
+	}
+
+	f, err := abrirArchivo(ctx, a.Ubicacion)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo %s: %w", a.Ubicacion, err)
+	}
+	defer f.Close()
+	sum := sha256.New()
+	lector := io.TeeReader(f, sum)
+	gz, err := gzip.NewReader(lector)
+	if err != nil {
+		return nil, ErrArchivoNoVerifica
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM auditoria_restaurada WHERE id_archivo = $1`, id); err != nil {
+		return nil, err
+	}
+	lineas := bufio.NewScanner(gz)
+	lineas.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var orden int64
+	n, err := tx.CopyFrom(ctx, pgx.Identifier{"auditoria_restaurada"}, []string{"id_archivo", "orden", "fila"},
+		pgx.CopyFromFunc(func() ([]any, error) {
+			if !lineas.Scan() {
+				return nil, lineas.Err()
+			}
+			orden++
+			return []any{id, orden, lineas.Text()}, nil
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("error cargando %s: %w", a.Ubicacion, err)
+	}
+	if err := terminarLectura(lector, sum, a.SHA256); err != nil {
+		return nil, err
+	}
+	if n != a.Filas {
+		return nil, ErrArchivoNoVerifica
+	}
+
+	res := &Restauracion{IDArchivo: id, Flujo: a.Flujo, Filas: n}
+	if tablasRetencion[a.Flujo].encadenada {
+		if res.Ruptura, err = verificarRestaurada(ctx, tx, a); err != nil {
+			return nil, err
+		}
+		integra := res.Ruptura == ""
+		res.CadenaIntegra = &integra
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE auditoria_archivos SET fecha_restauracion = NOW(), restaurado_por = NULLIF($2, 0)
+		 WHERE id_archivo = $1
+	`, id, idActor); err != nil {
+		return nil, err
+	}
+	if err := RegistrarEnTx(ctx, tx, Evento{
+		IDActor: idActor, Accion: "RESTAURAR-AUDITORIA", Recurso: a.Flujo, IDRecurso: id, Exito: true,
+		Descripcion: fmt.Sprintf("%s: %d filas (%d-%d)", a.Mes, n, a.PosDesde, a.PosHasta),
+	}); err != nil {
+		return nil, err
+	}
+	return res, tx.Commit(ctx)
+}
+
+// terminarLectura consume lo que quede tras el gzip y compara el SHA-256.
+func terminarLectura(r io.Reader, sum hash.Hash, esperado []byte) error {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Sum(nil), esperado) {
+		return ErrArchivoNoVerifica
+	}
+	return nil
+}
+
+// verificarRestaurada recalcula la cadena de las filas restauradas con la
+// misma forma canónica del trigger; devuelve la primera ruptura o "".
+func verificarRestaurada(ctx context.Context, tx pgx.Tx, a Archivo) (string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT (fila->>'cadena_pos')::bigint, auditoria_canonica(fila),
+		       decode(substr(fila->>'hash_anterior', 3), 'hex'), decode(substr(fila->>'hash', 3), 'hex')
+		  FROM auditoria_restaurada WHERE id_archivo = $1 ORDER BY orden
+	`, a.ID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	v := &Archivo{}
+	var prev []byte
+	for rows.Next() {
+		var pos int64
+		var canonica string
+		var hashAnterior, hashFila []byte
+		if err := rows.Scan(&pos, &canonica, &hashAnterior, &hashFila); err != nil {
+			return "", err
+		}
+		if v.Filas == 0 && pos != a.PosDesde {
+			return fmt.Sprintf("el archivo empieza en %d y no en %d", pos, a.PosDesde), nil
+		}
+		if err := comprobarEslabon(v, prev, pos, hashAnterior, hashFila, canonica); err != nil {
+			return err.Error(), nil
+		}
+		prev = hashFila
+		v.PosHasta = pos
+		v.Filas++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(prev, a.HashUltimo) {
+		return "el último eslabón no coincide con el hash firmado del archivo", nil
+	}
+	return "", nil
+}
+
+// LeerRestaurados recorre las filas restauradas de un archivo en orden.
+func LeerRestaurados(ctx context.Context, id int, emitir func(json.RawMessage) error) error {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT fila::text FROM auditoria_restaurada WHERE id_archivo = $1 ORDER BY orden
+	`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fila string
+		if err := rows.Scan(&fila); err != nil {
+			return err
+		}
+		if err := emitir(json.RawMessage(fila)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DescartarRestauracion borra las filas restauradas de un archivo.
+func DescartarRestauracion(ctx context.Context, id int) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM auditoria_restaurada WHERE id_archivo = $1`, id)
+	return err
+}
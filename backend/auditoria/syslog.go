@@ -0,0 +1,231 @@
+// backend/auditoria/syslog.go
+package auditoria
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/db"
+)
+
+/*
+   Reenvío a syslog
+   ----------------
+   Opcional: con AUDITORIA_SYSLOG definido (udp://host:514, tcp://host:601 o
+   unix:///dev/log) cada evento de auditoría ya confirmado en la base de datos
+   se envía al colector como mensaje RFC 5424 con carga CEF. El reenviador
+   sigue cada cadena por cadena_pos y guarda lo entregado en
+   auditoria_reenvio, así que sólo envía filas confirmadas (también las
+   escritas dentro de transacciones) y, tras un corte, reintenta el lote
+   pendiente: entrega al menos una vez.
+*/
+
+const (
+	syslogApp      = "consentimientos"
+	syslogFacility = 13 // log audit
+	reenvioCada    = 5 * time.Second
+	reenvioLote    = 500
+	cefProveedor   = "SistemaGestionConsentimiento"
+	cefProducto    = "auditoria"
+	cefVersion     = "1.0"
+)
+
+type reenviador struct {
+	red, dir string
+	host     string
+	conn     net.Conn
+}
+
+// IniciarReenvio arranca el reenvío al colector indicado; "" lo deja desactivado.
+func IniciarReenvio(destino string) error {
+	if destino == "" {
+		return nil
+	}
+	u, err := url.Parse(destino)
+	if err != nil {
+		return fmt.Errorf("destino syslog inválido: %w", err)
+	}
+	r := &reenviador{red: u.Scheme, dir: u.Host}
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "unix":
+		r.red, r.dir = "unixgram", u.Path
+	default:
+		return fmt.Errorf("destino syslog inválido %q: use udp://, tcp:// o unix://", destino)
+	}
+	if r.dir == "" {
+		return fmt.Errorf("destino syslog sin dirección: %q", destino)
+	}
+	if r.host, err = os.Hostname(); err != nil || r.host == "" {
+		r.host = "-"
+	}
+
+	go func() {
+		ticker := time.NewTicker(reenvioCada)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, f := range []Flujo{FlujoAccesos, FlujoEventos, FlujoPoliticas, FlujoLogin} {
+				if err := r.reenviarFlujo(context.Background(), f); err != nil {
+					log.Printf("Reenvío syslog de %s: %v", f, err)
+				}
+			}
+		}
+	}()
+	log.Printf("Reenvío de auditoría a %s activado", destino)
+	return nil
+}
+
+// reenviarFlujo envía el siguiente lote de un flujo y avanza su posición.
+func (r *reenviador) reenviarFlujo(ctx context.Context, f Flujo) error {
+	// La primera vez se empieza por la cabeza actual: no se reenvía el histórico
+	if _, err := db.Pool.Exec(ctx, `
+		INSERT INTO auditoria_reenvio (flujo, ultima_pos)
+		SELECT tabla, ultima_pos FROM cadena_auditoria_estado WHERE tabla = $1
+		ON CONFLICT (flujo) DO NOTHING
+	`, string(f)); err != nil {
+		return err
+	}
+	var desde int64
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT ultima_pos FROM auditoria_reenvio WHERE flujo = $1`, string(f),
+	).Scan(&desde); err != nil {
+		return err
+	}
+
+	ultima := desde
+	errLectura := leerRegistros(ctx, f, FiltroExportacion{}, desde, reenvioLote, func(reg Registro) error {
+		if err := r.enviar(MensajeSyslog(reg, r.host)); err != nil {
+			return err
+		}
+		ultima = reg.Pos
+		return nil
+	})
+	if ultima > desde {
+		if _, err := db.Pool.Exec(ctx, `
+			UPDATE auditoria_reenvio SET ultima_pos = $2, fecha = NOW() WHERE flujo = $1
+		`, string(f), ultima); err != nil {
+			return err
+		}
+	}
+	return errLectura
+}
+
+func (r *reenviador) enviar(msg string) error {
+	if r.conn == nil {
+		c, err := net.DialTimeout(r.red, r.dir, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		r.conn = c
+	}
+	r.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	if r.red == "tcp" {
+		// RFC 6587: entramado por recuento de octetos
+		_, err = fmt.Fprintf(r.conn, "%d %s", len(msg), msg)
+	} else {
+		_, err = r.conn.Write([]byte(msg))
+	}
+	if err != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return err
+}
+
+// MensajeSyslog compone el mensaje RFC 5424 de un registro con carga CEF.
+func MensajeSyslog(reg Registro, host string) string {
+	severidad := 6 // informational
+	if !reg.Exito {
+		severidad = 4 // warning
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s",
+		syslogFacility*8+severidad,
+		reg.Fecha.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		host, syslogApp, reg.Flujo, CEF(reg))
+}
+
+// CEF compone la línea CEF de un registro.
+func CEF(reg Registro) string {
+	severidad := "3"
+	resultado := "success"
+	if !reg.Exito {
+		severidad, resultado = "6", "failure"
+	}
+	ext := map[string]string{
+		"rt":         strconv.FormatInt(reg.Fecha.UnixMilli(), 10),
+		"act":        reg.Accion,
+		"outcome":    resultado,
+		"externalId": string(reg.Flujo) + ":" + strconv.FormatInt(reg.Pos, 10),
+	}
+	opcional := func(clave string, v *string) {
+		if v != nil && *v != "" {
+			ext[clave] = *v
+		}
+	}
+	etiquetado := func(n int, etiqueta string, v *string) {
+		if v != nil && *v != "" {
+			ext["cs"+strconv.Itoa(n)+"Label"] = etiqueta
+			ext["cs"+strconv.Itoa(n)] = *v
+		}
+	}
+	entero := func(n *int) *string {
+		if n == nil {
+			return nil
+		}
+		s := strconv.Itoa(*n)
+		return &s
+	}
+	opcional("suid", reg.Actor)
+	opcional("reason", reg.CodigoMotivo)
+	opcional("msg", reg.Descripcion)
+	opcional("src", reg.IP)
+	opcional("duid", entero(reg.IDTitular))
+	etiquetado(1, "rol", entero(reg.Rol))
+	etiquetado(2, "idSolicitud", reg.IDSolicitud)
+	etiquetado(3, "recurso", reg.Recurso)
+	etiquetado(4, "finalidad", reg.Finalidad)
+	etiquetado(5, "error", reg.Error)
+	if reg.IDRecurso != nil {
+		ext["cn1Label"] = "idRecurso"
+		ext["cn1"] = strconv.Itoa(*reg.IDRecurso)
+	}
+	if len(reg.Politicas) > 0 {
+		ps := make([]string, len(reg.Politicas))
+		for i, p := range reg.Politicas {
+			ps[i] = strconv.Itoa(p)
+		}
+		ext["cs6Label"] = "politicas"
+		ext["cs6"] = strings.Join(ps, ",")
+	}
+
+	claves := make([]string, 0, len(ext))
+	for k := range ext {
+		claves = append(claves, k)
+	}
+	sort.Strings(claves)
+	partes := make([]string, len(claves))
+	for i, k := range claves {
+		partes[i] = k + "=" + escaparExtensionCEF(ext[k])
+	}
+	return strings.Join([]string{"CEF:0", escaparCabeceraCEF(cefProveedor), escaparCabeceraCEF(cefProducto),
+		escaparCabeceraCEF(cefVersion), escaparCabeceraCEF(reg.Accion),
+		escaparCabeceraCEF(string(reg.Flujo) + " " + reg.Accion), severidad,
+		strings.Join(partes, " ")}, "|")
+}
+
+var (
+	cabeceraCEF  = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	extensionCEF = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func escaparCabeceraCEF(s string) string  { return cabeceraCEF.Replace(s) }
+func escaparExtensionCEF(s string) string { return extensionCEF.Replace(s) }
@@ -0,0 +1,44 @@
+package auditoria
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMensajeSyslogCEF(t *testing.T) {
+	actor, motivo, desc := "7", "cuota_excedida", "cuota excedida: 10/60s | a=b"
+	reg := Registro{
+		Flujo: FlujoAccesos, Pos: 42, Fecha: time.Date(2024, 5, 15, 10, 30, 0, 123456789, time.UTC),
+		Actor: &actor, Accion: "ACCESO-ORDINARIO", Exito: false, CodigoMotivo: &motivo,
+		Descripcion: &desc, Politicas: []int{3, 9},
+	}
+	msg := MensajeSyslog(reg, "srv1")
+
+	// facility 13 (log audit) * 8 + warning (4)
+	cabecera := "<108>1 2024-05-15T10:30:00.123456Z srv1 consentimientos - accesos - "
+	if !strings.HasPrefix(msg, cabecera) {
+		t.Fatalf("cabecera RFC 5424 = %q, want prefijo %q", msg, cabecera)
+	}
+	cef := strings.TrimPrefix(msg, cabecera)
+	if !strings.HasPrefix(cef, "CEF:0|SistemaGestionConsentimiento|auditoria|1.0|ACCESO-ORDINARIO|accesos ACCESO-ORDINARIO|6|") {
+		t.Errorf("cabecera CEF = %q", cef)
+	}
+	for _, want := range []string{
+		"outcome=failure", "suid=7", "reason=cuota_excedida", "externalId=accesos:42",
+		`msg=cuota excedida: 10/60s | a\=b`, "cs6=3,9", "cs6Label=politicas",
+	} {
+		if !strings.Contains(cef, want) {
+			t.Errorf("CEF sin %q: %s", want, cef)
+		}
+	}
+}
+
+func TestEscaparCEF(t *testing.T) {
+	if got := escaparCabeceraCEF(`a|b\c`); got != `a\|b\\c` {
+		t.Errorf("escaparCabeceraCEF = %q", got)
+	}
+	if got := escaparExtensionCEF("x=1\nlinea"); got != `x\=1\nlinea` {
+		t.Errorf("escaparExtensionCEF = %q", got)
+	}
+}
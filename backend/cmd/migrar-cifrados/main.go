@@ -0,0 +1,104 @@
+// backend/cmd/migrar-cifrados/main.go
+//
+// Reescribe en su lugar los valores de datos_personales_valores que todavía
+// están guardados como gob directo de abe.FAMECipher, envolviéndolos en el
+// sobre versionado de utils/sobre_cifrado.go. No descifra nada: sólo cambia
+// la codificación, por eso no necesita las claves ABE.
+//
+// Uso (desde backend/):
+//
+//	go run ./cmd/migrar-cifrados            # migra
+//	go run ./cmd/migrar-cifrados -simular   # sólo cuenta
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"backend/db"
+	"backend/utils"
+)
+
+type celda struct {
+	idUsuario int
+	atributo  string
+}
+
+func main() {
+	simular := flag.Bool("simular", false, "no escribe, sólo informa cuántos valores se migrarían")
+	flag.Parse()
+
+	db.ConectarDatosPersonales()
+	defer db.ConnDatos.Close()
+
+	ctx := context.Background()
+	rows, err := db.ConnDatos.Query(ctx, `
+		SELECT id_usuario, atributo FROM datos_personales_valores
+		 ORDER BY id_usuario, atributo
+	`)
+	if err != nil {
+		log.Fatalf("Error listando datos_personales_valores: %v", err)
+	}
+	var celdas []celda
+	for rows.Next() {
+		var c celda
+		if err := rows.Scan(&c.idUsuario, &c.atributo); err == nil {
+			celdas = append(celdas, c)
+		}
+	}
+	rows.Close()
+
+	var migrados, errores int
+	for _, c := range celdas {
+		n, err := migrarValor(ctx, c, *simular)
+		if err != nil {
+			log.Printf("id_usuario=%d atributo=%s: %v", c.idUsuario, c.atributo, err)
+			errores++
+			continue
+		}
+		migrados += n
+	}
+
+	accion := "migrados"
+	if *simular {
+		accion = "por migrar"
+	}
+	fmt.Printf("Valores revisados: %d, %s: %d, errores: %d\n", len(celdas), accion, migrados, errores)
+}
+
+// migrarValor reescribe un valor si todavía está en formato gob. La
+// condición sobre el valor anterior evita pisar una escritura concurrente.
+func migrarValor(ctx context.Context, c celda, simular bool) (int, error) {
+	var valor []byte
+	if err := db.ConnDatos.QueryRow(ctx, `
+		SELECT valor FROM datos_personales_valores
+		 WHERE id_usuario = $1 AND atributo = $2
+	`, c.idUsuario, c.atributo).Scan(&valor); err != nil {
+		return 0, err
+	}
+	if len(valor) == 0 || utils.EsSobre(valor) {
+		return 0, nil
+	}
+
+	sobre, err := utils.AbrirSobre(valor)
+	if err != nil {
+		return 0, err
+	}
+	if simular {
+		return 1, nil
+	}
+	nuevo, err := utils.SerializarCipher(sobre.Cipher, sobre.Politica)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := db.ConnDatos.Exec(ctx, `
+		UPDATE datos_personales_valores SET valor = $1
+		 WHERE id_usuario = $2 AND atributo = $3 AND valor = $4
+	`, nuevo, c.idUsuario, c.atributo, valor)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,176 @@
+// backend/cmd/rotar-indice-ciego/main.go
+//
+// Mantenimiento de los índices ciegos (utils/indice_ciego.go). Rotación completa:
+//
+//	go run ./cmd/rotar-indice-ciego -rotar        # 1) nueva clave activa
+//	(reiniciar el backend para que escriba con la clave nueva)
+//	go run ./cmd/rotar-indice-ciego -reindexar -retirar
+//	                                              # 2) reindexar y retirar las anteriores
+//
+// -reindexar solo también sirve para indexar un atributo recién marcado
+// como buscable. Reindexar necesita descifrar, por eso usa la clave maestra.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"backend/db"
+	"backend/utils"
+)
+
+func main() {
+	rotar := flag.Bool("rotar", false, "genera una nueva clave de índice y la deja activa")
+	reindexar := flag.Bool("reindexar", false, "recalcula los índices de los atributos buscables con la clave activa")
+	retirar := flag.Bool("retirar", false, "borra índices y claves de versiones anteriores a la activa")
+	flag.Parse()
+	if !*rotar && !*reindexar && !*retirar {
+		flag.Usage()
+		return
+	}
+
+	if *rotar {
+		if err := utils.CargarClavesIndice(); err != nil {
+			log.Printf("No hay claves previas (%v); se crea la primera", err)
+		}
+		v, err := utils.RotarClaveIndice()
+		if err != nil {
+			log.Fatalf("Error rotando clave de índice: %v", err)
+		}
+		fmt.Printf("Clave de índice activa: versión %d. Reinicie el backend antes de reindexar.\n", v)
+	}
+	if !*reindexar && !*retirar {
+		return
+	}
+
+	if err := utils.CargarClavesIndice(); err != nil {
+		log.Fatalf("Error cargando claves de índice: %v", err)
+	}
+	db.ConectarDB()
+	defer db.Pool.Close()
+	db.ConectarDatosPersonales()
+	defer db.ConnDatos.Close()
+	ctx := context.Background()
+
+	if *reindexar {
+		if !utils.CargarClavesABE() {
+			log.Fatal("No se pudieron cargar las claves ABE")
+		}
+		n, errores, err := reindexarTodo(ctx)
+		if err != nil {
+			log.Fatalf("Error reindexando: %v", err)
+		}
+		fmt.Printf("Valores reindexados: %d, errores: %d\n", n, errores)
+	}
+
+	if *retirar {
+		if err := retirarVersiones(ctx); err != nil {
+			log.Fatalf("No se retiraron las claves anteriores: %v", err)
+		}
+		fmt.Println("Versiones anteriores retiradas.")
+	}
+}
+
+func reindexarTodo(ctx context.Context) (int, int, error) {
+	var buscables []string
+	rows, err := db.Pool.Query(ctx, `SELECT nombre FROM atributos_datos WHERE buscable AND activo`)
+	if err != nil {
+		return 0, 0, err
+	}
+	for rows.Next() {
+		var nombre string
+		if err := rows.Scan(&nombre); err == nil {
+			buscables = append(buscables, nombre)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.ConnDatos.Query(ctx, `
+		SELECT id_usuario, atributo, valor FROM datos_personales_valores
+		 WHERE atributo = ANY($1)
+	`, buscables)
+	if err != nil {
+		return 0, 0, err
+	}
+	type fila struct {
+		idUsuario int
+		atributo  string
+		valor     []byte
+	}
+	var filas []fila
+	for rows.Next() {
+		var f fila
+		if err := rows.Scan(&f.idUsuario, &f.atributo, &f.valor); err == nil {
+			filas = append(filas, f)
+		}
+	}
+	rows.Close()
+
+	var n, errores int
+	for _, f := range filas {
+		ciph, err := utils.DeserializarCipher(f.valor)
+		if err != nil {
+			log.Printf("id_usuario=%d atributo=%s: %v", f.idUsuario, f.atributo, err)
+			errores++
+			continue
+		}
+		// Toda política incluye owner:<id>, así que basta ese atributo
+		plano, err := utils.DescifrarDatoABEConMaster(ciph, []string{utils.AtributoOwner(f.idUsuario)})
+		if err != nil {
+			log.Printf("id_usuario=%d atributo=%s: %v", f.idUsuario, f.atributo, err)
+			errores++
+			continue
+		}
+		if utils.NormalizarValorIndice(plano) == "" {
+			continue
+		}
+		version, indice, err := utils.IndiceCiego(f.atributo, plano)
+		if err != nil {
+			return n, errores, err
+		}
+		if _, err := db.ConnDatos.Exec(ctx, `
+			INSERT INTO indices_ciegos (id_usuario, atributo, version_clave, indice)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id_usuario, atributo, version_clave) DO UPDATE
+			  SET indice = EXCLUDED.indice
+		`, f.idUsuario, f.atributo, int(version), indice); err != nil {
+			log.Printf("id_usuario=%d atributo=%s: %v", f.idUsuario, f.atributo, err)
+			errores++
+			continue
+		}
+		n++
+	}
+	return n, errores, nil
+}
+
+// retirarVersiones sólo borra lo antiguo si todo valor indexado ya tiene
+// índice con la versión activa; si no, habría titulares imposibles de encontrar.
+func retirarVersiones(ctx context.Context) error {
+	versiones := utils.VersionesClaveIndice()
+	activa := int(versiones[len(versiones)-1])
+
+	var pendientes int
+	if err := db.ConnDatos.QueryRow(ctx, `
+		SELECT COUNT(*)
+		  FROM (SELECT DISTINCT id_usuario, atributo FROM indices_ciegos) t
+		 WHERE NOT EXISTS (
+		       SELECT 1 FROM indices_ciegos i
+		        WHERE i.id_usuario = t.id_usuario
+		          AND i.atributo   = t.atributo
+		          AND i.version_clave = $1)
+	`, activa).Scan(&pendientes); err != nil {
+		return err
+	}
+	if pendientes > 0 {
+		return fmt.Errorf("%d valores sin índice en la versión %d; ejecute -reindexar", pendientes, activa)
+	}
+
+	if _, err := db.ConnDatos.Exec(ctx,
+		`DELETE FROM indices_ciegos WHERE version_clave <> $1`, activa,
+	); err != nil {
+		return err
+	}
+	return utils.RetirarClavesIndice()
+}
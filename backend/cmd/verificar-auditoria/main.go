@@ -0,0 +1,64 @@
+// backend/cmd/verificar-auditoria/main.go
+//
+// Verifica la cadena de hashes de las tablas de auditoría (utils/cadena_auditoria.go):
+//
+//	go run ./cmd/verificar-auditoria                  # todas las tablas
+//	go run ./cmd/verificar-auditoria -tabla accesos   # una sola
+//	go run ./cmd/verificar-auditoria -checkpoint      # firma ahora la cabeza de cada cadena
+//
+// Necesita auditoria_firma.key (la del backend). Sale con código 1 si alguna
+// cadena está rota e indica el primer eslabón que no verifica.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backend/db"
+	"backend/utils"
+)
+
+func main() {
+	tabla := flag.String("tabla", "", "verifica sólo esta tabla")
+	checkpoint := flag.Bool("checkpoint", false, "firma un checkpoint de cada cadena antes de verificar")
+	flag.Parse()
+
+	if err := utils.CargarFirmaAuditoria(); err != nil {
+		log.Fatalf("Error cargando la clave de firma de auditoría: %v", err)
+	}
+	db.ConectarDB()
+	defer db.Pool.Close()
+	ctx := context.Background()
+
+	if *checkpoint {
+		if err := utils.CrearCheckpointsAuditoria(ctx); err != nil {
+			log.Fatalf("Error firmando checkpoints: %v", err)
+		}
+		fmt.Println("Checkpoints firmados.")
+	}
+
+	tablas := utils.TablasAuditoria
+	if *tabla != "" {
+		tablas = []string{*tabla}
+	}
+	rotas := 0
+	for _, t := range tablas {
+		res, err := utils.VerificarCadenaAuditoria(ctx, t)
+		if err != nil {
+			log.Fatalf("Error verificando %s: %v", t, err)
+		}
+		if res.Integra {
+			fmt.Printf("%-20s íntegra   %d eslabones (%d–%d), %d checkpoints\n",
+				t, res.Eslabones, res.PrimeraPos, res.UltimaPos, res.Checkpoints)
+			continue
+		}
+		rotas++
+		fmt.Printf("%-20s ROTA      eslabón %d: %s\n", t, res.Ruptura.Pos, res.Ruptura.Motivo)
+	}
+	if rotas > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,11 @@
+-- Base: consentimientos
+-- Claves FAME personales de los titulares ("owner:<id>"), envueltas con
+-- AES-GCM bajo una clave derivada de la contraseña (ver utils/claves_titular.go).
+CREATE TABLE IF NOT EXISTS claves_titular (
+    id_usuario        INTEGER PRIMARY KEY REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    clave_envuelta    BYTEA       NOT NULL,
+    nonce             BYTEA       NOT NULL,
+    salt_kdf          BYTEA       NOT NULL,
+    version_clave_abe INTEGER     NOT NULL DEFAULT 1,
+    fecha_emision     TIMESTAMP   NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,19 @@
+-- Base: consentimientos
+-- Reglas de tipo y validación en el catálogo de datos personales.
+ALTER TABLE atributos_datos
+    ADD COLUMN IF NOT EXISTS tipo         VARCHAR(20) NOT NULL DEFAULT 'texto',
+    ADD COLUMN IF NOT EXISTS obligatorio  BOOLEAN     NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS patron       TEXT,
+    ADD COLUMN IF NOT EXISTS opciones     TEXT[],
+    ADD COLUMN IF NOT EXISTS longitud_max INTEGER,
+    ADD COLUMN IF NOT EXISTS activo       BOOLEAN     NOT NULL DEFAULT TRUE;
+
+ALTER TABLE atributos_datos
+    ADD CONSTRAINT atributos_datos_tipo_chk
+    CHECK (tipo IN ('texto', 'numero', 'fecha', 'telefono', 'email', 'opcion'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS atributos_datos_nombre_uq ON atributos_datos (nombre);
+
+-- Tipos de los atributos que antes estaban fijos en el código
+UPDATE atributos_datos SET tipo = 'telefono' WHERE nombre IN ('telefono', 'celular');
+UPDATE atributos_datos SET tipo = 'fecha'    WHERE nombre = 'fecha_nacimiento';
@@ -0,0 +1,33 @@
+-- Base: datos_personales
+-- Un valor cifrado por atributo del catálogo, en lugar de ocho columnas fijas.
+CREATE TABLE IF NOT EXISTS datos_personales_valores (
+    id_usuario          INTEGER     NOT NULL,
+    atributo            VARCHAR(64) NOT NULL,
+    valor               BYTEA       NOT NULL,
+    fecha_actualizacion TIMESTAMP   NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id_usuario, atributo)
+);
+
+-- Copiar los valores existentes (los sobres/gob se copian tal cual, sin descifrar)
+INSERT INTO datos_personales_valores (id_usuario, atributo, valor, fecha_actualizacion)
+SELECT d.id_usuario, v.atributo, v.valor, d.fecha_creacion
+  FROM datos_personales d
+  CROSS JOIN LATERAL (VALUES
+        ('telefono', d.telefono), ('celular', d.celular),
+        ('direccion', d.direccion), ('ciudad', d.ciudad),
+        ('provincia', d.provincia), ('fecha_nacimiento', d.fecha_nacimiento),
+        ('genero', d.genero), ('estado_civil', d.estado_civil)
+  ) AS v(atributo, valor)
+ WHERE v.valor IS NOT NULL AND length(v.valor) > 0
+ON CONFLICT DO NOTHING;
+
+-- datos_personales queda como cabecera (id_dato, id_usuario, fecha_creacion)
+ALTER TABLE datos_personales
+    ALTER COLUMN telefono         DROP NOT NULL,
+    ALTER COLUMN celular          DROP NOT NULL,
+    ALTER COLUMN direccion        DROP NOT NULL,
+    ALTER COLUMN ciudad           DROP NOT NULL,
+    ALTER COLUMN provincia        DROP NOT NULL,
+    ALTER COLUMN fecha_nacimiento DROP NOT NULL,
+    ALTER COLUMN genero           DROP NOT NULL,
+    ALTER COLUMN estado_civil     DROP NOT NULL;
@@ -0,0 +1,6 @@
+-- Base: consentimientos
+-- Atributos del catálogo que llevan índice ciego (ver utils/indice_ciego.go).
+ALTER TABLE atributos_datos
+    ADD COLUMN IF NOT EXISTS buscable BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE atributos_datos SET buscable = TRUE WHERE nombre IN ('ciudad', 'provincia');
@@ -0,0 +1,14 @@
+-- Base: datos_personales
+-- HMAC del valor normalizado, por versión de clave de índice.
+CREATE TABLE IF NOT EXISTS indices_ciegos (
+    id_usuario    INTEGER     NOT NULL,
+    atributo      VARCHAR(64) NOT NULL,
+    version_clave SMALLINT    NOT NULL,
+    indice        BYTEA       NOT NULL,
+    PRIMARY KEY (id_usuario, atributo, version_clave)
+);
+
+CREATE INDEX IF NOT EXISTS indices_ciegos_busqueda_idx
+    ON indices_ciegos (atributo, version_clave, indice);
+
+-- Las filas existentes se indexan con: go run ./cmd/rotar-indice-ciego -reindexar
@@ -0,0 +1,45 @@
+-- Base: consentimientos
+-- Modo de acceso por política: los procesadores de una política
+-- "seudonimizado" reciben seudónimos en lugar de identificadores.
+ALTER TABLE politicas_privacidad
+    ADD COLUMN IF NOT EXISTS modo_acceso          VARCHAR(20) NOT NULL DEFAULT 'identificado',
+    ADD COLUMN IF NOT EXISTS generalizacion_fecha VARCHAR(20) NOT NULL DEFAULT 'anio';
+
+ALTER TABLE politicas_privacidad
+    ADD CONSTRAINT politicas_modo_acceso_chk
+    CHECK (modo_acceso IN ('identificado', 'seudonimizado')),
+    ADD CONSTRAINT politicas_generalizacion_fecha_chk
+    CHECK (generalizacion_fecha IN ('anio', 'rango_edad'));
+
+-- Qué hacer con cada atributo en modo seudonimizado
+ALTER TABLE atributos_datos
+    ADD COLUMN IF NOT EXISTS clase_privacidad VARCHAR(20) NOT NULL DEFAULT 'ninguna';
+
+ALTER TABLE atributos_datos
+    ADD CONSTRAINT atributos_clase_privacidad_chk
+    CHECK (clase_privacidad IN ('ninguna', 'identificador', 'cuasi_identificador'));
+
+UPDATE atributos_datos SET clase_privacidad = 'identificador'
+ WHERE nombre IN ('telefono', 'celular', 'direccion');
+UPDATE atributos_datos SET clase_privacidad = 'cuasi_identificador'
+ WHERE nombre = 'fecha_nacimiento';
+
+-- Seudónimo entregado a cada procesador por titular, para poder reidentificar
+CREATE TABLE IF NOT EXISTS seudonimos (
+    id_procesador  INTEGER     NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    id_titular     INTEGER     NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    seudonimo      VARCHAR(40) NOT NULL UNIQUE,
+    fecha_creacion TIMESTAMP   NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id_procesador, id_titular)
+);
+
+-- Cada reidentificación hecha por un controlador, con su justificación
+CREATE TABLE IF NOT EXISTS reidentificaciones (
+    id_reidentificacion SERIAL PRIMARY KEY,
+    id_controlador      INTEGER   NOT NULL REFERENCES usuarios(id_usuario),
+    id_procesador       INTEGER   NOT NULL,
+    id_titular          INTEGER   NOT NULL,
+    seudonimo           VARCHAR(40) NOT NULL,
+    justificacion       TEXT      NOT NULL,
+    fecha               TIMESTAMP NOT NULL DEFAULT NOW()
+);
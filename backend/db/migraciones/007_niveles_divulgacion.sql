@@ -0,0 +1,12 @@
+-- Base: consentimientos
+-- Cuánto de cada atributo divulga una política (ver utils/redaccion.go).
+ALTER TABLE politica_atributo
+    ADD COLUMN IF NOT EXISTS nivel_divulgacion VARCHAR(20) NOT NULL DEFAULT 'completo';
+
+ALTER TABLE politica_atributo
+    ADD CONSTRAINT politica_atributo_nivel_chk
+    CHECK (nivel_divulgacion IN ('completo', 'enmascarado', 'generalizado', 'presencia'));
+
+-- Nivel efectivamente aplicado a cada campo entregado: {"celular": "enmascarado", ...}
+ALTER TABLE accesos
+    ADD COLUMN IF NOT EXISTS niveles_aplicados JSONB;
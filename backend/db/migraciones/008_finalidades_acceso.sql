@@ -0,0 +1,38 @@
+-- Base: consentimientos
+-- Finalidades de tratamiento: catálogo, finalidades declaradas por política
+-- y finalidad/justificación declaradas en cada acceso.
+CREATE TABLE IF NOT EXISTS finalidades (
+    codigo      VARCHAR(40) PRIMARY KEY,
+    descripcion TEXT        NOT NULL
+);
+
+INSERT INTO finalidades (codigo, descripcion) VALUES
+    ('prestacion_servicio', 'Prestación del servicio contratado'),
+    ('facturacion',         'Facturación y cobro'),
+    ('soporte',             'Atención y soporte al titular'),
+    ('marketing',           'Comunicaciones comerciales'),
+    ('investigacion',       'Investigación y estadística'),
+    ('cumplimiento_legal',  'Cumplimiento de obligaciones legales')
+ON CONFLICT (codigo) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS politica_finalidad (
+    id_politica INTEGER     NOT NULL REFERENCES politicas_privacidad(id_politica) ON DELETE CASCADE,
+    codigo      VARCHAR(40) NOT NULL REFERENCES finalidades(codigo),
+    PRIMARY KEY (id_politica, codigo)
+);
+
+-- Las políticas existentes no declaraban finalidad y cada acceso exige una
+-- declarada: se les asigna prestacion_servicio para que los procesadores no
+-- queden sin acceso al desplegar. Sólo mientras la tabla está vacía, para no
+-- pisar lo que luego declare el controlador.
+INSERT INTO politica_finalidad (id_politica, codigo)
+SELECT p.id_politica, 'prestacion_servicio'
+  FROM politicas_privacidad p
+ WHERE NOT EXISTS (SELECT 1 FROM politica_finalidad)
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE accesos
+    ADD COLUMN IF NOT EXISTS finalidad     VARCHAR(40),
+    ADD COLUMN IF NOT EXISTS justificacion TEXT;
+
+CREATE INDEX IF NOT EXISTS accesos_finalidad_idx ON accesos (finalidad, fecha_evento DESC);
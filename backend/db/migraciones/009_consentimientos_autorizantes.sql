@@ -0,0 +1,12 @@
+-- Base: consentimientos
+-- Un acceso puede estar autorizado por varios consentimientos a la vez
+-- (unión de atributos de todas las políticas que coinciden con el tercero).
+ALTER TABLE accesos
+    ADD COLUMN IF NOT EXISTS consentimientos_autorizantes INTEGER[];
+
+-- Filas anteriores: el único consentimiento evaluado
+UPDATE accesos
+   SET consentimientos_autorizantes = ARRAY[id_consentimiento]
+ WHERE consentimientos_autorizantes IS NULL
+   AND exito
+   AND id_consentimiento IS NOT NULL;
@@ -0,0 +1,30 @@
+-- Base: consentimientos
+-- Cuotas de acceso por procesador y por política. Una cuota sin
+-- id_procesador ni id_politica es la cuota por defecto de todo procesador.
+CREATE TABLE IF NOT EXISTS cuotas_acceso (
+    id_cuota          SERIAL PRIMARY KEY,
+    id_procesador     INTEGER REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    id_politica       INTEGER REFERENCES politicas_privacidad(id_politica) ON DELETE CASCADE,
+    max_solicitudes   INTEGER CHECK (max_solicitudes > 0),
+    ventana_segundos  INTEGER NOT NULL DEFAULT 3600 CHECK (ventana_segundos > 0),
+    max_titulares_dia INTEGER CHECK (max_titulares_dia > 0),
+    activo            BOOLEAN NOT NULL DEFAULT TRUE,
+    ultima_alerta     TIMESTAMP,
+    fecha_creacion    TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (max_solicitudes IS NOT NULL OR max_titulares_dia IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_cuotas_acceso_procesador ON cuotas_acceso (id_procesador) WHERE activo;
+CREATE INDEX IF NOT EXISTS idx_cuotas_acceso_politica   ON cuotas_acceso (id_politica)   WHERE activo;
+
+-- El titular de cada acceso, para contar titulares distintos sin depender
+-- del consentimiento (los accesos denegados pueden no tener uno)
+ALTER TABLE accesos ADD COLUMN IF NOT EXISTS id_titular INTEGER;
+
+UPDATE accesos a
+   SET id_titular = c.id_usuario
+  FROM consentimientos c
+ WHERE c.id_consentimiento = a.id_consentimiento
+   AND a.id_titular IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_accesos_solicitante_fecha ON accesos (id_solicitante, fecha_evento);
@@ -0,0 +1,40 @@
+-- Base: consentimientos
+-- Acceso de emergencia (break-glass): un controlador accede a los datos de
+-- un titular sin consentimiento que lo cubra, con base legal, por tiempo
+-- limitado y con revisión posterior obligatoria de la APD.
+CREATE TABLE IF NOT EXISTS bases_legales_emergencia (
+    codigo      VARCHAR(40) PRIMARY KEY,
+    descripcion TEXT        NOT NULL
+);
+
+INSERT INTO bases_legales_emergencia (codigo, descripcion) VALUES
+    ('orden_judicial',          'Orden o requerimiento judicial'),
+    ('interes_vital',           'Protección de intereses vitales del titular o de terceros'),
+    ('obligacion_legal',        'Cumplimiento de una obligación legal del responsable'),
+    ('requerimiento_autoridad', 'Requerimiento de autoridad competente')
+ON CONFLICT (codigo) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS accesos_emergencia (
+    id_emergencia    SERIAL PRIMARY KEY,
+    id_controlador   INTEGER     NOT NULL REFERENCES usuarios(id_usuario),
+    id_titular       INTEGER     NOT NULL REFERENCES usuarios(id_usuario),
+    base_legal       VARCHAR(40) NOT NULL REFERENCES bases_legales_emergencia(codigo),
+    justificacion    TEXT        NOT NULL,
+    fecha_inicio     TIMESTAMP   NOT NULL DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP   NOT NULL,
+    revocado         BOOLEAN     NOT NULL DEFAULT FALSE,
+    -- Revisión posterior por la APD
+    estado_revision  VARCHAR(20) NOT NULL DEFAULT 'pendiente'
+                     CHECK (estado_revision IN ('pendiente', 'justificado', 'injustificado')),
+    id_revisor       INTEGER REFERENCES usuarios(id_usuario),
+    dictamen         TEXT,
+    fecha_revision   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_accesos_emergencia_revision ON accesos_emergencia (estado_revision);
+
+-- Cada acceso se etiqueta como ordinario o de emergencia
+ALTER TABLE accesos
+    ADD COLUMN IF NOT EXISTS tipo_acceso   VARCHAR(20) NOT NULL DEFAULT 'ordinario'
+                                           CHECK (tipo_acceso IN ('ordinario', 'emergencia')),
+    ADD COLUMN IF NOT EXISTS id_emergencia INTEGER REFERENCES accesos_emergencia(id_emergencia);
@@ -0,0 +1,13 @@
+-- Base: consentimientos
+-- Condiciones que el titular añade a un consentimiento: procesadores
+-- excluidos, días y franja horaria permitidos y un máximo de accesos.
+CREATE TABLE IF NOT EXISTS condiciones_consentimiento (
+    id_consentimiento      INTEGER PRIMARY KEY REFERENCES consentimientos(id_consentimiento) ON DELETE CASCADE,
+    procesadores_excluidos INTEGER[] NOT NULL DEFAULT '{}',
+    dias_permitidos        SMALLINT[],          -- ISO: 1 = lunes … 7 = domingo; NULL = todos
+    hora_desde             TIME,
+    hora_hasta             TIME,
+    max_accesos            INTEGER CHECK (max_accesos > 0),
+    fecha_actualizacion    TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((hora_desde IS NULL) = (hora_hasta IS NULL))
+);
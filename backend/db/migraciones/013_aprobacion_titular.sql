@@ -0,0 +1,26 @@
+-- Base: consentimientos
+-- Aprobación just-in-time: en políticas sensibles cada acceso de un
+-- procesador necesita una solicitud aprobada por el titular, de un solo uso.
+ALTER TABLE politicas_privacidad
+    ADD COLUMN IF NOT EXISTS requiere_aprobacion BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS solicitudes_acceso_titular (
+    id_solicitud     SERIAL PRIMARY KEY,
+    id_procesador    INTEGER     NOT NULL REFERENCES usuarios(id_usuario),
+    id_titular       INTEGER     NOT NULL REFERENCES usuarios(id_usuario),
+    campos           TEXT[]      NOT NULL,
+    finalidad        VARCHAR(40) NOT NULL REFERENCES finalidades(codigo),
+    justificacion    TEXT        NOT NULL,
+    estado           VARCHAR(20) NOT NULL DEFAULT 'pendiente'
+                     CHECK (estado IN ('pendiente', 'aprobada', 'denegada', 'usada')),
+    fecha_creacion   TIMESTAMP   NOT NULL DEFAULT NOW(),
+    fecha_expiracion TIMESTAMP   NOT NULL,
+    fecha_respuesta  TIMESTAMP,
+    motivo_respuesta TEXT,
+    fecha_uso        TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_solicitudes_acceso_titular_pend
+    ON solicitudes_acceso_titular (id_titular, estado);
+CREATE INDEX IF NOT EXISTS idx_solicitudes_acceso_titular_proc
+    ON solicitudes_acceso_titular (id_procesador, id_titular, estado);
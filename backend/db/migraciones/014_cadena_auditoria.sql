@@ -0,0 +1,99 @@
+-- Base: consentimientos
+-- Cadena de auditoría a prueba de manipulación: cada fila de accesos,
+-- auditoria_eventos, auditoria_politicas y auditoria_login se encadena a la
+-- anterior de su tabla con SHA-256:
+--
+--   hash = sha256(hash_anterior || auditoria_canonica(fila))
+--
+-- donde auditoria_canonica es el jsonb de la fila sin hash/hash_anterior y
+-- sin claves nulas (así añadir columnas nulables no altera filas antiguas).
+-- Lo calcula un trigger, de modo que cubre todos los INSERT existentes.
+-- Periódicamente el backend firma checkpoints (posición + hash) con una clave
+-- del servidor; ver utils/cadena_auditoria.go y cmd/verificar-auditoria.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+-- Cabeza de cada cadena. Su fila se bloquea en cada INSERT y serializa el
+-- encadenamiento aunque haya transacciones concurrentes.
+CREATE TABLE IF NOT EXISTS cadena_auditoria_estado (
+    tabla       VARCHAR(40) PRIMARY KEY,
+    ultima_pos  BIGINT      NOT NULL DEFAULT 0,
+    ultimo_hash BYTEA       NOT NULL DEFAULT decode(repeat('00', 32), 'hex')
+);
+
+INSERT INTO cadena_auditoria_estado (tabla) VALUES
+    ('accesos'), ('auditoria_eventos'), ('auditoria_politicas'), ('auditoria_login')
+ON CONFLICT (tabla) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS cadena_auditoria_checkpoints (
+    id_checkpoint SERIAL PRIMARY KEY,
+    tabla         VARCHAR(40) NOT NULL REFERENCES cadena_auditoria_estado(tabla),
+    cadena_pos    BIGINT      NOT NULL,
+    hash          BYTEA       NOT NULL,
+    fecha         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    firma         BYTEA       NOT NULL,
+    UNIQUE (tabla, cadena_pos)
+);
+
+CREATE OR REPLACE FUNCTION auditoria_canonica(fila JSONB) RETURNS TEXT AS $$
+    SELECT jsonb_strip_nulls(fila - 'hash' - 'hash_anterior')::text
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION encadenar_auditoria() RETURNS TRIGGER AS $$
+DECLARE
+    pos  BIGINT;
+    prev BYTEA;
+BEGIN
+    SELECT ultima_pos, ultimo_hash INTO pos, prev
+      FROM cadena_auditoria_estado
+     WHERE tabla = TG_TABLE_NAME
+       FOR UPDATE;
+
+    NEW.cadena_pos    := pos + 1;
+    NEW.hash_anterior := prev;
+    NEW.hash          := digest(prev || convert_to(auditoria_canonica(to_jsonb(NEW)), 'UTF8'), 'sha256');
+
+    UPDATE cadena_auditoria_estado
+       SET ultima_pos = NEW.cadena_pos, ultimo_hash = NEW.hash
+     WHERE tabla = TG_TABLE_NAME;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Columnas, encadenado de las filas ya existentes (en orden físico) y trigger
+DO $$
+DECLARE
+    t    TEXT;
+    r    RECORD;
+    pos  BIGINT;
+    prev BYTEA;
+    h    BYTEA;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['accesos', 'auditoria_eventos', 'auditoria_politicas', 'auditoria_login'] LOOP
+        EXECUTE format('ALTER TABLE %I
+                          ADD COLUMN IF NOT EXISTS cadena_pos    BIGINT,
+                          ADD COLUMN IF NOT EXISTS hash_anterior BYTEA,
+                          ADD COLUMN IF NOT EXISTS hash          BYTEA', t);
+        EXECUTE format('CREATE UNIQUE INDEX IF NOT EXISTS %I ON %I (cadena_pos)',
+                       'idx_' || t || '_cadena_pos', t);
+
+        SELECT ultima_pos, ultimo_hash INTO pos, prev
+          FROM cadena_auditoria_estado WHERE tabla = t FOR UPDATE;
+
+        FOR r IN EXECUTE format(
+            'SELECT ctid AS fila_ctid, to_jsonb(x) AS fila FROM %I x WHERE cadena_pos IS NULL ORDER BY ctid', t)
+        LOOP
+            pos := pos + 1;
+            h := digest(prev || convert_to(auditoria_canonica(r.fila || jsonb_build_object('cadena_pos', pos)), 'UTF8'), 'sha256');
+            EXECUTE format('UPDATE %I SET cadena_pos = $1, hash_anterior = $2, hash = $3 WHERE ctid = $4', t)
+              USING pos, prev, h, r.fila_ctid;
+            prev := h;
+        END LOOP;
+
+        UPDATE cadena_auditoria_estado SET ultima_pos = pos, ultimo_hash = prev WHERE tabla = t;
+
+        EXECUTE format('DROP TRIGGER IF EXISTS trg_encadenar_auditoria ON %I', t);
+        EXECUTE format('CREATE TRIGGER trg_encadenar_auditoria BEFORE INSERT ON %I
+                        FOR EACH ROW EXECUTE FUNCTION encadenar_auditoria()', t);
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,24 @@
+-- Base: consentimientos
+-- Auditoría unificada (paquete auditoria): las cuatro tablas de auditoría
+-- guardan también el rol del actor, un código de motivo, el ID de la
+-- solicitud HTTP y la IP de origen. Son columnas nulables sin valor por
+-- defecto, así que no cambian el hash de las filas ya encadenadas (014).
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY['accesos', 'auditoria_eventos', 'auditoria_politicas', 'auditoria_login'] LOOP
+        EXECUTE format('ALTER TABLE %I
+                          ADD COLUMN IF NOT EXISTS rol_actor     SMALLINT,
+                          ADD COLUMN IF NOT EXISTS codigo_motivo VARCHAR(60),
+                          ADD COLUMN IF NOT EXISTS id_solicitud  VARCHAR(64),
+                          ADD COLUMN IF NOT EXISTS ip_origen     VARCHAR(45)', t);
+    END LOOP;
+END;
+$$;
+
+-- Los intentos de login con un email desconocido se auditan sin usuario
+ALTER TABLE auditoria_login ALTER COLUMN id_usuario DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_auditoria_eventos_solicitud ON auditoria_eventos (id_solicitud);
+CREATE INDEX IF NOT EXISTS idx_accesos_solicitud           ON accesos (id_solicitud);
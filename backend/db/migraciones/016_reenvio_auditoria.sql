@@ -0,0 +1,9 @@
+-- Base: consentimientos
+-- Reenvío de auditoría a syslog (auditoria/syslog.go): último eslabón de
+-- cada flujo entregado al colector, para retomar tras un reinicio sin
+-- perder ni repetir eventos más allá del último lote.
+CREATE TABLE IF NOT EXISTS auditoria_reenvio (
+    flujo       VARCHAR(40) PRIMARY KEY REFERENCES cadena_auditoria_estado(tabla),
+    ultima_pos  BIGINT      NOT NULL,
+    fecha       TIMESTAMP   NOT NULL DEFAULT NOW()
+);
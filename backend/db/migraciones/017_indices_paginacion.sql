@@ -0,0 +1,16 @@
+-- Base: consentimientos
+-- Índices para los listados paginados (handlers/paginacion.go): el orden
+-- por defecto (fecha descendente + id de desempate) y los filtros comunes.
+CREATE INDEX IF NOT EXISTS idx_consentimientos_fecha_id  ON consentimientos (fecha_otorgado DESC, id_consentimiento DESC);
+CREATE INDEX IF NOT EXISTS idx_consentimientos_usuario   ON consentimientos (id_usuario);
+CREATE INDEX IF NOT EXISTS idx_consentimientos_politica  ON consentimientos (id_politica, estado);
+CREATE INDEX IF NOT EXISTS idx_consentimientos_estado    ON consentimientos (estado);
+
+CREATE INDEX IF NOT EXISTS idx_auditoria_eventos_fecha_id ON auditoria_eventos (fecha_evento DESC, id_evento DESC);
+CREATE INDEX IF NOT EXISTS idx_auditoria_eventos_usuario  ON auditoria_eventos (id_usuario, fecha_evento);
+CREATE INDEX IF NOT EXISTS idx_auditoria_eventos_registro ON auditoria_eventos (tabla_afectada, registro_id);
+
+CREATE INDEX IF NOT EXISTS idx_accesos_fecha_id         ON accesos (fecha_evento DESC, id_acceso DESC);
+CREATE INDEX IF NOT EXISTS idx_accesos_titular_fecha    ON accesos (id_titular, fecha_evento);
+CREATE INDEX IF NOT EXISTS idx_accesos_consentimiento   ON accesos (id_consentimiento);
+CREATE INDEX IF NOT EXISTS idx_accesos_autorizantes     ON accesos USING GIN (consentimientos_autorizantes);
@@ -0,0 +1,49 @@
+-- Base: consentimientos
+-- Retención de auditoría (auditoria/retencion.go): cada flujo guarda sus
+-- filas en línea durante "dias"; pasado ese plazo, cada mes vencido se
+-- archiva en un NDJSON comprimido y firmado (disco local o S3) y se poda
+-- de la tabla. En las tablas encadenadas se firma antes un checkpoint del
+-- último eslabón podado, de modo que la cadena restante sigue anclada.
+CREATE TABLE IF NOT EXISTS auditoria_retencion (
+    flujo               VARCHAR(40) PRIMARY KEY,
+    dias                INTEGER     NOT NULL CHECK (dias > 0),
+    fecha_actualizacion TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO auditoria_retencion (flujo, dias) VALUES
+    ('accesos',             1825),
+    ('auditoria_eventos',   1825),
+    ('auditoria_politicas', 3650),
+    ('auditoria_login',      365),
+    ('notificaciones',       180)
+ON CONFLICT (flujo) DO NOTHING;
+
+-- Un archivo por flujo y mes. pos_desde/pos_hasta son cadena_pos (o
+-- id_notificacion); hash_ultimo es el hash del último eslabón archivado.
+CREATE TABLE IF NOT EXISTS auditoria_archivos (
+    id_archivo         SERIAL PRIMARY KEY,
+    flujo              VARCHAR(40) NOT NULL REFERENCES auditoria_retencion(flujo),
+    mes                DATE        NOT NULL,
+    ubicacion          TEXT        NOT NULL,
+    pos_desde          BIGINT      NOT NULL,
+    pos_hasta          BIGINT      NOT NULL,
+    fecha_desde        TIMESTAMP   NOT NULL,
+    fecha_hasta        TIMESTAMP   NOT NULL,
+    filas              BIGINT      NOT NULL,
+    sha256             BYTEA       NOT NULL,
+    hash_ultimo        BYTEA,
+    firma              BYTEA       NOT NULL,
+    fecha_archivo      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    fecha_restauracion TIMESTAMPTZ,
+    restaurado_por     INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_auditoria_archivos_flujo ON auditoria_archivos (flujo, pos_hasta);
+
+-- Filas de un archivo restauradas para una investigación. Quedan fuera de
+-- las tablas en línea: no se reencadenan ni cuentan en cuotas.
+CREATE TABLE IF NOT EXISTS auditoria_restaurada (
+    id_archivo INTEGER NOT NULL REFERENCES auditoria_archivos(id_archivo) ON DELETE CASCADE,
+    orden      BIGINT  NOT NULL,
+    fila       JSONB   NOT NULL,
+    PRIMARY KEY (id_archivo, orden)
+);
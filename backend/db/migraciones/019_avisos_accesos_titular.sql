@@ -0,0 +1,12 @@
+-- Base: consentimientos
+-- Aviso al titular cuando un tercero lee sus datos (opcional, desactivado
+-- por defecto). Ver handlers/avisos_accesos_titular.go.
+CREATE TABLE IF NOT EXISTS preferencias_notificacion (
+    id_usuario          INTEGER   PRIMARY KEY REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    avisar_lecturas     BOOLEAN   NOT NULL DEFAULT FALSE,
+    fecha_actualizacion TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Para no repetir el aviso mientras siga sin leer uno del mismo lector
+CREATE INDEX IF NOT EXISTS idx_notificaciones_pendientes
+    ON notificaciones (id_usuario, tipo, referencia_id) WHERE NOT leido;
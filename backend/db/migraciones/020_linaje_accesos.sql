@@ -0,0 +1,55 @@
+-- Base: consentimientos
+-- Linaje de cada acceso: qué campos se entregaron y qué versión de qué
+-- política autorizó cada uno.
+--
+-- politicas_privacidad.version sube una vez por transacción que cambie la
+-- política o sus atributos (politica_atributo), así que una edición que
+-- borra y vuelve a insertar los atributos cuenta como una sola versión.
+ALTER TABLE politicas_privacidad
+    ADD COLUMN IF NOT EXISTS version    INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS version_tx BIGINT;
+
+CREATE OR REPLACE FUNCTION versionar_politica() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        -- Los atributos insertados junto con la política no la versionan
+        NEW.version    := 1;
+        NEW.version_tx := txid_current();
+    ELSIF OLD.version_tx IS DISTINCT FROM txid_current()
+       AND (NEW.version_tx IS NOT DISTINCT FROM txid_current()
+            OR to_jsonb(NEW) - 'version' - 'version_tx' <> to_jsonb(OLD) - 'version' - 'version_tx') THEN
+        NEW.version    := OLD.version + 1;
+        NEW.version_tx := txid_current();
+    ELSE
+        NEW.version    := OLD.version;
+        NEW.version_tx := OLD.version_tx;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_versionar_politica ON politicas_privacidad;
+CREATE TRIGGER trg_versionar_politica BEFORE INSERT OR UPDATE ON politicas_privacidad
+    FOR EACH ROW EXECUTE FUNCTION versionar_politica();
+
+CREATE OR REPLACE FUNCTION versionar_politica_atributo() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE politicas_privacidad
+       SET version_tx = txid_current()
+     WHERE id_politica IN (COALESCE(NEW.id_politica, OLD.id_politica), OLD.id_politica)
+       AND version_tx IS DISTINCT FROM txid_current();
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_versionar_politica_atributo ON politica_atributo;
+CREATE TRIGGER trg_versionar_politica_atributo AFTER INSERT OR UPDATE OR DELETE ON politica_atributo
+    FOR EACH ROW EXECUTE FUNCTION versionar_politica_atributo();
+
+-- {"direccion": {"nivel": "completo", "id_politica": 3, "version": 2, "id_consentimiento": 17}, ...}
+-- Nulable y sin valor por defecto: no altera el hash de las filas ya encadenadas.
+ALTER TABLE accesos ADD COLUMN IF NOT EXISTS linaje JSONB;
+
+-- Filas anteriores: el conjunto de campos ya estaba en niveles_aplicados
+CREATE INDEX IF NOT EXISTS idx_accesos_linaje  ON accesos USING GIN (linaje);
+CREATE INDEX IF NOT EXISTS idx_accesos_niveles ON accesos USING GIN (niveles_aplicados);
@@ -0,0 +1,71 @@
+-- Base: consentimientos
+-- Catálogo de motivos (auditoria/motivos.go): cada denegación o fallo
+-- auditado guarda un código en codigo_motivo. Las filas anteriores no se
+-- tocan, porque cambiar codigo_motivo alteraría su hash (014); el código que
+-- se infiere de su texto libre queda en auditoria_motivos_inferidos, por
+-- flujo y posición en la cadena.
+CREATE TABLE IF NOT EXISTS auditoria_motivos_inferidos (
+    flujo         VARCHAR(40) NOT NULL,
+    cadena_pos    BIGINT      NOT NULL,
+    codigo_motivo VARCHAR(60) NOT NULL,
+    PRIMARY KEY (flujo, cadena_pos)
+);
+
+-- Correspondencia aproximada entre los textos históricos y el catálogo;
+-- sólo se usa en esta migración. El orden importa: lo específico primero.
+CREATE OR REPLACE FUNCTION clasificar_motivo_historico(texto TEXT) RETURNS VARCHAR AS $$
+    SELECT CASE
+        WHEN texto IS NULL OR texto = ''                        THEN 'otro'
+        WHEN texto ILIKE 'emergencia: concesión expirada%'      THEN 'emergencia_no_vigente'
+        WHEN texto ILIKE '%atributo no valido%'
+          OR texto ILIKE '%atributo no válido%'                 THEN 'atributo_no_valido'
+        WHEN texto ILIKE 'finalidad o justificación ausente%'   THEN 'finalidad_ausente'
+        WHEN texto ILIKE 'no hay consentimiento activo%'        THEN 'sin_consentimiento'
+        WHEN texto ILIKE 'política no coincide%'
+          OR texto ILIKE 'politica no coincide%'                THEN 'politica_no_coincide'
+        WHEN texto ILIKE 'finalidad no declarada%'              THEN 'finalidad_no_declarada'
+        WHEN texto ILIKE 'condición del titular%'               THEN 'condicion_titular'
+        WHEN texto ILIKE 'cuota excedida%'                      THEN 'cuota_excedida'
+        WHEN texto ILIKE 'sin aprobación del titular%'          THEN 'sin_aprobacion'
+        WHEN texto ILIKE '%titular no encontrado%'              THEN 'titular_no_encontrado'
+        WHEN texto ILIKE 'fecha_expiracion excede%'             THEN 'expiracion_fuera_de_vigencia'
+        WHEN texto ILIKE '%seudónimo desconocido%'              THEN 'seudonimo_desconocido'
+        WHEN texto ILIKE '%seudonimización%'                    THEN 'error_interno'
+        WHEN texto ILIKE '%revocada%'                           THEN 'politica_revocada'
+        WHEN texto ILIKE '%expira%'                             THEN 'atributo_expirado'
+        WHEN texto ILIKE '%error%'                              THEN 'error_bd'
+        ELSE 'otro'
+    END
+$$ LANGUAGE sql IMMUTABLE;
+
+INSERT INTO auditoria_motivos_inferidos (flujo, cadena_pos, codigo_motivo)
+SELECT 'accesos', cadena_pos, clasificar_motivo_historico(motivo)
+  FROM accesos
+ WHERE NOT exito AND codigo_motivo IS NULL AND cadena_pos IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO auditoria_motivos_inferidos (flujo, cadena_pos, codigo_motivo)
+SELECT 'auditoria_eventos', cadena_pos, clasificar_motivo_historico(COALESCE(error_mensaje, descripcion))
+  FROM auditoria_eventos
+ WHERE accion LIKE 'FALLO%' AND codigo_motivo IS NULL AND cadena_pos IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO auditoria_motivos_inferidos (flujo, cadena_pos, codigo_motivo)
+SELECT 'auditoria_politicas', cadena_pos, clasificar_motivo_historico(COALESCE(error_mensaje, descripcion))
+  FROM auditoria_politicas
+ WHERE exito = FALSE AND codigo_motivo IS NULL AND cadena_pos IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- Los logins fallidos sin usuario eran emails desconocidos
+INSERT INTO auditoria_motivos_inferidos (flujo, cadena_pos, codigo_motivo)
+SELECT 'auditoria_login', cadena_pos,
+       CASE WHEN id_usuario IS NULL THEN 'usuario_desconocido' ELSE 'credenciales_invalidas' END
+  FROM auditoria_login
+ WHERE NOT exito AND codigo_motivo IS NULL AND cadena_pos IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+DROP FUNCTION clasificar_motivo_historico(TEXT);
+
+-- Paneles: fallos agrupados por código
+CREATE INDEX IF NOT EXISTS idx_auditoria_eventos_motivo ON auditoria_eventos (codigo_motivo) WHERE accion LIKE 'FALLO%';
+CREATE INDEX IF NOT EXISTS idx_accesos_motivo           ON accesos (codigo_motivo) WHERE NOT exito;
@@ -0,0 +1,90 @@
+-- Base: consentimientos
+-- Historial temporal: cada versión de una fila de las tablas que deciden un
+-- acceso queda en historial_temporal con su intervalo de validez
+-- [valido_desde, valido_hasta). estado_en(NULL::tabla, instante) devuelve
+-- la tabla tal como estaba en ese instante, con sus propias columnas, para
+-- responder consultas "a fecha" (handlers/consulta_historica.go).
+--
+-- Las versiones se fechan con la hora de la transacción (now()): todos los
+-- cambios de una misma transacción son simultáneos y una fila creada y
+-- modificada en ella no deja un intervalo intermedio visible. Cada versión
+-- guarda aparte la clave primaria de la fila (columnas indicadas al crear el
+-- trigger): la versión vigente se localiza por clave y no por la fila
+-- entera, que deja de coincidir en cuanto la tabla gana una columna.
+CREATE TABLE IF NOT EXISTS historial_temporal (
+    id_historial BIGSERIAL   PRIMARY KEY,
+    tabla        VARCHAR(60) NOT NULL,
+    clave        JSONB       NOT NULL, -- {"columna": valor} de la clave primaria
+    fila         JSONB       NOT NULL,
+    valido_desde TIMESTAMPTZ NOT NULL,
+    valido_hasta TIMESTAMPTZ,
+    operacion    VARCHAR(10) NOT NULL -- INSERT | UPDATE | INICIAL (estado al crear el historial)
+);
+
+CREATE INDEX IF NOT EXISTS idx_historial_temporal_tabla
+    ON historial_temporal (tabla, valido_desde, valido_hasta);
+-- Localiza la versión vigente de una fila al modificarla o borrarla
+CREATE INDEX IF NOT EXISTS idx_historial_temporal_vigente
+    ON historial_temporal (tabla, clave) WHERE valido_hasta IS NULL;
+
+-- clave_historial('{"id_politica": 3, "titulo": "x"}', '{id_politica}') = {"id_politica": 3}
+CREATE OR REPLACE FUNCTION clave_historial(fila JSONB, columnas TEXT[]) RETURNS JSONB AS $$
+    SELECT jsonb_object_agg(c, fila -> c) FROM unnest(columnas) AS c
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Argumentos del trigger: las columnas de la clave primaria de la tabla
+CREATE OR REPLACE FUNCTION registrar_historial_temporal() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND to_jsonb(NEW) = to_jsonb(OLD) THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE historial_temporal
+           SET valido_hasta = now()
+         WHERE tabla = TG_TABLE_NAME
+           AND clave = clave_historial(to_jsonb(OLD), TG_ARGV)
+           AND valido_hasta IS NULL;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO historial_temporal (tabla, clave, fila, valido_desde, operacion)
+        VALUES (TG_TABLE_NAME, clave_historial(to_jsonb(NEW), TG_ARGV), to_jsonb(NEW), now(), TG_OP);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- estado_en(NULL::consentimientos, '2024-05-01 10:00') AS c
+CREATE OR REPLACE FUNCTION estado_en(tipo ANYELEMENT, instante TIMESTAMPTZ) RETURNS SETOF ANYELEMENT AS $$
+    SELECT jsonb_populate_record(tipo, h.fila)
+      FROM historial_temporal h
+     WHERE h.tabla = pg_typeof(tipo)::text
+       AND h.valido_desde <= instante
+       AND (h.valido_hasta IS NULL OR h.valido_hasta > instante)
+$$ LANGUAGE sql STABLE;
+
+-- Consentimientos, políticas (con sus atributos y finalidades), atributos
+-- de terceros y condiciones del titular: todo lo que evalúa autorizarAcceso
+DO $$
+DECLARE
+    t RECORD;
+BEGIN
+    FOR t IN SELECT * FROM (VALUES
+            ('consentimientos',            ARRAY['id_consentimiento']),
+            ('politicas_privacidad',       ARRAY['id_politica']),
+            ('politica_atributo',          ARRAY['id_politica', 'id_atributo']),
+            ('politica_finalidad',         ARRAY['id_politica', 'codigo']),
+            ('atributos_terceros',         ARRAY['id_atributo']),
+            ('condiciones_consentimiento', ARRAY['id_consentimiento'])
+        ) AS v(tabla, clave) LOOP
+        IF NOT EXISTS (SELECT 1 FROM historial_temporal WHERE tabla = t.tabla) THEN
+            EXECUTE format('INSERT INTO historial_temporal (tabla, clave, fila, valido_desde, operacion)
+                            SELECT %L, clave_historial(to_jsonb(x), %L), to_jsonb(x), now(), ''INICIAL'' FROM %I x',
+                           t.tabla, t.clave, t.tabla);
+        END IF;
+        EXECUTE format('DROP TRIGGER IF EXISTS trg_historial_temporal ON %I', t.tabla);
+        EXECUTE format('CREATE TRIGGER trg_historial_temporal AFTER INSERT OR UPDATE OR DELETE ON %I
+                          FOR EACH ROW EXECUTE FUNCTION registrar_historial_temporal(%s)',
+                       t.tabla, array_to_string(t.clave, ', '));
+    END LOOP;
+END;
+$$;
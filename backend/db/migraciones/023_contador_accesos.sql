@@ -0,0 +1,59 @@
+-- Base: consentimientos
+-- Contador de accesos ordinarios concedidos bajo cada consentimiento, para
+-- la condición max_accesos. Contar filas de accesos deja de valer en cuanto
+-- la retención (018) poda las antiguas: el contador no se poda nunca.
+--
+-- Lo mantiene un trigger sobre accesos que no modifica la fila insertada,
+-- así que no altera su hash en la cadena (014). Queda en el historial
+-- temporal (022) para las consultas a fecha.
+CREATE TABLE IF NOT EXISTS contador_accesos_consentimiento (
+    id_consentimiento INTEGER PRIMARY KEY REFERENCES consentimientos(id_consentimiento) ON DELETE CASCADE,
+    accesos           BIGINT    NOT NULL DEFAULT 0,
+    fecha_ultimo      TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Punto de partida: los accesos que sigan en línea al aplicar la migración
+INSERT INTO contador_accesos_consentimiento (id_consentimiento, accesos, fecha_ultimo)
+SELECT c.id_consentimiento, COUNT(*), MAX(a.fecha_evento)
+  FROM accesos a
+  JOIN LATERAL (SELECT DISTINCT x
+                  FROM unnest(array_append(COALESCE(a.consentimientos_autorizantes, '{}'), a.id_consentimiento)) AS x
+                 WHERE x IS NOT NULL) AS c(id_consentimiento) ON TRUE
+  JOIN consentimientos k ON k.id_consentimiento = c.id_consentimiento
+ WHERE a.exito
+   AND a.tipo_acceso = 'ordinario'
+ GROUP BY c.id_consentimiento
+ON CONFLICT (id_consentimiento) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION contar_acceso_consentimiento() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.exito AND NEW.tipo_acceso = 'ordinario' THEN
+        INSERT INTO contador_accesos_consentimiento AS k (id_consentimiento, accesos, fecha_ultimo)
+        SELECT DISTINCT x, 1, NEW.fecha_evento
+          FROM unnest(array_append(COALESCE(NEW.consentimientos_autorizantes, '{}'), NEW.id_consentimiento)) AS x
+         WHERE x IS NOT NULL
+           AND EXISTS (SELECT 1 FROM consentimientos c WHERE c.id_consentimiento = x)
+        ON CONFLICT (id_consentimiento)
+        DO UPDATE SET accesos = k.accesos + 1, fecha_ultimo = EXCLUDED.fecha_ultimo;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_contar_acceso_consentimiento ON accesos;
+CREATE TRIGGER trg_contar_acceso_consentimiento AFTER INSERT ON accesos
+    FOR EACH ROW EXECUTE FUNCTION contar_acceso_consentimiento();
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM historial_temporal WHERE tabla = 'contador_accesos_consentimiento') THEN
+        INSERT INTO historial_temporal (tabla, clave, fila, valido_desde, operacion)
+        SELECT 'contador_accesos_consentimiento', clave_historial(to_jsonb(x), '{id_consentimiento}'),
+               to_jsonb(x), now(), 'INICIAL'
+          FROM contador_accesos_consentimiento x;
+    END IF;
+END;
+$$;
+DROP TRIGGER IF EXISTS trg_historial_temporal ON contador_accesos_consentimiento;
+CREATE TRIGGER trg_historial_temporal AFTER INSERT OR UPDATE OR DELETE ON contador_accesos_consentimiento
+    FOR EACH ROW EXECUTE FUNCTION registrar_historial_temporal(id_consentimiento);
@@ -0,0 +1,7 @@
+-- Base: consentimientos
+-- Los procesadores de políticas seudonimizadas nombran al titular por su
+-- seudónimo (tabla seudonimos) al acceder y al pedir aprobación. La
+-- solicitud guarda con qué seudónimo se pidió para no devolverle después al
+-- procesador el id real.
+ALTER TABLE solicitudes_acceso_titular
+    ADD COLUMN IF NOT EXISTS seudonimo VARCHAR(40);
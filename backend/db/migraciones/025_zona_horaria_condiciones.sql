@@ -0,0 +1,16 @@
+-- Base: consentimientos
+-- La franja horaria y los días permitidos de las condiciones se evalúan en
+-- la zona horaria que indica el titular (nombre IANA, p. ej.
+-- America/Guayaquil). Las condiciones existentes se evaluaban en la hora
+-- local del servidor: se les asigna la zona de la base, que en los
+-- despliegues actuales es la misma. Las nuevas, por defecto, en UTC.
+ALTER TABLE condiciones_consentimiento
+    ADD COLUMN IF NOT EXISTS zona_horaria VARCHAR(64);
+
+UPDATE condiciones_consentimiento
+   SET zona_horaria = current_setting('TimeZone')
+ WHERE zona_horaria IS NULL;
+
+ALTER TABLE condiciones_consentimiento
+    ALTER COLUMN zona_horaria SET DEFAULT 'UTC',
+    ALTER COLUMN zona_horaria SET NOT NULL;
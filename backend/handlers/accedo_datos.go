@@ -2,19 +2,27 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"backend/auditoria"
 	"backend/db"
 	"backend/models"
 	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
 )
 
-// GET /procesador/acceso-datos?id_usuario=NN
+// GET /procesador/acceso-datos?id_usuario=NN&finalidad=COD&justificacion=TEXTO
+// (o ?seudonimo=sd_… en lugar de id_usuario para los titulares que el
+// procesador sólo conoce por seudónimo). La finalidad debe estar declarada
+// en la política consentida.
 func ObtenerAccesoDatos(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
@@ -23,16 +31,20 @@ func ObtenerAccesoDatos(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	// 1️⃣ Leer id_usuario (titular)
+	// 1️⃣ Leer id_usuario o seudonimo (titular)
 	titularStr := r.URL.Query().Get("id_usuario")
-	if titularStr == "" {
-		http.Error(w, "Falta id_usuario", http.StatusBadRequest)
+	seudonimo := strings.TrimSpace(r.URL.Query().Get("seudonimo"))
+	if (titularStr == "") == (seudonimo == "") {
+		http.Error(w, "Indique id_usuario o seudonimo", http.StatusBadRequest)
 		return
 	}
-	idTitular, err := strconv.Atoi(titularStr)
-	if err != nil {
-		http.Error(w, "id_usuario inválido", http.StatusBadRequest)
-		return
+	idTitular := 0
+	if titularStr != "" {
+		var err error
+		if idTitular, err = strconv.Atoi(titularStr); err != nil {
+			http.Error(w, "id_usuario inválido", http.StatusBadRequest)
+			return
+		}
 	}
 
 	// 2️⃣ Leer X-User-ID (solicitante/procesador)
@@ -47,116 +59,152 @@ func ObtenerAccesoDatos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4️⃣ Obtenemos id_consentimiento, id_politica y la expiración del consentimiento activo más reciente
-	var (
-		idConsentimiento int
-		idPolitica       int
-		fechaExp         time.Time
-	)
-	err = db.Pool.
-		QueryRow(ctx, `
-			SELECT c.id_consentimiento, c.id_politica, c.fecha_expiracion
-			  FROM consentimientos c
-			 WHERE c.id_usuario      = $1
-			   AND c.estado          = 'activo'
-			   AND c.fecha_expiracion > NOW()
-			 ORDER BY c.fecha_expiracion DESC
-			 LIMIT 1
-		`, idTitular,
-		).
-		Scan(&idConsentimiento, &idPolitica, &fechaExp)
-	if err != nil {
-		// No hay consentimiento activo
-		LogAcceso(ctx, idSolicitante, 0, false, "no hay consentimiento activo")
-		http.Error(w, "No hay consentimiento activo", http.StatusNotFound)
+	// 2️⃣b Finalidad y justificación declaradas por el procesador
+	finalidad := strings.TrimSpace(r.URL.Query().Get("finalidad"))
+	justificacion := strings.TrimSpace(r.URL.Query().Get("justificacion"))
+	if finalidad == "" || len([]rune(justificacion)) < justificacionAccesoMinima {
+		auditoria.Registrar(ctx, auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante,
+			CodigoMotivo: models.MotivoFinalidadAusente, Descripcion: "finalidad o justificación ausente",
+			Acceso: &auditoria.Acceso{IDTitular: idTitular, Finalidad: finalidad, Justificacion: justificacion},
+		})
+		http.Error(w, fmt.Sprintf("Se requiere finalidad y una justificación de al menos %d caracteres", justificacionAccesoMinima), http.StatusBadRequest)
 		return
 	}
 
-	// 3️⃣ Si no es el titular, verificar dinámico
-	if idSolicitante != idTitular {
-		if ok := utils.VerificarAccesoDinamico(idSolicitante, idTitular); !ok {
-			LogAcceso(ctx, idSolicitante, idConsentimiento, false, "política no coincide")
-			http.Error(w, "Acceso denegado según política", http.StatusForbidden)
+	if seudonimo != "" {
+		id, denegacion := resolverSeudonimo(ctx, idSolicitante, seudonimo, finalidad, justificacion)
+		if denegacion != nil {
+			http.Error(w, denegacion.mensaje, denegacion.status)
 			return
 		}
+		idTitular = id
 	}
 
-	// 5️⃣ Leemos los atributos permitidos para esa política
-	attrRows, err := db.Pool.Query(ctx, `
-		SELECT ad.nombre
-		  FROM politica_atributo pa
-		  JOIN atributos_datos ad ON ad.id_atributo = pa.id_atributo
-		 WHERE pa.id_politica = $1
-	`, idPolitica)
-	if err != nil {
-		LogAcceso(ctx, idSolicitante, idConsentimiento, false, "error lectura atributos")
-		http.Error(w, "Error leyendo atributos de política", http.StatusInternalServerError)
+	respuesta, denegacion := accederDatosTitular(ctx, idSolicitante, idTitular, seudonimo == "", finalidad, justificacion)
+	if denegacion != nil {
+		if denegacion.reintentar > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(denegacion.reintentar))
+		}
+		http.Error(w, denegacion.mensaje, denegacion.status)
 		return
 	}
-	defer attrRows.Close()
 
-	permitidos := make([]string, 0, 8)
-	for attrRows.Next() {
-		var nombre string
-		if err := attrRows.Scan(&nombre); err == nil {
-			permitidos = append(permitidos, nombre)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(respuesta)
+}
+
+// accederDatosTitular autoriza, descifra y redacta los datos de un titular
+// para el solicitante, y deja el acceso (concedido o no) en accesos. La
+// usan el acceso individual y el acceso por lotes. porID indica que el
+// procesador nombró al titular por su id real, lo que no se admite si
+// ninguna política autorizante lo identifica.
+func accederDatosTitular(ctx context.Context, idSolicitante, idTitular int, porID bool, finalidad, justificacion string) (map[string]interface{}, *denegacionAcceso) {
+	registrar := func(idConsentimiento int, exito bool, codigo, motivo string, niveles map[string]string) {
+		auditoria.Registrar(ctx, auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, Exito: exito, CodigoMotivo: codigo, Descripcion: motivo,
+			Acceso: &auditoria.Acceso{
+				IDTitular: idTitular, IDConsentimiento: idConsentimiento, Niveles: niveles,
+				Finalidad: finalidad, Justificacion: justificacion,
+			},
+		})
+	}
+
+	// 3️⃣ Cuotas generales del procesador, antes de evaluar nada
+	if den := verificarCuotas(ctx, idSolicitante, idTitular, nil); den != nil {
+		registrar(0, false, den.codigo, den.motivo, nil)
+		return nil, den
+	}
+
+	// 3️⃣-5️⃣ Consentimientos activos que coinciden con los atributos del
+	// procesador y declaran la finalidad; unión de sus atributos
+	aut, denegacion := autorizarAcceso(ctx, idSolicitante, idTitular, finalidad)
+	if denegacion != nil {
+		registrar(aut.IDConsentimiento, false, denegacion.codigo, denegacion.motivo, nil)
+		return nil, denegacion
+	}
+	idConsentimiento, fechaExp, permitidos := aut.IDConsentimiento, aut.FechaExp, aut.Permitidos
+	registrar = func(idConsentimiento int, exito bool, codigo, motivo string, niveles map[string]string) {
+		auditoria.Registrar(ctx, auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, Exito: exito, CodigoMotivo: codigo, Descripcion: motivo,
+			Acceso: &auditoria.Acceso{
+				IDTitular: idTitular, IDConsentimiento: idConsentimiento, Consentimientos: aut.IDsConsentimientos(),
+				Niveles: niveles, Linaje: aut.Linaje(niveles), Finalidad: finalidad, Justificacion: justificacion,
+			},
+		})
+	}
+
+	// 5️⃣a Un titular sólo seudonimizado se pide por su seudónimo: aceptar su
+	// id real dejaría al procesador enlazar la identidad con los datos
+	if porID && !aut.Identificado {
+		den := &denegacionAcceso{codigo: models.MotivoSeudonimoRequerido, motivo: "titular seudonimizado pedido por id", mensaje: "Este titular sólo puede pedirse por su seudónimo", status: http.StatusForbidden}
+		registrar(idConsentimiento, false, den.codigo, den.motivo, nil)
+		return nil, den
+	}
+
+	// 5️⃣b Cuotas de las políticas que autorizan el acceso
+	if den := verificarCuotas(ctx, idSolicitante, idTitular, aut.IDsPoliticas()); den != nil {
+		registrar(idConsentimiento, false, den.codigo, den.motivo, nil)
+		return nil, den
+	}
+
+	// 5️⃣c Políticas sensibles: el titular debe haber aprobado este acceso.
+	// La aprobación es de un solo uso y limita los campos entregados; aquí
+	// sólo se lee, y se marca como usada al registrar el acceso concedido.
+	motivoExito := "Autorizado"
+	sinAprobacion := &denegacionAcceso{codigo: models.MotivoSinAprobacion, motivo: "sin aprobación del titular", mensaje: "El acceso requiere una solicitud aprobada por el titular", status: http.StatusForbidden}
+	idAprobacion := 0
+	if aut.RequiereAprobacion {
+		id, aprobados, err := aprobacionVigente(ctx, idSolicitante, idTitular, finalidad)
+		if err != nil {
+			registrar(idConsentimiento, false, sinAprobacion.codigo, sinAprobacion.motivo, nil)
+			return nil, sinAprobacion
 		}
+		idAprobacion = id
+		var filtrados []string
+		for _, campo := range permitidos {
+			if contiene(aprobados, campo) {
+				filtrados = append(filtrados, campo)
+			}
+		}
+		permitidos = filtrados
+		motivoExito = fmt.Sprintf("Autorizado (aprobación %d)", idAprobacion)
 	}
 
 	// 6️⃣ Leemos el email del titular
 	var email string
-	err = db.Pool.
+	err := db.Pool.
 		QueryRow(ctx, `SELECT email FROM usuarios WHERE id_usuario = $1`, idTitular).
 		Scan(&email)
 	if err != nil {
-		LogAcceso(ctx, idSolicitante, idConsentimiento, false, "titular no encontrado")
-		http.Error(w, "Titular no encontrado", http.StatusNotFound)
-		return
+		registrar(idConsentimiento, false, models.MotivoTitularNoEncontrado, "titular no encontrado", nil)
+		return nil, &denegacionAcceso{codigo: models.MotivoTitularNoEncontrado, motivo: "titular no encontrado", mensaje: "Titular no encontrado", status: http.StatusNotFound}
 	}
 
-	// 7️⃣ Recuperamos los datos cifrados del titular
-	var dp models.DatosPersonales
-	err = db.ConnDatos.
-		QueryRow(ctx, `
-			SELECT id_dato, telefono, celular, direccion, ciudad,
-				   provincia, fecha_nacimiento, genero, estado_civil
-			  FROM datos_personales
-			 WHERE id_usuario = $1
-		`, idTitular).
-		Scan(
-			&dp.IDDato,
-			&dp.Telefono,
-			&dp.Celular,
-			&dp.Direccion,
-			&dp.Ciudad,
-			&dp.Provincia,
-			&dp.FechaNacimiento,
-			&dp.Genero,
-			&dp.EstadoCivil,
-		)
+	// 7️⃣ Recuperamos los datos cifrados del titular (un valor por atributo)
+	dp, err := leerDatosCifrados(ctx, idTitular)
 	if err != nil {
 		// ➊ Log completo del error
 		log.Printf("ERROR en QueryRow datos_personales (titular=%d): %v", idTitular, err)
 
 		// ➋ Registrar en la tabla de accesos igualmente
-		LogAcceso(ctx, idSolicitante, idConsentimiento, false, fmt.Sprintf("error datos_personales: %v", err))
+		registrar(idConsentimiento, false, models.MotivoErrorBD, fmt.Sprintf("error datos_personales: %v", err), nil)
 
 		// ➌ Devolver mensaje y status adecuados
-		http.Error(w, fmt.Sprintf("Error al recuperar datos personales: %v", err), http.StatusInternalServerError)
-		return
+		return nil, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error datos_personales", mensaje: fmt.Sprintf("Error al recuperar datos personales: %v", err), status: http.StatusInternalServerError}
 	}
 
 	// 8️⃣ Helper para descifrar un campo con la clave del procesador
-	descifrar := func(ciphBytes []byte) string {
+	descifrar := func(ciphBytes []byte) (string, bool) {
 		ciph, err := utils.DeserializarCipher(ciphBytes)
 		if err != nil {
-			return "error deserializar"
+			return "error deserializar", false
 		}
 		plain, err := utils.DescifrarDatoABEConClaveUsuario(ciph, idSolicitante)
 		if err != nil {
-			return "no autorizado"
+			return "no autorizado", false
 		}
-		return plain
+		return plain, true
 	}
 
 	// 9️⃣ Construir la respuesta JSON
@@ -164,45 +212,104 @@ func ObtenerAccesoDatos(w http.ResponseWriter, r *http.Request) {
 		"email":        email,
 		"acceso_hasta": fechaExp.Format("2006-01-02"),
 	}
+	catalogo, err := catalogoPorNombre(ctx)
+	if err != nil {
+		registrar(idConsentimiento, false, models.MotivoErrorBD, "error lectura catálogo", nil)
+		return nil, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura catálogo", mensaje: "Error al cargar el catálogo de datos", status: http.StatusInternalServerError}
+	}
+	if !aut.Identificado {
+		// Ninguna política autorizante identifica al titular: sin email ni
+		// id, sólo el seudónimo estable para este procesador
+		seud, err := registrarSeudonimo(ctx, idSolicitante, idTitular)
+		if err != nil {
+			registrar(idConsentimiento, false, models.MotivoErrorInterno, "error seudonimización", nil)
+			return nil, &denegacionAcceso{codigo: models.MotivoErrorInterno, motivo: "error seudonimización", mensaje: "Error generando seudónimo", status: http.StatusInternalServerError}
+		}
+		delete(respuesta, "email")
+		respuesta["seudonimo"] = seud
+		respuesta["modo_acceso"] = "seudonimizado"
+	}
+
+	// Tras descifrar se aplica el nivel de divulgación de la política que
+	// autoriza cada campo; si esa política es seudonimizada, los campos
+	// completos pasan además por seudónimos.
+	ahora := time.Now()
+	niveles := map[string]string{}
+	autorizacion := map[string]interface{}{}
 	for _, campo := range permitidos {
-		switch campo {
-		case "telefono":
-			respuesta["telefono"] = descifrar(dp.Telefono)
-		case "celular":
-			respuesta["celular"] = descifrar(dp.Celular)
-		case "direccion":
-			respuesta["direccion"] = descifrar(dp.Direccion)
-		case "ciudad":
-			respuesta["ciudad"] = descifrar(dp.Ciudad)
-		case "provincia":
-			respuesta["provincia"] = descifrar(dp.Provincia)
-		case "fecha_nacimiento":
-			respuesta["fecha_nacimiento"] = descifrar(dp.FechaNacimiento)
-		case "genero":
-			respuesta["genero"] = descifrar(dp.Genero)
-		case "estado_civil":
-			respuesta["estado_civil"] = descifrar(dp.EstadoCivil)
-		}
-	}
-
-	// 🔟 Registrar acceso exitoso
-	LogAcceso(ctx, idSolicitante, idConsentimiento, true, "Autorizado")
+		valor, ok := dp.Valores[campo]
+		if !ok {
+			continue
+		}
+		ca := aut.Campos[campo]
+		autorizacion[campo] = map[string]interface{}{
+			"id_politica":       ca.Autorizacion.IDPolitica,
+			"politica":          ca.Autorizacion.Titulo,
+			"id_consentimiento": ca.Autorizacion.IDConsentimiento,
+			"nivel":             ca.Nivel,
+		}
+		plano, descifrado := descifrar(valor)
+		if !descifrado {
+			respuesta[campo] = plano
+			continue
+		}
+		if ca.Nivel != utils.NivelCompleto {
+			respuesta[campo], niveles[campo] = utils.AplicarNivel(ca.Nivel, catalogo[campo].Tipo, plano, ahora)
+			continue
+		}
+		if ca.Autorizacion.ModoAcceso == "seudonimizado" {
+			respuesta[campo], niveles[campo] = seudonimizarCampo(catalogo[campo], idSolicitante, campo, plano, ca.Autorizacion.GeneralizacionFecha)
+			continue
+		}
+		respuesta[campo], niveles[campo] = plano, utils.NivelCompleto
+	}
+	respuesta["autorizacion"] = autorizacion
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(respuesta)
+	// 🔟 Registrar acceso exitoso; las cuotas se vuelven a contar bajo el
+	// cerrojo del procesador en la misma transacción que la fila de accesos
+	motivo := motivoExito
+	if !aut.Identificado {
+		motivo += " (seudonimizado)"
+	}
+	if den := registrarAccesoConCuotas(ctx, idSolicitante, idTitular, aut.IDsPoliticas(), auditoria.Evento{
+		Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, Exito: true, Descripcion: motivo,
+		Acceso: &auditoria.Acceso{
+			IDTitular: idTitular, IDConsentimiento: idConsentimiento, Consentimientos: aut.IDsConsentimientos(),
+			Niveles: niveles, Linaje: aut.Linaje(niveles), Finalidad: finalidad, Justificacion: justificacion,
+		},
+	}, func(tx pgx.Tx) *denegacionAcceso {
+		if idAprobacion == 0 {
+			return nil
+		}
+		if err := consumirAprobacion(ctx, tx, idAprobacion); err != nil {
+			return sinAprobacion
+		}
+		return nil
+	}); den != nil {
+		return nil, den
+	}
+	avisarLecturaTitular(ctx, idTitular, idSolicitante, niveles, finalidad)
+	return respuesta, nil
 }
 
 // GET /procesador/titulares-por-atributo?atributo=XYZ
+// Los titulares de una política seudonimizada sólo aparecen con el seudónimo
+// que les corresponde ante este procesador, sin id ni email.
 func ObtenerTitularesPorAtributo(w http.ResponseWriter, r *http.Request) {
 	atributo := r.URL.Query().Get("atributo")
 	if atributo == "" {
 		http.Error(w, "Falta atributo", http.StatusBadRequest)
 		return
 	}
+	idProcesador, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta o es inválido X-User-ID", http.StatusUnauthorized)
+		return
+	}
 
 	ctx := r.Context()
 	rows, err := db.Pool.Query(ctx, `
-		SELECT u.id_usuario, u.email
+		SELECT u.id_usuario, u.email, p.modo_acceso
 		  FROM usuarios u
 		  JOIN consentimientos c ON u.id_usuario = c.id_usuario
 		  JOIN politicas_privacidad p ON c.id_politica = p.id_politica
@@ -214,19 +321,36 @@ func ObtenerTitularesPorAtributo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error en consulta", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
 	type Titular struct {
-		ID    int    `json:"id"`
-		Email string `json:"email"`
+		ID        int    `json:"id,omitempty"`
+		Email     string `json:"email,omitempty"`
+		Seudonimo string `json:"seudonimo,omitempty"`
 	}
 
 	var lista []Titular
+	var seudonimizados []int
 	for rows.Next() {
 		var t Titular
-		if err := rows.Scan(&t.ID, &t.Email); err == nil {
-			lista = append(lista, t)
+		var modo string
+		if err := rows.Scan(&t.ID, &t.Email, &modo); err != nil {
+			continue
+		}
+		if modo == "seudonimizado" {
+			seudonimizados = append(seudonimizados, t.ID)
+			continue
+		}
+		lista = append(lista, t)
+	}
+	rows.Close()
+	for _, id := range seudonimizados {
+		seud, err := registrarSeudonimo(ctx, idProcesador, id)
+		if err != nil {
+			log.Printf("Error generando seudónimo (procesador=%d): %v", idProcesador, err)
+			http.Error(w, "Error generando seudónimo", http.StatusInternalServerError)
+			return
 		}
+		lista = append(lista, Titular{Seudonimo: seud})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
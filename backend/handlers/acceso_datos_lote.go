@@ -0,0 +1,241 @@
+// backend/handlers/acceso_datos_lote.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+)
+
+const (
+	// loteMaxTitulares limita cuántos titulares se procesan por solicitud.
+	loteMaxTitulares = 500
+	// loteTrabajadores acota las evaluaciones/descifrados ABE concurrentes.
+	loteTrabajadores = 4
+)
+
+// SolicitudLote es el cuerpo de POST /procesador/acceso-datos/lote.
+// Se indica sólo uno de IDsTitulares, Seudonimos (los titulares que el
+// procesador conoce por seudónimo) o Atributo (titulares con consentimiento
+// activo a la política de ese título).
+type SolicitudLote struct {
+	IDsTitulares  []int    `json:"ids_titulares"`
+	Seudonimos    []string `json:"seudonimos"`
+	Atributo      string   `json:"atributo"`
+	Finalidad     string   `json:"finalidad"`
+	Justificacion string   `json:"justificacion"`
+}
+
+// ResultadoLote es una línea NDJSON de la respuesta: los datos del titular
+// o el motivo por el que se denegó, sin interrumpir el resto del lote.
+// Cada línea lleva el id_usuario sólo si lo dio el procesador o si el acceso
+// identifica al titular; si no, el seudónimo del titular para el procesador.
+type ResultadoLote struct {
+	IDUsuario int                    `json:"id_usuario,omitempty"`
+	Seudonimo string                 `json:"seudonimo,omitempty"`
+	Estado    string                 `json:"estado"` // "autorizado" | "denegado"
+	Datos     map[string]interface{} `json:"datos,omitempty"`
+	Motivo    string                 `json:"motivo,omitempty"`
+	Status    int                    `json:"status,omitempty"`
+	// ReintentarEn son los segundos sugeridos cuando se supera una cuota
+	ReintentarEn int `json:"reintentar_en,omitempty"`
+}
+
+// titularLote es un titular del lote tal como lo nombró el procesador.
+type titularLote struct {
+	id        int
+	seudonimo string // si se pidió por seudónimo
+	porID     bool   // si se pidió por su id real (ids_titulares)
+	// seudonimizado: elegido por atributo en una política seudonimizada
+	seudonimizado bool
+}
+
+// ObtenerAccesoDatosLote POST /procesador/acceso-datos/lote
+// Evalúa cada titular por separado (consentimiento, finalidad, ABE) con un
+// número acotado de trabajadores y emite un resultado NDJSON por titular a
+// medida que termina. Cada titular deja su propia fila en accesos.
+func ObtenerAccesoDatosLote(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := r.Context()
+
+	idSolicitante, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta o es inválido X-User-ID", http.StatusUnauthorized)
+		return
+	}
+
+	var in SolicitudLote
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	in.Finalidad = strings.TrimSpace(in.Finalidad)
+	in.Justificacion = strings.TrimSpace(in.Justificacion)
+	in.Atributo = strings.TrimSpace(in.Atributo)
+	if in.Finalidad == "" || len([]rune(in.Justificacion)) < justificacionAccesoMinima {
+		auditoria.Registrar(ctx, auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante,
+			CodigoMotivo: models.MotivoFinalidadAusente, Descripcion: "finalidad o justificación ausente",
+			Acceso: &auditoria.Acceso{Finalidad: in.Finalidad, Justificacion: in.Justificacion},
+		})
+		http.Error(w, fmt.Sprintf("Se requiere finalidad y una justificación de al menos %d caracteres", justificacionAccesoMinima), http.StatusBadRequest)
+		return
+	}
+	indicados := 0
+	for _, indicado := range []bool{len(in.IDsTitulares) > 0, len(in.Seudonimos) > 0, in.Atributo != ""} {
+		if indicado {
+			indicados++
+		}
+	}
+	if indicados != 1 {
+		http.Error(w, "Indique ids_titulares, seudonimos o atributo", http.StatusBadRequest)
+		return
+	}
+	if len(in.IDsTitulares) > loteMaxTitulares || len(in.Seudonimos) > loteMaxTitulares {
+		http.Error(w, "El lote supera el máximo de "+strconv.Itoa(loteMaxTitulares)+" titulares", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 1) Titulares a evaluar (sin duplicados). Los seudónimos que no son
+	// del procesador se contestan como denegados sin evaluar nada más.
+	var titulares []titularLote
+	var rechazados []ResultadoLote
+	for _, id := range in.IDsTitulares {
+		titulares = append(titulares, titularLote{id: id, porID: true})
+	}
+	for _, seud := range in.Seudonimos {
+		seud = strings.TrimSpace(seud)
+		id, denegacion := resolverSeudonimo(ctx, idSolicitante, seud, in.Finalidad, in.Justificacion)
+		if denegacion != nil {
+			rechazados = append(rechazados, ResultadoLote{
+				Seudonimo: seud, Estado: "denegado", Motivo: denegacion.mensaje, Status: denegacion.status,
+			})
+			continue
+		}
+		titulares = append(titulares, titularLote{id: id, seudonimo: seud})
+	}
+	if in.Atributo != "" {
+		rows, err := db.Pool.Query(ctx, `
+			SELECT DISTINCT c.id_usuario, p.modo_acceso = 'seudonimizado'
+			  FROM consentimientos c
+			  JOIN politicas_privacidad p ON p.id_politica = c.id_politica
+			 WHERE p.titulo = $1
+			   AND c.estado = 'activo'
+			   AND c.fecha_expiracion > NOW()
+			 ORDER BY c.id_usuario
+		`, in.Atributo)
+		if err != nil {
+			http.Error(w, "Error en consulta", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			t := titularLote{}
+			if err := rows.Scan(&t.id, &t.seudonimizado); err == nil {
+				titulares = append(titulares, t)
+			}
+		}
+		rows.Close()
+	}
+	vistos := make(map[int]bool, len(titulares))
+	unicos := make([]titularLote, 0, len(titulares))
+	for _, t := range titulares {
+		if !vistos[t.id] {
+			vistos[t.id] = true
+			unicos = append(unicos, t)
+		}
+	}
+	if len(unicos) > loteMaxTitulares {
+		http.Error(w, "El lote supera el máximo de "+strconv.Itoa(loteMaxTitulares)+" titulares", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 2) Pool de trabajadores; la escritura se hace sólo desde este goroutine
+	pendientes := make(chan titularLote)
+	resultados := make(chan ResultadoLote)
+	var wg sync.WaitGroup
+	for i := 0; i < loteTrabajadores; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range pendientes {
+				res := ResultadoLote{Estado: "autorizado"}
+				datos, denegacion := accederDatosTitular(ctx, idSolicitante, t.id, t.porID, in.Finalidad, in.Justificacion)
+				if denegacion != nil {
+					res.Estado, res.Motivo, res.Status = "denegado", denegacion.mensaje, denegacion.status
+					res.ReintentarEn = denegacion.reintentar
+				} else {
+					res.Datos = datos
+				}
+				// Nunca el id real de un titular que el procesador no nombró
+				// por id y que el acceso no identifica
+				seud, _ := datos["seudonimo"].(string)
+				switch {
+				case t.seudonimo != "":
+					res.Seudonimo = t.seudonimo
+				case seud != "":
+					res.Seudonimo = seud
+				case t.seudonimizado && denegacion != nil:
+					seud, err := registrarSeudonimo(ctx, idSolicitante, t.id)
+					res.Seudonimo = seud
+					if err != nil {
+						log.Printf("Error generando seudónimo (procesador=%d): %v", idSolicitante, err)
+						res.Motivo, res.Status = "Error generando seudónimo", http.StatusInternalServerError
+					}
+				default:
+					res.IDUsuario = t.id
+				}
+				resultados <- res
+			}
+		}()
+	}
+	go func() {
+		defer close(pendientes)
+		for _, t := range unicos {
+			select {
+			case pendientes <- t:
+			case <-ctx.Done():
+				// El cliente se desconectó: no se evalúan más titulares
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(resultados)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var autorizados, denegados int
+	for _, res := range rechazados {
+		denegados++
+		enc.Encode(res)
+	}
+	for res := range resultados {
+		if res.Estado == "autorizado" {
+			autorizados++
+		} else {
+			denegados++
+		}
+		if err := enc.Encode(res); err != nil {
+			// Seguimos drenando para que los trabajadores terminen
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	log.Printf("ObtenerAccesoDatosLote (procesador=%d): %d autorizados, %d denegados en %s",
+		idSolicitante, autorizados, denegados, time.Since(start))
+}
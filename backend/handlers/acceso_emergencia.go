@@ -0,0 +1,393 @@
+// backend/handlers/acceso_emergencia.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+   Acceso de emergencia (break-glass)
+   ----------------------------------
+   Un controlador puede acceder a los datos de un titular sin consentimiento
+   que lo cubra cuando hay una base legal (orden judicial, interés vital…).
+   El acceso se abre con justificación escrita, dura como mucho
+   emergenciaDuracionMaxima, se notifica en el acto al titular, a los
+   custodios y a la APD, y queda pendiente de revisión hasta que un usuario
+   APD lo dictamina. Cada lectura queda en accesos con tipo_acceso
+   'emergencia'. Los datos se descifran con la clave maestra y el atributo
+   owner del titular, que toda política incluye.
+*/
+
+const (
+	justificacionEmergenciaMinima = 30
+	emergenciaDuracionDefecto     = 60  // minutos
+	emergenciaDuracionMaxima      = 240 // minutos
+)
+
+// AccesoEmergencia es una concesión break-glass y su revisión.
+type AccesoEmergencia struct {
+	ID              int        `json:"id_emergencia"`
+	IDControlador   int        `json:"id_controlador"`
+	Controlador     string     `json:"controlador,omitempty"`
+	IDTitular       int        `json:"id_titular"`
+	BaseLegal       string     `json:"base_legal"`
+	Justificacion   string     `json:"justificacion"`
+	FechaInicio     time.Time  `json:"fecha_inicio"`
+	FechaExpiracion time.Time  `json:"fecha_expiracion"`
+	Revocado        bool       `json:"revocado"`
+	EstadoRevision  string     `json:"estado_revision"`
+	IDRevisor       *int       `json:"id_revisor,omitempty"`
+	Dictamen        *string    `json:"dictamen,omitempty"`
+	FechaRevision   *time.Time `json:"fecha_revision,omitempty"`
+	Accesos         int        `json:"accesos"`
+}
+
+// ObtenerBasesLegalesEmergencia GET /controlador/emergencias/bases-legales
+func ObtenerBasesLegalesEmergencia(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Pool.Query(r.Context(),
+		`SELECT codigo, descripcion FROM bases_legales_emergencia ORDER BY codigo`)
+	if err != nil {
+		http.Error(w, "Error consultando bases legales", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lista := []Finalidad{}
+	for rows.Next() {
+		var b Finalidad
+		if err := rows.Scan(&b.Codigo, &b.Descripcion); err == nil {
+			lista = append(lista, b)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// AbrirAccesoEmergencia POST /controlador/emergencias
+// Body: {"id_titular": N, "base_legal": "orden_judicial", "justificacion": "...", "duracion_minutos": 60}
+func AbrirAccesoEmergencia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idControlador, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	var in struct {
+		IDTitular       int    `json:"id_titular"`
+		BaseLegal       string `json:"base_legal"`
+		Justificacion   string `json:"justificacion"`
+		DuracionMinutos int    `json:"duracion_minutos"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	in.Justificacion = strings.TrimSpace(in.Justificacion)
+	if in.IDTitular == 0 || in.BaseLegal == "" || len([]rune(in.Justificacion)) < justificacionEmergenciaMinima {
+		http.Error(w, fmt.Sprintf("Se requiere id_titular, base_legal y una justificación de al menos %d caracteres", justificacionEmergenciaMinima), http.StatusBadRequest)
+		return
+	}
+	if in.DuracionMinutos == 0 {
+		in.DuracionMinutos = emergenciaDuracionDefecto
+	}
+	if in.DuracionMinutos < 0 || in.DuracionMinutos > emergenciaDuracionMaxima {
+		http.Error(w, fmt.Sprintf("duracion_minutos debe estar entre 1 y %d", emergenciaDuracionMaxima), http.StatusBadRequest)
+		return
+	}
+
+	var existe bool
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM bases_legales_emergencia WHERE codigo = $1)`, in.BaseLegal,
+	).Scan(&existe); err != nil || !existe {
+		http.Error(w, "Base legal desconocida", http.StatusBadRequest)
+		return
+	}
+	var nombreControlador string
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT nombre FROM usuarios WHERE id_usuario = $1`, idControlador,
+	).Scan(&nombreControlador); err != nil {
+		nombreControlador = "un controlador"
+	}
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM usuarios WHERE id_usuario = $1)`, in.IDTitular,
+	).Scan(&existe); err != nil || !existe {
+		http.Error(w, "Titular no encontrado", http.StatusNotFound)
+		return
+	}
+
+	// La concesión y su auditoría se guardan juntas
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		http.Error(w, "Error iniciando transacción", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	e := AccesoEmergencia{
+		IDControlador: idControlador, IDTitular: in.IDTitular,
+		BaseLegal: in.BaseLegal, Justificacion: in.Justificacion,
+		EstadoRevision: "pendiente",
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO accesos_emergencia
+		  (id_controlador, id_titular, base_legal, justificacion, fecha_inicio, fecha_expiracion)
+		VALUES ($1, $2, $3, $4, NOW(), NOW() + $5 * INTERVAL '1 minute')
+		RETURNING id_emergencia, fecha_inicio, fecha_expiracion
+	`, idControlador, in.IDTitular, in.BaseLegal, in.Justificacion, in.DuracionMinutos).
+		Scan(&e.ID, &e.FechaInicio, &e.FechaExpiracion); err != nil {
+		http.Error(w, "Error registrando acceso de emergencia", http.StatusInternalServerError)
+		return
+	}
+	if err := auditoria.RegistrarEnTx(ctx, tx, auditoria.Evento{
+		IDActor: idControlador, Rol: 2, Accion: "EMERGENCIA-ABRIR",
+		Recurso: "accesos_emergencia", IDRecurso: e.ID, Exito: true,
+		CodigoMotivo: in.BaseLegal, Descripcion: fmt.Sprintf("[%s] %s", in.BaseLegal, in.Justificacion),
+	}); err != nil {
+		http.Error(w, "Error registrando auditoría", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "Error guardando acceso de emergencia", http.StatusInternalServerError)
+		return
+	}
+
+	// Aviso inmediato al titular, a los custodios (4) y a la APD (5)
+	mensaje := fmt.Sprintf("El controlador '%s' abrió un acceso de emergencia a los datos del titular %d (base legal: %s) hasta %s.",
+		nombreControlador, in.IDTitular, in.BaseLegal, e.FechaExpiracion.Format("2006-01-02 15:04"))
+	// Abrir la concesión no es leer: las lecturas quedan después en accesos
+	mensajeTitular := fmt.Sprintf("Se abrió un acceso de emergencia a tus datos hasta %s (base legal: %s). La autoridad de protección de datos revisará este acceso.",
+		e.FechaExpiracion.Format("2006-01-02 15:04"), in.BaseLegal)
+	_ = CrearNotificacion(ctx, &models.Notificacion{
+		UsuarioID:       in.IDTitular,
+		Tipo:            "acceso_emergencia",
+		ReferenciaTabla: "accesos_emergencia",
+		ReferenciaID:    e.ID,
+		Mensaje:         mensajeTitular,
+		URLRecurso:      ptrString("/titular/accesos"),
+	})
+	notificarRoles(ctx, []int{4}, models.Notificacion{
+		Tipo: "acceso_emergencia", ReferenciaTabla: "accesos_emergencia", ReferenciaID: e.ID,
+		Mensaje: mensaje, URLRecurso: ptrString("/custodio/accesos"),
+	})
+	notificarRoles(ctx, []int{5}, models.Notificacion{
+		Tipo: "revision_emergencia", ReferenciaTabla: "accesos_emergencia", ReferenciaID: e.ID,
+		Mensaje: mensaje + " Requiere revisión.", URLRecurso: ptrString("/apd/emergencias"),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(e)
+}
+
+// ObtenerDatosEmergencia GET /controlador/emergencias/{id}/datos
+// Descifra los datos del titular mientras la concesión esté vigente.
+func ObtenerDatosEmergencia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idControlador, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	idEmergencia, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	var idTitular int
+	var baseLegal, justificacion string
+	var vigente bool
+	err = db.Pool.QueryRow(ctx, `
+		SELECT id_titular, base_legal, justificacion,
+		       NOT revocado AND fecha_expiracion > NOW()
+		  FROM accesos_emergencia
+		 WHERE id_emergencia = $1 AND id_controlador = $2
+	`, idEmergencia, idControlador).Scan(&idTitular, &baseLegal, &justificacion, &vigente)
+	if err != nil {
+		http.Error(w, "Acceso de emergencia no encontrado", http.StatusNotFound)
+		return
+	}
+	registrar := func(exito bool, codigo, motivo string, niveles map[string]string) {
+		// Sin política: la emergencia entrega todo, email incluido
+		var linaje map[string]auditoria.CampoDivulgado
+		if exito {
+			linaje = map[string]auditoria.CampoDivulgado{"email": {Nivel: utils.NivelCompleto}}
+			for campo, nivel := range niveles {
+				linaje[campo] = auditoria.CampoDivulgado{Nivel: nivel}
+			}
+		}
+		auditoria.Registrar(ctx, auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idControlador, Exito: exito, CodigoMotivo: codigo, Descripcion: motivo,
+			Acceso: &auditoria.Acceso{
+				IDTitular: idTitular, Niveles: niveles, Linaje: linaje, Justificacion: justificacion,
+				TipoAcceso: "emergencia", IDEmergencia: idEmergencia,
+			},
+		})
+	}
+	if !vigente {
+		registrar(false, models.MotivoEmergenciaNoVigente, "emergencia: concesión expirada o revocada", nil)
+		http.Error(w, "El acceso de emergencia expiró o fue revocado", http.StatusGone)
+		return
+	}
+
+	var email string
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT email FROM usuarios WHERE id_usuario = $1`, idTitular,
+	).Scan(&email); err != nil {
+		registrar(false, models.MotivoTitularNoEncontrado, "emergencia: titular no encontrado", nil)
+		http.Error(w, "Titular no encontrado", http.StatusNotFound)
+		return
+	}
+	dp, err := leerDatosCifrados(ctx, idTitular)
+	if err != nil {
+		registrar(false, models.MotivoErrorBD, fmt.Sprintf("emergencia: error datos_personales: %v", err), nil)
+		http.Error(w, "Error al recuperar datos personales", http.StatusInternalServerError)
+		return
+	}
+
+	owner := []string{utils.AtributoOwner(idTitular)}
+	respuesta := map[string]interface{}{
+		"email":         email,
+		"id_emergencia": idEmergencia,
+	}
+	niveles := map[string]string{}
+	for campo, valor := range dp.Valores {
+		ciph, err := utils.DeserializarCipher(valor)
+		if err != nil {
+			respuesta[campo] = "error deserializar"
+			continue
+		}
+		plano, err := utils.DescifrarDatoABEConMaster(ciph, owner)
+		if err != nil {
+			log.Printf("Emergencia %d: error descifrando %s: %v", idEmergencia, campo, err)
+			respuesta[campo] = "error descifrado"
+			continue
+		}
+		respuesta[campo], niveles[campo] = plano, utils.NivelCompleto
+	}
+
+	registrar(true, "", "emergencia: "+baseLegal, niveles)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(respuesta)
+}
+
+// ObtenerEmergenciasAPD GET /apd/api/emergencias?estado=pendiente
+func ObtenerEmergenciasAPD(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT e.id_emergencia, e.id_controlador, COALESCE(u.nombre, ''), e.id_titular,
+		       e.base_legal, e.justificacion, e.fecha_inicio, e.fecha_expiracion, e.revocado,
+		       e.estado_revision, e.id_revisor, e.dictamen, e.fecha_revision,
+		       (SELECT COUNT(*) FROM accesos a WHERE a.id_emergencia = e.id_emergencia)
+		  FROM accesos_emergencia e
+		  LEFT JOIN usuarios u ON u.id_usuario = e.id_controlador
+		 WHERE ($1 = '' OR e.estado_revision = $1)
+		 ORDER BY e.fecha_inicio DESC
+	`, r.URL.Query().Get("estado"))
+	if err != nil {
+		http.Error(w, "Error consultando accesos de emergencia", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lista := []AccesoEmergencia{}
+	for rows.Next() {
+		var e AccesoEmergencia
+		if err := rows.Scan(&e.ID, &e.IDControlador, &e.Controlador, &e.IDTitular,
+			&e.BaseLegal, &e.Justificacion, &e.FechaInicio, &e.FechaExpiracion, &e.Revocado,
+			&e.EstadoRevision, &e.IDRevisor, &e.Dictamen, &e.FechaRevision, &e.Accesos); err != nil {
+			log.Println("scan accesos_emergencia:", err)
+			continue
+		}
+		lista = append(lista, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// RevisarAccesoEmergencia PUT /apd/api/emergencias/{id}/revision
+// Body: {"estado": "justificado"|"injustificado", "dictamen": "..."}
+// Cierra la revisión y revoca la concesión si seguía vigente.
+func RevisarAccesoEmergencia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idRevisor, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	idEmergencia, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	var in struct {
+		Estado   string `json:"estado"`
+		Dictamen string `json:"dictamen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	in.Dictamen = strings.TrimSpace(in.Dictamen)
+	if (in.Estado != "justificado" && in.Estado != "injustificado") || in.Dictamen == "" {
+		http.Error(w, "Se requiere estado (justificado|injustificado) y dictamen", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		http.Error(w, "Error iniciando transacción", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var idControlador int
+	err = tx.QueryRow(ctx, `
+		UPDATE accesos_emergencia
+		   SET estado_revision = $2, dictamen = $3, id_revisor = $4,
+		       fecha_revision = NOW(), revocado = TRUE
+		 WHERE id_emergencia = $1 AND estado_revision = 'pendiente'
+		RETURNING id_controlador
+	`, idEmergencia, in.Estado, in.Dictamen, idRevisor).Scan(&idControlador)
+	if err != nil {
+		http.Error(w, "Acceso de emergencia no encontrado o ya revisado", http.StatusConflict)
+		return
+	}
+	if err := auditoria.RegistrarEnTx(ctx, tx, auditoria.Evento{
+		IDActor: idRevisor, Rol: 5, Accion: "EMERGENCIA-REVISAR",
+		Recurso: "accesos_emergencia", IDRecurso: idEmergencia, Exito: true,
+		Descripcion: in.Estado + ": " + in.Dictamen,
+	}); err != nil {
+		http.Error(w, "Error registrando auditoría", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "Error guardando revisión", http.StatusInternalServerError)
+		return
+	}
+
+	_ = CrearNotificacion(ctx, &models.Notificacion{
+		UsuarioID:       idControlador,
+		Tipo:            "revision_emergencia",
+		ReferenciaTabla: "accesos_emergencia",
+		ReferenciaID:    idEmergencia,
+		Mensaje:         fmt.Sprintf("La APD revisó tu acceso de emergencia #%d: %s.", idEmergencia, in.Estado),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
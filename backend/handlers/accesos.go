@@ -2,9 +2,10 @@
 package handlers
 
 import (
+	"backend/auditoria"
 	"backend/db"
-	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -23,24 +24,79 @@ type AccesoView struct {
 	FechaEvento      time.Time `json:"fecha_evento"`
 	Resultado        string    `json:"resultado"`
 	MotivoFallo      string    `json:"motivo_fallo"`
+	Finalidad        *string   `json:"finalidad,omitempty"`
+	Justificacion    *string   `json:"justificacion,omitempty"`
+	TipoAcceso       string    `json:"tipo_acceso,omitempty"` // "ordinario" | "emergencia"
+	IDEmergencia     *int      `json:"id_emergencia,omitempty"`
+	// Campos entregados y, por campo, la versión de la política que lo autorizó
+	Campos []string                      `json:"campos"`
+	Linaje map[string]CampoDivulgadoView `json:"linaje,omitempty"`
 }
 
+// CampoDivulgadoView es el linaje de un campo con el título de su política.
+type CampoDivulgadoView struct {
+	auditoria.CampoDivulgado
+	Politica string `json:"politica,omitempty"`
+}
+
+// Columnas de linaje de un acceso (alias a): los campos entregados y su
+// linaje con el título de cada política. Los accesos anteriores al linaje
+// sólo tienen niveles_aplicados, que se presentan como linaje sin política.
+const (
+	camposAccesoSQL = `ARRAY(SELECT jsonb_object_keys(COALESCE(a.linaje, a.niveles_aplicados, '{}'::jsonb)) ORDER BY 1)`
+	linajeAccesoSQL = `(SELECT jsonb_object_agg(l.key, l.value || COALESCE(jsonb_build_object('politica', p.titulo), '{}'::jsonb))
+	      FROM jsonb_each(COALESCE(a.linaje,
+	                               (SELECT jsonb_object_agg(n.key, jsonb_build_object('nivel', n.value))
+	                                  FROM jsonb_each_text(a.niveles_aplicados) n))) l
+	      LEFT JOIN politicas_privacidad p ON p.id_politica = (l.value->>'id_politica')::int)`
+	// filtroCampoSQL filtra los accesos que entregaron el campo del parámetro %s ("" = todos)
+	filtroCampoSQL = `(%[1]s = '' OR a.linaje ? %[1]s OR a.niveles_aplicados ? %[1]s)`
+)
+
 // GET /custodio/accesos
-// GET /custodio/accesos
+// GET /apd/api/accesos  (?finalidad=COD filtra por finalidad declarada,
+// ?campo=direccion por campo entregado)
+// Paginado y filtrable con los parámetros comunes (ver paginacion.go);
+// id_usuario filtra por solicitante o titular y estado por tipo_acceso.
 func ObtenerAccesosCustodio(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pg, err := prepararPagina(r, especAccesos,
+		[]string{"($1 = '' OR a.finalidad = $1)", fmt.Sprintf(filtroCampoSQL, "$2")},
+		q.Get("finalidad"), q.Get("campo"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	const desde = `
+    FROM vw_accesos_custodio v
+    JOIN accesos a ON a.id_acceso = v.id_acceso
+    `
+	var total int64
+	if err := db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) `+desde+pg.WhereTotal, pg.ArgsTotal...).Scan(&total); err != nil {
+		log.Printf("Error contando vw_accesos_custodio: %v", err)
+		http.Error(w, "Error leyendo accesos custodia", http.StatusInternalServerError)
+		return
+	}
+
 	rows, err := db.Pool.Query(r.Context(), `
       SELECT 
-        id_acceso,
-      usuario,
-      rol,
-      atributo,
-      id_consentimiento,
-      fecha_evento,
-      resultado,
-      motivo_fallo
-    FROM vw_accesos_custodio
-    ORDER BY fecha_evento DESC
-    `)
+        v.id_acceso,
+      v.usuario,
+      v.rol,
+      v.atributo,
+      v.id_consentimiento,
+      v.fecha_evento,
+      v.resultado,
+      v.motivo_fallo,
+      a.finalidad,
+      a.justificacion,
+      a.tipo_acceso,
+      a.id_emergencia,
+      `+camposAccesoSQL+`,
+      `+linajeAccesoSQL+`,
+      `+pg.Cursor+desde+pg.Where+`
+    `+pg.OrderBy+`
+    `+pg.Limit, pg.Args...)
 	if err != nil {
 		log.Printf("Error en Query vw_accesos_custodio: %v", err)
 		http.Error(w, "Error leyendo accesos custodia", http.StatusInternalServerError)
@@ -48,8 +104,13 @@ func ObtenerAccesosCustodio(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var lista []AccesoView
+	lista := []AccesoView{}
+	leidas, ultimoID := 0, 0
+	var ultimoValor string
 	for rows.Next() {
+		if leidas++; pg.hayMas(leidas) {
+			break
+		}
 		var a AccesoView
 		if err := rows.Scan(
 			&a.IDAcceso,
@@ -60,6 +121,14 @@ func ObtenerAccesosCustodio(w http.ResponseWriter, r *http.Request) {
 			&a.FechaEvento,
 			&a.Resultado,
 			&a.MotivoFallo,
+			&a.Finalidad,
+			&a.Justificacion,
+			&a.TipoAcceso,
+			&a.IDEmergencia,
+			&a.Campos,
+			&a.Linaje,
+			&ultimoValor,
+			&ultimoID,
 		); err != nil {
 			log.Println("scan custodia:", err)
 			continue
@@ -67,10 +136,31 @@ func ObtenerAccesosCustodio(w http.ResponseWriter, r *http.Request) {
 		lista = append(lista, a)
 	}
 
+	pg.escribirCabeceras(w, r, total, pg.hayMas(leidas), ultimoValor, ultimoID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lista)
 }
 
+// especAccesos pagina accesos (alias a).
+var especAccesos = especPaginacion{
+	ID: "a.id_acceso",
+	Orden: map[string]columnaOrden{
+		"fecha": {"a.fecha_evento", "timestamptz"},
+		"id":    {"a.id_acceso", "int"},
+	},
+	OrdenDefecto: "-fecha",
+	Filtros: filtrosPaginacion{
+		Fecha:  "a.fecha_evento",
+		Estado: "a.tipo_acceso",
+		Politica: `EXISTS (SELECT 1 FROM consentimientos c
+		                    WHERE c.id_politica = %[1]s
+		                      AND (c.id_consentimiento = a.id_consentimiento
+		                           OR c.id_consentimiento = ANY(a.consentimientos_autorizantes)))`,
+		Usuario: "(a.id_solicitante = %[1]s OR a.id_titular = %[1]s)",
+		Exito:   "a.exito",
+	},
+}
+
 // GET /auditor/accesos
 func ObtenerAccesosAuditor(w http.ResponseWriter, r *http.Request) {
 	rows, err := db.Pool.Query(r.Context(), `
@@ -119,19 +209,3 @@ func ObtenerAccesosAuditor(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lista)
 }
-
-// LogAcceso registra cada intento de descifrado, usando ahora el consent ID.
-func LogAcceso(ctx context.Context, userID, consentID int, exito bool, motivo string) {
-	_, err := db.Pool.Exec(ctx, `
-    INSERT INTO accesos (
-      id_solicitante,
-      id_consentimiento,
-      exito,
-      motivo,
-      fecha_evento
-    ) VALUES ($1, $2, $3, $4, NOW())
-  `, userID, consentID, exito, motivo)
-	if err != nil {
-		log.Printf("Error registrando acceso (user=%d, consent=%d): %v", userID, consentID, err)
-	}
-}
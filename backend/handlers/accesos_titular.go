@@ -0,0 +1,160 @@
+// backend/handlers/accesos_titular.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+)
+
+// AccesoTitularView es un acceso a los datos del titular visto por él mismo.
+type AccesoTitularView struct {
+	IDAcceso             int                           `json:"id_acceso"`
+	Solicitante          string                        `json:"solicitante"`
+	RolSolicitante       *string                       `json:"rol_solicitante,omitempty"`
+	Politica             string                        `json:"politica"` // la primera de Politicas
+	Politicas            []string                      `json:"politicas"`
+	Campos               []string                      `json:"campos"`
+	Niveles              map[string]string             `json:"niveles,omitempty"`
+	Linaje               map[string]CampoDivulgadoView `json:"linaje,omitempty"`
+	Resultado            string                        `json:"resultado"`
+	Exito                bool                          `json:"exito"`
+	CodigoMotivo         string                        `json:"codigo_motivo,omitempty"`
+	Motivo               string                        `json:"motivo"` // etiqueta del código, nunca el detalle interno
+	TipoAcceso           string                        `json:"tipo_acceso"`
+	Finalidad            *string                       `json:"finalidad,omitempty"`
+	FinalidadDescripcion *string                       `json:"finalidad_descripcion,omitempty"`
+	Justificacion        *string                       `json:"justificacion,omitempty"`
+	FechaEvento          time.Time                     `json:"fecha_evento"`
+}
+
+// especAccesosTitular pagina el historial de accesos del titular (alias a).
+var especAccesosTitular = especPaginacion{
+	ID: "a.id_acceso",
+	Orden: map[string]columnaOrden{
+		"fecha": {"a.fecha_evento", "timestamptz"},
+	},
+	OrdenDefecto: "-fecha",
+	Filtros: filtrosPaginacion{
+		Fecha:  "a.fecha_evento",
+		Estado: "a.tipo_acceso",
+		Politica: `EXISTS (SELECT 1 FROM consentimientos c
+		                    WHERE c.id_politica = %[1]s
+		                      AND (c.id_consentimiento = a.id_consentimiento
+		                           OR c.id_consentimiento = ANY(a.consentimientos_autorizantes)))`,
+		Usuario: "a.id_solicitante",
+		Exito:   "a.exito",
+	},
+}
+
+// ObtenerAccesosTitular GET /titular/accesos?finalidad=COD&campo=direccion
+//
+// Informe de transparencia: cada lectura de los datos del titular
+// autenticado y cada intento denegado, con quién, bajo qué políticas, qué
+// campos, para qué y cuándo. Admite los parámetros de paginación comunes
+// (desde, hasta, exito, estado=ordinario|emergencia, id_politica,
+// id_usuario = solicitante) y lang=es|en. El motivo es la etiqueta del
+// código del catálogo: el detalle de accesos.motivo (cuotas, condiciones)
+// es interno del procesador y la custodia.
+func ObtenerAccesosTitular(w http.ResponseWriter, r *http.Request) {
+	idTitular, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	pg, err := prepararPagina(r, especAccesosTitular,
+		[]string{"a.id_titular = $1", "a.id_solicitante <> $1", "($2 = '' OR a.finalidad = $2)",
+			fmt.Sprintf(filtroCampoSQL, "$3")},
+		idTitular, r.URL.Query().Get("finalidad"), r.URL.Query().Get("campo"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM accesos a `+pg.WhereTotal,
+		pg.ArgsTotal...).Scan(&total); err != nil {
+		log.Printf("Error contando accesos del titular %d: %v", idTitular, err)
+		http.Error(w, "Error leyendo accesos", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT a.id_acceso,
+		       COALESCE(u.nombre, 'desconocido'),
+		       ro.nombre,
+		       ARRAY(SELECT p.titulo
+		               FROM consentimientos c
+		               JOIN politicas_privacidad p ON p.id_politica = c.id_politica
+		              WHERE c.id_consentimiento = a.id_consentimiento
+		                 OR c.id_consentimiento = ANY(a.consentimientos_autorizantes)
+		              ORDER BY c.id_consentimiento <> a.id_consentimiento, p.titulo),
+		       `+camposAccesoSQL+`,
+		       a.niveles_aplicados,
+		       `+linajeAccesoSQL+`,
+		       a.exito,
+		       COALESCE(`+fmt.Sprintf(auditoria.MotivoEfectivoSQL, "a", auditoria.FlujoAccesos)+`, ''),
+		       a.tipo_acceso,
+		       a.finalidad,
+		       f.descripcion,
+		       a.justificacion,
+		       a.fecha_evento,
+		       `+pg.Cursor+`
+		  FROM accesos a
+		  LEFT JOIN usuarios u    ON u.id_usuario = a.id_solicitante
+		  LEFT JOIN roles ro      ON ro.id_rol = a.rol_actor
+		  LEFT JOIN finalidades f ON f.codigo = a.finalidad
+		`+pg.Where+`
+		`+pg.OrderBy+`
+		`+pg.Limit, pg.Args...)
+	if err != nil {
+		log.Printf("Error leyendo accesos del titular %d: %v", idTitular, err)
+		http.Error(w, "Error leyendo accesos", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	idioma := idiomaSolicitud(r)
+	lista := []AccesoTitularView{}
+	leidas, ultimoID := 0, 0
+	var ultimoValor string
+	for rows.Next() {
+		if leidas++; pg.hayMas(leidas) {
+			break
+		}
+		var a AccesoTitularView
+		if err := rows.Scan(
+			&a.IDAcceso, &a.Solicitante, &a.RolSolicitante, &a.Politicas, &a.Campos, &a.Niveles, &a.Linaje,
+			&a.Exito, &a.CodigoMotivo, &a.TipoAcceso, &a.Finalidad, &a.FinalidadDescripcion,
+			&a.Justificacion, &a.FechaEvento, &ultimoValor, &ultimoID,
+		); err != nil {
+			log.Println("scan accesos titular:", err)
+			continue
+		}
+		if len(a.Politicas) > 0 {
+			a.Politica = a.Politicas[0]
+		}
+		if a.CodigoMotivo != "" {
+			a.Motivo = auditoria.EtiquetaMotivo(a.CodigoMotivo, idioma)
+		}
+		a.Resultado = "Denegado"
+		if a.Exito {
+			a.Resultado = "Autorizado"
+		}
+		lista = append(lista, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error leyendo accesos del titular %d: %v", idTitular, err)
+		http.Error(w, "Error leyendo accesos", http.StatusInternalServerError)
+		return
+	}
+
+	pg.escribirCabeceras(w, r, total, pg.hayMas(leidas), ultimoValor, ultimoID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
@@ -145,30 +145,48 @@ SELECT id, operacion, id_politica, titulo, descripcion, usuario
 }
 
 // ListConsents GET /apd/api/consents
-// Lista todos los consentimientos registrados
+// Lista los consentimientos registrados, paginados (ver paginacion.go)
 func ListConsents(w http.ResponseWriter, r *http.Request) {
-	const sql = `
-SELECT id_consentimiento, id_usuario, id_politica,
-       fecha_otorgado, fecha_expiracion, estado
-  FROM consentimientos
- ORDER BY fecha_otorgado DESC`
+	pg, err := prepararPagina(r, especConsentimientos, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var total int64
+	if err := db.Pool.QueryRow(r.Context(),
+		`SELECT COUNT(*) FROM consentimientos c `+pg.WhereTotal, pg.ArgsTotal...,
+	).Scan(&total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	rows, err := db.Pool.Query(context.Background(), sql)
+	rows, err := db.Pool.Query(r.Context(), `
+SELECT c.id_consentimiento, c.id_usuario, c.id_politica,
+       c.fecha_otorgado, c.fecha_expiracion, c.estado, `+pg.Cursor+`
+  FROM consentimientos c
+ `+pg.Where+`
+ `+pg.OrderBy+`
+ `+pg.Limit, pg.Args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var list []Consent
+	list := []Consent{}
+	leidas, ultimoID := 0, 0
+	var ultimoValor string
 	for rows.Next() {
+		if leidas++; pg.hayMas(leidas) {
+			break
+		}
 		var c Consent
 		var fo time.Time
 		var exp *time.Time
 
 		if err := rows.Scan(
 			&c.ID, &c.UsuarioID, &c.PolicyID,
-			&fo, &exp, &c.Estado,
+			&fo, &exp, &c.Estado, &ultimoValor, &ultimoID,
 		); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -186,10 +204,29 @@ SELECT id_consentimiento, id_usuario, id_politica,
 		list = append(list, c)
 	}
 
+	pg.escribirCabeceras(w, r, total, pg.hayMas(leidas), ultimoValor, ultimoID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(list)
 }
 
+// especConsentimientos pagina consentimientos (alias c), para la APD y el controlador.
+var especConsentimientos = especPaginacion{
+	ID: "c.id_consentimiento",
+	Orden: map[string]columnaOrden{
+		"fecha":      {"c.fecha_otorgado", "timestamptz"},
+		"expiracion": {"COALESCE(c.fecha_expiracion, 'infinity')", "timestamptz"},
+		"estado":     {"c.estado", "text"},
+		"id":         {"c.id_consentimiento", "int"},
+	},
+	OrdenDefecto: "-fecha",
+	Filtros: filtrosPaginacion{
+		Fecha:    "c.fecha_otorgado",
+		Estado:   "c.estado",
+		Politica: "c.id_politica",
+		Usuario:  "c.id_usuario",
+	},
+}
+
 // ConsentHistory GET /apd/api/consents/{id}/history
 // Devuelve el historial de auditoría de un consentimiento concreto
 func ConsentHistory(w http.ResponseWriter, r *http.Request) {
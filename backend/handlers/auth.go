@@ -79,6 +79,12 @@ func RegistrarUsuario(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error al asignar rol de titular", http.StatusInternalServerError)
 			return
 		}
+
+		// 4.1) Emitir la clave personal owner:<id>, envuelta con su contraseña
+		if _, err := utils.GuardarClaveTitular(context.Background(), userID, req.Password); err != nil {
+			http.Error(w, "Error al emitir la clave del titular", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// 5) Responder con el nuevo ID
@@ -0,0 +1,114 @@
+// backend/handlers/avisos_accesos_titular.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"backend/db"
+	"backend/models"
+)
+
+// avisoLecturaAgrupado es cuánto tiempo se agrupan en un solo aviso sin leer
+// las lecturas de un mismo tercero.
+const avisoLecturaAgrupado = "1 hour"
+
+// PreferenciasAvisoAccesos GET|PUT /titular/accesos/avisos
+type PreferenciasAvisoAccesos struct {
+	AvisarLecturas bool `json:"avisar_lecturas"`
+}
+
+// ObtenerAvisosAccesos GET /titular/accesos/avisos
+func ObtenerAvisosAccesos(w http.ResponseWriter, r *http.Request) {
+	idTitular, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	var p PreferenciasAvisoAccesos
+	if err := db.Pool.QueryRow(r.Context(), `
+		SELECT COALESCE((SELECT avisar_lecturas FROM preferencias_notificacion WHERE id_usuario = $1), FALSE)
+	`, idTitular).Scan(&p.AvisarLecturas); err != nil {
+		http.Error(w, "Error leyendo preferencias", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// ActualizarAvisosAccesos PUT /titular/accesos/avisos
+//
+//	{"avisar_lecturas": true}
+func ActualizarAvisosAccesos(w http.ResponseWriter, r *http.Request) {
+	idTitular, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	var p PreferenciasAvisoAccesos
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if _, err := db.Pool.Exec(r.Context(), `
+		INSERT INTO preferencias_notificacion (id_usuario, avisar_lecturas)
+		VALUES ($1, $2)
+		ON CONFLICT (id_usuario) DO UPDATE
+		   SET avisar_lecturas = EXCLUDED.avisar_lecturas, fecha_actualizacion = NOW()
+	`, idTitular, p.AvisarLecturas); err != nil {
+		http.Error(w, "Error guardando preferencias", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// avisarLecturaTitular notifica al titular que un tercero leyó sus datos si
+// lo ha pedido. No bloquea la respuesta: el aviso se crea aparte y mientras
+// quede uno sin leer del mismo lector en la última hora no se repite.
+func avisarLecturaTitular(ctx context.Context, idTitular, idLector int, niveles map[string]string, finalidad string) {
+	if idTitular == idLector || len(niveles) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		var avisar bool
+		var nombre string
+		if err := db.Pool.QueryRow(ctx, `
+			SELECT COALESCE((SELECT avisar_lecturas FROM preferencias_notificacion WHERE id_usuario = $1), FALSE)
+			       AND NOT EXISTS (SELECT 1 FROM notificaciones
+			                        WHERE id_usuario = $1 AND tipo = 'lectura_datos' AND referencia_id = $2
+			                          AND NOT leido AND fecha_creacion > NOW() - $3::interval),
+			       COALESCE((SELECT nombre FROM usuarios WHERE id_usuario = $2), 'un tercero')
+		`, idTitular, idLector, avisoLecturaAgrupado).Scan(&avisar, &nombre); err != nil {
+			log.Printf("Error comprobando aviso de lectura (titular=%d): %v", idTitular, err)
+			return
+		}
+		if !avisar {
+			return
+		}
+		campos := make([]string, 0, len(niveles))
+		for c := range niveles {
+			campos = append(campos, c)
+		}
+		sort.Strings(campos)
+		mensaje := fmt.Sprintf("'%s' leyó tus datos (%s)", nombre, strings.Join(campos, ", "))
+		if finalidad != "" {
+			mensaje += " para " + finalidad
+		}
+		if err := CrearNotificacion(ctx, &models.Notificacion{
+			UsuarioID:       idTitular,
+			Tipo:            "lectura_datos",
+			ReferenciaTabla: "usuarios",
+			ReferenciaID:    idLector,
+			Mensaje:         mensaje + ".",
+			URLRecurso:      ptrString("/titular/accesos"),
+		}); err != nil {
+			log.Printf("Error notificando lectura (titular=%d): %v", idTitular, err)
+		}
+	}()
+}
@@ -0,0 +1,256 @@
+// backend/handlers/busqueda_datos.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	limiteBusquedaDefecto = 50
+	limiteBusquedaMaximo  = 200
+)
+
+// En políticas seudonimizadas sólo se llena Seudonimo (ni id ni email).
+type resultadoBusqueda struct {
+	IDUsuario   int         `json:"id_usuario,omitempty"`
+	Email       string      `json:"email,omitempty"`
+	Seudonimo   string      `json:"seudonimo,omitempty"`
+	Valor       interface{} `json:"valor"`
+	AccesoHasta string      `json:"acceso_hasta"`
+	Permitidos  []string    `json:"campos_permitidos"`
+}
+
+// BuscarTitulares GET /procesador/busqueda-titulares?atributo=ciudad&valor=Quito&finalidad=COD&justificacion=TEXTO
+// (también bajo /controlador). Busca por índice ciego y sólo devuelve los
+// titulares que pasan las mismas comprobaciones que ObtenerAccesoDatos.
+func BuscarTitulares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// 1) Solicitante
+	idSolicitante, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta X-User-ID válido", http.StatusUnauthorized)
+		return
+	}
+
+	// 2) Parámetros
+	atributo := r.URL.Query().Get("atributo")
+	valor := r.URL.Query().Get("valor")
+	if atributo == "" || utils.NormalizarValorIndice(valor) == "" {
+		http.Error(w, "Faltan atributo y valor", http.StatusBadRequest)
+		return
+	}
+	finalidad := strings.TrimSpace(r.URL.Query().Get("finalidad"))
+	justificacion := strings.TrimSpace(r.URL.Query().Get("justificacion"))
+	if finalidad == "" || len([]rune(justificacion)) < justificacionAccesoMinima {
+		http.Error(w, fmt.Sprintf("Se requiere finalidad y una justificación de al menos %d caracteres", justificacionAccesoMinima), http.StatusBadRequest)
+		return
+	}
+	limite := limiteBusquedaDefecto
+	if l, err := strconv.Atoi(r.URL.Query().Get("limite")); err == nil && l > 0 {
+		limite = l
+	}
+	if limite > limiteBusquedaMaximo {
+		limite = limiteBusquedaMaximo
+	}
+
+	// 3) El atributo debe estar marcado como buscable en el catálogo
+	catalogo, err := cargarCatalogoDatos(ctx, true)
+	if err != nil {
+		http.Error(w, "Error al cargar el catálogo de datos", http.StatusInternalServerError)
+		return
+	}
+	buscable := false
+	for _, a := range catalogo {
+		if a.Nombre == atributo {
+			buscable = a.Buscable
+			break
+		}
+	}
+	if !buscable {
+		http.Error(w, "El atributo no admite búsqueda", http.StatusBadRequest)
+		return
+	}
+
+	// 3b) Buscar por igualdad revela el valor exacto de cada coincidencia:
+	//     sólo se admite si alguna política que el procesador puede usar
+	//     para esta finalidad divulga el atributo completo. Si todas lo
+	//     enmascaran, generalizan o sólo indican presencia, no hay búsqueda.
+	atributosTercero, err := utils.AtributosTercero(ctx, idSolicitante)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Error leyendo atributos del procesador", http.StatusInternalServerError)
+		return
+	}
+	var completo bool
+	if err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS (
+		       SELECT 1 FROM politicas_privacidad p
+		         JOIN politica_atributo pa ON pa.id_politica = p.id_politica
+		         JOIN atributos_datos ad ON ad.id_atributo = pa.id_atributo
+		         JOIN politica_finalidad pf ON pf.id_politica = p.id_politica AND pf.codigo = $3
+		        WHERE p.titulo = ANY($1) AND ad.nombre = $2 AND pa.nivel_divulgacion = $4)
+	`, atributosTercero, atributo, finalidad, utils.NivelCompleto).Scan(&completo); err != nil {
+		http.Error(w, "Error leyendo las políticas del procesador", http.StatusInternalServerError)
+		return
+	}
+	if !completo {
+		http.Error(w, "El nivel de divulgación autorizado para este atributo no permite buscar por él", http.StatusForbidden)
+		return
+	}
+
+	// 4) Candidatos por índice ciego (todas las versiones de clave vigentes)
+	indices, err := utils.IndicesBusqueda(atributo, valor)
+	if err != nil {
+		http.Error(w, "Índice ciego no disponible", http.StatusInternalServerError)
+		return
+	}
+	versiones := make([]int16, 0, len(indices))
+	hashes := make([][]byte, 0, len(indices))
+	for v, h := range indices {
+		versiones = append(versiones, int16(v))
+		hashes = append(hashes, h)
+	}
+	rows, err := db.ConnDatos.Query(ctx, `
+		SELECT DISTINCT i.id_usuario
+		  FROM indices_ciegos i
+		  JOIN unnest($2::smallint[], $3::bytea[]) AS b(version_clave, indice)
+		    ON b.version_clave = i.version_clave AND b.indice = i.indice
+		 WHERE i.atributo = $1
+		 ORDER BY i.id_usuario
+		 LIMIT $4
+	`, atributo, versiones, hashes, limite)
+	if err != nil {
+		http.Error(w, "Error en la búsqueda", http.StatusInternalServerError)
+		return
+	}
+	var candidatos []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			candidatos = append(candidatos, id)
+		}
+	}
+	rows.Close()
+
+	// 5) Filtrar cada candidato con consentimiento + verificación dinámica + ABE.
+	//    Los descartados no se registran: el solicitante no pidió a ese titular
+	//    y la respuesta no revela nada de él.
+	buscado := utils.NormalizarValorIndice(valor)
+	resultados := []resultadoBusqueda{}
+	var cuota *denegacionAcceso
+	for _, idTitular := range candidatos {
+		aut, denegacion := autorizarAcceso(ctx, idSolicitante, idTitular, finalidad)
+		// Los titulares que exigen aprobación previa no aparecen en búsquedas,
+		// ni aquellos cuyo valor no se divulgaría completo: compararlo ya lo
+		// revelaría
+		if denegacion != nil || aut.RequiereAprobacion || !contiene(aut.Permitidos, atributo) ||
+			aut.Campos[atributo].Nivel != utils.NivelCompleto {
+			continue
+		}
+		// Cada descifrado cuenta para las cuotas; al superarlas (aquí o al
+		// registrar el acceso) se corta la búsqueda y se devuelve lo
+		// encontrado hasta entonces
+		if cuota = verificarCuotas(ctx, idSolicitante, idTitular, nil); cuota == nil {
+			cuota = verificarCuotas(ctx, idSolicitante, idTitular, aut.IDsPoliticas())
+		}
+		if cuota != nil {
+			auditoria.Registrar(ctx, auditoria.Evento{
+				Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, CodigoMotivo: cuota.codigo, Descripcion: cuota.motivo,
+				Acceso: &auditoria.Acceso{
+					IDTitular: idTitular, IDConsentimiento: aut.IDConsentimiento,
+					Finalidad: finalidad, Justificacion: justificacion,
+				},
+			})
+			break
+		}
+
+		var cifrado []byte
+		if err := db.ConnDatos.QueryRow(ctx, `
+			SELECT valor FROM datos_personales_valores
+			 WHERE id_usuario = $1 AND atributo = $2
+		`, idTitular, atributo).Scan(&cifrado); err != nil {
+			continue
+		}
+		ciph, err := utils.DeserializarCipher(cifrado)
+		if err != nil {
+			continue
+		}
+		plano, err := utils.DescifrarDatoABEConClaveUsuario(ciph, idSolicitante)
+		if err != nil || utils.NormalizarValorIndice(plano) != buscado {
+			continue
+		}
+
+		res := resultadoBusqueda{
+			Valor:       plano,
+			AccesoHasta: aut.FechaExp.Format("2006-01-02"),
+			Permitidos:  aut.Permitidos,
+		}
+		nivel := utils.NivelCompleto
+		ca := aut.Campos[atributo]
+		if ca.Autorizacion.ModoAcceso == "seudonimizado" {
+			if res.Seudonimo, err = registrarSeudonimo(ctx, idSolicitante, idTitular); err != nil {
+				continue
+			}
+		} else {
+			res.IDUsuario = idTitular
+			if err := db.Pool.QueryRow(ctx,
+				`SELECT email FROM usuarios WHERE id_usuario = $1`, idTitular,
+			).Scan(&res.Email); err != nil {
+				continue
+			}
+		}
+
+		linaje := map[string]auditoria.CampoDivulgado{atributo: linajeCampo(nivel, ca.Autorizacion)}
+		if res.Email != "" {
+			linaje["email"] = linajeCampo(utils.NivelCompleto, ca.Autorizacion)
+		}
+		if cuota = registrarAccesoConCuotas(ctx, idSolicitante, idTitular, aut.IDsPoliticas(), auditoria.Evento{
+			Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, Exito: true,
+			Descripcion: fmt.Sprintf("búsqueda por %s", atributo),
+			Acceso: &auditoria.Acceso{
+				IDTitular: idTitular, IDConsentimiento: ca.Autorizacion.IDConsentimiento,
+				Consentimientos: []int{ca.Autorizacion.IDConsentimiento},
+				Niveles:         map[string]string{atributo: nivel},
+				Linaje:          linaje,
+				Finalidad:       finalidad, Justificacion: justificacion,
+			},
+		}, nil); cuota != nil {
+			break
+		}
+		avisarLecturaTitular(ctx, idTitular, idSolicitante, map[string]string{atributo: nivel}, finalidad)
+		resultados = append(resultados, res)
+	}
+
+	if cuota != nil {
+		if cuota.reintentar > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(cuota.reintentar))
+		}
+		if len(resultados) == 0 {
+			http.Error(w, cuota.mensaje, cuota.status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultados)
+}
+
+func contiene(lista []string, s string) bool {
+	for _, x := range lista {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
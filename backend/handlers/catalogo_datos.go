@@ -0,0 +1,353 @@
+// backend/handlers/catalogo_datos.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// Los datos personales se describen en el catálogo atributos_datos: agregar
+// un atributo allí basta para que se pueda guardar, validar, cifrar y leer.
+
+var (
+	reNombreAtributo = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+	reTelefono       = regexp.MustCompile(`^\+?[0-9 ]{7,15}$`)
+)
+
+// cargarCatalogoDatos devuelve los atributos del catálogo (sólo activos si se pide).
+func cargarCatalogoDatos(ctx context.Context, soloActivos bool) ([]models.AtributoDato, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id_atributo, nombre, etiqueta, tipo, obligatorio,
+		       patron, opciones, longitud_max, buscable, clase_privacidad, activo
+		  FROM atributos_datos
+		 WHERE activo OR NOT $1
+		 ORDER BY id_atributo
+	`, soloActivos)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var catalogo []models.AtributoDato
+	for rows.Next() {
+		var a models.AtributoDato
+		if err := rows.Scan(
+			&a.ID, &a.Nombre, &a.Etiqueta, &a.Tipo, &a.Obligatorio,
+			&a.Patron, &a.Opciones, &a.LongitudMax, &a.Buscable, &a.ClasePrivacidad, &a.Activo,
+		); err != nil {
+			return nil, err
+		}
+		catalogo = append(catalogo, a)
+	}
+	return catalogo, rows.Err()
+}
+
+// validarValorAtributo aplica las reglas del catálogo a un valor en claro.
+func validarValorAtributo(a models.AtributoDato, valor string) error {
+	if valor == "" {
+		if a.Obligatorio {
+			return fmt.Errorf("%s es obligatorio", a.Nombre)
+		}
+		return nil
+	}
+	if a.LongitudMax != nil && utf8.RuneCountInString(valor) > *a.LongitudMax {
+		return fmt.Errorf("%s supera %d caracteres", a.Nombre, *a.LongitudMax)
+	}
+
+	switch a.Tipo {
+	case "numero":
+		if _, err := strconv.ParseFloat(valor, 64); err != nil {
+			return fmt.Errorf("%s debe ser numérico", a.Nombre)
+		}
+	case "fecha":
+		if _, err := time.Parse("2006-01-02", valor); err != nil {
+			return fmt.Errorf("%s debe tener formato AAAA-MM-DD", a.Nombre)
+		}
+	case "telefono":
+		if !reTelefono.MatchString(valor) {
+			return fmt.Errorf("%s no es un teléfono válido", a.Nombre)
+		}
+	case "email":
+		if _, err := mail.ParseAddress(valor); err != nil {
+			return fmt.Errorf("%s no es un correo válido", a.Nombre)
+		}
+	case "opcion":
+		valido := false
+		for _, o := range a.Opciones {
+			if o == valor {
+				valido = true
+				break
+			}
+		}
+		if !valido {
+			return fmt.Errorf("%s debe ser uno de: %s", a.Nombre, strings.Join(a.Opciones, ", "))
+		}
+	}
+
+	if a.Patron != nil && *a.Patron != "" {
+		re, err := regexp.Compile(*a.Patron)
+		if err != nil {
+			return fmt.Errorf("patrón inválido en el catálogo para %s", a.Nombre)
+		}
+		if !re.MatchString(valor) {
+			return fmt.Errorf("%s no cumple el formato requerido", a.Nombre)
+		}
+	}
+	return nil
+}
+
+// validarDatosContraCatalogo comprueba que todos los campos existan en el
+// catálogo y cumplan sus reglas. Con completo=true exige los obligatorios.
+func validarDatosContraCatalogo(catalogo []models.AtributoDato, valores map[string]string, completo bool) error {
+	porNombre := make(map[string]models.AtributoDato, len(catalogo))
+	for _, a := range catalogo {
+		porNombre[a.Nombre] = a
+	}
+	for nombre, valor := range valores {
+		a, ok := porNombre[nombre]
+		if !ok {
+			return fmt.Errorf("atributo desconocido: %s", nombre)
+		}
+		if err := validarValorAtributo(a, valor); err != nil {
+			return err
+		}
+	}
+	if completo {
+		for _, a := range catalogo {
+			if _, ok := valores[a.Nombre]; !ok && a.Obligatorio {
+				return fmt.Errorf("%s es obligatorio", a.Nombre)
+			}
+		}
+	}
+	return nil
+}
+
+// guardarValoresCifrados cifra cada valor con la política y lo guarda como
+// una fila de datos_personales_valores. Para los atributos buscables del
+// catálogo reemplaza además su índice ciego.
+func guardarValoresCifrados(ctx context.Context, catalogo []models.AtributoDato, idUsuario int, valores map[string]string, politica string) error {
+	buscables := map[string]bool{}
+	for _, a := range catalogo {
+		buscables[a.Nombre] = a.Buscable
+	}
+
+	tx, err := db.ConnDatos.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO datos_personales (id_usuario, fecha_creacion)
+		VALUES ($1, NOW())
+		ON CONFLICT (id_usuario) DO NOTHING
+	`, idUsuario); err != nil {
+		return fmt.Errorf("error creando cabecera de datos: %w", err)
+	}
+
+	for nombre, plano := range valores {
+		ciph, err := utils.CifrarDatoABE(plano, politica)
+		if err != nil {
+			return fmt.Errorf("error al cifrar %s: %w", nombre, err)
+		}
+		sobre, err := utils.SerializarCipher(ciph, politica)
+		if err != nil {
+			return fmt.Errorf("error al serializar %s: %w", nombre, err)
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO datos_personales_valores (id_usuario, atributo, valor, fecha_actualizacion)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (id_usuario, atributo) DO UPDATE
+			  SET valor               = EXCLUDED.valor,
+			      fecha_actualizacion = EXCLUDED.fecha_actualizacion
+		`, idUsuario, nombre, sobre); err != nil {
+			return fmt.Errorf("error guardando %s: %w", nombre, err)
+		}
+
+		if !buscables[nombre] {
+			continue
+		}
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM indices_ciegos WHERE id_usuario = $1 AND atributo = $2`, idUsuario, nombre,
+		); err != nil {
+			return fmt.Errorf("error limpiando índice de %s: %w", nombre, err)
+		}
+		if plano == "" {
+			continue
+		}
+		version, indice, err := utils.IndiceCiego(nombre, plano)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO indices_ciegos (id_usuario, atributo, version_clave, indice)
+			VALUES ($1, $2, $3, $4)
+		`, idUsuario, nombre, int(version), indice); err != nil {
+			return fmt.Errorf("error guardando índice de %s: %w", nombre, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// leerDatosCifrados devuelve la cabecera y los valores cifrados del titular.
+func leerDatosCifrados(ctx context.Context, idUsuario int) (models.DatosPersonales, error) {
+	dp := models.DatosPersonales{IDUsuario: idUsuario, Valores: map[string][]byte{}}
+	if err := db.ConnDatos.QueryRow(ctx, `
+		SELECT id_dato, fecha_creacion FROM datos_personales WHERE id_usuario = $1
+	`, idUsuario).Scan(&dp.IDDato, &dp.FechaCreacion); err != nil {
+		return dp, err
+	}
+
+	rows, err := db.ConnDatos.Query(ctx, `
+		SELECT atributo, valor FROM datos_personales_valores WHERE id_usuario = $1
+	`, idUsuario)
+	if err != nil {
+		return dp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nombre string
+		var valor []byte
+		if err := rows.Scan(&nombre, &valor); err != nil {
+			return dp, err
+		}
+		dp.Valores[nombre] = valor
+	}
+	return dp, rows.Err()
+}
+
+// --------------------------
+// Handlers del catálogo (controlador)
+// --------------------------
+
+// ObtenerAtributosDatos GET /controlador/atributos-datos y /procesador/atributos-datos
+func ObtenerAtributosDatos(w http.ResponseWriter, r *http.Request) {
+	catalogo, err := cargarCatalogoDatos(r.Context(), r.URL.Query().Get("todos") != "true")
+	if err != nil {
+		http.Error(w, "Error al consultar atributos de datos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalogo)
+}
+
+func validarDefinicionAtributo(a models.AtributoDato) error {
+	if !reNombreAtributo.MatchString(a.Nombre) {
+		return fmt.Errorf("nombre inválido: use minúsculas, dígitos y '_'")
+	}
+	switch a.Tipo {
+	case "texto", "numero", "fecha", "telefono", "email":
+	case "opcion":
+		if len(a.Opciones) == 0 {
+			return fmt.Errorf("un atributo de tipo opcion necesita opciones")
+		}
+	default:
+		return fmt.Errorf("tipo desconocido: %s", a.Tipo)
+	}
+	switch a.ClasePrivacidad {
+	case "ninguna", "identificador", "cuasi_identificador":
+	default:
+		return fmt.Errorf("clase_privacidad desconocida: %s", a.ClasePrivacidad)
+	}
+	if a.Patron != nil && *a.Patron != "" {
+		if _, err := regexp.Compile(*a.Patron); err != nil {
+			return fmt.Errorf("patrón inválido: %v", err)
+		}
+	}
+	return nil
+}
+
+// CrearAtributoDato POST /controlador/atributos-datos
+func CrearAtributoDato(w http.ResponseWriter, r *http.Request) {
+	var a models.AtributoDato
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if a.Tipo == "" {
+		a.Tipo = "texto"
+	}
+	if a.ClasePrivacidad == "" {
+		a.ClasePrivacidad = "ninguna"
+	}
+	if err := validarDefinicionAtributo(a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.Pool.QueryRow(r.Context(), `
+		INSERT INTO atributos_datos
+		  (nombre, etiqueta, tipo, obligatorio, patron, opciones, longitud_max,
+		   buscable, clase_privacidad, activo)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, TRUE)
+		RETURNING id_atributo
+	`, a.Nombre, a.Etiqueta, a.Tipo, a.Obligatorio, a.Patron, a.Opciones, a.LongitudMax,
+		a.Buscable, a.ClasePrivacidad).Scan(&a.ID)
+	if err != nil {
+		http.Error(w, "Error creando atributo: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.Activo = true
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// ActualizarAtributoDato PUT /controlador/atributos-datos/{id}
+// El nombre no se cambia: es la clave con la que están guardados los valores.
+func ActualizarAtributoDato(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	var a models.AtributoDato
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if err := db.Pool.QueryRow(r.Context(),
+		`SELECT nombre FROM atributos_datos WHERE id_atributo = $1`, id,
+	).Scan(&a.Nombre); err != nil {
+		http.Error(w, "Atributo no encontrado", http.StatusNotFound)
+		return
+	}
+	if a.ClasePrivacidad == "" {
+		a.ClasePrivacidad = "ninguna"
+	}
+	if err := validarDefinicionAtributo(a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Pool.Exec(r.Context(), `
+		UPDATE atributos_datos
+		   SET etiqueta = $1, tipo = $2, obligatorio = $3, patron = $4,
+		       opciones = $5, longitud_max = $6, buscable = $7,
+		       clase_privacidad = $8, activo = $9
+		 WHERE id_atributo = $10
+	`, a.Etiqueta, a.Tipo, a.Obligatorio, a.Patron, a.Opciones, a.LongitudMax, a.Buscable,
+		a.ClasePrivacidad, a.Activo, id); err != nil {
+		http.Error(w, "Error actualizando atributo: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"mensaje": "Atributo actualizado correctamente"})
+}
@@ -0,0 +1,137 @@
+// backend/handlers/condiciones_consentimiento.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// consentimientoDelTitular lee {id} de la ruta y comprueba que el
+// consentimiento pertenece al titular autenticado.
+func consentimientoDelTitular(w http.ResponseWriter, r *http.Request) (int, bool) {
+	idTitular, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return 0, false
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return 0, false
+	}
+	var propio bool
+	if err := db.Pool.QueryRow(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM consentimientos
+		                WHERE id_consentimiento = $1 AND id_usuario = $2)
+	`, id, idTitular).Scan(&propio); err != nil || !propio {
+		http.Error(w, "Consentimiento no encontrado", http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// ObtenerCondicionesConsentimiento GET /titular/consentimientos/{id}/condiciones
+func ObtenerCondicionesConsentimiento(w http.ResponseWriter, r *http.Request) {
+	id, ok := consentimientoDelTitular(w, r)
+	if !ok {
+		return
+	}
+	condiciones, err := utils.CargarCondiciones(r.Context(), []int{id})
+	if err != nil {
+		http.Error(w, "Error leyendo condiciones", http.StatusInternalServerError)
+		return
+	}
+	c, existe := condiciones[id]
+	if !existe {
+		c = models.CondicionesConsentimiento{IDConsentimiento: id, ProcesadoresExcluidos: []int{}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// GuardarCondicionesConsentimiento PUT /titular/consentimientos/{id}/condiciones
+// Reemplaza todas las condiciones del consentimiento.
+func GuardarCondicionesConsentimiento(w http.ResponseWriter, r *http.Request) {
+	id, ok := consentimientoDelTitular(w, r)
+	if !ok {
+		return
+	}
+	var c models.CondicionesConsentimiento
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidarCondiciones(c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.ProcesadoresExcluidos == nil {
+		c.ProcesadoresExcluidos = []int{}
+	}
+	if c.ZonaHoraria == "" {
+		c.ZonaHoraria = utils.ZonaHorariaDefecto
+	}
+
+	if _, err := db.Pool.Exec(r.Context(), `
+		INSERT INTO condiciones_consentimiento
+		  (id_consentimiento, procesadores_excluidos, dias_permitidos, hora_desde, hora_hasta,
+		   max_accesos, zona_horaria, fecha_actualizacion)
+		VALUES ($1, $2, $3, $4::time, $5::time, $6, $7, NOW())
+		ON CONFLICT (id_consentimiento) DO UPDATE
+		   SET procesadores_excluidos = EXCLUDED.procesadores_excluidos,
+		       dias_permitidos        = EXCLUDED.dias_permitidos,
+		       hora_desde             = EXCLUDED.hora_desde,
+		       hora_hasta             = EXCLUDED.hora_hasta,
+		       max_accesos            = EXCLUDED.max_accesos,
+		       zona_horaria           = EXCLUDED.zona_horaria,
+		       fecha_actualizacion    = NOW()
+	`, id, c.ProcesadoresExcluidos, diasONulo(c.DiasPermitidos), c.HoraDesde, c.HoraHasta, c.MaxAccesos, c.ZonaHoraria); err != nil {
+		http.Error(w, "Error guardando condiciones: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	registrarEventoCondiciones(r.Context(), id, "UPDATE-CONDICIONES")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EliminarCondicionesConsentimiento DELETE /titular/consentimientos/{id}/condiciones
+func EliminarCondicionesConsentimiento(w http.ResponseWriter, r *http.Request) {
+	id, ok := consentimientoDelTitular(w, r)
+	if !ok {
+		return
+	}
+	if _, err := db.Pool.Exec(r.Context(),
+		`DELETE FROM condiciones_consentimiento WHERE id_consentimiento = $1`, id,
+	); err != nil {
+		http.Error(w, "Error eliminando condiciones", http.StatusInternalServerError)
+		return
+	}
+	registrarEventoCondiciones(r.Context(), id, "DELETE-CONDICIONES")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func diasONulo(dias []int) []int {
+	if len(dias) == 0 {
+		return nil
+	}
+	return dias
+}
+
+func registrarEventoCondiciones(ctx context.Context, idConsentimiento int, accion string) {
+	idTitular, _ := GetUserIDFromCtx(ctx)
+	auditoria.Registrar(ctx, auditoria.Evento{
+		IDActor: idTitular, Rol: 1, Accion: accion, Recurso: "condiciones_consentimiento",
+		IDRecurso: idConsentimiento, Exito: true, Descripcion: "condiciones del titular",
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"time"
 
+	"backend/auditoria"
 	"backend/db"
 	"backend/models"
 )
@@ -50,11 +51,10 @@ func GuardarConsentimiento(w http.ResponseWriter, r *http.Request) {
 	if in.Estado == "activo" {
 		if in.FechaExpiracion == nil || in.FechaExpiracion.After(finPol) {
 			errMsg := fmt.Sprintf("Fecha_expiracion excede fecha_fin para politica=%d", in.IDPolitica)
-			db.Pool.Exec(ctx, `
-				INSERT INTO auditoria_eventos
-				  (id_usuario, accion, tabla_afectada, descripcion, fecha_evento, exito, error_mensaje)
-				VALUES ($1, 'FALLO-INSERT', 'consentimientos', $2, NOW(), false, $3)
-			`, in.IDUsuario, errMsg, errMsg)
+			auditoria.Registrar(ctx, auditoria.Evento{
+				IDActor: in.IDUsuario, Accion: "FALLO-INSERT", Recurso: "consentimientos",
+				CodigoMotivo: models.MotivoExpiracionFueraVigencia, Descripcion: errMsg, Error: errMsg,
+			})
 
 			http.Error(w,
 				"La fecha de expiración no puede exceder la vigencia de la política",
@@ -129,11 +129,10 @@ func GuardarConsentimiento(w http.ResponseWriter, r *http.Request) {
         RETURNING id_consentimiento
     `, in.IDUsuario, in.IDPolitica, now, in.FechaExpiracion, in.Estado).Scan(&nuevoID)
 	if err != nil {
-		db.Pool.Exec(ctx, `
-			INSERT INTO auditoria_eventos
-			  (id_usuario, accion, tabla_afectada, descripcion, fecha_evento, exito, error_mensaje)
-			VALUES ($1, 'FALLO-INSERT', 'consentimientos', $2, NOW(), false, $3)
-		`, in.IDUsuario, err.Error(), err.Error())
+		auditoria.Registrar(ctx, auditoria.Evento{
+			IDActor: in.IDUsuario, Accion: "FALLO-INSERT", Recurso: "consentimientos",
+			CodigoMotivo: models.MotivoErrorBD, Descripcion: err.Error(), Error: err.Error(),
+		})
 
 		http.Error(w, "Error guardando consentimiento: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -245,11 +244,10 @@ func ObtenerConsentimientosPorUsuario(w http.ResponseWriter, r *http.Request) {
     `, idUsr)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error consultando consentimientos: %v", err)
-		db.Pool.Exec(context.Background(), `
-			INSERT INTO auditoria_eventos
-			  (id_usuario, accion, tabla_afectada, descripcion, fecha_evento, exito, error_mensaje)
-			VALUES ($1, 'FALLO-QUERY', 'consentimientos', $2, NOW(), false, $3)
-		`, idUsr, errMsg, errMsg)
+		auditoria.Registrar(r.Context(), auditoria.Evento{
+			IDActor: idUsr, Accion: "FALLO-QUERY", Recurso: "consentimientos",
+			CodigoMotivo: models.MotivoErrorBD, Descripcion: errMsg, Error: errMsg,
+		})
 
 		http.Error(w, "Error consultando consentimientos", http.StatusInternalServerError)
 		return
@@ -0,0 +1,229 @@
+// backend/handlers/consulta_historica.go
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+   Consultas a fecha
+   -----------------
+   Con el historial temporal (migración 022) la APD puede ver qué había
+   consentido un titular en un instante pasado y repetir la evaluación de
+   acceso de un procesador tal como habría sido entonces: mismos pasos que
+   autorizarAcceso, con el estado de consentimientos, políticas, atributos
+   del tercero y condiciones de ese momento. No se evalúan las cuotas ni la
+   aprobación puntual del titular, que dependen de la secuencia de accesos.
+*/
+
+// EvaluacionConsentimientoView es lo que decidió la evaluación sobre un
+// consentimiento activo en el instante consultado.
+type EvaluacionConsentimientoView struct {
+	CoincideAtributos   bool   `json:"coincide_atributos"`
+	CondicionIncumplida string `json:"condicion_incumplida,omitempty"`
+	DeclaraFinalidad    bool   `json:"declara_finalidad"`
+	Autoriza            bool   `json:"autoriza"`
+}
+
+// ConsentimientoEnView es un consentimiento del titular tal como estaba.
+type ConsentimientoEnView struct {
+	IDConsentimiento int                           `json:"id_consentimiento"`
+	IDPolitica       int                           `json:"id_politica"`
+	Politica         string                        `json:"politica"`
+	VersionPolitica  int                           `json:"version_politica"`
+	Estado           string                        `json:"estado"`
+	FechaOtorgado    *time.Time                    `json:"fecha_otorgado"`
+	FechaExpiracion  *time.Time                    `json:"fecha_expiracion"`
+	Evaluacion       *EvaluacionConsentimientoView `json:"evaluacion,omitempty"` // sólo los activos
+}
+
+// ConsultaHistoricaView responde si el acceso se habría permitido y por qué.
+type ConsultaHistoricaView struct {
+	Instante            time.Time                           `json:"instante"`
+	IDTitular           int                                 `json:"id_titular"`
+	IDProcesador        int                                 `json:"id_procesador"`
+	Finalidad           string                              `json:"finalidad,omitempty"`
+	Permitido           bool                                `json:"permitido"`
+	CodigoMotivo        string                              `json:"codigo_motivo,omitempty"`
+	Motivo              string                              `json:"motivo,omitempty"`
+	Detalle             string                              `json:"detalle,omitempty"`
+	AtributosProcesador []string                            `json:"atributos_procesador"`
+	Consentimientos     []ConsentimientoEnView              `json:"consentimientos"`
+	Campos              map[string]auditoria.CampoDivulgado `json:"campos,omitempty"`
+	RequiereAprobacion  bool                                `json:"requiere_aprobacion"`
+	NoEvaluado          []string                            `json:"no_evaluado"`
+	// HistorialDesde es cuándo empezó a registrarse el historial; antes de
+	// esa fecha sólo se conoce el estado que había al crearlo
+	HistorialDesde    *time.Time `json:"historial_desde,omitempty"`
+	HistorialCompleto bool       `json:"historial_completo"`
+}
+
+// ConsultarAccesoEnFecha GET /apd/api/consentimientos/a-fecha
+//
+//	?id_titular=N&id_procesador=N&instante=2024-05-01T10:00:00Z
+//	&finalidad=COD (opcional: sin ella no se comprueba la finalidad)
+//	&lang=es|en
+func ConsultarAccesoEnFecha(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	idTitular, err1 := strconv.Atoi(q.Get("id_titular"))
+	idProcesador, err2 := strconv.Atoi(q.Get("id_procesador"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Se requieren id_titular e id_procesador", http.StatusBadRequest)
+		return
+	}
+	instante, err := fechaFiltro(q.Get("instante"))
+	if err != nil || instante == nil {
+		http.Error(w, "instante inválido: use YYYY-MM-DD o RFC 3339", http.StatusBadRequest)
+		return
+	}
+	if instante.After(time.Now()) {
+		http.Error(w, "El instante no puede ser futuro", http.StatusBadRequest)
+		return
+	}
+
+	res := ConsultaHistoricaView{
+		Instante: *instante, IDTitular: idTitular, IDProcesador: idProcesador, Finalidad: q.Get("finalidad"),
+		AtributosProcesador: []string{}, NoEvaluado: []string{"cuotas", "aprobacion_titular"},
+	}
+	if err := db.Pool.QueryRow(ctx, `
+		SELECT MIN(valido_desde) FROM historial_temporal WHERE operacion = 'INICIAL'
+	`).Scan(&res.HistorialDesde); err != nil {
+		log.Println("Error leyendo el inicio del historial:", err)
+		http.Error(w, "Error leyendo el historial", http.StatusInternalServerError)
+		return
+	}
+	res.HistorialCompleto = res.HistorialDesde == nil || !instante.Before(*res.HistorialDesde)
+
+	if res.Consentimientos, err = consentimientosEnFecha(r, idTitular, instante); err != nil {
+		log.Printf("Error leyendo consentimientos de %d a %s: %v", idTitular, instante, err)
+		http.Error(w, "Error leyendo el historial", http.StatusInternalServerError)
+		return
+	}
+
+	var traza trazaAcceso
+	aut, denegacion := evaluarAcceso(ctx, idProcesador, idTitular, res.Finalidad, instante, &traza)
+	if denegacion != nil && denegacion.status == http.StatusInternalServerError {
+		log.Printf("Error evaluando el acceso de %d a %d en %s: %s", idProcesador, idTitular, instante, denegacion.motivo)
+		http.Error(w, "Error evaluando el acceso", http.StatusInternalServerError)
+		return
+	}
+	if traza.Atributos != nil {
+		res.AtributosProcesador = traza.Atributos
+	}
+	for i := range res.Consentimientos {
+		c := &res.Consentimientos[i]
+		for _, e := range traza.Activos {
+			if e.IDConsentimiento == c.IDConsentimiento {
+				c.Evaluacion = &EvaluacionConsentimientoView{
+					CoincideAtributos: e.CoincideAtributos, CondicionIncumplida: e.CondicionIncumplida,
+					DeclaraFinalidad: e.DeclaraFinalidad, Autoriza: e.Autoriza,
+				}
+			}
+		}
+	}
+	if denegacion != nil {
+		res.CodigoMotivo, res.Detalle = denegacion.codigo, denegacion.motivo
+		res.Motivo = auditoria.EtiquetaMotivo(denegacion.codigo, idiomaSolicitud(r))
+	} else {
+		res.Permitido = true
+		res.RequiereAprobacion = aut.RequiereAprobacion
+		niveles := make(map[string]string, len(aut.Campos))
+		for nombre, c := range aut.Campos {
+			niveles[nombre] = c.Nivel
+		}
+		res.Campos = aut.Linaje(niveles)
+	}
+
+	idAPD, _ := GetUserIDFromCtx(ctx)
+	auditoria.Registrar(ctx, auditoria.Evento{
+		IDActor: idAPD, Accion: "CONSULTA-A-FECHA", Recurso: "historial_temporal", IDRecurso: idTitular,
+		Exito: true, Descripcion: "procesador=" + strconv.Itoa(idProcesador) + " instante=" + instante.Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// consentimientosEnFecha lista los consentimientos del titular, en cualquier
+// estado, tal como estaban en el instante, con la versión de su política.
+func consentimientosEnFecha(r *http.Request, idTitular int, en *time.Time) ([]ConsentimientoEnView, error) {
+	args := []any{idTitular}
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT c.id_consentimiento, c.id_politica, COALESCE(p.titulo, ''), COALESCE(p.version, 0),
+		       c.estado, c.fecha_otorgado, c.fecha_expiracion
+		  FROM `+utils.TablaEn("consentimientos", en, &args)+` c
+		  LEFT JOIN `+utils.TablaEn("politicas_privacidad", en, &args)+` p ON p.id_politica = c.id_politica
+		 WHERE c.id_usuario = $1
+		 ORDER BY c.id_consentimiento
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lista := []ConsentimientoEnView{}
+	for rows.Next() {
+		var c ConsentimientoEnView
+		if err := rows.Scan(&c.IDConsentimiento, &c.IDPolitica, &c.Politica, &c.VersionPolitica,
+			&c.Estado, &c.FechaOtorgado, &c.FechaExpiracion); err != nil {
+			return nil, err
+		}
+		lista = append(lista, c)
+	}
+	return lista, rows.Err()
+}
+
+// VersionTemporal es una versión de una fila en historial_temporal.
+type VersionTemporal struct {
+	ValidoDesde time.Time       `json:"valido_desde"`
+	ValidoHasta *time.Time      `json:"valido_hasta"` // nil = vigente
+	Operacion   string          `json:"operacion"`
+	Fila        json.RawMessage `json:"fila"`
+}
+
+// ObtenerVersionesConsentimiento GET /apd/api/consents/{id}/versiones
+//
+// Todas las versiones del consentimiento, de la más antigua a la actual. Un
+// consentimiento borrado conserva sus versiones con la última cerrada.
+func ObtenerVersionesConsentimiento(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT valido_desde, valido_hasta, operacion, fila
+		  FROM historial_temporal
+		 WHERE tabla = 'consentimientos'
+		   AND clave = jsonb_build_object('id_consentimiento', $1::int)
+		   AND (valido_hasta IS NULL OR valido_hasta > valido_desde)
+		 ORDER BY valido_desde, id_historial
+	`, id)
+	if err != nil {
+		log.Printf("Error leyendo versiones del consentimiento %d: %v", id, err)
+		http.Error(w, "Error leyendo el historial", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	versiones := []VersionTemporal{}
+	for rows.Next() {
+		var v VersionTemporal
+		if err := rows.Scan(&v.ValidoDesde, &v.ValidoHasta, &v.Operacion, &v.Fila); err != nil {
+			log.Println("scan versiones consentimiento:", err)
+			continue
+		}
+		versiones = append(versiones, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versiones)
+}
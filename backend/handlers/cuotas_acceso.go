@@ -0,0 +1,397 @@
+// backend/handlers/cuotas_acceso.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+   Cuotas de acceso
+   ----------------
+   Limitan cuántos titulares puede descifrar un procesador, para que una
+   cuenta comprometida no pueda vaciar datos_personales de uno en uno. Se
+   cuentan sobre la tabla accesos:
+
+     max_solicitudes    solicitudes en los últimos ventana_segundos
+     max_titulares_dia  titulares distintos con acceso concedido hoy
+
+   Las cuotas sin política se comprueban antes de evaluar el consentimiento;
+   las de política, con las políticas que autorizan el acceso. Las
+   solicitudes rechazadas por cuota no cuentan para la propia cuota.
+
+   Esas comprobaciones tempranas sólo evitan trabajo: la decisión que vale
+   se toma al registrar el acceso (registrarAccesoConCuotas), que vuelve a
+   contar bajo un cerrojo consultivo del procesador y escribe la fila de
+   accesos en la misma transacción. Así dos solicitudes concurrentes (el
+   acceso por lotes) no pueden pasar ambas con el último hueco de la cuota.
+   Si no se pueden leer las cuotas, el acceso se deniega.
+*/
+
+// motivoCuotaExcedida encabeza accesos.motivo cuando se rechaza por cuota.
+const motivoCuotaExcedida = "cuota excedida"
+
+// consultaSQL es lo que evaluarCuotas necesita de db.Pool o de una transacción.
+type consultaSQL interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// denegacionCuotasError es la denegación cuando no se pueden leer las cuotas.
+func denegacionCuotasError(motivo string) *denegacionAcceso {
+	return &denegacionAcceso{
+		codigo:  models.MotivoErrorBD,
+		motivo:  motivo,
+		mensaje: "Error comprobando las cuotas de acceso",
+		status:  http.StatusServiceUnavailable,
+	}
+}
+
+// verificarCuotas comprueba las cuotas del procesador. Con politicas nil se
+// evalúan las cuotas generales; si no, las de esas políticas. Devuelve una
+// denegación 429 con el tiempo sugerido de reintento si alguna se supera.
+func verificarCuotas(ctx context.Context, idProcesador, idTitular int, politicas []int) *denegacionAcceso {
+	den, cuota := evaluarCuotas(ctx, db.Pool, idProcesador, idTitular, politicas)
+	if den != nil && den.codigo == models.MotivoCuotaExcedida {
+		alertarCuotaExcedida(ctx, cuota, idProcesador, den.motivo)
+	}
+	return den
+}
+
+// registrarAccesoConCuotas escribe el acceso concedido ev si, bajo el
+// cerrojo de cuotas del procesador, no se supera ninguna cuota general ni
+// de las políticas indicadas. Si alguna se supera escribe en su lugar la
+// denegación y la devuelve. enTx (opcional) corre en la misma transacción
+// justo antes de escribir el acceso concedido, y puede denegarlo.
+func registrarAccesoConCuotas(ctx context.Context, idProcesador, idTitular int, politicas []int, ev auditoria.Evento, enTx func(pgx.Tx) *denegacionAcceso) *denegacionAcceso {
+	denegar := func(den *denegacionAcceso) *denegacionAcceso {
+		ev.Exito, ev.CodigoMotivo, ev.Descripcion = false, den.codigo, den.motivo
+		if ev.Acceso != nil {
+			acceso := *ev.Acceso
+			acceso.Niveles, acceso.Linaje = nil, nil
+			ev.Acceso = &acceso
+		}
+		return den
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error abriendo transacción de cuotas (procesador=%d): %v", idProcesador, err)
+		den := denegar(denegacionCuotasError("error transacción cuotas"))
+		auditoria.Registrar(ctx, ev)
+		return den
+	}
+	defer tx.Rollback(ctx)
+
+	var den *denegacionAcceso
+	var cuota models.CuotaAcceso
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('cuotas_acceso'), $1::int)`, idProcesador); err != nil {
+		log.Printf("Error tomando el cerrojo de cuotas (procesador=%d): %v", idProcesador, err)
+		den = denegacionCuotasError("error cerrojo cuotas")
+	} else if den, cuota = evaluarCuotas(ctx, tx, idProcesador, idTitular, nil); den == nil {
+		den, cuota = evaluarCuotas(ctx, tx, idProcesador, idTitular, politicas)
+	}
+	if den == nil && enTx != nil {
+		den = enTx(tx)
+	}
+	if den != nil {
+		denegar(den)
+	}
+
+	if err = auditoria.RegistrarEnTx(ctx, tx, ev); err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error registrando el acceso de %d a %d: %v", idProcesador, idTitular, err)
+		tx.Rollback(ctx)
+		if den == nil {
+			den = denegar(&denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error registro acceso", mensaje: "Error registrando el acceso", status: http.StatusInternalServerError})
+		}
+		auditoria.Registrar(ctx, ev)
+		return den
+	}
+	if den != nil && den.codigo == models.MotivoCuotaExcedida {
+		alertarCuotaExcedida(ctx, cuota, idProcesador, den.motivo)
+	}
+	return den
+}
+
+// evaluarCuotas es verificarCuotas sin avisar a nadie; devuelve también la
+// cuota superada. La usa la explicación de accesos (explicar_acceso.go).
+// Si no se pueden leer las cuotas o los accesos, deniega con error_bd.
+func evaluarCuotas(ctx context.Context, q consultaSQL, idProcesador, idTitular int, politicas []int) (*denegacionAcceso, models.CuotaAcceso) {
+	rows, err := q.Query(ctx, `
+		SELECT id_cuota, id_politica, max_solicitudes, ventana_segundos, max_titulares_dia
+		  FROM cuotas_acceso
+		 WHERE activo
+		   AND (id_procesador IS NULL OR id_procesador = $1)
+		   AND CASE WHEN $2::int[] IS NULL THEN id_politica IS NULL
+		            ELSE id_politica = ANY($2) END
+	`, idProcesador, politicas)
+	if err != nil {
+		log.Printf("Error leyendo cuotas (procesador=%d): %v", idProcesador, err)
+		return denegacionCuotasError("error lectura cuotas"), models.CuotaAcceso{}
+	}
+	var cuotas []models.CuotaAcceso
+	for rows.Next() {
+		var c models.CuotaAcceso
+		if err := rows.Scan(&c.ID, &c.IDPolitica, &c.MaxSolicitudes, &c.VentanaSegundos, &c.MaxTitularesDia); err != nil {
+			rows.Close()
+			log.Printf("Error leyendo cuotas (procesador=%d): %v", idProcesador, err)
+			return denegacionCuotasError("error lectura cuotas"), models.CuotaAcceso{}
+		}
+		cuotas = append(cuotas, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error leyendo cuotas (procesador=%d): %v", idProcesador, err)
+		return denegacionCuotasError("error lectura cuotas"), models.CuotaAcceso{}
+	}
+	for _, c := range cuotas {
+		var solicitudes, titularesHoy int
+		var yaAccedido bool
+		var reintentoVentana, reintentoDia float64
+		err := q.QueryRow(ctx, `
+			SELECT COUNT(*) FILTER (WHERE a.fecha_evento > NOW() - $2 * INTERVAL '1 second'),
+			       COALESCE(EXTRACT(EPOCH FROM
+			           MIN(a.fecha_evento) FILTER (WHERE a.fecha_evento > NOW() - $2 * INTERVAL '1 second')
+			           + $2 * INTERVAL '1 second' - NOW()), 0),
+			       COUNT(DISTINCT a.id_titular) FILTER (WHERE a.exito AND a.fecha_evento >= date_trunc('day', NOW())),
+			       COALESCE(BOOL_OR(a.id_titular = $4) FILTER (WHERE a.exito AND a.fecha_evento >= date_trunc('day', NOW())), false),
+			       EXTRACT(EPOCH FROM date_trunc('day', NOW()) + INTERVAL '1 day' - NOW())
+			  FROM accesos a
+			 WHERE a.id_solicitante = $1
+			   AND a.fecha_evento >= LEAST(NOW() - $2 * INTERVAL '1 second', date_trunc('day', NOW()))
+			   AND `+fmt.Sprintf(auditoria.MotivoEfectivoSQL, "a", auditoria.FlujoAccesos)+` IS DISTINCT FROM $5
+			   AND ($3::int IS NULL OR EXISTS (
+			         SELECT 1 FROM consentimientos c
+			          WHERE c.id_politica = $3
+			            AND c.id_consentimiento = ANY(COALESCE(a.consentimientos_autorizantes, ARRAY[a.id_consentimiento]))))
+		`, idProcesador, c.VentanaSegundos, c.IDPolitica, idTitular, models.MotivoCuotaExcedida).
+			Scan(&solicitudes, &reintentoVentana, &titularesHoy, &yaAccedido, &reintentoDia)
+		if err != nil {
+			log.Printf("Error contando accesos para cuota %d: %v", c.ID, err)
+			return denegacionCuotasError(fmt.Sprintf("error contando accesos (cuota %d)", c.ID)), c
+		}
+
+		var den *denegacionAcceso
+		switch {
+		case c.MaxSolicitudes != nil && solicitudes >= *c.MaxSolicitudes:
+			den = &denegacionAcceso{
+				codigo:     models.MotivoCuotaExcedida,
+				motivo:     fmt.Sprintf("%s: %d solicitudes en %ds (cuota %d)", motivoCuotaExcedida, solicitudes, c.VentanaSegundos, c.ID),
+				mensaje:    "Se superó la cuota de solicitudes de acceso",
+				status:     http.StatusTooManyRequests,
+				reintentar: segundosReintento(reintentoVentana),
+			}
+		case c.MaxTitularesDia != nil && !yaAccedido && titularesHoy >= *c.MaxTitularesDia:
+			den = &denegacionAcceso{
+				codigo:     models.MotivoCuotaExcedida,
+				motivo:     fmt.Sprintf("%s: %d titulares distintos hoy (cuota %d)", motivoCuotaExcedida, titularesHoy, c.ID),
+				mensaje:    "Se superó la cuota diaria de titulares distintos",
+				status:     http.StatusTooManyRequests,
+				reintentar: segundosReintento(reintentoDia),
+			}
+		}
+		if den != nil {
+			return den, c
+		}
+	}
+	return nil, models.CuotaAcceso{}
+}
+
+func segundosReintento(s float64) int {
+	if s < 1 {
+		return 1
+	}
+	return int(math.Ceil(s))
+}
+
+// alertarCuotaExcedida avisa a custodios y controladores, como mucho una
+// vez por ventana de la cuota para no inundar las notificaciones.
+func alertarCuotaExcedida(ctx context.Context, c models.CuotaAcceso, idProcesador int, motivo string) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE cuotas_acceso
+		   SET ultima_alerta = NOW()
+		 WHERE id_cuota = $1
+		   AND (ultima_alerta IS NULL OR ultima_alerta < NOW() - ventana_segundos * INTERVAL '1 second')
+	`, c.ID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return
+	}
+
+	var nombre string
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT nombre FROM usuarios WHERE id_usuario = $1`, idProcesador,
+	).Scan(&nombre); err != nil {
+		nombre = "desconocido"
+	}
+	mensaje := fmt.Sprintf("El procesador '%s' (id %d) alcanzó una cuota de acceso a datos personales: %s.", nombre, idProcesador, motivo)
+
+	rows, err := db.Pool.Query(ctx, `SELECT DISTINCT id_usuario, id_rol FROM usuarios_roles WHERE id_rol IN (2, 4)`)
+	if err != nil {
+		log.Printf("Error buscando destinatarios de alerta de cuota: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idUsuario, idRol int
+		if err := rows.Scan(&idUsuario, &idRol); err != nil {
+			continue
+		}
+		url := "/custodio/accesos"
+		if idRol == 2 {
+			url = "/controlador/cuotas-acceso"
+		}
+		_ = CrearNotificacion(ctx, &models.Notificacion{
+			UsuarioID:       idUsuario,
+			Tipo:            "cuota_excedida",
+			ReferenciaTabla: "cuotas_acceso",
+			ReferenciaID:    c.ID,
+			Mensaje:         mensaje,
+			URLRecurso:      ptrString(url),
+		})
+	}
+}
+
+// ObtenerCuotasAcceso GET /controlador/cuotas-acceso
+func ObtenerCuotasAcceso(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT id_cuota, id_procesador, id_politica, max_solicitudes, ventana_segundos,
+		       max_titulares_dia, activo, ultima_alerta, fecha_creacion
+		  FROM cuotas_acceso
+		 ORDER BY id_cuota
+	`)
+	if err != nil {
+		http.Error(w, "Error consultando cuotas", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lista := []models.CuotaAcceso{}
+	for rows.Next() {
+		var c models.CuotaAcceso
+		if err := rows.Scan(&c.ID, &c.IDProcesador, &c.IDPolitica, &c.MaxSolicitudes, &c.VentanaSegundos,
+			&c.MaxTitularesDia, &c.Activo, &c.UltimaAlerta, &c.FechaCreacion); err == nil {
+			lista = append(lista, c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// CrearCuotaAcceso POST /controlador/cuotas-acceso
+func CrearCuotaAcceso(w http.ResponseWriter, r *http.Request) {
+	var c models.CuotaAcceso
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if err := validarCuota(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.Pool.QueryRow(r.Context(), `
+		INSERT INTO cuotas_acceso
+		  (id_procesador, id_politica, max_solicitudes, ventana_segundos, max_titulares_dia, activo)
+		VALUES ($1, $2, $3, $4, $5, TRUE)
+		RETURNING id_cuota, activo, fecha_creacion
+	`, c.IDProcesador, c.IDPolitica, c.MaxSolicitudes, c.VentanaSegundos, c.MaxTitularesDia).
+		Scan(&c.ID, &c.Activo, &c.FechaCreacion)
+	if err != nil {
+		http.Error(w, "Error guardando cuota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// ActualizarCuotaAcceso PUT /controlador/cuotas-acceso/{id}
+func ActualizarCuotaAcceso(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	// activo omitido conserva el valor guardado, no desactiva la cuota
+	var in struct {
+		models.CuotaAcceso
+		Activo *bool `json:"activo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	c := in.CuotaAcceso
+	if err := validarCuota(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Pool.Exec(r.Context(), `
+		UPDATE cuotas_acceso
+		   SET id_procesador = $2, id_politica = $3, max_solicitudes = $4,
+		       ventana_segundos = $5, max_titulares_dia = $6, activo = COALESCE($7, activo)
+		 WHERE id_cuota = $1
+	`, id, c.IDProcesador, c.IDPolitica, c.MaxSolicitudes, c.VentanaSegundos, c.MaxTitularesDia, in.Activo)
+	if err != nil {
+		http.Error(w, "Error actualizando cuota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Cuota no encontrada", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EliminarCuotaAcceso DELETE /controlador/cuotas-acceso/{id}
+func EliminarCuotaAcceso(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	tag, err := db.Pool.Exec(r.Context(), `DELETE FROM cuotas_acceso WHERE id_cuota = $1`, id)
+	if err != nil {
+		http.Error(w, "Error eliminando cuota", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Cuota no encontrada", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validarCuota(c *models.CuotaAcceso) error {
+	if c.MaxSolicitudes == nil && c.MaxTitularesDia == nil {
+		return fmt.Errorf("indique max_solicitudes o max_titulares_dia")
+	}
+	if (c.MaxSolicitudes != nil && *c.MaxSolicitudes <= 0) || (c.MaxTitularesDia != nil && *c.MaxTitularesDia <= 0) {
+		return fmt.Errorf("los límites deben ser positivos")
+	}
+	if c.VentanaSegundos == 0 {
+		c.VentanaSegundos = 3600
+	}
+	if c.VentanaSegundos < 0 {
+		return fmt.Errorf("ventana_segundos inválida")
+	}
+	return nil
+}
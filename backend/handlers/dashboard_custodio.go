@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"backend/auditoria"
 	"backend/db"
+	"backend/models"
 )
 
 type RazonesItem struct {
-	Motivo string `json:"motivo"`
+	Codigo string `json:"codigo"` // del catálogo de motivos de auditoría
+	Motivo string `json:"motivo"` // etiqueta en el idioma pedido (?lang=es|en)
 	Count  int    `json:"count"`
 }
 
@@ -48,32 +52,29 @@ func ObtenerDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3) Conteo de motivos para el chart
+	// 3) Conteo de motivos para el chart, por código del catálogo
 	rows, err := db.Pool.Query(ctx, `
-		SELECT 
-		  CASE 
-		    WHEN error_mensaje ILIKE '%atributo no valido%' THEN 'Atributo no válido'
-		    WHEN error_mensaje ILIKE '%revocada%'        THEN 'Política revocada'
-		    WHEN error_mensaje ILIKE '%expira%'          THEN 'Atributo expirado'
-		    ELSE 'Otro' 
-		  END AS motivo,
-		  COUNT(*) 
-		FROM auditoria_eventos
-		WHERE tabla_afectada='consentimientos' AND accion LIKE 'FALLO%'
-		GROUP BY motivo
-	`)
+		SELECT COALESCE(`+fmt.Sprintf(auditoria.MotivoEfectivoSQL, "e", auditoria.FlujoEventos)+`, $1) AS codigo,
+		       COUNT(*)
+		  FROM auditoria_eventos e
+		 WHERE e.tabla_afectada='consentimientos' AND e.accion LIKE 'FALLO%'
+		 GROUP BY codigo
+		 ORDER BY COUNT(*) DESC, codigo
+	`, models.MotivoOtro)
 	if err != nil {
 		http.Error(w, "Error agrupando motivos: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
+	idioma := idiomaSolicitud(r)
 	var razones []RazonesItem
 	for rows.Next() {
 		var it RazonesItem
-		if err := rows.Scan(&it.Motivo, &it.Count); err != nil {
+		if err := rows.Scan(&it.Codigo, &it.Count); err != nil {
 			continue
 		}
+		it.Motivo = auditoria.EtiquetaMotivo(it.Codigo, idioma)
 		razones = append(razones, it)
 	}
 
@@ -2,31 +2,50 @@
 package handlers
 
 import (
+	"backend/auditoria"
 	"backend/db"
 	"backend/models"
 	"backend/utils"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // --------------------------
 // Estructuras de entrada
 // --------------------------
 
+// DatosPersonalesInput llega como objeto plano: "id_usuario" más un campo
+// por cada atributo del catálogo (p. ej. {"id_usuario": 16, "ciudad": "Quito"}).
 type DatosPersonalesInput struct {
-	IDUsuario       int    `json:"id_usuario"`
-	Telefono        string `json:"telefono"`
-	Celular         string `json:"celular"`
-	Direccion       string `json:"direccion"`
-	Ciudad          string `json:"ciudad"`
-	Provincia       string `json:"provincia"`
-	FechaNacimiento string `json:"fecha_nacimiento"`
-	Genero          string `json:"genero"`
-	EstadoCivil     string `json:"estado_civil"`
+	IDUsuario int
+	Valores   map[string]string
+}
+
+func (in *DatosPersonalesInput) UnmarshalJSON(b []byte) error {
+	var crudo map[string]json.RawMessage
+	if err := json.Unmarshal(b, &crudo); err != nil {
+		return err
+	}
+	in.Valores = map[string]string{}
+	for clave, valor := range crudo {
+		if clave == "id_usuario" {
+			if err := json.Unmarshal(valor, &in.IDUsuario); err != nil {
+				return fmt.Errorf("id_usuario inválido")
+			}
+			continue
+		}
+		var texto string
+		if err := json.Unmarshal(valor, &texto); err != nil {
+			return fmt.Errorf("%s debe ser texto", clave)
+		}
+		in.Valores[clave] = texto
+	}
+	return nil
 }
 
 // --------------------------
@@ -86,12 +105,16 @@ type DatosPersonalesInput struct {
 } */
 
 func construirPoliticaDinamica(idUsuario int) (string, error) {
-	// Siempre incluimos el owner
-	partes := []string{fmt.Sprintf("owner:%d", idUsuario)}
+	// Siempre incluimos el owner (sin fecha: el titular nunca pierde acceso)
+	hijos := []utils.NodoPolitica{utils.Atributo{Nombre: fmt.Sprintf("owner:%d", idUsuario)}}
 
-	// Consulta títulos de políticas activas + pendientes de revocación (<24 h)
+	// Consulta títulos de políticas activas + pendientes de revocación (<24 h),
+	// junto con la fecha en que deja de valer el consentimiento
 	const sqlQuery = `
-		SELECT DISTINCT p.titulo
+		SELECT p.titulo,
+		       MAX(CASE WHEN c.estado = 'revocado_pendiente'
+		                THEN c.fecha_revocacion + INTERVAL '24 hours'
+		                ELSE c.fecha_expiracion END) AS vigente_hasta
 		  FROM consentimientos c
 		  JOIN politicas_privacidad p ON c.id_politica = p.id_politica
 		 WHERE c.id_usuario = $1
@@ -100,6 +123,7 @@ func construirPoliticaDinamica(idUsuario int) (string, error) {
 		      OR (c.estado = 'revocado_pendiente'
 		          AND c.fecha_revocacion > NOW() - INTERVAL '24 hours')
 		   )
+		 GROUP BY p.titulo
 	`
 
 	rows, err := db.Pool.Query(context.Background(), sqlQuery, idUsuario)
@@ -110,19 +134,21 @@ func construirPoliticaDinamica(idUsuario int) (string, error) {
 
 	for rows.Next() {
 		var titulo string
-		if err := rows.Scan(&titulo); err != nil {
+		var hasta *time.Time
+		if err := rows.Scan(&titulo, &hasta); err != nil {
 			return "", fmt.Errorf("error leyendo título de política: %w", err)
 		}
-		partes = append(partes, titulo)
+		// El acceso del título caduca por época cuando vence el consentimiento
+		hijos = append(hijos, utils.Atributo{Nombre: titulo, Hasta: hasta})
 	}
 
 	// Si solo tenemos el owner, devolvemos eso
-	if len(partes) == 1 {
-		return partes[0], nil
+	if len(hijos) == 1 {
+		return hijos[0].String(), nil
 	}
 
-	// Concatenar con OR
-	return strings.Join(partes, " OR "), nil
+	// Unir con OR
+	return utils.Compuerta{Operador: "OR", Hijos: hijos}.String(), nil
 }
 
 // --------------------------
@@ -133,94 +159,35 @@ func GuardarDatosPersonales(w http.ResponseWriter, r *http.Request) {
 
 	var input DatosPersonalesInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
+		http.Error(w, "Datos inválidos: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 1) Construir la política ABE dinámica (owner:<id> OR <títulos de políticas activas>)
-	politica, err := construirPoliticaDinamica(input.IDUsuario)
-	if err != nil {
-		http.Error(w, "No se pudo construir la política ABE: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 2) Helper para cifrar + serializar
-	encrypt := func(plain string) ([]byte, error) {
-		ciph, err := utils.CifrarDatoABE(plain, politica)
-		if err != nil {
-			return nil, err
-		}
-		return utils.SerializarCipher(ciph)
-	}
-
-	// 3) Cifrar cada campo
-	telBytes, err := encrypt(input.Telefono)
+	// 1) Validar contra el catálogo (todos los obligatorios deben venir)
+	catalogo, err := cargarCatalogoDatos(r.Context(), true)
 	if err != nil {
-		http.Error(w, "Error al cifrar teléfono", http.StatusInternalServerError)
+		http.Error(w, "Error al cargar el catálogo de datos", http.StatusInternalServerError)
 		return
 	}
-	celBytes, err := encrypt(input.Celular)
-	if err != nil {
-		http.Error(w, "Error al cifrar celular", http.StatusInternalServerError)
+	if err := validarDatosContraCatalogo(catalogo, input.Valores, true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	dirBytes, err := encrypt(input.Direccion)
-	if err != nil {
-		http.Error(w, "Error al cifrar dirección", http.StatusInternalServerError)
-		return
-	}
-	ciuBytes, err := encrypt(input.Ciudad)
-	if err != nil {
-		http.Error(w, "Error al cifrar ciudad", http.StatusInternalServerError)
-		return
-	}
-	provBytes, err := encrypt(input.Provincia)
-	if err != nil {
-		http.Error(w, "Error al cifrar provincia", http.StatusInternalServerError)
-		return
-	}
-	fechaBytes, err := encrypt(input.FechaNacimiento)
-	if err != nil {
-		http.Error(w, "Error al cifrar fecha de nacimiento", http.StatusInternalServerError)
-		return
-	}
-	genBytes, err := encrypt(input.Genero)
-	if err != nil {
-		http.Error(w, "Error al cifrar género", http.StatusInternalServerError)
-		return
-	}
-	estadoBytes, err := encrypt(input.EstadoCivil)
+
+	// 2) Construir la política ABE dinámica (owner:<id> OR <títulos de políticas activas>)
+	politica, err := construirPoliticaDinamica(input.IDUsuario)
 	if err != nil {
-		http.Error(w, "Error al cifrar estado civil", http.StatusInternalServerError)
+		http.Error(w, "No se pudo construir la política ABE: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 4) Upsert: INSERT o UPDATE según ya exista fila para este usuario
-	_, err = db.ConnDatos.Exec(context.Background(), `
-		INSERT INTO datos_personales
-		  (id_usuario, telefono, celular, direccion, ciudad, provincia,
-		   fecha_nacimiento, genero, estado_civil, fecha_creacion)
-		VALUES
-		  ($1,$2,$3,$4,$5,$6,$7,$8,$9,NOW())
-		ON CONFLICT (id_usuario) DO UPDATE
-		  SET telefono         = EXCLUDED.telefono,
-		      celular          = EXCLUDED.celular,
-		      direccion        = EXCLUDED.direccion,
-		      ciudad           = EXCLUDED.ciudad,
-		      provincia        = EXCLUDED.provincia,
-		      fecha_nacimiento = EXCLUDED.fecha_nacimiento,
-		      genero           = EXCLUDED.genero,
-		      estado_civil     = EXCLUDED.estado_civil
-	`, input.IDUsuario,
-		telBytes, celBytes, dirBytes, ciuBytes, provBytes,
-		fechaBytes, genBytes, estadoBytes,
-	)
-	if err != nil {
+	// 3) Cifrar cada valor y guardarlo en datos_personales_valores
+	if err := guardarValoresCifrados(r.Context(), catalogo, input.IDUsuario, input.Valores, politica); err != nil {
 		http.Error(w, "Error al guardar/actualizar datos personales: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 5) Respuesta con la política que se usó para cifrar
+	// 4) Respuesta con la política que se usó para cifrar
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -257,39 +224,24 @@ func ObtenerDatosPersonales(w http.ResponseWriter, r *http.Request) {
 		}
 		idSolicitante = idSol
 
-		// Si no es el titular, verificar acceso dinámico
+		// Un tercero no lee por aquí: /procesador/acceso-datos aplica cuotas,
+		// finalidad, niveles de divulgación, seudónimos y aprobación
 		if idSolicitante != idUsuario {
-			if ok := utils.VerificarAccesoDinamico(idSolicitante, idUsuario); !ok {
-				http.Error(w,
-					"Acceso denegado: no coincide con política",
-					http.StatusForbidden,
-				)
-				return
-			}
+			auditoria.Registrar(r.Context(), auditoria.Evento{
+				Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, CodigoMotivo: models.MotivoOtro,
+				Descripcion: "lectura de tercero fuera de /procesador/acceso-datos",
+				Acceso:      &auditoria.Acceso{IDTitular: idUsuario},
+			})
+			http.Error(w,
+				"Acceso denegado: los terceros acceden por /procesador/acceso-datos",
+				http.StatusForbidden,
+			)
+			return
 		}
 	}
 
 	// 3) Recuperar los datos cifrados
-	var datos models.DatosPersonales
-	err = db.ConnDatos.QueryRow(context.Background(), `
-        SELECT id_dato, id_usuario,
-               telefono, celular, direccion, ciudad,
-               provincia, fecha_nacimiento, genero, estado_civil, fecha_creacion
-          FROM datos_personales
-         WHERE id_usuario = $1
-    `, idUsuario).Scan(
-		&datos.IDDato,
-		&datos.IDUsuario,
-		&datos.Telefono,
-		&datos.Celular,
-		&datos.Direccion,
-		&datos.Ciudad,
-		&datos.Provincia,
-		&datos.FechaNacimiento,
-		&datos.Genero,
-		&datos.EstadoCivil,
-		&datos.FechaCreacion,
-	)
+	datos, err := leerDatosCifrados(r.Context(), idUsuario)
 	if err != nil {
 		http.Error(w, "Datos personales no encontrados", http.StatusNotFound)
 		return
@@ -302,145 +254,100 @@ func ObtenerDatosPersonales(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 5) Separar la política en claves (para el caso de titular)
-	claves := strings.Split(politica, " OR ")
+	// 5) Desenvolvemos la clave personal owner:<id> del titular con la
+	//    clave derivada de su contraseña (X-Clave-Titular); nunca la maestra.
+	kek, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Clave-Titular"))
+	if err != nil || len(kek) == 0 {
+		http.Error(w, "Falta X-Clave-Titular válida", http.StatusUnauthorized)
+		return
+	}
+	claveTitular, err := utils.CargarClaveTitular(r.Context(), idUsuario, kek)
+	if err != nil {
+		http.Error(w, "No se pudo abrir la clave del titular: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
 
-	// 6) Función helper para descifrar
-	descifrar := func(ciphBytes []byte) string {
+	// 6) Función helper para descifrar con la clave del titular
+	descifrar := func(ciphBytes []byte) (string, bool) {
 		ciph, err := utils.DeserializarCipher(ciphBytes)
 		if err != nil {
-			return "error al deserializar"
-		}
-
-		// Si es el titular, usamos la clave maestra
-		if idSolicitante == idUsuario {
-			plain, err := utils.DescifrarDatoABEConMaster(ciph, claves)
-			if err != nil {
-				return "no autorizado"
-			}
-			return plain
+			return "error al deserializar", false
 		}
-
-		// Si es un tercero → usamos su clave persistida
-		plain, err := utils.DescifrarDatoABEConClaveUsuario(ciph, idSolicitante)
+		plain, err := utils.DescifrarDatoABEConClave(ciph, claveTitular)
 		if err != nil {
-			return "no autorizado"
+			return "no autorizado", false
 		}
-		return plain
+		return plain, true
 	}
 
-	// 7) Construir la respuesta JSON
+	// 7) Construir la respuesta JSON: un campo por atributo guardado
 	resp := map[string]interface{}{
 		"id_dato":            datos.IDDato,
 		"id_usuario":         datos.IDUsuario,
-		"telefono":           descifrar(datos.Telefono),
-		"celular":            descifrar(datos.Celular),
-		"direccion":          descifrar(datos.Direccion),
-		"ciudad":             descifrar(datos.Ciudad),
-		"provincia":          descifrar(datos.Provincia),
-		"fecha_nacimiento":   descifrar(datos.FechaNacimiento),
-		"genero":             descifrar(datos.Genero),
-		"estado_civil":       descifrar(datos.EstadoCivil),
 		"fecha_creacion":     datos.FechaCreacion,
 		"politica_utilizada": politica,
 	}
+	leidos := map[string]string{}
+	for nombre, valor := range datos.Valores {
+		plano, ok := descifrar(valor)
+		resp[nombre] = plano
+		if ok {
+			leidos[nombre] = utils.NivelCompleto
+		}
+	}
 	// 8) Registrar acceso SATISFACTORIO
-	LogAcceso(r.Context(), idSolicitante, datos.IDDato, true, "")
+	auditoria.Registrar(r.Context(), auditoria.Evento{
+		Flujo: auditoria.FlujoAccesos, IDActor: idSolicitante, Exito: true,
+		Acceso: &auditoria.Acceso{IDTitular: idUsuario, Niveles: leidos},
+	})
+	avisarLecturaTitular(r.Context(), idUsuario, idSolicitante, leidos, "")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ActualizarDatosPersonales sólo reescribe los atributos que vienen en el cuerpo.
 func ActualizarDatosPersonales(w http.ResponseWriter, r *http.Request) {
 
 	var input DatosPersonalesInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Datos inválidos", http.StatusBadRequest)
-		return
-	}
-
-	// 1) Reconstruir la política ABE dinámica (owner:<id> OR <títulos de políticas activas>)
-	politica, err := construirPoliticaDinamica(input.IDUsuario)
-	if err != nil {
-		http.Error(w, "No se pudo construir la política ABE: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Datos inválidos: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2) Helper para cifrar + serializar
-	encrypt := func(plain string) ([]byte, error) {
-		ciph, err := utils.CifrarDatoABE(plain, politica)
-		if err != nil {
-			return nil, err
-		}
-		return utils.SerializarCipher(ciph)
-	}
-
-	// 3) Cifrar cada campo
-	telBytes, err := encrypt(input.Telefono)
-	if err != nil {
-		http.Error(w, "Error al cifrar teléfono", http.StatusInternalServerError)
-		return
-	}
-	celBytes, err := encrypt(input.Celular)
-	if err != nil {
-		http.Error(w, "Error al cifrar celular", http.StatusInternalServerError)
+	// 1) Debe existir la cabecera de datos del titular
+	var existe bool
+	if err := db.ConnDatos.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM datos_personales WHERE id_usuario = $1)`, input.IDUsuario,
+	).Scan(&existe); err != nil {
+		http.Error(w, "Error al consultar datos personales", http.StatusInternalServerError)
 		return
 	}
-	dirBytes, err := encrypt(input.Direccion)
-	if err != nil {
-		http.Error(w, "Error al cifrar dirección", http.StatusInternalServerError)
-		return
-	}
-	ciuBytes, err := encrypt(input.Ciudad)
-	if err != nil {
-		http.Error(w, "Error al cifrar ciudad", http.StatusInternalServerError)
-		return
-	}
-	provBytes, err := encrypt(input.Provincia)
-	if err != nil {
-		http.Error(w, "Error al cifrar provincia", http.StatusInternalServerError)
+	if !existe {
+		http.Error(w, "Datos personales no encontrados", http.StatusNotFound)
 		return
 	}
-	fechaBytes, err := encrypt(input.FechaNacimiento)
+
+	// 2) Validar sólo los atributos enviados
+	catalogo, err := cargarCatalogoDatos(r.Context(), true)
 	if err != nil {
-		http.Error(w, "Error al cifrar fecha de nacimiento", http.StatusInternalServerError)
+		http.Error(w, "Error al cargar el catálogo de datos", http.StatusInternalServerError)
 		return
 	}
-	genBytes, err := encrypt(input.Genero)
-	if err != nil {
-		http.Error(w, "Error al cifrar género", http.StatusInternalServerError)
+	if err := validarDatosContraCatalogo(catalogo, input.Valores, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	estadoBytes, err := encrypt(input.EstadoCivil)
+
+	// 3) Reconstruir la política ABE dinámica (owner:<id> OR <títulos de políticas activas>)
+	politica, err := construirPoliticaDinamica(input.IDUsuario)
 	if err != nil {
-		http.Error(w, "Error al cifrar estado civil", http.StatusInternalServerError)
+		http.Error(w, "No se pudo construir la política ABE: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 4) Ejecutar el UPDATE en lugar del INSERT
-	_, err = db.ConnDatos.Exec(context.Background(), `
-		UPDATE datos_personales
-		   SET telefono         = $1,
-		       celular          = $2,
-		       direccion        = $3,
-		       ciudad           = $4,
-		       provincia        = $5,
-		       fecha_nacimiento = $6,
-		       genero           = $7,
-		       estado_civil     = $8
-		 WHERE id_usuario = $9
-	`,
-		telBytes,
-		celBytes,
-		dirBytes,
-		ciuBytes,
-		provBytes,
-		fechaBytes,
-		genBytes,
-		estadoBytes,
-		input.IDUsuario,
-	)
-	if err != nil {
+	// 4) Cifrar y reescribir los atributos recibidos
+	if err := guardarValoresCifrados(r.Context(), catalogo, input.IDUsuario, input.Valores, politica); err != nil {
 		http.Error(w, "Error al actualizar datos personales: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -470,6 +377,18 @@ func EliminarDatosPersonales(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := db.ConnDatos.Exec(context.Background(),
+		`DELETE FROM datos_personales_valores WHERE id_usuario = $1`, idUsuario,
+	); err != nil {
+		http.Error(w, "Error al eliminar datos personales", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.ConnDatos.Exec(context.Background(),
+		`DELETE FROM indices_ciegos WHERE id_usuario = $1`, idUsuario,
+	); err != nil {
+		http.Error(w, "Error al eliminar datos personales", http.StatusInternalServerError)
+		return
+	}
 	if _, err := db.ConnDatos.Exec(context.Background(),
 		`DELETE FROM datos_personales WHERE id_usuario = $1`, idUsuario,
 	); err != nil {
@@ -0,0 +1,365 @@
+// backend/handlers/estado_consentimiento.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/db"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+   Estado de consentimiento para procesadores
+   ------------------------------------------
+   Permite comprobar, sin descifrar nada, si el consentimiento de un titular
+   para una política (por id o por título/atributo) sigue vigente. Se aplican
+   las mismas reglas que al acceso: la política debe coincidir con un
+   atributo del procesador y el titular no debe haberlo excluido; si no, la
+   respuesta es "no_autorizado" y no revela nada más. Como en el acceso, un
+   titular que sólo consintió políticas seudonimizadas se consulta por su
+   seudónimo: por id real, las políticas seudonimizadas dan "no_autorizado".
+   Las respuestas se guardan estadoCacheTTL en memoria; los errores de
+   lectura ("error") no se guardan.
+*/
+
+const (
+	estadoCacheTTL     = 30 * time.Second
+	estadoCacheMax     = 10000
+	estadoLoteMaxPares = 500
+)
+
+// ConsultaEstado es un par (titular, política o atributo). El titular se da
+// por id_usuario o por seudonimo.
+type ConsultaEstado struct {
+	IDUsuario  int    `json:"id_usuario,omitempty"`
+	Seudonimo  string `json:"seudonimo,omitempty"`
+	IDPolitica int    `json:"id_politica,omitempty"`
+	Atributo   string `json:"atributo,omitempty"`
+}
+
+// EstadoConsentimiento es la respuesta para un par consultado.
+type EstadoConsentimiento struct {
+	ConsultaEstado
+	// Estado: vigente | condicionado | revocado | expirado | sin_consentimiento
+	// | no_autorizado | consulta_invalida | error
+	Estado              string     `json:"estado"`
+	Vigente             bool       `json:"vigente"`
+	IDConsentimiento    int        `json:"id_consentimiento,omitempty"`
+	Politica            string     `json:"politica,omitempty"`
+	FechaExpiracion     *time.Time `json:"fecha_expiracion,omitempty"`
+	RevocacionPendiente bool       `json:"revocacion_pendiente"`
+	FechaRevocacion     *time.Time `json:"fecha_revocacion,omitempty"`
+	AtributosPermitidos []string   `json:"atributos_permitidos,omitempty"`
+	RequiereAprobacion  bool       `json:"requiere_aprobacion"`
+	CondicionIncumplida string     `json:"condicion_incumplida,omitempty"`
+	ConsultadoEn        time.Time  `json:"consultado_en"`
+}
+
+type entradaEstado struct {
+	estado EstadoConsentimiento
+	vence  time.Time
+}
+
+var (
+	estadoCacheMu sync.Mutex
+	estadoCache   = map[string]entradaEstado{}
+)
+
+func claveEstado(idProcesador int, q ConsultaEstado) string {
+	return strconv.Itoa(idProcesador) + "|" + strconv.Itoa(q.IDUsuario) + "|" +
+		strconv.Itoa(q.IDPolitica) + "|" + q.Atributo + "|" + q.Seudonimo
+}
+
+// consultarEstado resuelve un par con caché breve.
+func consultarEstado(ctx context.Context, idProcesador int, atributos []string, q ConsultaEstado) EstadoConsentimiento {
+	clave := claveEstado(idProcesador, q)
+	ahora := time.Now()
+
+	estadoCacheMu.Lock()
+	if e, ok := estadoCache[clave]; ok && ahora.Before(e.vence) {
+		estadoCacheMu.Unlock()
+		return e.estado
+	}
+	estadoCacheMu.Unlock()
+
+	res, err := calcularEstado(ctx, idProcesador, atributos, q)
+	if err != nil {
+		// Nunca se da por bueno (ni se guarda) un estado que no se pudo leer
+		log.Printf("Error calculando estado de consentimiento (procesador=%d): %v", idProcesador, err)
+		return EstadoConsentimiento{ConsultaEstado: q, Estado: "error", ConsultadoEn: ahora}
+	}
+	res.ConsultadoEn = ahora
+
+	estadoCacheMu.Lock()
+	if len(estadoCache) >= estadoCacheMax {
+		for k, e := range estadoCache {
+			if ahora.After(e.vence) {
+				delete(estadoCache, k)
+			}
+		}
+		if len(estadoCache) >= estadoCacheMax {
+			estadoCache = map[string]entradaEstado{}
+		}
+	}
+	estadoCache[clave] = entradaEstado{estado: res, vence: ahora.Add(estadoCacheTTL)}
+	estadoCacheMu.Unlock()
+	return res
+}
+
+func calcularEstado(ctx context.Context, idProcesador int, atributos []string, q ConsultaEstado) (EstadoConsentimiento, error) {
+	res := EstadoConsentimiento{ConsultaEstado: q, Estado: "no_autorizado"}
+	noAutorizado := EstadoConsentimiento{ConsultaEstado: q, Estado: "no_autorizado"}
+
+	idTitular := q.IDUsuario
+	if q.Seudonimo != "" {
+		err := db.Pool.QueryRow(ctx, `
+			SELECT id_titular FROM seudonimos WHERE id_procesador = $1 AND seudonimo = $2
+		`, idProcesador, q.Seudonimo).Scan(&idTitular)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return noAutorizado, nil
+		}
+		if err != nil {
+			return res, err
+		}
+	} else {
+		// Por id real, una política seudonimizada sólo se informa si otra
+		// política del procesador ya identifica al titular
+		var seudonimizada bool
+		if err := db.Pool.QueryRow(ctx, `
+			SELECT COALESCE(bool_or(modo_acceso = 'seudonimizado'), FALSE)
+			  FROM politicas_privacidad
+			 WHERE ($1 > 0 AND id_politica = $1) OR ($1 = 0 AND titulo = $2)
+		`, q.IDPolitica, q.Atributo).Scan(&seudonimizada); err != nil {
+			return res, err
+		}
+		if seudonimizada {
+			identificado, err := titularIdentificado(ctx, idProcesador, atributos, idTitular)
+			if err != nil {
+				return res, err
+			}
+			if !identificado {
+				return noAutorizado, nil
+			}
+		}
+	}
+
+	// El consentimiento más favorable del titular para esa política
+	var titulo, estado string
+	var vigente bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT c.id_consentimiento, p.titulo, c.estado, c.fecha_expiracion,
+		       COALESCE(c.revocado_pendiente, FALSE), c.fecha_revocacion, p.requiere_aprobacion,
+		       c.estado = 'activo' AND c.fecha_expiracion > NOW(),
+		       ARRAY(SELECT ad.nombre
+		               FROM politica_atributo pa
+		               JOIN atributos_datos ad ON ad.id_atributo = pa.id_atributo
+		              WHERE pa.id_politica = c.id_politica
+		              ORDER BY ad.nombre)
+		  FROM consentimientos c
+		  JOIN politicas_privacidad p ON p.id_politica = c.id_politica
+		 WHERE c.id_usuario = $1
+		   AND (($2 > 0 AND c.id_politica = $2) OR ($2 = 0 AND p.titulo = $3))
+		 ORDER BY (c.estado = 'activo' AND c.fecha_expiracion > NOW()) DESC, c.fecha_expiracion DESC
+		 LIMIT 1
+	`, idTitular, q.IDPolitica, q.Atributo).Scan(
+		&res.IDConsentimiento, &titulo, &estado, &res.FechaExpiracion,
+		&res.RevocacionPendiente, &res.FechaRevocacion, &res.RequiereAprobacion,
+		&vigente, &res.AtributosPermitidos)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Sin consentimiento: sólo se dice si la política sería visible
+		sinConsentimiento := EstadoConsentimiento{ConsultaEstado: q, Estado: "sin_consentimiento"}
+		if q.Atributo != "" && contiene(atributos, q.Atributo) {
+			return sinConsentimiento, nil
+		}
+		if q.IDPolitica > 0 {
+			var t string
+			err := db.Pool.QueryRow(ctx, `SELECT titulo FROM politicas_privacidad WHERE id_politica = $1`,
+				q.IDPolitica).Scan(&t)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return res, err
+			}
+			if err == nil && contiene(atributos, t) {
+				return sinConsentimiento, nil
+			}
+		}
+		return noAutorizado, nil
+	}
+	if err != nil {
+		return res, err
+	}
+
+	// Mismas reglas que el acceso: atributo del procesador y condiciones
+	if !contiene(atributos, titulo) {
+		return noAutorizado, nil
+	}
+	condiciones, err := utils.CargarCondiciones(ctx, []int{res.IDConsentimiento})
+	if err != nil {
+		return res, err
+	}
+	if c, ok := condiciones[res.IDConsentimiento]; ok {
+		incumplida := utils.EvaluarCondiciones(c, idProcesador, time.Now())
+		if incumplida == utils.CondicionProcesadorExcluido {
+			return noAutorizado, nil
+		}
+		// Horario o máximo de accesos: el consentimiento existe pero ahora no permite acceder
+		res.CondicionIncumplida = incumplida
+	}
+
+	res.Politica = titulo
+	res.Vigente = vigente && res.CondicionIncumplida == ""
+	switch {
+	case vigente && res.CondicionIncumplida != "":
+		res.Estado = "condicionado"
+	case vigente:
+		res.Estado = "vigente"
+	case estado == "revocado":
+		res.Estado = "revocado"
+	default:
+		res.Estado = "expirado"
+	}
+	return res, nil
+}
+
+// titularIdentificado indica si el titular tiene algún consentimiento activo
+// a una política no seudonimizada que coincide con los atributos del
+// procesador y no lo excluye, como aut.Identificado en el acceso.
+func titularIdentificado(ctx context.Context, idProcesador int, atributos []string, idTitular int) (bool, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT c.id_consentimiento
+		  FROM consentimientos c
+		  JOIN politicas_privacidad p ON p.id_politica = c.id_politica
+		 WHERE c.id_usuario = $1
+		   AND c.estado = 'activo'
+		   AND c.fecha_expiracion > NOW()
+		   AND p.modo_acceso <> 'seudonimizado'
+		   AND p.titulo = ANY($2)
+	`, idTitular, atributos)
+	if err != nil {
+		return false, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return false, err
+	}
+	condiciones, err := utils.CargarCondiciones(ctx, ids)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		c, ok := condiciones[id]
+		if !ok || utils.EvaluarCondiciones(c, idProcesador, time.Now()) != utils.CondicionProcesadorExcluido {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// idProcesadorYAtributos lee X-User-ID y los atributos asignados al procesador.
+func idProcesadorYAtributos(w http.ResponseWriter, r *http.Request) (int, []string, bool) {
+	idProcesador, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta o es inválido X-User-ID", http.StatusUnauthorized)
+		return 0, nil, false
+	}
+	atributos, err := utils.AtributosTercero(r.Context(), idProcesador)
+	if err != nil {
+		// Sin atributos asignados nada es visible, pero no es un error
+		atributos = nil
+	}
+	return idProcesador, atributos, true
+}
+
+// ObtenerEstadoConsentimiento GET /procesador/estado-consentimiento?(id_usuario=N|seudonimo=S)&(id_politica=M|atributo=X)
+func ObtenerEstadoConsentimiento(w http.ResponseWriter, r *http.Request) {
+	idProcesador, atributos, ok := idProcesadorYAtributos(w, r)
+	if !ok {
+		return
+	}
+	var q ConsultaEstado
+	var err error
+	q.Seudonimo = strings.TrimSpace(r.URL.Query().Get("seudonimo"))
+	if s := r.URL.Query().Get("id_usuario"); s != "" || q.Seudonimo == "" {
+		if q.IDUsuario, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "id_usuario inválido", http.StatusBadRequest)
+			return
+		}
+	}
+	if (q.IDUsuario == 0) == (q.Seudonimo == "") {
+		http.Error(w, "Indique id_usuario o seudonimo", http.StatusBadRequest)
+		return
+	}
+	if s := r.URL.Query().Get("id_politica"); s != "" {
+		if q.IDPolitica, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "id_politica inválido", http.StatusBadRequest)
+			return
+		}
+	}
+	q.Atributo = strings.TrimSpace(r.URL.Query().Get("atributo"))
+	if (q.IDPolitica == 0) == (q.Atributo == "") {
+		http.Error(w, "Indique id_politica o atributo", http.StatusBadRequest)
+		return
+	}
+
+	res := consultarEstado(r.Context(), idProcesador, atributos, q)
+	if res.Estado == "error" {
+		http.Error(w, "Error consultando el consentimiento", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=30")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ObtenerEstadoConsentimientoLote POST /procesador/estado-consentimiento/lote
+// Body: {"consultas": [{"id_usuario": 1, "id_politica": 2}, {"seudonimo": "sd_…", "atributo": "marketing"}]}
+func ObtenerEstadoConsentimientoLote(w http.ResponseWriter, r *http.Request) {
+	idProcesador, atributos, ok := idProcesadorYAtributos(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Consultas []ConsultaEstado `json:"consultas"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if len(in.Consultas) == 0 || len(in.Consultas) > estadoLoteMaxPares {
+		http.Error(w, "Se requieren entre 1 y "+strconv.Itoa(estadoLoteMaxPares)+" consultas", http.StatusBadRequest)
+		return
+	}
+
+	resultados := make([]EstadoConsentimiento, 0, len(in.Consultas))
+	for _, q := range in.Consultas {
+		q.Atributo = strings.TrimSpace(q.Atributo)
+		q.Seudonimo = strings.TrimSpace(q.Seudonimo)
+		if (q.IDUsuario == 0) == (q.Seudonimo == "") || (q.IDPolitica == 0) == (q.Atributo == "") {
+			resultados = append(resultados, EstadoConsentimiento{ConsultaEstado: q, Estado: "consulta_invalida"})
+			continue
+		}
+		resultados = append(resultados, consultarEstado(r.Context(), idProcesador, atributos, q))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=30")
+	json.NewEncoder(w).Encode(resultados)
+}
@@ -0,0 +1,364 @@
+// backend/handlers/evaluacion_acceso.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+   Evaluación de acceso de terceros
+   --------------------------------
+   Un titular puede tener varios consentimientos activos a la vez. Sólo
+   autorizan al tercero aquellos cuya política (título) coincide con uno de
+   sus atributos, cuyas condiciones del titular se cumplen ahora y que
+   declaran la finalidad pedida. Los campos entregados
+   son la unión de los atributos de esas políticas; si un campo lo autorizan
+   varias, gana la que más divulga (identificado antes que seudonimizado,
+   luego por nivel de divulgación).
+
+   La misma evaluación se puede repetir en un instante pasado con el
+   historial temporal (migración 022) y dejar una traza de cada paso para
+   explicarla (consulta_historica.go, explicar_acceso.go).
+*/
+
+// consentimientoAutorizante es un consentimiento activo que autoriza al tercero.
+type consentimientoAutorizante struct {
+	IDConsentimiento    int
+	IDPolitica          int
+	Version             int
+	Titulo              string
+	FechaExp            time.Time
+	ModoAcceso          string
+	GeneralizacionFecha string
+	RequiereAprobacion  bool
+}
+
+// campoAutorizado es un atributo entregable y la política que lo autoriza.
+type campoAutorizado struct {
+	Nombre       string
+	Nivel        string
+	Autorizacion *consentimientoAutorizante
+}
+
+// autorizacionAcceso es lo que un tercero puede ver de un titular.
+type autorizacionAcceso struct {
+	Consentimientos  []consentimientoAutorizante
+	Campos           map[string]campoAutorizado
+	Permitidos       []string  // nombres de Campos, ordenados
+	IDConsentimiento int       // consentimiento principal (columna accesos.id_consentimiento)
+	FechaExp         time.Time // la mayor expiración entre los autorizantes
+	// Identificado es true si alguna política autorizante no es seudonimizada
+	Identificado bool
+	// RequiereAprobacion es true si alguna política autorizante exige que el
+	// titular apruebe cada acceso (ver solicitudes_acceso_titular.go)
+	RequiereAprobacion bool
+}
+
+// IDsConsentimientos lista los consentimientos autorizantes para la auditoría.
+func (a *autorizacionAcceso) IDsConsentimientos() []int {
+	ids := make([]int, 0, len(a.Consentimientos))
+	for _, c := range a.Consentimientos {
+		ids = append(ids, c.IDConsentimiento)
+	}
+	return ids
+}
+
+// Linaje describe, para la auditoría, qué política autorizó cada campo
+// entregado y con qué nivel; incluye el email si alguna política
+// autorizante identifica al titular.
+func (a *autorizacionAcceso) Linaje(niveles map[string]string) map[string]auditoria.CampoDivulgado {
+	if len(niveles) == 0 {
+		return nil
+	}
+	linaje := make(map[string]auditoria.CampoDivulgado, len(niveles)+1)
+	for campo, nivel := range niveles {
+		linaje[campo] = linajeCampo(nivel, a.Campos[campo].Autorizacion)
+	}
+	if a.Identificado {
+		for i := range a.Consentimientos {
+			if c := &a.Consentimientos[i]; c.ModoAcceso != "seudonimizado" {
+				linaje["email"] = linajeCampo(utils.NivelCompleto, c)
+				break
+			}
+		}
+	}
+	return linaje
+}
+
+func linajeCampo(nivel string, c *consentimientoAutorizante) auditoria.CampoDivulgado {
+	cd := auditoria.CampoDivulgado{Nivel: nivel}
+	if c != nil {
+		cd.IDPolitica, cd.Version, cd.IDConsentimiento = c.IDPolitica, c.Version, c.IDConsentimiento
+	}
+	return cd
+}
+
+// IDsPoliticas lista las políticas autorizantes, sin repetir.
+func (a *autorizacionAcceso) IDsPoliticas() []int {
+	ids := []int{}
+	vistas := map[int]bool{}
+	for _, c := range a.Consentimientos {
+		if !vistas[c.IDPolitica] {
+			vistas[c.IDPolitica] = true
+			ids = append(ids, c.IDPolitica)
+		}
+	}
+	return ids
+}
+
+// denegacionAcceso describe por qué no se concede el acceso.
+type denegacionAcceso struct {
+	codigo  string // del catálogo de auditoría; se guarda en accesos.codigo_motivo
+	motivo  string // detalle, en accesos.motivo
+	mensaje string // se devuelve al cliente
+	status  int
+	// reintentar son los segundos sugeridos en Retry-After (cuotas, 429)
+	reintentar int
+}
+
+// trazaAcceso recoge, si se pide, cómo se evaluó cada consentimiento activo.
+type trazaAcceso struct {
+	Activos   []consentimientoEvaluado
+	Atributos []string // del tercero; nil si accede el propio titular
+}
+
+// consentimientoEvaluado es un consentimiento activo y lo que decidió sobre él.
+type consentimientoEvaluado struct {
+	consentimientoAutorizante
+	DeclaraFinalidad    bool
+	CoincideAtributos   bool
+	CondicionIncumplida string // "" si se cumplen o no se llegaron a evaluar
+	Autoriza            bool
+}
+
+func (t *trazaAcceso) consentimiento(id int) *consentimientoEvaluado {
+	for i := range t.Activos {
+		if t.Activos[i].IDConsentimiento == id {
+			return &t.Activos[i]
+		}
+	}
+	return &consentimientoEvaluado{}
+}
+
+// autorizarAcceso evalúa todos los consentimientos activos del titular
+// frente a los atributos del tercero y la finalidad declarada. Siempre
+// devuelve aut (con IDConsentimiento = 0 si no hay ninguno) para auditar.
+func autorizarAcceso(ctx context.Context, idSolicitante, idTitular int, finalidad string) (*autorizacionAcceso, *denegacionAcceso) {
+	return evaluarAcceso(ctx, idSolicitante, idTitular, finalidad, nil, nil)
+}
+
+// evaluarAcceso es autorizarAcceso en el instante en (nil = ahora), con el
+// estado que tenían entonces consentimientos, políticas, atributos del
+// tercero y condiciones. Con traza no nil deja constancia de cada paso. Una
+// finalidad vacía no se comprueba (sólo en consultas históricas).
+func evaluarAcceso(ctx context.Context, idSolicitante, idTitular int, finalidad string, en *time.Time, traza *trazaAcceso) (*autorizacionAcceso, *denegacionAcceso) {
+	aut := &autorizacionAcceso{Campos: map[string]campoAutorizado{}}
+
+	// 1) Consentimientos activos del titular con la configuración de su política
+	args := []any{idTitular, finalidad}
+	rows, err := db.Pool.Query(ctx, `
+		SELECT c.id_consentimiento, c.id_politica, p.version, p.titulo, c.fecha_expiracion,
+		       p.modo_acceso, p.generalizacion_fecha, p.requiere_aprobacion,
+		       $2 = '' OR EXISTS (SELECT 1 FROM `+utils.TablaEn("politica_finalidad", en, &args)+` pf
+		                           WHERE pf.id_politica = c.id_politica AND pf.codigo = $2)
+		  FROM `+utils.TablaEn("consentimientos", en, &args)+` c
+		  JOIN `+utils.TablaEn("politicas_privacidad", en, &args)+` p ON p.id_politica = c.id_politica
+		 WHERE c.id_usuario      = $1
+		   AND c.estado          = 'activo'
+		   AND c.fecha_expiracion > `+utils.InstanteEn(en, &args)+`
+		 ORDER BY c.fecha_expiracion DESC
+	`, args...)
+	if err != nil {
+		return aut, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura consentimientos", mensaje: "Error leyendo consentimientos", status: http.StatusInternalServerError}
+	}
+	type candidato struct {
+		consentimientoAutorizante
+		declaraFinalidad bool
+	}
+	var activos []candidato
+	for rows.Next() {
+		var c candidato
+		if err := rows.Scan(&c.IDConsentimiento, &c.IDPolitica, &c.Version, &c.Titulo, &c.FechaExp,
+			&c.ModoAcceso, &c.GeneralizacionFecha, &c.RequiereAprobacion, &c.declaraFinalidad); err == nil {
+			activos = append(activos, c)
+			if traza != nil {
+				traza.Activos = append(traza.Activos, consentimientoEvaluado{
+					consentimientoAutorizante: c.consentimientoAutorizante, DeclaraFinalidad: c.declaraFinalidad,
+				})
+			}
+		}
+	}
+	rows.Close()
+	if len(activos) == 0 {
+		return aut, &denegacionAcceso{codigo: models.MotivoSinConsentimiento, motivo: "no hay consentimiento activo", mensaje: "No hay consentimiento activo", status: http.StatusNotFound}
+	}
+	aut.IDConsentimiento = activos[0].IDConsentimiento
+
+	// 2) Intersección con los atributos del tercero (el titular lo ve todo)
+	var atributos map[string]bool
+	if idSolicitante != idTitular {
+		lista, err := utils.AtributosTerceroEn(ctx, idSolicitante, en)
+		if traza != nil {
+			traza.Atributos = lista
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return aut, &denegacionAcceso{codigo: models.MotivoPoliticaNoCoincide, motivo: "política no coincide", mensaje: "Acceso denegado según política", status: http.StatusForbidden}
+		}
+		if err != nil {
+			return aut, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura atributos del tercero", mensaje: "Error leyendo atributos del procesador", status: http.StatusInternalServerError}
+		}
+		atributos = map[string]bool{}
+		for _, a := range lista {
+			atributos[a] = true
+		}
+	}
+	var coinciden []candidato
+	for _, c := range activos {
+		if atributos == nil || atributos[c.Titulo] {
+			coinciden = append(coinciden, c)
+			if traza != nil {
+				traza.consentimiento(c.IDConsentimiento).CoincideAtributos = true
+			}
+		}
+	}
+	if len(coinciden) == 0 {
+		return aut, &denegacionAcceso{codigo: models.MotivoPoliticaNoCoincide, motivo: "política no coincide", mensaje: "Acceso denegado según política", status: http.StatusForbidden}
+	}
+	aut.IDConsentimiento = coinciden[0].IDConsentimiento
+
+	// 2b) Condiciones que el titular puso a cada consentimiento
+	if atributos != nil {
+		ids := make([]int, 0, len(coinciden))
+		for _, c := range coinciden {
+			ids = append(ids, c.IDConsentimiento)
+		}
+		condiciones, err := utils.CargarCondicionesEn(ctx, ids, en)
+		if err != nil {
+			return aut, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura condiciones", mensaje: "Error leyendo condiciones del consentimiento", status: http.StatusInternalServerError}
+		}
+		ahora := time.Now()
+		if en != nil {
+			ahora = *en
+		}
+		var cumplen []candidato
+		bloqueo := ""
+		for _, c := range coinciden {
+			cond, ok := condiciones[c.IDConsentimiento]
+			if !ok {
+				cumplen = append(cumplen, c)
+				continue
+			}
+			if incumplida := utils.EvaluarCondiciones(cond, idSolicitante, ahora); incumplida == "" {
+				cumplen = append(cumplen, c)
+			} else {
+				if traza != nil {
+					traza.consentimiento(c.IDConsentimiento).CondicionIncumplida = incumplida
+				}
+				if bloqueo == "" {
+					bloqueo = incumplida
+				}
+			}
+		}
+		if len(cumplen) == 0 {
+			return aut, &denegacionAcceso{codigo: models.MotivoCondicionTitular, motivo: utils.MotivoCondicion(bloqueo), mensaje: "Acceso denegado por las condiciones del titular", status: http.StatusForbidden}
+		}
+		coinciden = cumplen
+		aut.IDConsentimiento = coinciden[0].IDConsentimiento
+	}
+
+	// 3) Sólo cuentan las políticas que declaran la finalidad pedida
+	for _, c := range coinciden {
+		if c.declaraFinalidad {
+			aut.Consentimientos = append(aut.Consentimientos, c.consentimientoAutorizante)
+			if traza != nil {
+				traza.consentimiento(c.IDConsentimiento).Autoriza = true
+			}
+		}
+	}
+	if len(aut.Consentimientos) == 0 {
+		return aut, &denegacionAcceso{codigo: models.MotivoFinalidadNoDeclarada, motivo: "finalidad no declarada en la política", mensaje: "La finalidad no está declarada en la política consentida", status: http.StatusForbidden}
+	}
+	aut.IDConsentimiento = aut.Consentimientos[0].IDConsentimiento
+
+	// 4) Unión de atributos de las políticas autorizantes
+	porPolitica := map[int]*consentimientoAutorizante{}
+	idsPolitica := make([]int, 0, len(aut.Consentimientos))
+	for i := range aut.Consentimientos {
+		c := &aut.Consentimientos[i]
+		if c.FechaExp.After(aut.FechaExp) {
+			aut.FechaExp = c.FechaExp
+		}
+		if c.ModoAcceso != "seudonimizado" {
+			aut.Identificado = true
+		}
+		if c.RequiereAprobacion {
+			aut.RequiereAprobacion = true
+		}
+		if _, ok := porPolitica[c.IDPolitica]; !ok {
+			porPolitica[c.IDPolitica] = c
+			idsPolitica = append(idsPolitica, c.IDPolitica)
+		}
+	}
+
+	args = []any{idsPolitica}
+	attrRows, err := db.Pool.Query(ctx, `
+		SELECT pa.id_politica, ad.nombre, pa.nivel_divulgacion
+		  FROM `+utils.TablaEn("politica_atributo", en, &args)+` pa
+		  JOIN atributos_datos ad ON ad.id_atributo = pa.id_atributo
+		 WHERE pa.id_politica = ANY($1)
+	`, args...)
+	if err != nil {
+		return aut, &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura atributos", mensaje: "Error leyendo atributos de política", status: http.StatusInternalServerError}
+	}
+	defer attrRows.Close()
+
+	for attrRows.Next() {
+		var idPol int
+		var nombre, nivel string
+		if err := attrRows.Scan(&idPol, &nombre, &nivel); err != nil {
+			continue
+		}
+		nuevo := campoAutorizado{Nombre: nombre, Nivel: nivel, Autorizacion: porPolitica[idPol]}
+		if actual, ok := aut.Campos[nombre]; !ok || divulgaMas(nuevo, actual) {
+			aut.Campos[nombre] = nuevo
+		}
+	}
+	for nombre := range aut.Campos {
+		aut.Permitidos = append(aut.Permitidos, nombre)
+	}
+	sort.Strings(aut.Permitidos)
+	return aut, nil
+}
+
+// divulgaMas decide qué autorización gana cuando dos políticas cubren el mismo campo.
+func divulgaMas(a, b campoAutorizado) bool {
+	aIdent := a.Autorizacion.ModoAcceso != "seudonimizado"
+	bIdent := b.Autorizacion.ModoAcceso != "seudonimizado"
+	if aIdent != bIdent {
+		return aIdent
+	}
+	return rangoNivel(a.Nivel) > rangoNivel(b.Nivel)
+}
+
+func rangoNivel(nivel string) int {
+	switch nivel {
+	case utils.NivelCompleto:
+		return 3
+	case utils.NivelEnmascarado, utils.NivelGeneralizado:
+		return 2
+	case utils.NivelPresencia:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,341 @@
+// backend/handlers/explicar_acceso.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+   Explicación de accesos
+   ----------------------
+   Un procesador al que se le deniega el acceso sólo ve "Acceso denegado
+   según política" o campos "no autorizado". Controladores, custodios y la
+   APD pueden pedir la explicación completa para un par procesador/titular:
+   se recorren los mismos pasos que accederDatosTitular, en el mismo orden,
+   pero sin efectos (no se consumen aprobaciones, no se avisa de cuotas, no
+   se registra un acceso) y sin descifrar nada: para el cifrado ABE sólo se
+   compara la política guardada en el sobre de cada campo con los atributos
+   de la clave del procesador.
+*/
+
+// Resultados de un paso de la explicación
+const (
+	pasoOK         = "ok"
+	pasoFallo      = "fallo"
+	pasoNoEvaluado = "no_evaluado" // un paso anterior ya decidió la denegación
+	pasoNoAplica   = "no_aplica"
+)
+
+// PasoExplicacion es un paso de la decisión de acceso.
+type PasoExplicacion struct {
+	Paso      string `json:"paso"`
+	Resultado string `json:"resultado"`
+	Detalle   string `json:"detalle,omitempty"`
+}
+
+// ConsentimientoExplicado es un consentimiento activo del titular y lo que
+// la evaluación decidió sobre él.
+type ConsentimientoExplicado struct {
+	IDConsentimiento    int       `json:"id_consentimiento"`
+	IDPolitica          int       `json:"id_politica"`
+	Politica            string    `json:"politica"`
+	VersionPolitica     int       `json:"version_politica"`
+	FechaExpiracion     time.Time `json:"fecha_expiracion"`
+	CoincideAtributos   bool      `json:"coincide_atributos"`
+	CondicionIncumplida string    `json:"condicion_incumplida,omitempty"`
+	DeclaraFinalidad    bool      `json:"declara_finalidad"`
+	Autoriza            bool      `json:"autoriza"`
+}
+
+// CampoExplicado es un dato del titular: si la política lo permite y si la
+// clave del procesador satisface la política con que está cifrado.
+type CampoExplicado struct {
+	Campo           string `json:"campo"`
+	Permitido       bool   `json:"permitido"`
+	Nivel           string `json:"nivel,omitempty"`
+	IDPolitica      int    `json:"id_politica,omitempty"`
+	Politica        string `json:"politica,omitempty"`
+	PoliticaCifrado string `json:"politica_cifrado,omitempty"`
+	// Descifrable es nil si el valor no está guardado o su política no se
+	// puede recuperar (cifrados antiguos)
+	Descifrable *bool `json:"descifrable,omitempty"`
+}
+
+// ExplicacionAccesoView es la decisión completa para un procesador y un titular.
+type ExplicacionAccesoView struct {
+	IDProcesador          int                       `json:"id_procesador"`
+	IDTitular             int                       `json:"id_titular"`
+	Finalidad             string                    `json:"finalidad,omitempty"`
+	Permitido             bool                      `json:"permitido"`
+	PasoFallido           string                    `json:"paso_fallido,omitempty"`
+	CodigoMotivo          string                    `json:"codigo_motivo,omitempty"`
+	Motivo                string                    `json:"motivo,omitempty"`
+	Detalle               string                    `json:"detalle,omitempty"`
+	Pasos                 []PasoExplicacion         `json:"pasos"`
+	AtributosProcesador   []string                  `json:"atributos_procesador"`
+	AtributosClave        []string                  `json:"atributos_clave"`
+	Consentimientos       []ConsentimientoExplicado `json:"consentimientos"`
+	PoliticasCoincidentes []string                  `json:"politicas_coincidentes"`
+	PoliticaABE           string                    `json:"politica_abe"`
+	Campos                []CampoExplicado          `json:"campos"`
+}
+
+// pasoDeMotivo indica qué paso de evaluarAcceso produce cada denegación.
+var pasoDeMotivo = map[string]string{
+	models.MotivoSinConsentimiento:    "consentimientos_activos",
+	models.MotivoPoliticaNoCoincide:   "atributos_procesador",
+	models.MotivoCondicionTitular:     "condiciones_titular",
+	models.MotivoFinalidadNoDeclarada: "finalidad",
+}
+
+// ExplicarAcceso GET /controlador/accesos/explicar, /custodio/accesos/explicar
+// y /apd/api/accesos/explicar
+//
+//	?id_procesador=N&id_titular=N
+//	&finalidad=COD (opcional: sin ella no se comprueba la finalidad)
+//	&lang=es|en
+func ExplicarAcceso(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	idProcesador, err1 := strconv.Atoi(q.Get("id_procesador"))
+	idTitular, err2 := strconv.Atoi(q.Get("id_titular"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Se requieren id_procesador e id_titular", http.StatusBadRequest)
+		return
+	}
+	if idProcesador == idTitular {
+		http.Error(w, "El titular siempre accede a sus propios datos", http.StatusBadRequest)
+		return
+	}
+	finalidad := strings.TrimSpace(q.Get("finalidad"))
+
+	res := ExplicacionAccesoView{
+		IDProcesador: idProcesador, IDTitular: idTitular, Finalidad: finalidad,
+		AtributosProcesador: []string{}, AtributosClave: []string{},
+		Consentimientos: []ConsentimientoExplicado{}, PoliticasCoincidentes: []string{}, Campos: []CampoExplicado{},
+	}
+	var den *denegacionAcceso
+	paso := func(nombre, resultado, detalle string) {
+		res.Pasos = append(res.Pasos, PasoExplicacion{nombre, resultado, detalle})
+		if resultado == pasoFallo && res.PasoFallido == "" {
+			res.PasoFallido = nombre
+		}
+	}
+
+	// 1) Cuotas generales: se siguen evaluando los demás pasos, porque una
+	// cuota se libera sola y lo interesante es qué pasará después
+	d, _ := evaluarCuotas(ctx, db.Pool, idProcesador, idTitular, nil)
+	if d != nil && d.codigo == models.MotivoErrorBD {
+		log.Printf("Error explicando el acceso de %d a %d: %s", idProcesador, idTitular, d.motivo)
+		http.Error(w, "Error evaluando el acceso", http.StatusInternalServerError)
+		return
+	}
+	if d != nil {
+		paso("cuotas_generales", pasoFallo, fmt.Sprintf("%s (reintentar en %ds)", d.motivo, d.reintentar))
+		den = d
+	} else {
+		paso("cuotas_generales", pasoOK, "")
+	}
+
+	// 2)-5) Consentimientos, atributos, condiciones y finalidad
+	var traza trazaAcceso
+	aut, denEval := evaluarAcceso(ctx, idProcesador, idTitular, finalidad, nil, &traza)
+	if denEval != nil && denEval.status == http.StatusInternalServerError {
+		log.Printf("Error explicando el acceso de %d a %d: %s", idProcesador, idTitular, denEval.motivo)
+		http.Error(w, "Error evaluando el acceso", http.StatusInternalServerError)
+		return
+	}
+	// Sin consentimientos activos evaluarAcceso no llega a leer los atributos
+	if traza.Atributos == nil {
+		traza.Atributos, _ = utils.AtributosTercero(ctx, idProcesador)
+	}
+	if traza.Atributos != nil {
+		res.AtributosProcesador = traza.Atributos
+	}
+	for _, c := range traza.Activos {
+		res.Consentimientos = append(res.Consentimientos, ConsentimientoExplicado{
+			IDConsentimiento: c.IDConsentimiento, IDPolitica: c.IDPolitica, Politica: c.Titulo,
+			VersionPolitica: c.Version, FechaExpiracion: c.FechaExp,
+			CoincideAtributos: c.CoincideAtributos, CondicionIncumplida: c.CondicionIncumplida,
+			DeclaraFinalidad: c.DeclaraFinalidad, Autoriza: c.Autoriza,
+		})
+		if c.CoincideAtributos && !contiene(res.PoliticasCoincidentes, c.Titulo) {
+			res.PoliticasCoincidentes = append(res.PoliticasCoincidentes, c.Titulo)
+		}
+	}
+	fallido := ""
+	if denEval != nil {
+		fallido = pasoDeMotivo[denEval.codigo]
+		if den == nil {
+			den = denEval
+		}
+	}
+	pasosEvaluacion := []struct{ nombre, detalle string }{
+		{"consentimientos_activos", fmt.Sprintf("%d consentimiento(s) activo(s)", len(traza.Activos))},
+		{"atributos_procesador", strings.Join(res.PoliticasCoincidentes, ", ")},
+		{"condiciones_titular", ""},
+		{"finalidad", ""},
+	}
+	evaluado := true
+	for _, p := range pasosEvaluacion {
+		switch {
+		case !evaluado:
+			paso(p.nombre, pasoNoEvaluado, "")
+		case p.nombre == fallido:
+			paso(p.nombre, pasoFallo, denEval.motivo)
+			evaluado = false
+		case p.nombre == "finalidad" && finalidad == "":
+			paso(p.nombre, pasoNoEvaluado, "sin finalidad: se consideran todas las políticas")
+		default:
+			paso(p.nombre, pasoOK, p.detalle)
+		}
+	}
+
+	// 6)-7) Cuotas de las políticas autorizantes y aprobación del titular
+	if denEval != nil {
+		paso("cuotas_politica", pasoNoEvaluado, "")
+		paso("aprobacion_titular", pasoNoEvaluado, "")
+	} else {
+		d, _ := evaluarCuotas(ctx, db.Pool, idProcesador, idTitular, aut.IDsPoliticas())
+		if d != nil && d.codigo == models.MotivoErrorBD {
+			log.Printf("Error explicando el acceso de %d a %d: %s", idProcesador, idTitular, d.motivo)
+			http.Error(w, "Error evaluando el acceso", http.StatusInternalServerError)
+			return
+		}
+		if d != nil {
+			paso("cuotas_politica", pasoFallo, fmt.Sprintf("%s (reintentar en %ds)", d.motivo, d.reintentar))
+			if den == nil {
+				den = d
+			}
+		} else {
+			paso("cuotas_politica", pasoOK, "")
+		}
+		switch {
+		case !aut.RequiereAprobacion:
+			paso("aprobacion_titular", pasoNoAplica, "")
+		case finalidad == "":
+			paso("aprobacion_titular", pasoNoEvaluado, "la aprobación es por finalidad")
+		default:
+			if idAprobacion, aprobados, err := aprobacionVigente(ctx, idProcesador, idTitular, finalidad); err != nil {
+				paso("aprobacion_titular", pasoFallo, "sin aprobación vigente del titular")
+				if den == nil {
+					den = &denegacionAcceso{codigo: models.MotivoSinAprobacion, motivo: "sin aprobación del titular"}
+				}
+			} else {
+				var filtrados []string
+				for _, campo := range aut.Permitidos {
+					if contiene(aprobados, campo) {
+						filtrados = append(filtrados, campo)
+					}
+				}
+				aut.Permitidos = filtrados
+				paso("aprobacion_titular", pasoOK, fmt.Sprintf("aprobación %d", idAprobacion))
+			}
+		}
+	}
+
+	// 8) Cifrado ABE: política actual del titular y, por campo, la del sobre
+	politica, err := construirPoliticaDinamica(idTitular)
+	if err != nil {
+		log.Printf("Error construyendo la política ABE de %d: %v", idTitular, err)
+	}
+	res.PoliticaABE = politica
+	if atributos, err := utils.CargarAtributosUsuario(idProcesador); err == nil {
+		res.AtributosClave = atributos
+	}
+	datos, err := leerDatosCifrados(ctx, idTitular)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error leyendo datos cifrados de %d: %v", idTitular, err)
+		http.Error(w, "Error leyendo datos personales", http.StatusInternalServerError)
+		return
+	}
+	permitidos := map[string]bool{}
+	if den == nil {
+		for _, campo := range aut.Permitidos {
+			permitidos[campo] = true
+		}
+	}
+	nombres := []string{}
+	for nombre := range datos.Valores {
+		nombres = append(nombres, nombre)
+	}
+	for nombre := range permitidos {
+		if _, ok := datos.Valores[nombre]; !ok {
+			nombres = append(nombres, nombre)
+		}
+	}
+	sort.Strings(nombres)
+	ahora := time.Now()
+	var sinClave []string
+	for _, nombre := range nombres {
+		c := CampoExplicado{Campo: nombre, Permitido: permitidos[nombre]}
+		if ca, ok := aut.Campos[nombre]; ok && c.Permitido {
+			c.Nivel = ca.Nivel
+			if ca.Autorizacion != nil {
+				c.IDPolitica, c.Politica = ca.Autorizacion.IDPolitica, ca.Autorizacion.Titulo
+			}
+		}
+		if valor, ok := datos.Valores[nombre]; ok {
+			sobre, err := utils.AbrirSobre(valor)
+			switch {
+			case errors.Is(err, utils.ErrVersionClave):
+				// cifrado con claves maestras anteriores: nadie puede descifrarlo
+				descifrable := false
+				c.Descifrable = &descifrable
+			case err == nil && sobre.Politica != "":
+				c.PoliticaCifrado = sobre.Politica
+				if arbol, err := utils.ParsearPolitica(sobre.Politica); err == nil {
+					descifrable := utils.SatisfacePolitica(arbol, res.AtributosClave, ahora)
+					c.Descifrable = &descifrable
+				}
+			}
+			if c.Permitido && c.Descifrable != nil && !*c.Descifrable {
+				sinClave = append(sinClave, nombre)
+			}
+		}
+		res.Campos = append(res.Campos, c)
+	}
+	switch {
+	case den != nil:
+		paso("cifrado_abe", pasoNoEvaluado, "")
+	case len(sinClave) > 0:
+		paso("cifrado_abe", pasoFallo, "la clave del procesador no descifra: "+strings.Join(sinClave, ", "))
+	default:
+		paso("cifrado_abe", pasoOK, "")
+	}
+
+	if den != nil {
+		res.CodigoMotivo, res.Detalle = den.codigo, den.motivo
+		res.Motivo = auditoria.EtiquetaMotivo(den.codigo, idiomaSolicitud(r))
+	} else {
+		res.Permitido = true
+	}
+
+	// El actor es la APD o el controlador (CtxUserIDKey) o el custodio (CtxUserIDKey1)
+	idActor, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		idActor, _ = UserIDFromContext(ctx)
+	}
+	auditoria.Registrar(ctx, auditoria.Evento{
+		IDActor: idActor, Accion: "EXPLICAR-ACCESO", Recurso: "accesos", IDRecurso: idTitular, Exito: true,
+		Descripcion: fmt.Sprintf("procesador=%d finalidad=%s", idProcesador, finalidad),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
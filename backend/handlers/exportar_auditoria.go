@@ -0,0 +1,143 @@
+// backend/handlers/exportar_auditoria.go
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/auditoria"
+)
+
+// exportarFlushCada es cada cuántas filas se vacía la respuesta al cliente.
+const exportarFlushCada = 500
+
+// ExportarAuditoria GET /apd/api/auditoria/exportar y /custodio/auditoria/exportar
+//
+//	?flujo=accesos|auditoria_eventos|auditoria_politicas|auditoria_login
+//	&formato=csv|ndjson (ndjson por defecto)
+//	&desde=2024-01-01&hasta=2024-02-01 (fecha o RFC 3339; hasta excluido)
+//	&actor=ID&id_politica=N&exito=true|false&motivo=cuota_excedida
+//	&lang=es|en (etiqueta del motivo; si no, Accept-Language)
+//
+// La respuesta se genera fila a fila; la propia exportación queda auditada.
+func ExportarAuditoria(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	flujo := auditoria.Flujo(q.Get("flujo"))
+	if !auditoria.FlujoValido(flujo) {
+		http.Error(w, "flujo inválido: accesos, auditoria_eventos, auditoria_politicas o auditoria_login", http.StatusBadRequest)
+		return
+	}
+	formato := q.Get("formato")
+	if formato == "" {
+		formato = "ndjson"
+	}
+
+	filtro, err := leerFiltroExportacion(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idioma := idiomaSolicitud(r)
+
+	escritor, err := auditoria.NuevoEscritorExportacion(w, formato)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if formato == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("%s_%s.%s", flujo, time.Now().Format("20060102-150405"), formato)))
+
+	// El actor es la APD (CtxUserIDKey) o el custodio (CtxUserIDKey1)
+	idActor, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		idActor, _ = UserIDFromContext(r.Context())
+	}
+	auditoria.Registrar(r.Context(), auditoria.Evento{
+		IDActor: idActor, Accion: "EXPORTAR-AUDITORIA", Recurso: string(flujo), Exito: true,
+		Descripcion: fmt.Sprintf("formato=%s %s", formato, r.URL.RawQuery),
+	})
+
+	flusher, _ := w.(http.Flusher)
+	filas := 0
+	err = auditoria.Exportar(r.Context(), flujo, filtro, func(reg auditoria.Registro) error {
+		if reg.CodigoMotivo != nil {
+			reg.EtiquetaMotivo = auditoria.EtiquetaMotivo(*reg.CodigoMotivo, idioma)
+		}
+		if err := escritor.Escribir(reg); err != nil {
+			return err
+		}
+		if filas++; filas%exportarFlushCada == 0 && flusher != nil {
+			if err := escritor.Terminar(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = escritor.Terminar()
+	}
+	if err != nil && filas == 0 {
+		// Aún no se ha enviado nada al cliente
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Error exportando auditoría", http.StatusInternalServerError)
+		log.Printf("Error exportando %s: %v", flujo, err)
+	} else if err != nil {
+		// Las cabeceras ya se enviaron: sólo queda cortar la respuesta
+		log.Printf("Error exportando %s tras %d filas: %v", flujo, filas, err)
+	}
+}
+
+// leerFiltroExportacion interpreta desde, hasta, actor, id_politica, exito y motivo.
+func leerFiltroExportacion(q url.Values) (auditoria.FiltroExportacion, error) {
+	var filtro auditoria.FiltroExportacion
+	var err error
+	if filtro.Desde, err = fechaFiltro(q.Get("desde")); err != nil {
+		return filtro, fmt.Errorf("desde inválido")
+	}
+	if filtro.Hasta, err = fechaFiltro(q.Get("hasta")); err != nil {
+		return filtro, fmt.Errorf("hasta inválido")
+	}
+	filtro.Actor = strings.TrimSpace(q.Get("actor"))
+	if s := q.Get("id_politica"); s != "" {
+		if filtro.IDPolitica, err = strconv.Atoi(s); err != nil {
+			return filtro, fmt.Errorf("id_politica inválido")
+		}
+	}
+	if s := q.Get("exito"); s != "" {
+		exito, err := strconv.ParseBool(s)
+		if err != nil {
+			return filtro, fmt.Errorf("exito inválido")
+		}
+		filtro.Exito = &exito
+	}
+	if filtro.Motivo = q.Get("motivo"); filtro.Motivo != "" && !auditoria.MotivoValido(filtro.Motivo) {
+		return filtro, fmt.Errorf("motivo desconocido: %q", filtro.Motivo)
+	}
+	return filtro, nil
+}
+
+// fechaFiltro acepta una fecha (YYYY-MM-DD) o un instante RFC 3339; vacío es sin filtro.
+func fechaFiltro(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,76 @@
+// backend/handlers/finalidades.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"backend/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// justificacionAccesoMinima es la longitud mínima de la justificación que
+// acompaña a la finalidad en cada acceso de un procesador.
+const justificacionAccesoMinima = 10
+
+type Finalidad struct {
+	Codigo      string `json:"codigo"`
+	Descripcion string `json:"descripcion"`
+}
+
+// ObtenerFinalidades GET /controlador/finalidades y /procesador/finalidades
+// Con ?id_politica=N devuelve sólo las finalidades declaradas por esa política.
+func ObtenerFinalidades(w http.ResponseWriter, r *http.Request) {
+	idPol := 0
+	if s := r.URL.Query().Get("id_politica"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "id_politica inválido", http.StatusBadRequest)
+			return
+		}
+		idPol = id
+	}
+
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT f.codigo, f.descripcion
+		  FROM finalidades f
+		 WHERE $1 = 0
+		    OR EXISTS (SELECT 1 FROM politica_finalidad pf
+		                WHERE pf.codigo = f.codigo AND pf.id_politica = $1)
+		 ORDER BY f.codigo
+	`, idPol)
+	if err != nil {
+		http.Error(w, "Error consultando finalidades", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lista := []Finalidad{}
+	for rows.Next() {
+		var f Finalidad
+		if err := rows.Scan(&f.Codigo, &f.Descripcion); err == nil {
+			lista = append(lista, f)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// guardarFinalidadesPolitica reemplaza las finalidades declaradas por una política.
+func guardarFinalidadesPolitica(ctx context.Context, tx pgx.Tx, idPol int, codigos []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM politica_finalidad WHERE id_politica = $1`, idPol); err != nil {
+		return err
+	}
+	for _, c := range codigos {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO politica_finalidad (id_politica, codigo) VALUES ($1, $2)
+		`, idPol, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,11 +3,15 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
+	"backend/auditoria"
 	"backend/db"
+	"backend/models"
 	"backend/utils"
 )
 
@@ -33,6 +37,9 @@ func LoginUsuario(w http.ResponseWriter, r *http.Request) {
 		  WHERE email=$1`, req.Email,
 	).Scan(&userID)
 	if err != nil {
+		auditoria.Registrar(r.Context(), auditoria.Evento{
+			Flujo: auditoria.FlujoLogin, CodigoMotivo: models.MotivoUsuarioDesconocido,
+		})
 		http.Error(w, "Usuario no encontrado", http.StatusUnauthorized)
 		return
 	}
@@ -53,6 +60,9 @@ func LoginUsuario(w http.ResponseWriter, r *http.Request) {
 	// 3) Comparar hash
 	hashIngresado := utils.HashConSalt(req.Password, salt)
 	if !bytes.Equal([]byte(hashIngresado), hashGuardado) {
+		auditoria.Registrar(r.Context(), auditoria.Evento{
+			Flujo: auditoria.FlujoLogin, IDActor: userID, CodigoMotivo: models.MotivoCredencialesInvalidas,
+		})
 		http.Error(w, "Contraseña incorrecta", http.StatusUnauthorized)
 		return
 	}
@@ -68,14 +78,8 @@ func LoginUsuario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 5) Auditoría (opcional)
-	if _, err := db.Pool.Exec(context.Background(),
-		`INSERT INTO auditoria_login(id_usuario, exito, fecha_intento)
-		 VALUES($1, TRUE, NOW())`,
-		userID,
-	); err != nil {
-		log.Println("Error auditando login:", err)
-	}
+	// 5) Auditoría
+	auditoria.Registrar(r.Context(), auditoria.Evento{Flujo: auditoria.FlujoLogin, IDActor: userID, Exito: true})
 
 	// 6) Traer el id_rol
 	var idRol int
@@ -91,11 +95,27 @@ func LoginUsuario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 7) Responder con el ID y el rol
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"mensaje":    "Inicio de sesión exitoso",
 		"id_usuario": userID,
 		"id_rol":     idRol,
-	})
+	}
+
+	// 7) Titular: derivar la clave que desenvuelve su clave personal ABE.
+	//    Si aún no tiene (usuarios anteriores), se emite ahora.
+	if idRol == 1 {
+		kek, err := utils.ClaveEnvolturaTitular(context.Background(), userID, req.Password)
+		if errors.Is(err, utils.ErrClaveTitularInexistente) {
+			kek, err = utils.GuardarClaveTitular(context.Background(), userID, req.Password)
+		}
+		if err != nil {
+			log.Println("Error preparando clave del titular:", err)
+		} else {
+			resp["clave_titular"] = base64.StdEncoding.EncodeToString(kek)
+		}
+	}
+
+	// 8) Responder con el ID y el rol
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
@@ -2,7 +2,6 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 
@@ -10,14 +9,41 @@ import (
 	"backend/models"
 )
 
+// MonitorConsentimientos GET /controlador/monitoreo-consentimientos
+// Eventos de auditoría con el estado del consentimiento afectado, paginados
+// y filtrables con los parámetros comunes (ver paginacion.go).
 func MonitorConsentimientos(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Pool.Query(context.Background(), `
+	pg, err := prepararPagina(r, especMonitoreo, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	const desde = `
+        FROM auditoria_eventos ae
+        JOIN usuarios u 
+          ON u.id_usuario = ae.id_usuario
+        LEFT JOIN consentimientos c
+          ON ae.tabla_afectada='consentimientos'
+         AND c.id_consentimiento = ae.registro_id
+        `
+	var total int64
+	if err := db.Pool.QueryRow(r.Context(), `SELECT COUNT(*) `+desde+pg.WhereTotal, pg.ArgsTotal...).Scan(&total); err != nil {
+		http.Error(w, "Error al leer auditoría: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Pool.Query(r.Context(), `
         SELECT
           ae.id_evento,
+          ae.id_usuario,
+          ae.accion,
+          ae.tabla_afectada,
+          COALESCE(ae.registro_id, 0),
+          COALESCE(ae.descripcion, ''),
+          ae.fecha_evento,
+          COALESCE(ae.exito, TRUE),
           u.nombre                  AS nombre_usuario,
           COALESCE(rp.nombre,'Sin rol') AS rol_usuario,
-          p.titulo                  AS accion,
-          ae.fecha_evento,
           CASE
             WHEN ae.tabla_afectada='consentimientos'
                  AND c.estado='activo' THEN 'Concedido'
@@ -26,13 +52,8 @@ func MonitorConsentimientos(w http.ResponseWriter, r *http.Request) {
             ELSE 'Desconocido'
           END                        AS permiso_estado,
           p.titulo                   AS politica_nombre,
-          c.fecha_otorgado           AS fecha_consentimiento,
-          c.fecha_expiracion         AS fecha_expiracion_consent,
-          ae.descripcion,
-          ut.nombre                  AS titular_nombre
-        FROM auditoria_eventos ae
-        JOIN usuarios u 
-          ON u.id_usuario = ae.id_usuario
+          ut.nombre                  AS titular_nombre,
+          `+pg.Cursor+desde+`
         LEFT JOIN (
           SELECT ur.id_usuario, r.nombre
             FROM usuarios_roles ur
@@ -44,23 +65,26 @@ func MonitorConsentimientos(w http.ResponseWriter, r *http.Request) {
               GROUP BY id_usuario
            )
         ) rp ON rp.id_usuario = ae.id_usuario
-        LEFT JOIN consentimientos c
-          ON ae.tabla_afectada='consentimientos'
-         AND c.id_consentimiento = ae.registro_id
         LEFT JOIN politicas_privacidad p
           ON p.id_politica = c.id_politica
         LEFT JOIN usuarios ut
           ON ut.id_usuario = c.id_usuario   -- el titular del consentimiento
-        ORDER BY ae.fecha_evento DESC
-    `)
+        `+pg.Where+`
+        `+pg.OrderBy+`
+        `+pg.Limit, pg.Args...)
 	if err != nil {
 		http.Error(w, "Error al leer auditoría: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var lista []models.EventoMonitoreo
+	lista := []models.EventoMonitoreo{}
+	leidas, ultimoID := 0, 0
+	var ultimoValor string
 	for rows.Next() {
+		if leidas++; pg.hayMas(leidas) {
+			break
+		}
 		var ev models.EventoMonitoreo
 		if err := rows.Scan(
 			&ev.IDEvento,
@@ -70,6 +94,14 @@ func MonitorConsentimientos(w http.ResponseWriter, r *http.Request) {
 			&ev.RegistroID,
 			&ev.Descripcion,
 			&ev.FechaEvento,
+			&ev.Exito,
+			&ev.NombreUsuario,
+			&ev.RolUsuario,
+			&ev.PermisoEstado,
+			&ev.Politica,
+			&ev.Titular,
+			&ultimoValor,
+			&ultimoID,
 		); err != nil {
 			http.Error(w, "Error mapeando fila: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -77,6 +109,25 @@ func MonitorConsentimientos(w http.ResponseWriter, r *http.Request) {
 		lista = append(lista, ev)
 	}
 
+	pg.escribirCabeceras(w, r, total, pg.hayMas(leidas), ultimoValor, ultimoID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lista)
 }
+
+// especMonitoreo pagina auditoria_eventos (alias ae) unido a consentimientos (c).
+var especMonitoreo = especPaginacion{
+	ID: "ae.id_evento",
+	Orden: map[string]columnaOrden{
+		"fecha":  {"ae.fecha_evento", "timestamptz"},
+		"accion": {"ae.accion", "text"},
+		"id":     {"ae.id_evento", "int"},
+	},
+	OrdenDefecto: "-fecha",
+	Filtros: filtrosPaginacion{
+		Fecha:    "ae.fecha_evento",
+		Estado:   "c.estado",
+		Politica: "c.id_politica",
+		Usuario:  "(ae.id_usuario = %[1]s OR c.id_usuario = %[1]s)",
+		Exito:    "ae.exito",
+	},
+}
@@ -0,0 +1,54 @@
+// backend/handlers/motivos_auditoria.go
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"backend/auditoria"
+)
+
+// idiomaSolicitud es el idioma de las etiquetas: ?lang= o Accept-Language.
+func idiomaSolicitud(r *http.Request) string {
+	return auditoria.Idioma(r.URL.Query().Get("lang") + "," + r.Header.Get("Accept-Language"))
+}
+
+// ObtenerMotivosAuditoria GET /apd/api/auditoria/motivos y /custodio/auditoria/motivos
+//
+// Catálogo de códigos de denegación y fallo con su etiqueta (?lang=es|en).
+func ObtenerMotivosAuditoria(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auditoria.CatalogoMotivos(idiomaSolicitud(r)))
+}
+
+// ContarMotivosAuditoria GET /apd/api/auditoria/motivos/conteo y /custodio/auditoria/motivos/conteo
+//
+//	?flujo=accesos (por defecto)|auditoria_eventos|auditoria_politicas|auditoria_login
+//	&desde=&hasta=&actor=&id_politica=&motivo=&lang= (como en la exportación)
+//
+// Fallos del flujo agrupados por código de motivo.
+func ContarMotivosAuditoria(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	flujo := auditoria.Flujo(q.Get("flujo"))
+	if flujo == "" {
+		flujo = auditoria.FlujoAccesos
+	}
+	if !auditoria.FlujoValido(flujo) {
+		http.Error(w, "flujo inválido: accesos, auditoria_eventos, auditoria_politicas o auditoria_login", http.StatusBadRequest)
+		return
+	}
+	filtro, err := leerFiltroExportacion(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conteos, err := auditoria.ContarMotivos(r.Context(), flujo, filtro, idiomaSolicitud(r))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error contando motivos", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conteos)
+}
@@ -212,3 +212,27 @@ func CrearSolicitudAtributoP(w http.ResponseWriter, r *http.Request) {
 func ptrString(s string) *string {
 	return &s
 }
+
+// notificarRoles envía una copia de la notificación a cada usuario con
+// alguno de los roles indicados. Los errores individuales se ignoran.
+func notificarRoles(ctx context.Context, roles []int, plantilla models.Notificacion) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT DISTINCT id_usuario FROM usuarios_roles WHERE id_rol = ANY($1)`, roles)
+	if err != nil {
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		n := plantilla
+		n.UsuarioID = id
+		_ = CrearNotificacion(ctx, &n)
+	}
+}
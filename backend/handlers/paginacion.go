@@ -0,0 +1,240 @@
+// backend/handlers/paginacion.go
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+   Paginación de listados
+   ----------------------
+   Parámetros comunes a los listados grandes (consentimientos, monitoreo,
+   accesos):
+
+     limite=100 (máx. 1000)   cursor=<X-Siguiente-Cursor de la página anterior>
+     orden=-fecha (campo; "-" = descendente)
+     desde=YYYY-MM-DD|RFC3339  hasta=…  estado=  id_politica=  id_usuario=  exito=true|false
+
+   La respuesta sigue siendo el arreglo JSON de siempre; el total y el cursor
+   de la página siguiente van en las cabeceras X-Total-Count,
+   X-Siguiente-Cursor y Link (rel="next"). El cursor es de conjunto de
+   claves (valor de orden + id), así que las páginas no se solapan aunque
+   entren filas nuevas.
+*/
+
+const (
+	paginaLimiteDefecto = 100
+	paginaLimiteMax     = 1000
+)
+
+// columnaOrden es una expresión ordenable y su tipo SQL, para el cursor.
+// Debe ser no nula: las columnas nulables van con COALESCE.
+type columnaOrden struct {
+	Expr string
+	Tipo string
+}
+
+// filtrosPaginacion son las expresiones SQL de cada filtro común. "" = el
+// listado no lo admite. Si la expresión contiene %[1]s es una condición
+// completa con el parámetro en ese lugar; si no, se compara por igualdad.
+type filtrosPaginacion struct {
+	Fecha    string
+	Estado   string
+	Politica string
+	Usuario  string
+	Exito    string
+}
+
+// especPaginacion describe cómo paginar un listado.
+type especPaginacion struct {
+	ID           string // columna única de desempate
+	Orden        map[string]columnaOrden
+	OrdenDefecto string // p. ej. "-fecha"
+	Filtros      filtrosPaginacion
+}
+
+// paginaSQL son los fragmentos SQL de una página y sus argumentos.
+type paginaSQL struct {
+	Where      string // filtros y cursor
+	WhereTotal string // sólo filtros
+	OrderBy    string
+	Limit      string
+	Cursor     string // columnas (valor de orden, id) para el siguiente cursor
+	Args       []any
+	ArgsTotal  []any
+
+	limite int
+	orden  string
+}
+
+type cursorPagina struct {
+	Orden string `json:"o"`
+	Valor string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// prepararPagina lee los parámetros comunes. args son los argumentos que la
+// consulta ya usa ($1…$n); condiciones, sus condiciones propias.
+func prepararPagina(r *http.Request, e especPaginacion, condiciones []string, args ...any) (*paginaSQL, error) {
+	q := r.URL.Query()
+	p := &paginaSQL{limite: paginaLimiteDefecto, Args: args}
+	arg := func(v any) string {
+		p.Args = append(p.Args, v)
+		return "$" + strconv.Itoa(len(p.Args))
+	}
+	filtro := func(expr string, v any) {
+		n := arg(v)
+		if strings.Contains(expr, "%[1]s") {
+			condiciones = append(condiciones, fmt.Sprintf(expr, n))
+		} else {
+			condiciones = append(condiciones, expr+" = "+n)
+		}
+	}
+
+	if s := q.Get("limite"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("limite inválido")
+		}
+		if n > paginaLimiteMax {
+			n = paginaLimiteMax
+		}
+		p.limite = n
+	}
+
+	// Filtros
+	for _, f := range []struct {
+		param, expr string
+		fecha       bool
+		cmp         string
+	}{
+		{"desde", e.Filtros.Fecha, true, ">="},
+		{"hasta", e.Filtros.Fecha, true, "<"},
+	} {
+		s := q.Get(f.param)
+		if s == "" {
+			continue
+		}
+		if f.expr == "" {
+			return nil, fmt.Errorf("filtro no admitido: %s", f.param)
+		}
+		t, err := fechaFiltro(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválido", f.param)
+		}
+		condiciones = append(condiciones, f.expr+" "+f.cmp+" "+arg(*t))
+	}
+	if s := q.Get("estado"); s != "" {
+		if e.Filtros.Estado == "" {
+			return nil, fmt.Errorf("filtro no admitido: estado")
+		}
+		filtro(e.Filtros.Estado, s)
+	}
+	for _, f := range []struct{ param, expr string }{
+		{"id_politica", e.Filtros.Politica},
+		{"id_usuario", e.Filtros.Usuario},
+	} {
+		s := q.Get(f.param)
+		if s == "" {
+			continue
+		}
+		if f.expr == "" {
+			return nil, fmt.Errorf("filtro no admitido: %s", f.param)
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválido", f.param)
+		}
+		filtro(f.expr, n)
+	}
+	if s := q.Get("exito"); s != "" {
+		if e.Filtros.Exito == "" {
+			return nil, fmt.Errorf("filtro no admitido: exito")
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("exito inválido")
+		}
+		filtro(e.Filtros.Exito, b)
+	}
+	p.WhereTotal = where(condiciones)
+	p.ArgsTotal = append([]any(nil), p.Args...)
+
+	// Orden
+	p.orden = q.Get("orden")
+	if p.orden == "" {
+		p.orden = e.OrdenDefecto
+	}
+	desc := strings.HasPrefix(p.orden, "-")
+	col, ok := e.Orden[strings.TrimPrefix(p.orden, "-")]
+	if !ok {
+		campos := make([]string, 0, len(e.Orden))
+		for k := range e.Orden {
+			campos = append(campos, k)
+		}
+		return nil, fmt.Errorf("orden inválido; campos: %s", strings.Join(campos, ", "))
+	}
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+	p.OrderBy = fmt.Sprintf("ORDER BY %s %s, %s %s", col.Expr, dir, e.ID, dir)
+	p.Cursor = fmt.Sprintf("(%s)::text, %s", col.Expr, e.ID)
+
+	// Cursor: continuar tras la última fila de la página anterior
+	if s := q.Get("cursor"); s != "" {
+		c, err := decodificarCursor(s)
+		if err != nil || c.Orden != p.orden {
+			return nil, fmt.Errorf("cursor inválido")
+		}
+		condiciones = append(condiciones, fmt.Sprintf("(%s, %s) %s (%s::%s, %s)",
+			col.Expr, e.ID, cmp, arg(c.Valor), col.Tipo, arg(c.ID)))
+	}
+	p.Where = where(condiciones)
+	// Una fila de más indica si hay página siguiente
+	p.Limit = "LIMIT " + arg(p.limite+1)
+	return p, nil
+}
+
+func where(condiciones []string) string {
+	if len(condiciones) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(condiciones, " AND ")
+}
+
+func decodificarCursor(s string) (cursorPagina, error) {
+	var c cursorPagina
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// hayMas indica si se leyó la fila de más; el llamador la descarta.
+func (p *paginaSQL) hayMas(leidas int) bool { return leidas > p.limite }
+
+// escribirCabeceras publica el total y, si hay más filas, el cursor de la
+// página siguiente a partir de la última fila devuelta.
+func (p *paginaSQL) escribirCabeceras(w http.ResponseWriter, r *http.Request, total int64, hayMas bool, ultimoValor string, ultimoID int) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if !hayMas {
+		return
+	}
+	b, _ := json.Marshal(cursorPagina{Orden: p.orden, Valor: ultimoValor, ID: ultimoID})
+	cursor := base64.RawURLEncoding.EncodeToString(b)
+	w.Header().Set("X-Siguiente-Cursor", cursor)
+
+	sig := *r.URL
+	q := sig.Query()
+	q.Set("cursor", cursor)
+	sig.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", sig.RequestURI()))
+}
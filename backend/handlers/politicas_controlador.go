@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"backend/auditoria"
 	"backend/db"
+	"backend/models"
+	"backend/utils"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,21 +16,38 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// auditPoliticaFailure deja constancia de una operación fallida sobre políticas.
 func auditPoliticaFailure(ctx context.Context, operacion, descripcion string, idPolitica int, errMsg string) {
-	_, err := db.Pool.Exec(ctx, `
-		INSERT INTO auditoria_politicas
-		  (operacion, id_politica, titulo, descripcion, fecha, usuario, exito, error_mensaje)
-		VALUES (
-		  $1, $2,
-		  (SELECT titulo FROM politicas_privacidad WHERE id_politica=$2),
-		  $3, NOW(),
-		  current_setting('app.current_user')::text,
-		  false, $4
-		)
-	`, operacion, idPolitica, descripcion, errMsg)
-	if err != nil {
-		log.Printf("Error auditando fallo política [%s %d]: %v", operacion, idPolitica, err)
+	idControlador, _ := GetUserIDFromCtx(ctx)
+	auditoria.Registrar(ctx, auditoria.Evento{
+		Flujo: auditoria.FlujoPoliticas, IDActor: idControlador, Rol: 2,
+		Accion: operacion, IDRecurso: idPolitica, CodigoMotivo: models.MotivoErrorBD,
+		Descripcion: descripcion, Error: errMsg,
+	})
+}
+
+// normalizarModoAcceso aplica los valores por defecto y rechaza los desconocidos.
+func normalizarModoAcceso(modo, generalizacion *string) error {
+	if *modo == "" {
+		*modo = "identificado"
+	}
+	if *generalizacion == "" {
+		*generalizacion = "anio"
+	}
+	if *modo != "identificado" && *modo != "seudonimizado" {
+		return fmt.Errorf("modo_acceso inválido: %s", *modo)
 	}
+	if *generalizacion != "anio" && *generalizacion != "rango_edad" {
+		return fmt.Errorf("generalizacion_fecha inválida: %s", *generalizacion)
+	}
+	return nil
+}
+
+func nivelDe(niveles map[int]string, idAtributo int) string {
+	if n, ok := niveles[idAtributo]; ok {
+		return n
+	}
+	return utils.NivelCompleto
 }
 
 func ObtenerPoliticasParaControlador(w http.ResponseWriter, r *http.Request) {
@@ -65,34 +85,6 @@ func ObtenerPoliticasParaControlador(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(lista)
 }
 
-func ObtenerAtributosDatos(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Pool.Query(context.Background(), `
-		SELECT id_atributo, nombre, etiqueta FROM atributos_datos
-	`)
-	if err != nil {
-		http.Error(w, "Error al consultar atributos de datos", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var resultados []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var nombre, etiqueta string
-		if err := rows.Scan(&id, &nombre, &etiqueta); err != nil {
-			continue
-		}
-		resultados = append(resultados, map[string]interface{}{
-			"id_atributo": id,
-			"nombre":      nombre,
-			"etiqueta":    etiqueta,
-		})
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resultados)
-}
-
 func CrearPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	type input struct {
@@ -101,12 +93,37 @@ func CrearPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 		FechaInicio string `json:"fecha_inicio"`
 		FechaFin    string `json:"fecha_fin"`
 		Atributos   []int  `json:"atributos"`
+		// Nivel de divulgación por id de atributo; por defecto "completo"
+		Niveles map[int]string `json:"niveles"`
+		// Códigos de finalidades (tabla finalidades) para las que se consiente
+		Finalidades []string `json:"finalidades"`
+		// Modo de acceso de los procesadores (ver utils/seudonimos.go)
+		ModoAcceso          string `json:"modo_acceso"`
+		GeneralizacionFecha string `json:"generalizacion_fecha"`
+		// Cada acceso exige la aprobación previa del titular
+		RequiereAprobacion bool `json:"requiere_aprobacion"`
 	}
 	var in input
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, "JSON inválido", http.StatusBadRequest)
 		return
 	}
+	// El título es el atributo ABE de quienes consienten: debe poder
+	// aparecer en la política de cifrado
+	if err := utils.ValidarNombreAtributo(in.Titulo); err != nil {
+		http.Error(w, "Título inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := normalizarModoAcceso(&in.ModoAcceso, &in.GeneralizacionFecha); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for aid, nivel := range in.Niveles {
+		if !utils.NivelValido(nivel) {
+			http.Error(w, fmt.Sprintf("Nivel de divulgación inválido para atributo %d: %s", aid, nivel), http.StatusBadRequest)
+			return
+		}
+	}
 
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
@@ -118,10 +135,13 @@ func CrearPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 
 	var idPol int
 	err = tx.QueryRow(ctx, `
-		INSERT INTO politicas_privacidad (titulo, descripcion, fecha_inicio, fecha_fin)
-		VALUES ($1,$2,$3,$4)
+		INSERT INTO politicas_privacidad
+		  (titulo, descripcion, fecha_inicio, fecha_fin, modo_acceso, generalizacion_fecha,
+		   requiere_aprobacion)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
 		RETURNING id_politica
-	`, in.Titulo, in.Descripcion, in.FechaInicio, in.FechaFin).Scan(&idPol)
+	`, in.Titulo, in.Descripcion, in.FechaInicio, in.FechaFin,
+		in.ModoAcceso, in.GeneralizacionFecha, in.RequiereAprobacion).Scan(&idPol)
 	if err != nil {
 		http.Error(w, "Error insertando política: "+err.Error(), http.StatusInternalServerError)
 		auditPoliticaFailure(ctx, "INSERT", fmt.Sprintf("Crear '%s'", in.Titulo), 0, err.Error())
@@ -130,15 +150,21 @@ func CrearPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 
 	for _, aid := range in.Atributos {
 		if _, err := tx.Exec(ctx, `
-			INSERT INTO politica_atributo (id_politica, id_atributo)
-			VALUES ($1,$2)
-		`, idPol, aid); err != nil {
+			INSERT INTO politica_atributo (id_politica, id_atributo, nivel_divulgacion)
+			VALUES ($1,$2,$3)
+		`, idPol, aid, nivelDe(in.Niveles, aid)); err != nil {
 			http.Error(w, "Error asociando atributos: "+err.Error(), http.StatusInternalServerError)
 			auditPoliticaFailure(ctx, "INSERT_ATTR", fmt.Sprintf("Asociar atributo %d", aid), idPol, err.Error())
 			return
 		}
 	}
 
+	if err := guardarFinalidadesPolitica(ctx, tx, idPol, in.Finalidades); err != nil {
+		http.Error(w, "Error asociando finalidades: "+err.Error(), http.StatusBadRequest)
+		auditPoliticaFailure(ctx, "INSERT_FIN", "Asociar finalidades", idPol, err.Error())
+		return
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		http.Error(w, "Error guardando política", http.StatusInternalServerError)
 		auditPoliticaFailure(ctx, "COMMIT", "Crear política", idPol, err.Error())
@@ -167,12 +193,54 @@ func ActualizarPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 		FechaInicio string `json:"fecha_inicio"`
 		FechaFin    string `json:"fecha_fin"`
 		Atributos   []int  `json:"atributos"`
+		// Campos añadidos a la política: si no vienen se conserva lo guardado.
+		// Nivel de divulgación por id de atributo; por defecto el actual, o
+		// "completo" para los atributos nuevos
+		Niveles map[int]string `json:"niveles"`
+		// Códigos de finalidades (tabla finalidades) para las que se consiente
+		Finalidades *[]string `json:"finalidades"`
+		// Modo de acceso de los procesadores (ver utils/seudonimos.go)
+		ModoAcceso          *string `json:"modo_acceso"`
+		GeneralizacionFecha *string `json:"generalizacion_fecha"`
+		// Cada acceso exige la aprobación previa del titular
+		RequiereAprobacion *bool `json:"requiere_aprobacion"`
 	}
 	var in input
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, "JSON inválido", http.StatusBadRequest)
 		return
 	}
+	// El título es el atributo ABE de quienes consienten: debe poder
+	// aparecer en la política de cifrado
+	if err := utils.ValidarNombreAtributo(in.Titulo); err != nil {
+		http.Error(w, "Título inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.ModoAcceso != nil || in.GeneralizacionFecha != nil {
+		var modo, generalizacion string
+		if in.ModoAcceso != nil {
+			modo = *in.ModoAcceso
+		}
+		if in.GeneralizacionFecha != nil {
+			generalizacion = *in.GeneralizacionFecha
+		}
+		if err := normalizarModoAcceso(&modo, &generalizacion); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.ModoAcceso != nil {
+			in.ModoAcceso = &modo
+		}
+		if in.GeneralizacionFecha != nil {
+			in.GeneralizacionFecha = &generalizacion
+		}
+	}
+	for aid, nivel := range in.Niveles {
+		if !utils.NivelValido(nivel) {
+			http.Error(w, fmt.Sprintf("Nivel de divulgación inválido para atributo %d: %s", aid, nivel), http.StatusBadRequest)
+			return
+		}
+	}
 
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
@@ -184,14 +252,37 @@ func ActualizarPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 
 	if _, err := tx.Exec(ctx, `
 		UPDATE politicas_privacidad
-		   SET titulo=$1, descripcion=$2, fecha_inicio=$3, fecha_fin=$4
-		 WHERE id_politica=$5
-	`, in.Titulo, in.Descripcion, in.FechaInicio, in.FechaFin, idPol); err != nil {
+		   SET titulo=$1, descripcion=$2, fecha_inicio=$3, fecha_fin=$4,
+		       modo_acceso=COALESCE($5::text, modo_acceso),
+		       generalizacion_fecha=COALESCE($6::text, generalizacion_fecha),
+		       requiere_aprobacion=COALESCE($7::boolean, requiere_aprobacion)
+		 WHERE id_politica=$8
+	`, in.Titulo, in.Descripcion, in.FechaInicio, in.FechaFin,
+		in.ModoAcceso, in.GeneralizacionFecha, in.RequiereAprobacion, idPol); err != nil {
 		http.Error(w, "Error actualizando política: "+err.Error(), http.StatusInternalServerError)
 		auditPoliticaFailure(ctx, "UPDATE", "Actualizar campos", idPol, err.Error())
 		return
 	}
 
+	// Sin niveles en el cuerpo, cada atributo que sigue conserva el suyo
+	if in.Niveles == nil {
+		in.Niveles = map[int]string{}
+		rows, err := tx.Query(ctx, `SELECT id_atributo, nivel_divulgacion FROM politica_atributo WHERE id_politica=$1`, idPol)
+		if err != nil {
+			http.Error(w, "Error leyendo atributos anteriores: "+err.Error(), http.StatusInternalServerError)
+			auditPoliticaFailure(ctx, "SELECT_ATTR", "Leer niveles previos", idPol, err.Error())
+			return
+		}
+		for rows.Next() {
+			var aid int
+			var nivel string
+			if err := rows.Scan(&aid, &nivel); err == nil {
+				in.Niveles[aid] = nivel
+			}
+		}
+		rows.Close()
+	}
+
 	if _, err := tx.Exec(ctx, `DELETE FROM politica_atributo WHERE id_politica=$1`, idPol); err != nil {
 		http.Error(w, "Error eliminando atributos anteriores: "+err.Error(), http.StatusInternalServerError)
 		auditPoliticaFailure(ctx, "DELETE_ATTR", "Eliminar atributos previos", idPol, err.Error())
@@ -200,15 +291,23 @@ func ActualizarPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 
 	for _, aid := range in.Atributos {
 		if _, err := tx.Exec(ctx, `
-			INSERT INTO politica_atributo (id_politica, id_atributo)
-			VALUES ($1,$2)
-		`, idPol, aid); err != nil {
+			INSERT INTO politica_atributo (id_politica, id_atributo, nivel_divulgacion)
+			VALUES ($1,$2,$3)
+		`, idPol, aid, nivelDe(in.Niveles, aid)); err != nil {
 			http.Error(w, "Error insertando atributos nuevos: "+err.Error(), http.StatusInternalServerError)
 			auditPoliticaFailure(ctx, "INSERT_ATTR", fmt.Sprintf("Asociar atributo %d", aid), idPol, err.Error())
 			return
 		}
 	}
 
+	if in.Finalidades != nil {
+		if err := guardarFinalidadesPolitica(ctx, tx, idPol, *in.Finalidades); err != nil {
+			http.Error(w, "Error asociando finalidades: "+err.Error(), http.StatusBadRequest)
+			auditPoliticaFailure(ctx, "INSERT_FIN", "Asociar finalidades", idPol, err.Error())
+			return
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		http.Error(w, "Error guardando cambios", http.StatusInternalServerError)
 		auditPoliticaFailure(ctx, "COMMIT", "Actualizar política", idPol, err.Error())
@@ -228,7 +327,7 @@ func ObtenerAtributosDePolitica(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := db.Pool.Query(context.Background(), `
-		SELECT id_atributo FROM politica_atributo WHERE id_politica = $1
+		SELECT id_atributo, nivel_divulgacion FROM politica_atributo WHERE id_politica = $1
 	`, id)
 	if err != nil {
 		http.Error(w, "Error consultando atributos", http.StatusInternalServerError)
@@ -236,15 +335,26 @@ func ObtenerAtributosDePolitica(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	// ?detalle=true devuelve también el nivel de divulgación de cada atributo
+	type atributoNivel struct {
+		IDAtributo int    `json:"id_atributo"`
+		Nivel      string `json:"nivel_divulgacion"`
+	}
 	var atributos []int
+	var detalle []atributoNivel
 	for rows.Next() {
-		var idAtributo int
-		if err := rows.Scan(&idAtributo); err == nil {
-			atributos = append(atributos, idAtributo)
+		var a atributoNivel
+		if err := rows.Scan(&a.IDAtributo, &a.Nivel); err == nil {
+			atributos = append(atributos, a.IDAtributo)
+			detalle = append(detalle, a)
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("detalle") == "true" {
+		json.NewEncoder(w).Encode(detalle)
+		return
+	}
 	json.NewEncoder(w).Encode(atributos)
 }
 
@@ -258,17 +368,22 @@ func ObtenerPoliticaPorIDC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var p struct {
-		ID          int       `json:"id_politica"`
-		Titulo      string    `json:"titulo"`
-		Descripcion string    `json:"descripcion"`
-		FechaInicio time.Time `json:"fecha_inicio"`
-		FechaFin    time.Time `json:"fecha_fin"`
+		ID                  int       `json:"id_politica"`
+		Titulo              string    `json:"titulo"`
+		Descripcion         string    `json:"descripcion"`
+		FechaInicio         time.Time `json:"fecha_inicio"`
+		FechaFin            time.Time `json:"fecha_fin"`
+		ModoAcceso          string    `json:"modo_acceso"`
+		GeneralizacionFecha string    `json:"generalizacion_fecha"`
+		RequiereAprobacion  bool      `json:"requiere_aprobacion"`
 	}
 	if err := db.Pool.QueryRow(ctx, `
-		SELECT id_politica, titulo, descripcion, fecha_inicio, fecha_fin
+		SELECT id_politica, titulo, descripcion, fecha_inicio, fecha_fin,
+		       modo_acceso, generalizacion_fecha, requiere_aprobacion
 		  FROM politicas_privacidad
 		 WHERE id_politica=$1
-	`, idPol).Scan(&p.ID, &p.Titulo, &p.Descripcion, &p.FechaInicio, &p.FechaFin); err != nil {
+	`, idPol).Scan(&p.ID, &p.Titulo, &p.Descripcion, &p.FechaInicio, &p.FechaFin,
+		&p.ModoAcceso, &p.GeneralizacionFecha, &p.RequiereAprobacion); err != nil {
 		http.Error(w, "No se encontró la política", http.StatusNotFound)
 		auditPoliticaFailure(ctx, "SELECT", "Obtener política por ID", idPol, err.Error())
 		return
@@ -278,25 +393,46 @@ func ObtenerPoliticaPorIDC(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(p)
 }
 
+// ObtenerConsentimientos GET /controlador/consentimientos, paginado (ver paginacion.go)
 func ObtenerConsentimientos(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Pool.Query(context.Background(), `
-		SELECT c.id_consentimiento, u.nombre, p.titulo, c.fecha_expiracion, c.estado
+	pg, err := prepararPagina(r, especConsentimientos, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var total int64
+	if err := db.Pool.QueryRow(r.Context(),
+		`SELECT COUNT(*) FROM consentimientos c `+pg.WhereTotal, pg.ArgsTotal...,
+	).Scan(&total); err != nil {
+		http.Error(w, "Error al obtener consentimientos: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT c.id_consentimiento, u.nombre, p.titulo, c.fecha_expiracion, c.estado, `+pg.Cursor+`
 		FROM consentimientos c
 		JOIN usuarios u ON u.id_usuario = c.id_usuario
 		JOIN politicas_privacidad p ON p.id_politica = c.id_politica
-	`)
+		`+pg.Where+`
+		`+pg.OrderBy+`
+		`+pg.Limit, pg.Args...)
 	if err != nil {
 		http.Error(w, "Error al obtener consentimientos: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var lista []map[string]interface{}
+	lista := []map[string]interface{}{}
+	leidas, ultimoID := 0, 0
+	var ultimoValor string
 	for rows.Next() {
+		if leidas++; pg.hayMas(leidas) {
+			break
+		}
 		var id int
 		var nombre, titulo, estado string
 		var fechaExp *time.Time
-		if err := rows.Scan(&id, &nombre, &titulo, &fechaExp, &estado); err == nil {
+		if err := rows.Scan(&id, &nombre, &titulo, &fechaExp, &estado, &ultimoValor, &ultimoID); err == nil {
 			item := map[string]interface{}{
 				"id_consentimiento": id,
 				"usuario":           nombre,
@@ -308,6 +444,7 @@ func ObtenerConsentimientos(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	pg.escribirCabeceras(w, r, total, pg.hayMas(leidas), ultimoValor, ultimoID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(lista)
 }
@@ -414,3 +551,39 @@ func EliminarPoliticaControlador(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"mensaje": "Política eliminada correctamente"})
 }
+
+// POST /controlador/politicas-abe/validar
+// Analiza una expresión de política ABE y devuelve su forma canónica y la
+// expresión compilada, o el token que provocó el error.
+func ValidarPoliticaABE(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Politica string `json:"politica"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	arbol, err := utils.ParsearPolitica(in.Politica)
+	if err == nil {
+		var compilada string
+		if compilada, err = utils.CompilarPolitica(arbol, time.Now()); err == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"canonica":  arbol.String(),
+				"compilada": compilada,
+				"atributos": utils.AtributosDePolitica(arbol),
+			})
+			return
+		}
+	}
+
+	resp := map[string]interface{}{"error": err.Error()}
+	var errPol *utils.ErrorPolitica
+	if errors.As(err, &errPol) {
+		resp["posicion"] = errPol.Posicion
+		resp["token"] = errPol.Token
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(resp)
+}
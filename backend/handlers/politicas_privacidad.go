@@ -13,6 +13,7 @@ import (
 
 	"backend/db"
 	"backend/models"
+	"backend/utils"
 
 	"github.com/gorilla/mux"
 )
@@ -294,6 +295,12 @@ func CrearPolitica(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "El título no puede quedar vacío", http.StatusBadRequest)
 		return
 	}
+	// El título es el atributo ABE de quienes consienten: debe poder
+	// aparecer en la política de cifrado
+	if err := utils.ValidarNombreAtributo(input.Titulo); err != nil {
+		http.Error(w, "Título inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	if input.FechaFin.Before(input.FechaInicio) {
 		http.Error(w, "La fecha de fin no puede ser anterior a la fecha de inicio", http.StatusBadRequest)
 		return
@@ -341,6 +348,13 @@ func ActualizarPolitica(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// El título es el atributo ABE de quienes consienten: debe poder
+	// aparecer en la política de cifrado
+	if err := utils.ValidarNombreAtributo(input.Titulo); err != nil {
+		http.Error(w, "Título inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Validación mínima de fechas
 	if input.FechaFin.Before(input.FechaInicio) {
 		http.Error(w, "La fecha de fin no puede ser anterior a la fecha de inicio", http.StatusBadRequest)
@@ -0,0 +1,161 @@
+// backend/handlers/retencion_auditoria.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// ObtenerRetencionAuditoria GET /apd/api/auditoria/retencion
+func ObtenerRetencionAuditoria(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Pool.Query(r.Context(), `
+		SELECT flujo, dias, fecha_actualizacion FROM auditoria_retencion ORDER BY flujo
+	`)
+	if err != nil {
+		http.Error(w, "Error leyendo la retención", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	lista := []models.RetencionAuditoria{}
+	for rows.Next() {
+		var ret models.RetencionAuditoria
+		if err := rows.Scan(&ret.Flujo, &ret.Dias, &ret.FechaActualizacion); err != nil {
+			http.Error(w, "Error leyendo la retención", http.StatusInternalServerError)
+			return
+		}
+		lista = append(lista, ret)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// ActualizarRetencionAuditoria PUT /apd/api/auditoria/retencion
+//
+//	{"flujo": "accesos", "dias": 1825}
+func ActualizarRetencionAuditoria(w http.ResponseWriter, r *http.Request) {
+	var ret models.RetencionAuditoria
+	if err := json.NewDecoder(r.Body).Decode(&ret); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	if ret.Dias < 1 {
+		http.Error(w, "dias debe ser mayor que cero", http.StatusBadRequest)
+		return
+	}
+	var anterior int
+	err := db.Pool.QueryRow(r.Context(), `
+		UPDATE auditoria_retencion r
+		   SET dias = $2, fecha_actualizacion = NOW()
+		  FROM (SELECT dias FROM auditoria_retencion WHERE flujo = $1 FOR UPDATE) a
+		 WHERE r.flujo = $1
+		RETURNING a.dias
+	`, ret.Flujo, ret.Dias).Scan(&anterior)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Flujo desconocido", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error actualizando la retención", http.StatusInternalServerError)
+		return
+	}
+
+	idActor, _ := GetUserIDFromCtx(r.Context())
+	auditoria.Registrar(r.Context(), auditoria.Evento{
+		IDActor: idActor, Accion: "RETENCION-AUDITORIA", Recurso: "auditoria_retencion", Exito: true,
+		Descripcion: fmt.Sprintf("%s: %d → %d días", ret.Flujo, anterior, ret.Dias),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListarArchivosAuditoria GET /apd/api/auditoria/archivos[?flujo=accesos]
+func ListarArchivosAuditoria(w http.ResponseWriter, r *http.Request) {
+	archivos, err := auditoria.ListarArchivos(r.Context(), r.URL.Query().Get("flujo"))
+	if err != nil {
+		log.Printf("Error listando archivos de auditoría: %v", err)
+		http.Error(w, "Error listando archivos", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archivos)
+}
+
+// RestaurarArchivoAuditoria POST /apd/api/auditoria/archivos/{id}/restaurar
+//
+// Verifica el archivo (SHA-256, firma y cadena) y carga sus filas para
+// consultarlas en /archivos/{id}/registros.
+func RestaurarArchivoAuditoria(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	idActor, _ := GetUserIDFromCtx(r.Context())
+	res, err := auditoria.RestaurarArchivo(r.Context(), id, idActor)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "Archivo no encontrado", http.StatusNotFound)
+		return
+	case errors.Is(err, auditoria.ErrArchivoNoVerifica):
+		auditoria.Registrar(r.Context(), auditoria.Evento{
+			IDActor: idActor, Accion: "RESTAURAR-AUDITORIA", Recurso: "auditoria_archivos", IDRecurso: id,
+			Exito: false, CodigoMotivo: models.MotivoArchivoAlterado, Error: err.Error(),
+		})
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Printf("Error restaurando el archivo %d: %v", id, err)
+		http.Error(w, "Error restaurando el archivo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ObtenerRegistrosRestaurados GET /apd/api/auditoria/archivos/{id}/registros
+//
+// Devuelve en NDJSON las filas restauradas, tal como estaban en la tabla.
+func ObtenerRegistrosRestaurados(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	filas := 0
+	err = auditoria.LeerRestaurados(r.Context(), id, func(fila json.RawMessage) error {
+		filas++
+		_, err := w.Write(append(fila, '\n'))
+		return err
+	})
+	if err != nil && filas == 0 {
+		http.Error(w, "Error leyendo los registros restaurados", http.StatusInternalServerError)
+	}
+	if err != nil {
+		log.Printf("Error leyendo los registros restaurados del archivo %d: %v", id, err)
+	}
+}
+
+// DescartarRestauracionAuditoria DELETE /apd/api/auditoria/archivos/{id}/restauracion
+func DescartarRestauracionAuditoria(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	if err := auditoria.DescartarRestauracion(r.Context(), id); err != nil {
+		http.Error(w, "Error descartando la restauración", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
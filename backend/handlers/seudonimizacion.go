@@ -0,0 +1,166 @@
+// backend/handlers/seudonimizacion.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// justificacionMinima es la longitud mínima exigida para reidentificar.
+const justificacionMinima = 20
+
+// registrarSeudonimo devuelve el seudónimo del titular para el procesador y
+// guarda la correspondencia, que sólo se consulta al reidentificar.
+func registrarSeudonimo(ctx context.Context, idProcesador, idTitular int) (string, error) {
+	seud := utils.SeudonimoTitular(idProcesador, idTitular)
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO seudonimos (id_procesador, id_titular, seudonimo, fecha_creacion)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (id_procesador, id_titular) DO NOTHING
+	`, idProcesador, idTitular, seud)
+	return seud, err
+}
+
+// resolverSeudonimo devuelve el titular detrás de un seudónimo entregado al
+// procesador, que es como lo nombran los procesadores de políticas
+// seudonimizadas. Un seudónimo que no es suyo se deniega y se audita.
+func resolverSeudonimo(ctx context.Context, idProcesador int, seud, finalidad, justificacion string) (int, *denegacionAcceso) {
+	var idTitular int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id_titular FROM seudonimos WHERE id_procesador = $1 AND seudonimo = $2
+	`, idProcesador, seud).Scan(&idTitular)
+	if err == nil {
+		return idTitular, nil
+	}
+	den := &denegacionAcceso{codigo: models.MotivoSeudonimoDesconocido, motivo: "seudónimo desconocido: " + seud, mensaje: "Seudónimo desconocido", status: http.StatusNotFound}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		den = &denegacionAcceso{codigo: models.MotivoErrorBD, motivo: "error lectura seudónimos", mensaje: "Error resolviendo el seudónimo", status: http.StatusInternalServerError}
+	}
+	auditoria.Registrar(ctx, auditoria.Evento{
+		Flujo: auditoria.FlujoAccesos, IDActor: idProcesador, CodigoMotivo: den.codigo, Descripcion: den.motivo,
+		Acceso: &auditoria.Acceso{Finalidad: finalidad, Justificacion: justificacion},
+	})
+	return 0, den
+}
+
+// catalogoPorNombre indexa el catálogo (incluidos inactivos) por nombre.
+func catalogoPorNombre(ctx context.Context) (map[string]models.AtributoDato, error) {
+	catalogo, err := cargarCatalogoDatos(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	porNombre := make(map[string]models.AtributoDato, len(catalogo))
+	for _, a := range catalogo {
+		porNombre[a.Nombre] = a
+	}
+	return porNombre, nil
+}
+
+// seudonimizarCampo transforma un valor ya descifrado según su clase de
+// privacidad y devuelve también el nivel aplicado. Los cuasi-identificadores
+// que no son fechas se entregan tal cual.
+func seudonimizarCampo(a models.AtributoDato, idProcesador int, campo, plano, generalizacion string) (string, string) {
+	switch a.ClasePrivacidad {
+	case "identificador":
+		return utils.SeudonimoValor(idProcesador, campo, plano), utils.NivelSeudonimo
+	case "cuasi_identificador":
+		if a.Tipo == "fecha" {
+			return utils.GeneralizarFecha(plano, generalizacion, time.Now()), utils.NivelGeneralizado
+		}
+	}
+	return plano, utils.NivelCompleto
+}
+
+// ReidentificarSeudonimo POST /controlador/reidentificar
+// Devuelve la identidad real detrás de un seudónimo. Exige justificación y
+// deja constancia en reidentificaciones y auditoria_eventos.
+func ReidentificarSeudonimo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idControlador, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	var in struct {
+		Seudonimo     string `json:"seudonimo"`
+		Justificacion string `json:"justificacion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	in.Justificacion = strings.TrimSpace(in.Justificacion)
+	if in.Seudonimo == "" || len([]rune(in.Justificacion)) < justificacionMinima {
+		http.Error(w, "Se requiere seudónimo y una justificación de al menos 20 caracteres", http.StatusBadRequest)
+		return
+	}
+
+	var idProcesador, idTitular int
+	var email, nombre string
+	err := db.Pool.QueryRow(ctx, `
+		SELECT s.id_procesador, s.id_titular, u.email, u.nombre
+		  FROM seudonimos s
+		  JOIN usuarios u ON u.id_usuario = s.id_titular
+		 WHERE s.seudonimo = $1
+	`, in.Seudonimo).Scan(&idProcesador, &idTitular, &email, &nombre)
+	if err != nil {
+		auditoria.Registrar(ctx, auditoria.Evento{
+			IDActor: idControlador, Rol: 2, Accion: "FALLO-REIDENTIFICAR", Recurso: "seudonimos",
+			CodigoMotivo: models.MotivoSeudonimoDesconocido, Descripcion: in.Justificacion,
+			Error: "seudónimo desconocido",
+		})
+		http.Error(w, "Seudónimo no encontrado", http.StatusNotFound)
+		return
+	}
+
+	// Sin registro de auditoría no se entrega la identidad
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		http.Error(w, "Error iniciando transacción", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var idReid int
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO reidentificaciones
+		  (id_controlador, id_procesador, id_titular, seudonimo, justificacion, fecha)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id_reidentificacion
+	`, idControlador, idProcesador, idTitular, in.Seudonimo, in.Justificacion).Scan(&idReid); err != nil {
+		http.Error(w, "Error registrando reidentificación", http.StatusInternalServerError)
+		return
+	}
+	if err := auditoria.RegistrarEnTx(ctx, tx, auditoria.Evento{
+		IDActor: idControlador, Rol: 2, Accion: "REIDENTIFICAR", Recurso: "reidentificaciones",
+		IDRecurso: idReid, Exito: true, Descripcion: in.Justificacion,
+	}); err != nil {
+		http.Error(w, "Error registrando auditoría", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, "Error guardando reidentificación", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id_reidentificacion": idReid,
+		"id_usuario":          idTitular,
+		"nombre":              nombre,
+		"email":               email,
+		"id_procesador":       idProcesador,
+	})
+}
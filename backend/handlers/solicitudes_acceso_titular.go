@@ -0,0 +1,324 @@
+// backend/handlers/solicitudes_acceso_titular.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/auditoria"
+	"backend/db"
+	"backend/models"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+   Aprobación just-in-time
+   -----------------------
+   En las políticas con requiere_aprobacion no basta el consentimiento: el
+   procesador pide al titular acceso a unos campos para una finalidad, el
+   titular aprueba o deniega antes de que la solicitud expire y cada
+   aprobación permite un único descifrado en ObtenerAccesoDatos.
+*/
+
+const (
+	solicitudValidezDefecto = 72  // horas
+	solicitudValidezMaxima  = 168 // horas
+)
+
+// SolicitudAccesoTitular es una petición de acceso pendiente de (o ya con)
+// la respuesta del titular.
+type SolicitudAccesoTitular struct {
+	ID              int        `json:"id_solicitud"`
+	IDProcesador    int        `json:"id_procesador"`
+	Procesador      string     `json:"procesador,omitempty"`
+	IDTitular       int        `json:"id_titular,omitempty"` // al procesador, sólo si no la pidió por seudónimo
+	Seudonimo       string     `json:"seudonimo,omitempty"`
+	Campos          []string   `json:"campos"`
+	Finalidad       string     `json:"finalidad"`
+	Justificacion   string     `json:"justificacion"`
+	Estado          string     `json:"estado"` // pendiente | aprobada | denegada | usada | expirada
+	FechaCreacion   time.Time  `json:"fecha_creacion"`
+	FechaExpiracion time.Time  `json:"fecha_expiracion"`
+	FechaRespuesta  *time.Time `json:"fecha_respuesta,omitempty"`
+	MotivoRespuesta *string    `json:"motivo_respuesta,omitempty"`
+	FechaUso        *time.Time `json:"fecha_uso,omitempty"`
+}
+
+// consumirAprobacion marca como usada la aprobación indicada dentro de la
+// transacción que registra el acceso, de modo que sólo se gasta si el acceso
+// queda registrado. Falla si entretanto otro acceso la usó o expiró.
+func consumirAprobacion(ctx context.Context, tx pgx.Tx, idAprobacion int) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE solicitudes_acceso_titular
+		   SET estado = 'usada', fecha_uso = NOW()
+		 WHERE id_solicitud = $1
+		   AND estado = 'aprobada' AND fecha_expiracion > NOW()
+	`, idAprobacion)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// aprobacionVigente es la aprobación vigente más antigua del titular para
+// el procesador y la finalidad, con los campos aprobados, sin marcarla como
+// usada.
+func aprobacionVigente(ctx context.Context, idProcesador, idTitular int, finalidad string) (int, []string, error) {
+	var id int
+	var campos []string
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id_solicitud, campos FROM solicitudes_acceso_titular
+		 WHERE id_procesador = $1 AND id_titular = $2 AND finalidad = $3
+		   AND estado = 'aprobada' AND fecha_expiracion > NOW()
+		 ORDER BY fecha_respuesta
+		 LIMIT 1
+	`, idProcesador, idTitular, finalidad).Scan(&id, &campos)
+	return id, campos, err
+}
+
+// CrearSolicitudAccesoTitular POST /procesador/solicitudes-acceso
+// Body: {"id_titular": N, "campos": ["telefono"], "finalidad": "soporte", "justificacion": "...", "horas_validez": 72}
+// En lugar de id_titular, "seudonimo" para los titulares que el procesador
+// sólo conoce por seudónimo.
+func CrearSolicitudAccesoTitular(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idProcesador, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta o es inválido X-User-ID", http.StatusUnauthorized)
+		return
+	}
+
+	var in struct {
+		IDTitular     int      `json:"id_titular"`
+		Seudonimo     string   `json:"seudonimo"`
+		Campos        []string `json:"campos"`
+		Finalidad     string   `json:"finalidad"`
+		Justificacion string   `json:"justificacion"`
+		HorasValidez  int      `json:"horas_validez"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	in.Finalidad = strings.TrimSpace(in.Finalidad)
+	in.Justificacion = strings.TrimSpace(in.Justificacion)
+	in.Seudonimo = strings.TrimSpace(in.Seudonimo)
+	if (in.IDTitular == 0) == (in.Seudonimo == "") || len(in.Campos) == 0 || in.Finalidad == "" ||
+		len([]rune(in.Justificacion)) < justificacionAccesoMinima {
+		http.Error(w, fmt.Sprintf("Se requiere id_titular o seudonimo, campos, finalidad y una justificación de al menos %d caracteres", justificacionAccesoMinima), http.StatusBadRequest)
+		return
+	}
+	if in.HorasValidez == 0 {
+		in.HorasValidez = solicitudValidezDefecto
+	}
+	if in.HorasValidez < 0 || in.HorasValidez > solicitudValidezMaxima {
+		http.Error(w, fmt.Sprintf("horas_validez debe estar entre 1 y %d", solicitudValidezMaxima), http.StatusBadRequest)
+		return
+	}
+
+	// Sólo se molesta al titular si el procesador podría acceder con su
+	// aprobación: consentimiento, condiciones y finalidad deben cumplirse
+	if in.Seudonimo != "" {
+		id, denegacion := resolverSeudonimo(ctx, idProcesador, in.Seudonimo, in.Finalidad, in.Justificacion)
+		if denegacion != nil {
+			http.Error(w, denegacion.mensaje, denegacion.status)
+			return
+		}
+		in.IDTitular = id
+	}
+	aut, denegacion := autorizarAcceso(ctx, idProcesador, in.IDTitular, in.Finalidad)
+	if denegacion != nil {
+		http.Error(w, denegacion.mensaje, denegacion.status)
+		return
+	}
+	if in.Seudonimo == "" && !aut.Identificado {
+		http.Error(w, "Este titular sólo puede pedirse por su seudónimo", http.StatusForbidden)
+		return
+	}
+	if !aut.RequiereAprobacion {
+		http.Error(w, "Las políticas consentidas no requieren aprobación del titular", http.StatusConflict)
+		return
+	}
+	for _, c := range in.Campos {
+		if !contiene(aut.Permitidos, c) {
+			http.Error(w, "Campo no autorizado por la política: "+c, http.StatusForbidden)
+			return
+		}
+	}
+
+	s := SolicitudAccesoTitular{
+		IDProcesador: idProcesador, IDTitular: in.IDTitular, Seudonimo: in.Seudonimo, Campos: in.Campos,
+		Finalidad: in.Finalidad, Justificacion: in.Justificacion, Estado: "pendiente",
+	}
+	if err := db.Pool.QueryRow(ctx, `
+		INSERT INTO solicitudes_acceso_titular
+		  (id_procesador, id_titular, seudonimo, campos, finalidad, justificacion, fecha_creacion, fecha_expiracion)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NOW(), NOW() + $7 * INTERVAL '1 hour')
+		RETURNING id_solicitud, fecha_creacion, fecha_expiracion
+	`, idProcesador, in.IDTitular, in.Seudonimo, in.Campos, in.Finalidad, in.Justificacion, in.HorasValidez).
+		Scan(&s.ID, &s.FechaCreacion, &s.FechaExpiracion); err != nil {
+		http.Error(w, "Error guardando solicitud: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var nombre string
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT nombre FROM usuarios WHERE id_usuario = $1`, idProcesador,
+	).Scan(&nombre); err != nil {
+		nombre = "un procesador"
+	}
+	_ = CrearNotificacion(ctx, &models.Notificacion{
+		UsuarioID:       in.IDTitular,
+		Tipo:            "solicitud_acceso_titular",
+		ReferenciaTabla: "solicitudes_acceso_titular",
+		ReferenciaID:    s.ID,
+		Mensaje: fmt.Sprintf("'%s' solicita acceder a %s para %s. Responde antes del %s.",
+			nombre, strings.Join(in.Campos, ", "), in.Finalidad, s.FechaExpiracion.Format("2006-01-02 15:04")),
+		URLRecurso: ptrString("/titular/solicitudes-acceso"),
+	})
+
+	if s.Seudonimo != "" {
+		s.IDTitular = 0
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// listarSolicitudesAcceso devuelve las solicitudes filtradas por procesador
+// o titular; las pendientes ya vencidas se muestran como "expirada".
+func listarSolicitudesAcceso(ctx context.Context, columna string, idUsuario int, estado string) ([]SolicitudAccesoTitular, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT s.id_solicitud, s.id_procesador, COALESCE(u.nombre, ''), s.id_titular, COALESCE(s.seudonimo, ''), s.campos,
+		       s.finalidad, s.justificacion,
+		       CASE WHEN s.estado IN ('pendiente', 'aprobada') AND s.fecha_expiracion <= NOW()
+		            THEN 'expirada' ELSE s.estado END AS estado_actual,
+		       s.fecha_creacion, s.fecha_expiracion, s.fecha_respuesta, s.motivo_respuesta, s.fecha_uso
+		  FROM solicitudes_acceso_titular s
+		  LEFT JOIN usuarios u ON u.id_usuario = s.id_procesador
+		 WHERE s.`+columna+` = $1
+		 ORDER BY s.fecha_creacion DESC
+	`, idUsuario)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lista := []SolicitudAccesoTitular{}
+	for rows.Next() {
+		var s SolicitudAccesoTitular
+		if err := rows.Scan(&s.ID, &s.IDProcesador, &s.Procesador, &s.IDTitular, &s.Seudonimo, &s.Campos,
+			&s.Finalidad, &s.Justificacion, &s.Estado,
+			&s.FechaCreacion, &s.FechaExpiracion, &s.FechaRespuesta, &s.MotivoRespuesta, &s.FechaUso); err != nil {
+			log.Println("scan solicitudes_acceso_titular:", err)
+			continue
+		}
+		// El procesador no ve el id de los titulares que pidió por seudónimo
+		if columna == "id_procesador" && s.Seudonimo != "" {
+			s.IDTitular = 0
+		}
+		if estado == "" || s.Estado == estado {
+			lista = append(lista, s)
+		}
+	}
+	return lista, nil
+}
+
+// ObtenerSolicitudesAccesoProcesador GET /procesador/solicitudes-acceso?estado=
+func ObtenerSolicitudesAccesoProcesador(w http.ResponseWriter, r *http.Request) {
+	idProcesador, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		http.Error(w, "Falta o es inválido X-User-ID", http.StatusUnauthorized)
+		return
+	}
+	lista, err := listarSolicitudesAcceso(r.Context(), "id_procesador", idProcesador, r.URL.Query().Get("estado"))
+	if err != nil {
+		http.Error(w, "Error consultando solicitudes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// ObtenerSolicitudesAccesoTitular GET /titular/solicitudes-acceso?estado=
+func ObtenerSolicitudesAccesoTitular(w http.ResponseWriter, r *http.Request) {
+	idTitular, ok := GetUserIDFromCtx(r.Context())
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	lista, err := listarSolicitudesAcceso(r.Context(), "id_titular", idTitular, r.URL.Query().Get("estado"))
+	if err != nil {
+		http.Error(w, "Error consultando solicitudes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lista)
+}
+
+// ResponderSolicitudAccesoTitular PUT /titular/solicitudes-acceso/{id}
+// Body: {"aprobar": true|false, "motivo": "..."}
+func ResponderSolicitudAccesoTitular(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idTitular, ok := GetUserIDFromCtx(ctx)
+	if !ok {
+		http.Error(w, "Usuario no autenticado", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	var in struct {
+		Aprobar bool   `json:"aprobar"`
+		Motivo  string `json:"motivo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "JSON inválido", http.StatusBadRequest)
+		return
+	}
+	estado := "denegada"
+	if in.Aprobar {
+		estado = "aprobada"
+	}
+
+	var idProcesador int
+	err = db.Pool.QueryRow(ctx, `
+		UPDATE solicitudes_acceso_titular
+		   SET estado = $3, fecha_respuesta = NOW(), motivo_respuesta = NULLIF($4, '')
+		 WHERE id_solicitud = $1 AND id_titular = $2
+		   AND estado = 'pendiente' AND fecha_expiracion > NOW()
+		RETURNING id_procesador
+	`, id, idTitular, estado, strings.TrimSpace(in.Motivo)).Scan(&idProcesador)
+	if err != nil {
+		http.Error(w, "Solicitud no encontrada, ya respondida o expirada", http.StatusConflict)
+		return
+	}
+	auditoria.Registrar(ctx, auditoria.Evento{
+		IDActor: idTitular, Rol: 1, Accion: "SOLICITUD-" + strings.ToUpper(estado),
+		Recurso: "solicitudes_acceso_titular", IDRecurso: id, Exito: true, Descripcion: in.Motivo,
+	})
+
+	_ = CrearNotificacion(ctx, &models.Notificacion{
+		UsuarioID:       idProcesador,
+		Tipo:            "solicitud_acceso_titular",
+		ReferenciaTabla: "solicitudes_acceso_titular",
+		ReferenciaID:    id,
+		Mensaje:         fmt.Sprintf("Tu solicitud de acceso #%d fue %s por el titular.", id, estado),
+		URLRecurso:      ptrString("/procesador/solicitudes-acceso"),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,32 @@
+// backend/handlers/verificar_auditoria.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"backend/utils"
+)
+
+// VerificarCadenaAuditoria GET /apd/api/auditoria/verificar[?tabla=accesos]
+// Recorre la cadena de hashes de las tablas de auditoría y devuelve, por
+// tabla, si está íntegra o el primer eslabón roto.
+func VerificarCadenaAuditoria(w http.ResponseWriter, r *http.Request) {
+	tablas := utils.TablasAuditoria
+	if t := r.URL.Query().Get("tabla"); t != "" {
+		tablas = []string{t}
+	}
+
+	resultados := make([]*utils.ResultadoCadena, 0, len(tablas))
+	for _, t := range tablas {
+		res, err := utils.VerificarCadenaAuditoria(r.Context(), t)
+		if err != nil {
+			http.Error(w, "Error verificando la cadena de auditoría: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resultados = append(resultados, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultados)
+}
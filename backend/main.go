@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"backend/auditoria"
 	"backend/db"
 	"backend/handlers"
 	"backend/utils"
@@ -19,7 +23,8 @@ func habilitarCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User-ID")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User-ID, X-Clave-Titular, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Total-Count, X-Siguiente-Cursor, Link")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -33,9 +38,19 @@ func main() {
 	db.ConectarDB()
 	defer db.Pool.Close()
 	db.ConectarDatosPersonales()
+	auditoria.Iniciar()
+	if err := auditoria.IniciarReenvio(os.Getenv("AUDITORIA_SYSLOG")); err != nil {
+		log.Fatalf("Error configurando el reenvío de auditoría: %v", err)
+	}
+	if err := auditoria.ConfigurarProxies(os.Getenv("AUDITORIA_PROXIES")); err != nil {
+		log.Fatalf("Error configurando los proxies de auditoría: %v", err)
+	}
 
 	// 1️⃣ Inicializar ABE
 	utils.InicializarABE()
+	utils.InicializarIndiceCiego()
+	utils.InicializarSeudonimos()
+	utils.InicializarFirmaAuditoria()
 
 	// 2️⃣ Configurar router y rutas
 	r := mux.NewRouter()
@@ -57,6 +72,10 @@ func main() {
 	ctd.HandleFunc("/notificaciones/count", handlers.GetUnreadCount).Methods("GET")
 	ctd.HandleFunc("/notificaciones/{id}/leer", handlers.MarkAsRead).Methods("PUT")
 	ctd.HandleFunc("/accesos", handlers.ObtenerAccesosCustodio).Methods("GET")
+	ctd.HandleFunc("/accesos/explicar", handlers.ExplicarAcceso).Methods("GET")
+	ctd.HandleFunc("/auditoria/exportar", handlers.ExportarAuditoria).Methods("GET")
+	ctd.HandleFunc("/auditoria/motivos", handlers.ObtenerMotivosAuditoria).Methods("GET")
+	ctd.HandleFunc("/auditoria/motivos/conteo", handlers.ContarMotivosAuditoria).Methods("GET")
 	ctd.HandleFunc("/consentimientos", handlers.ObtenerConsentimientosCustodio).Methods("GET")
 	ctd.HandleFunc("/api/fallos", handlers.ObtenerFallos).Methods("GET")
 	ctd.HandleFunc("/api/dashboard", handlers.ObtenerDashboard).Methods("GET")
@@ -88,10 +107,24 @@ func main() {
 	ctrl.HandleFunc("/politicas-privacidad/{id_politica}", handlers.ActualizarPoliticaControlador).Methods("PUT")
 	ctrl.HandleFunc("/politicas-privacidad/{id_politica}", handlers.EliminarPoliticaControlador).Methods("DELETE")
 	ctrl.HandleFunc("/politicas-privacidad/{id_politica}", handlers.ObtenerPoliticaPorIDC).Methods("GET")
+	ctrl.HandleFunc("/politicas-abe/validar", handlers.ValidarPoliticaABE).Methods("POST")
 
 	// • Asignación de atributos a política
 	ctrl.HandleFunc("/politica-atributos", handlers.ObtenerAtributosDePolitica).Methods("GET")
 	ctrl.HandleFunc("/atributos-datos", handlers.ObtenerAtributosDatos).Methods("GET")
+	ctrl.HandleFunc("/atributos-datos", handlers.CrearAtributoDato).Methods("POST")
+	ctrl.HandleFunc("/atributos-datos/{id}", handlers.ActualizarAtributoDato).Methods("PUT")
+	ctrl.HandleFunc("/busqueda-titulares", handlers.BuscarTitulares).Methods("GET")
+	ctrl.HandleFunc("/reidentificar", handlers.ReidentificarSeudonimo).Methods("POST")
+	ctrl.HandleFunc("/finalidades", handlers.ObtenerFinalidades).Methods("GET")
+	ctrl.HandleFunc("/cuotas-acceso", handlers.ObtenerCuotasAcceso).Methods("GET")
+	ctrl.HandleFunc("/cuotas-acceso", handlers.CrearCuotaAcceso).Methods("POST")
+	ctrl.HandleFunc("/cuotas-acceso/{id}", handlers.ActualizarCuotaAcceso).Methods("PUT")
+	ctrl.HandleFunc("/cuotas-acceso/{id}", handlers.EliminarCuotaAcceso).Methods("DELETE")
+	ctrl.HandleFunc("/emergencias/bases-legales", handlers.ObtenerBasesLegalesEmergencia).Methods("GET")
+	ctrl.HandleFunc("/emergencias", handlers.AbrirAccesoEmergencia).Methods("POST")
+	ctrl.HandleFunc("/emergencias/{id}/datos", handlers.ObtenerDatosEmergencia).Methods("GET")
+	ctrl.HandleFunc("/accesos/explicar", handlers.ExplicarAcceso).Methods("GET")
 
 	// • Consentimientos (monitoreo)
 	ctrl.HandleFunc("/consentimientos", handlers.ObtenerConsentimientos).Methods("GET")
@@ -129,12 +162,24 @@ func main() {
 	// Políticas
 	tit.HandleFunc("/politicas", handlers.ObtenerPoliticas).Methods("GET")
 
+	// Historial de accesos a mis datos
+	tit.HandleFunc("/accesos", handlers.ObtenerAccesosTitular).Methods("GET")
+	tit.HandleFunc("/accesos/avisos", handlers.ObtenerAvisosAccesos).Methods("GET")
+	tit.HandleFunc("/accesos/avisos", handlers.ActualizarAvisosAccesos).Methods("PUT")
+
+	// Solicitudes de acceso que requieren mi aprobación
+	tit.HandleFunc("/solicitudes-acceso", handlers.ObtenerSolicitudesAccesoTitular).Methods("GET")
+	tit.HandleFunc("/solicitudes-acceso/{id}", handlers.ResponderSolicitudAccesoTitular).Methods("PUT")
+
 	// Consentimientos
 	tit.HandleFunc("/consentimientos", handlers.GuardarConsentimiento).Methods("POST")
 	tit.HandleFunc("/consentimientos", handlers.ObtenerConsentimientosPorUsuario).Methods("GET")
 	tit.HandleFunc("/consentimientos", handlers.ActualizarConsentimiento).Methods("PUT")
 	tit.HandleFunc("/consentimientos", handlers.EliminarConsentimiento).Methods("DELETE")
 	tit.HandleFunc("/consentimientos/revocar", handlers.RevocarConsentimiento).Methods("POST")
+	tit.HandleFunc("/consentimientos/{id}/condiciones", handlers.ObtenerCondicionesConsentimiento).Methods("GET")
+	tit.HandleFunc("/consentimientos/{id}/condiciones", handlers.GuardarCondicionesConsentimiento).Methods("PUT")
+	tit.HandleFunc("/consentimientos/{id}/condiciones", handlers.EliminarCondicionesConsentimiento).Methods("DELETE")
 
 	// Dashboard titular
 	tit.HandleFunc("/dashboard", handlers.Dashboard).Methods("GET")
@@ -152,10 +197,17 @@ func main() {
 
 	// Endpoint de acceso a datos personales
 	proc.HandleFunc("/acceso-datos", handlers.ObtenerAccesoDatos).Methods("GET")
+	proc.HandleFunc("/acceso-datos/lote", handlers.ObtenerAccesoDatosLote).Methods("POST")
 	// Listado de políticas (o lo que uses en PoliticasProcesadorComponent)
 	//proc.HandleFunc("/politicas-procesador", handlers.ObtenerPoliticasParaProcesador).Methods("GET")
 	proc.HandleFunc("/atributos-terceros", handlers.ObtenerAtributosDeTercero).Methods("GET")
 	proc.HandleFunc("/titulares-por-atributo", handlers.ObtenerTitularesPorAtributo).Methods("GET")
+	proc.HandleFunc("/estado-consentimiento", handlers.ObtenerEstadoConsentimiento).Methods("GET")
+	proc.HandleFunc("/estado-consentimiento/lote", handlers.ObtenerEstadoConsentimientoLote).Methods("POST")
+	proc.HandleFunc("/busqueda-titulares", handlers.BuscarTitulares).Methods("GET")
+	proc.HandleFunc("/finalidades", handlers.ObtenerFinalidades).Methods("GET")
+	proc.HandleFunc("/solicitudes-acceso", handlers.CrearSolicitudAccesoTitular).Methods("POST")
+	proc.HandleFunc("/solicitudes-acceso", handlers.ObtenerSolicitudesAccesoProcesador).Methods("GET")
 	proc.HandleFunc("/solicitudes-attributo", handlers.CrearSolicitudAtributoP).Methods("POST")
 	proc.HandleFunc("/solicitudes-modificacion", handlers.CrearSolicitudModificacion).Methods("POST")
 	proc.HandleFunc("/politicas", handlers.ObtenerPoliticasParaProcesador).Methods("GET")
@@ -176,7 +228,22 @@ func main() {
 	apd.HandleFunc("/policies/{id}/history", handlers.PolicyHistory).Methods("GET")
 	apd.HandleFunc("/consents", handlers.ListConsents).Methods("GET")
 	apd.HandleFunc("/consents/{id}/history", handlers.ConsentHistory).Methods("GET")
+	apd.HandleFunc("/consents/{id}/versiones", handlers.ObtenerVersionesConsentimiento).Methods("GET")
+	apd.HandleFunc("/consentimientos/a-fecha", handlers.ConsultarAccesoEnFecha).Methods("GET")
 	apd.HandleFunc("/accesos", handlers.ObtenerAccesosCustodio).Methods("GET")
+	apd.HandleFunc("/accesos/explicar", handlers.ExplicarAcceso).Methods("GET")
+	apd.HandleFunc("/emergencias", handlers.ObtenerEmergenciasAPD).Methods("GET")
+	apd.HandleFunc("/emergencias/{id}/revision", handlers.RevisarAccesoEmergencia).Methods("PUT")
+	apd.HandleFunc("/auditoria/verificar", handlers.VerificarCadenaAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/exportar", handlers.ExportarAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/motivos", handlers.ObtenerMotivosAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/motivos/conteo", handlers.ContarMotivosAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/retencion", handlers.ObtenerRetencionAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/retencion", handlers.ActualizarRetencionAuditoria).Methods("PUT")
+	apd.HandleFunc("/auditoria/archivos", handlers.ListarArchivosAuditoria).Methods("GET")
+	apd.HandleFunc("/auditoria/archivos/{id}/restaurar", handlers.RestaurarArchivoAuditoria).Methods("POST")
+	apd.HandleFunc("/auditoria/archivos/{id}/registros", handlers.ObtenerRegistrosRestaurados).Methods("GET")
+	apd.HandleFunc("/auditoria/archivos/{id}/restauracion", handlers.DescartarRestauracionAuditoria).Methods("DELETE")
 	// • Políticas de privacidad con conteo de consentimientos activos
 
 	// 3️⃣ Tareas background
@@ -246,7 +313,42 @@ RETURNING c.id_consentimiento;
 		}
 	}()
 
+	// 3.4) Firmar checkpoints de la cadena de auditoría
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if err := utils.CrearCheckpointsAuditoria(context.Background()); err != nil {
+				log.Printf("Error firmando checkpoints de auditoría: %v", err)
+			}
+		}
+	}()
+
+	// 3.5) Retención: archivar y podar la auditoría vencida (una vez al día,
+	// sólo si AUDITORIA_ARCHIVO indica dónde archivar)
+	if err := auditoria.IniciarRetencion(os.Getenv("AUDITORIA_ARCHIVO")); err != nil {
+		log.Fatalf("Error configurando el archivo de auditoría: %v", err)
+	}
+
 	// 4️⃣ Arrancar servidor
 	log.Println("Servidor corriendo en http://localhost:3000")
-	log.Fatal(http.ListenAndServe(":3000", habilitarCORS(r)))
+	srv := &http.Server{Addr: ":3000", Handler: habilitarCORS(auditoria.Middleware(r))}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// 5️⃣ Apagado ordenado: terminar las solicitudes en curso y vaciar la
+	// cola de auditoría antes de salir
+	ctxSenal, parar := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer parar()
+	<-ctxSenal.Done()
+	log.Println("Apagando el servidor...")
+	ctxApagado, cancelar := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelar()
+	if err := srv.Shutdown(ctxApagado); err != nil {
+		log.Printf("Error apagando el servidor: %v", err)
+	}
+	auditoria.Detener()
 }
@@ -0,0 +1,19 @@
+package models
+
+// AtributoDato es una entrada del catálogo atributos_datos. Define qué datos
+// personales existen, cómo se validan y cómo se muestran.
+type AtributoDato struct {
+	ID          int      `json:"id_atributo"`
+	Nombre      string   `json:"nombre"`   // clave JSON, p. ej. "telefono"
+	Etiqueta    string   `json:"etiqueta"` // texto para la UI
+	Tipo        string   `json:"tipo"`     // texto, numero, fecha, telefono, email, opcion
+	Obligatorio bool     `json:"obligatorio"`
+	Patron      *string  `json:"patron,omitempty"` // expresión regular adicional
+	Opciones    []string `json:"opciones,omitempty"`
+	LongitudMax *int     `json:"longitud_max,omitempty"`
+	Buscable    bool     `json:"buscable"` // se guarda índice ciego para búsquedas
+	// ClasePrivacidad indica qué se entrega en modo seudonimizado:
+	// "identificador" → seudónimo, "cuasi_identificador" → valor generalizado.
+	ClasePrivacidad string `json:"clase_privacidad"`
+	Activo          bool   `json:"activo"`
+}
@@ -12,4 +12,12 @@ type EventoMonitoreo struct {
 	RegistroID    int       `json:"registro_id"`    // El ID (PK) de la fila afectada en esa tabla
 	Descripcion   string    `json:"descripcion"`    // Texto libre con detalles de la operación
 	FechaEvento   time.Time `json:"fecha_evento"`   // Marca de tiempo en que ocurrió el evento
+	Exito         bool      `json:"exito"`
+
+	// Contexto para el monitoreo de consentimientos
+	NombreUsuario string  `json:"nombre_usuario"`
+	RolUsuario    string  `json:"rol_usuario"`
+	PermisoEstado string  `json:"permiso_estado"`     // Concedido | Denegado | Desconocido
+	Politica      *string `json:"politica,omitempty"` // título de la política del consentimiento
+	Titular       *string `json:"titular,omitempty"`  // nombre del titular del consentimiento
 }
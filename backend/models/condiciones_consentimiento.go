@@ -0,0 +1,15 @@
+package models
+
+// CondicionesConsentimiento son las restricciones que el titular añade a un
+// consentimiento más allá de la política: a quién excluye, cuándo se puede
+// acceder y cuántas veces.
+type CondicionesConsentimiento struct {
+	IDConsentimiento      int     `json:"id_consentimiento"`
+	ProcesadoresExcluidos []int   `json:"procesadores_excluidos"`
+	DiasPermitidos        []int   `json:"dias_permitidos,omitempty"` // 1 = lunes … 7 = domingo
+	HoraDesde             *string `json:"hora_desde,omitempty"`      // "HH:MM"
+	HoraHasta             *string `json:"hora_hasta,omitempty"`      // "HH:MM"; si es menor que desde, cruza la medianoche
+	ZonaHoraria           string  `json:"zona_horaria,omitempty"`    // IANA; días y franja se evalúan en ella (por defecto "UTC")
+	MaxAccesos            *int    `json:"max_accesos,omitempty"`
+	AccesosRealizados     int     `json:"accesos_realizados"`
+}
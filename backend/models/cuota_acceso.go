@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// CuotaAcceso limita cuántos accesos a datos personales puede hacer un
+// procesador. Sin IDProcesador aplica a todos los procesadores; con
+// IDPolitica sólo cuenta los accesos autorizados por esa política.
+type CuotaAcceso struct {
+	ID              int        `json:"id_cuota"`
+	IDProcesador    *int       `json:"id_procesador,omitempty"`
+	IDPolitica      *int       `json:"id_politica,omitempty"`
+	MaxSolicitudes  *int       `json:"max_solicitudes,omitempty"` // por ventana
+	VentanaSegundos int        `json:"ventana_segundos"`
+	MaxTitularesDia *int       `json:"max_titulares_dia,omitempty"` // titulares distintos por día
+	Activo          bool       `json:"activo"`
+	UltimaAlerta    *time.Time `json:"ultima_alerta,omitempty"`
+	FechaCreacion   time.Time  `json:"fecha_creacion"`
+}
@@ -2,16 +2,11 @@ package models
 
 import "time"
 
+// DatosPersonales es la cabecera de los datos de un titular. Los valores
+// viven en datos_personales_valores, uno cifrado por atributo del catálogo.
 type DatosPersonales struct {
-	IDDato          int       `json:"id_dato"`
-	IDUsuario       int       `json:"id_usuario"`
-	Telefono        []byte    `json:"telefono"`
-	Celular         []byte    `json:"celular"`
-	Direccion       []byte    `json:"direccion"`
-	Ciudad          []byte    `json:"ciudad"`
-	Provincia       []byte    `json:"provincia"`
-	FechaNacimiento []byte    `json:"fecha_nacimiento"` 
-	Genero          []byte    `json:"genero"`
-	EstadoCivil     []byte    `json:"estado_civil"`
-	FechaCreacion   time.Time `json:"fecha_creacion"`
+	IDDato        int               `json:"id_dato"`
+	IDUsuario     int               `json:"id_usuario"`
+	Valores       map[string][]byte `json:"valores"` // nombre de atributo → sobre cifrado
+	FechaCreacion time.Time         `json:"fecha_creacion"`
 }
@@ -0,0 +1,34 @@
+package models
+
+// Códigos de motivo de las denegaciones y fallos auditados (columna
+// codigo_motivo). Viven aquí para que utils y auditoria los compartan sin
+// importarse entre sí; las etiquetas por idioma están en auditoria/motivos.go.
+const (
+	// Accesos a datos personales
+	MotivoFinalidadAusente     = "finalidad_ausente"
+	MotivoSinConsentimiento    = "sin_consentimiento"
+	MotivoPoliticaNoCoincide   = "politica_no_coincide"
+	MotivoFinalidadNoDeclarada = "finalidad_no_declarada"
+	MotivoCondicionTitular     = "condicion_titular"
+	MotivoCuotaExcedida        = "cuota_excedida"
+	MotivoSinAprobacion        = "sin_aprobacion"
+	MotivoTitularNoEncontrado  = "titular_no_encontrado"
+	MotivoEmergenciaNoVigente  = "emergencia_no_vigente"
+	MotivoSeudonimoRequerido   = "seudonimo_requerido"
+
+	// Consentimientos y políticas (los tres primeros, sólo en filas antiguas)
+	MotivoAtributoNoValido        = "atributo_no_valido"
+	MotivoPoliticaRevocada        = "politica_revocada"
+	MotivoAtributoExpirado        = "atributo_expirado"
+	MotivoExpiracionFueraVigencia = "expiracion_fuera_de_vigencia"
+
+	// Reidentificación, archivo y login
+	MotivoSeudonimoDesconocido  = "seudonimo_desconocido"
+	MotivoArchivoAlterado       = "archivo_alterado"
+	MotivoUsuarioDesconocido    = "usuario_desconocido"
+	MotivoCredencialesInvalidas = "credenciales_invalidas"
+
+	MotivoErrorBD      = "error_bd"
+	MotivoErrorInterno = "error_interno"
+	MotivoOtro         = "otro"
+)
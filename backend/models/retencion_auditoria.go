@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RetencionAuditoria es el plazo en línea de un flujo de auditoría (o de
+// notificaciones) antes de archivarlo y podarlo.
+type RetencionAuditoria struct {
+	Flujo              string    `json:"flujo"`
+	Dias               int       `json:"dias"`
+	FechaActualizacion time.Time `json:"fecha_actualizacion"`
+}
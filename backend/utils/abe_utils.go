@@ -3,24 +3,28 @@ package utils
 
 import (
 	"backend/db"
+	"backend/models"
 	"bytes"
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/fentec-project/gofe/abe"
+	"github.com/jackc/pgx/v5"
 )
 
 var pubKey *abe.FAMEPubKey
 var secKey *abe.FAMESecKey
 
 const (
-	pubKeyFile = "abe_public.key"
-	secKeyFile = "abe_secret.key"
+	pubKeyFile     = "abe_public.key"
+	secKeyFile     = "abe_secret.key"
+	versionKeyFile = "abe_version.key" // VersionClaveABE del par guardado
 )
 
 func init() {
@@ -43,10 +47,31 @@ func InicializarABE() {
 	if err != nil {
 		log.Fatalf("Error al generar claves maestras ABE: %v", err)
 	}
+	// Un par nuevo nunca reutiliza la versión de uno anterior: lo cifrado
+	// con él fallará con ErrVersionClave y no dentro de FAME
+	VersionClaveABE = siguienteVersionClaveABE()
 	if err := GuardarClavesABE(); err != nil {
 		log.Fatalf("Error al guardar claves ABE: %v", err)
 	}
-	fmt.Println("Claves ABE generadas y guardadas correctamente.")
+	fmt.Printf("Claves ABE generadas y guardadas correctamente (versión %d).\n", VersionClaveABE)
+}
+
+// siguienteVersionClaveABE es una más que la mayor conocida: la del archivo
+// de versión, si sobrevivió a las claves, y la de las claves de titular.
+func siguienteVersionClaveABE() uint16 {
+	var anterior uint16
+	if v, err := cargarArchivoGob(versionKeyFile); err == nil {
+		anterior = *v.(*uint16)
+	}
+	var enBD int
+	if err := db.Pool.QueryRow(context.Background(),
+		`SELECT COALESCE(MAX(version_clave_abe), 0) FROM claves_titular`).Scan(&enBD); err != nil {
+		log.Fatalf("Error leyendo versiones de clave ABE en uso: %v", err)
+	}
+	if uint16(enBD) > anterior {
+		anterior = uint16(enBD)
+	}
+	return anterior + 1
 }
 
 func GuardarClavesABE() error {
@@ -56,7 +81,7 @@ func GuardarClavesABE() error {
 	if err := guardarArchivoGob(secKeyFile, secKey); err != nil {
 		return err
 	}
-	return nil
+	return guardarArchivoGob(versionKeyFile, VersionClaveABE)
 }
 
 func CargarClavesABE() bool {
@@ -67,6 +92,20 @@ func CargarClavesABE() bool {
 	}
 	pubKey = pub.(*abe.FAMEPubKey)
 	secKey = sec.(*abe.FAMESecKey)
+
+	// Claves de antes de versionarlas: son la versión 1
+	v, err := cargarArchivoGob(versionKeyFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		VersionClaveABE = 1
+		if err := guardarArchivoGob(versionKeyFile, VersionClaveABE); err != nil {
+			log.Fatalf("Error al guardar la versión de clave ABE: %v", err)
+		}
+	case err != nil:
+		log.Fatalf("Error al leer la versión de clave ABE: %v", err)
+	default:
+		VersionClaveABE = *v.(*uint16)
+	}
 	return true
 }
 
@@ -92,6 +131,12 @@ func cargarArchivoGob(nombre string) (interface{}, error) {
 		result = &abe.FAMEPubKey{}
 	case secKeyFile:
 		result = &abe.FAMESecKey{}
+	case indiceKeyFile:
+		result = &ClavesIndice{}
+	case seudonimoKeyFile, firmaAuditoriaKeyFile:
+		result = &[]byte{}
+	case versionKeyFile:
+		result = new(uint16)
 	default:
 		return nil, fmt.Errorf("archivo de clave desconocido")
 	}
@@ -103,18 +148,40 @@ func cargarArchivoGob(nombre string) (interface{}, error) {
 }
 
 // Cifrado ABE
+// La política se escribe en el lenguaje de politica_abe.go y se compila
+// a la expresión AND/OR que entiende abe.BooleanToMSP.
 func CifrarDatoABE(dato string, politica string) (*abe.FAMECipher, error) {
 	scheme := abe.NewFAME()
-	mspStruct, err := abe.BooleanToMSP(politica, false)
+	arbol, err := ParsearPolitica(politica)
+	if err != nil {
+		return nil, fmt.Errorf("política inválida: %w", err)
+	}
+	expresion, err := CompilarPolitica(arbol, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error compilando política: %w", err)
+	}
+	mspStruct, err := abe.BooleanToMSP(expresion, false)
 	if err != nil {
 		return nil, fmt.Errorf("error MSP: %v", err)
 	}
 	return scheme.Encrypt(dato, mspStruct, pubKey)
 }
 
+// generarClavesCifrado deriva, con la clave maestra, sólo las claves de las
+// filas del cifrado que los atributos (vigentes hoy) permiten abrir.
+func generarClavesCifrado(scheme *abe.FAME, cipher *abe.FAMECipher, atributos []string) (*abe.FAMEAttribKeys, error) {
+	filas := filasAutorizadas(cipher.Msp, AtributosVigentes(atributos, time.Now()))
+	if len(filas) == 0 {
+		return nil, fmt.Errorf("los atributos no satisfacen la política del cifrado")
+	}
+	return scheme.GenerateAttribKeys(filas, secKey)
+}
+
+// DescifrarDatoABEConMaster deriva claves desde la clave maestra. Sólo debe
+// usarse en tareas de recifrado; los titulares usan su clave personal.
 func DescifrarDatoABEConMaster(cipher *abe.FAMECipher, atributos []string) (string, error) {
 	scheme := abe.NewFAME()
-	attribKeys, err := scheme.GenerateAttribKeys(atributos, secKey)
+	attribKeys, err := generarClavesCifrado(scheme, cipher, atributos)
 	if err != nil {
 		return "", fmt.Errorf("error generando claves de atributos: %v", err)
 	}
@@ -135,7 +202,7 @@ func DescifrarDatoABEConClaveUsuario(cipher *abe.FAMECipher, idUsuario int) (str
 	}
 
 	// 2) Generar attribKeys
-	attribKeys, err := scheme.GenerateAttribKeys(atributos, secKey)
+	attribKeys, err := generarClavesCifrado(scheme, cipher, atributos)
 	if err != nil {
 		return "", fmt.Errorf("error generando claves de atributos: %v", err)
 	}
@@ -148,84 +215,111 @@ func DescifrarDatoABEConClaveUsuario(cipher *abe.FAMECipher, idUsuario int) (str
 	return texto, nil
 }
 
-func SerializarCipher(cipher *abe.FAMECipher) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(cipher)
+// ParsePoliticaToAtributos devuelve los atributos que aparecen en la política.
+func ParsePoliticaToAtributos(politica string) []string {
+	arbol, err := ParsearPolitica(politica)
 	if err != nil {
-		return nil, err
+		return nil
 	}
-	return buf.Bytes(), nil
+	return AtributosDePolitica(arbol)
 }
 
-func DeserializarCipher(data []byte) (*abe.FAMECipher, error) {
-	var cipher abe.FAMECipher
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(&cipher)
-	if err != nil {
-		return nil, err
-	}
-	return &cipher, nil
+// AtributosTercero devuelve los atributos asignados más recientes de un tercero.
+func AtributosTercero(ctx context.Context, idTercero int) ([]string, error) {
+	return AtributosTerceroEn(ctx, idTercero, nil)
 }
 
-func ParsePoliticaToAtributos(politica string) []string {
-	limpia := strings.ReplaceAll(politica, "(", "")
-	limpia = strings.ReplaceAll(limpia, ")", "")
-	partes := strings.Split(limpia, " OR ")
-	for i := range partes {
-		partes[i] = strings.TrimSpace(partes[i])
-	}
-	return partes
-}
-
-// VerificarAccesoDinamico
-func VerificarAccesoDinamico(idTercero, idTitular int) bool {
+// AtributosTerceroEn es AtributosTercero en un instante pasado (nil = ahora).
+func AtributosTerceroEn(ctx context.Context, idTercero int, en *time.Time) ([]string, error) {
 	var atributosRaw []byte
-	err := db.Pool.QueryRow(context.Background(), `
-		SELECT atributos FROM atributos_terceros
+	args := []any{idTercero}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT atributos FROM `+TablaEn("atributos_terceros", en, &args)+` t
 		WHERE id_usuario = $1
 		ORDER BY fecha_asignacion DESC LIMIT 1
-	`, idTercero).Scan(&atributosRaw)
+	`, args...).Scan(&atributosRaw)
 	if err != nil {
-		fmt.Println("Error al obtener atributos:", err)
-		return false
+		return nil, fmt.Errorf("error al obtener atributos: %w", err)
 	}
 
 	var atributos []string
 	if err := json.Unmarshal(atributosRaw, &atributos); err != nil {
-		fmt.Println("Error al parsear atributos:", err)
-		return false
+		return nil, fmt.Errorf("error al parsear atributos: %w", err)
+	}
+	return atributos, nil
+}
+
+// VerificarAccesoDinamico
+func VerificarAccesoDinamico(idTercero, idTitular int) bool {
+	ok, _, _ := EvaluarAccesoDinamico(context.Background(), idTercero, idTitular)
+	return ok
+}
+
+// EvaluarAccesoDinamico comprueba que algún consentimiento activo del titular
+// coincida con los atributos del tercero y que sus condiciones lo permitan
+// ahora. Si se deniega, devuelve el código del catálogo (models/motivos.go)
+// y el detalle para accesos.motivo.
+func EvaluarAccesoDinamico(ctx context.Context, idTercero, idTitular int) (bool, string, string) {
+	atributos, err := AtributosTercero(ctx, idTercero)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, models.MotivoPoliticaNoCoincide, "política no coincide"
+	}
+	if err != nil {
+		fmt.Println(err)
+		return false, models.MotivoErrorBD, "error lectura atributos del tercero"
 	}
 
-	rows, err := db.Pool.Query(context.Background(), `
-		SELECT p.titulo
+	rows, err := db.Pool.Query(ctx, `
+		SELECT c.id_consentimiento, p.titulo
 		FROM consentimientos c
 		JOIN politicas_privacidad p ON c.id_politica = p.id_politica
 		WHERE c.id_usuario = $1 AND c.estado = 'activo' AND c.fecha_expiracion > NOW()
 	`, idTitular)
 	if err != nil {
 		fmt.Println("Error al consultar políticas:", err)
-		return false
+		return false, models.MotivoErrorBD, "error lectura consentimientos"
 	}
-	defer rows.Close()
 
-	var politicas []string
+	var coinciden []int
 	for rows.Next() {
+		var id int
 		var titulo string
-		if err := rows.Scan(&titulo); err == nil {
-			politicas = append(politicas, titulo)
+		if err := rows.Scan(&id, &titulo); err != nil {
+			continue
+		}
+		for _, atributo := range atributos {
+			if atributo == titulo {
+				coinciden = append(coinciden, id)
+				break
+			}
 		}
 	}
+	rows.Close()
+	if len(coinciden) == 0 {
+		return false, models.MotivoPoliticaNoCoincide, "política no coincide"
+	}
 
-	for _, atributo := range atributos {
-		for _, politica := range politicas {
-			if atributo == politica {
-				return true
-			}
+	condiciones, err := CargarCondiciones(ctx, coinciden)
+	if err != nil {
+		fmt.Println(err)
+		return false, models.MotivoErrorBD, "error lectura condiciones"
+	}
+	ahora := time.Now()
+	motivo := ""
+	for _, id := range coinciden {
+		c, ok := condiciones[id]
+		if !ok {
+			return true, "", ""
+		}
+		condicion := EvaluarCondiciones(c, idTercero, ahora)
+		if condicion == "" {
+			return true, "", ""
+		}
+		if motivo == "" {
+			motivo = MotivoCondicion(condicion)
 		}
 	}
-	return false
+	return false, models.MotivoCondicionTitular, motivo
 }
 
 // GuardarAtributosUsuario → guarda solo []string
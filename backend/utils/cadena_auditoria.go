@@ -0,0 +1,363 @@
+// backend/utils/cadena_auditoria.go
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"backend/db"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+/*
+   Cadena de auditoría
+   -------------------
+   Las tablas de auditoría se encadenan fila a fila con SHA-256 mediante un
+   trigger (migración 014). Aquí se verifica la cadena recalculando cada hash
+   a partir de la forma canónica que devuelve auditoria_canonica(), y se
+   emiten checkpoints firmados con Ed25519: quien reescriba la tabla entera
+   puede rehacer los hashes, pero no falsificar la firma de los checkpoints.
+   La clave vive en auditoria_firma.key.
+*/
+
+const firmaAuditoriaKeyFile = "auditoria_firma.key"
+
+// TablasAuditoria son las tablas encadenadas, en el orden en que se verifican.
+var TablasAuditoria = []string{"accesos", "auditoria_eventos", "auditoria_politicas", "auditoria_login"}
+
+var claveFirmaAuditoria ed25519.PrivateKey
+
+// hashGenesis es el hash_anterior del primer eslabón de cada cadena.
+var hashGenesis = make([]byte, sha256.Size)
+
+// Eslabon es una fila de una tabla encadenada.
+type Eslabon struct {
+	Pos          int64
+	HashAnterior []byte
+	Hash         []byte
+	Canonica     string
+}
+
+// CheckpointAuditoria ancla (tabla, posición, hash) con la firma del servidor.
+type CheckpointAuditoria struct {
+	ID    int       `json:"id_checkpoint"`
+	Tabla string    `json:"tabla"`
+	Pos   int64     `json:"cadena_pos"`
+	Hash  []byte    `json:"hash"`
+	Fecha time.Time `json:"fecha"`
+	Firma []byte    `json:"firma"`
+}
+
+// RupturaCadena es el primer eslabón que no verifica.
+type RupturaCadena struct {
+	Pos    int64  `json:"cadena_pos"`
+	Motivo string `json:"motivo"`
+}
+
+// ResultadoCadena resume la verificación de una tabla.
+type ResultadoCadena struct {
+	Tabla       string         `json:"tabla"`
+	Integra     bool           `json:"integra"`
+	Eslabones   int64          `json:"eslabones"`
+	PrimeraPos  int64          `json:"primera_pos"`
+	UltimaPos   int64          `json:"ultima_pos"`
+	Checkpoints int            `json:"checkpoints"`
+	Ruptura     *RupturaCadena `json:"ruptura,omitempty"`
+}
+
+// InicializarFirmaAuditoria carga la clave de firma de checkpoints o genera una nueva.
+func InicializarFirmaAuditoria() {
+	if err := CargarFirmaAuditoria(); err == nil {
+		fmt.Println("Clave de firma de auditoría cargada desde archivo.")
+		return
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("Error al generar clave de firma de auditoría: %v", err)
+	}
+	if err := guardarArchivoGob(firmaAuditoriaKeyFile, []byte(priv)); err != nil {
+		log.Fatalf("Error al guardar clave de firma de auditoría: %v", err)
+	}
+	claveFirmaAuditoria = priv
+	fmt.Println("Clave de firma de auditoría generada y guardada correctamente.")
+}
+
+// CargarFirmaAuditoria lee la clave existente sin generar una nueva.
+func CargarFirmaAuditoria() error {
+	leida, err := cargarArchivoGob(firmaAuditoriaKeyFile)
+	if err != nil {
+		return err
+	}
+	clave := *leida.(*[]byte)
+	if len(clave) != ed25519.PrivateKeySize {
+		return fmt.Errorf("clave de firma de auditoría inválida")
+	}
+	claveFirmaAuditoria = ed25519.PrivateKey(clave)
+	return nil
+}
+
+// HashEslabon es sha256(hash_anterior || forma canónica), igual que el trigger.
+func HashEslabon(anterior []byte, canonica string) []byte {
+	h := sha256.New()
+	h.Write(anterior)
+	h.Write([]byte(canonica))
+	return h.Sum(nil)
+}
+
+func mensajeCheckpoint(c CheckpointAuditoria) []byte {
+	return []byte("cadena-auditoria|" + c.Tabla + "|" + strconv.FormatInt(c.Pos, 10) + "|" +
+		hex.EncodeToString(c.Hash) + "|" + c.Fecha.UTC().Format(time.RFC3339Nano))
+}
+
+func firmarCheckpoint(priv ed25519.PrivateKey, c *CheckpointAuditoria) {
+	c.Firma = ed25519.Sign(priv, mensajeCheckpoint(*c))
+}
+
+// FirmarAuditoria firma un mensaje con la clave de auditoría (p. ej. el
+// manifiesto de un archivo de retención).
+func FirmarAuditoria(msg []byte) ([]byte, error) {
+	if claveFirmaAuditoria == nil {
+		return nil, fmt.Errorf("clave de firma de auditoría no cargada")
+	}
+	return ed25519.Sign(claveFirmaAuditoria, msg), nil
+}
+
+// VerificarFirmaAuditoria comprueba una firma hecha con FirmarAuditoria.
+func VerificarFirmaAuditoria(msg, firma []byte) bool {
+	if claveFirmaAuditoria == nil {
+		return false
+	}
+	return ed25519.Verify(claveFirmaAuditoria.Public().(ed25519.PublicKey), msg, firma)
+}
+
+// verificadorCadena recorre una cadena eslabón a eslabón.
+type verificadorCadena struct {
+	checkpoints map[int64]CheckpointAuditoria
+	firmaMala   *RupturaCadena
+	prev        []byte
+	esperada    int64
+	res         ResultadoCadena
+	ultimoCkPos int64
+}
+
+func nuevoVerificador(tabla string, pub ed25519.PublicKey, checkpoints []CheckpointAuditoria) *verificadorCadena {
+	v := &verificadorCadena{
+		checkpoints: map[int64]CheckpointAuditoria{},
+		res:         ResultadoCadena{Tabla: tabla, Checkpoints: len(checkpoints)},
+	}
+	for _, c := range checkpoints {
+		if !ed25519.Verify(pub, mensajeCheckpoint(c), c.Firma) {
+			if v.firmaMala == nil || c.Pos < v.firmaMala.Pos {
+				v.firmaMala = &RupturaCadena{Pos: c.Pos,
+					Motivo: fmt.Sprintf("firma inválida en el checkpoint %d", c.ID)}
+			}
+			continue
+		}
+		v.checkpoints[c.Pos] = c
+		if c.Pos > v.ultimoCkPos {
+			v.ultimoCkPos = c.Pos
+		}
+	}
+	return v
+}
+
+// siguiente comprueba un eslabón; devuelve la ruptura si es el primero que falla.
+func (v *verificadorCadena) siguiente(e Eslabon) *RupturaCadena {
+	if v.firmaMala != nil && e.Pos >= v.firmaMala.Pos {
+		return v.firmaMala
+	}
+	if v.res.Eslabones == 0 {
+		v.res.PrimeraPos = e.Pos
+		switch {
+		case e.Pos == 1:
+			if !bytes.Equal(e.HashAnterior, hashGenesis) {
+				return &RupturaCadena{Pos: e.Pos, Motivo: "el primer eslabón no parte del hash génesis"}
+			}
+		default:
+			// Inicio podado: debe quedar anclado a un checkpoint firmado
+			c, ok := v.checkpoints[e.Pos-1]
+			if !ok || !bytes.Equal(c.Hash, e.HashAnterior) {
+				return &RupturaCadena{Pos: e.Pos,
+					Motivo: fmt.Sprintf("faltan los eslabones anteriores a %d sin checkpoint que los ancle", e.Pos)}
+			}
+		}
+	} else {
+		if e.Pos != v.esperada {
+			return &RupturaCadena{Pos: v.esperada, Motivo: fmt.Sprintf("falta el eslabón %d", v.esperada)}
+		}
+		if !bytes.Equal(e.HashAnterior, v.prev) {
+			return &RupturaCadena{Pos: e.Pos,
+				Motivo: fmt.Sprintf("hash_anterior no coincide con el hash del eslabón %d", e.Pos-1)}
+		}
+	}
+	if !bytes.Equal(HashEslabon(e.HashAnterior, e.Canonica), e.Hash) {
+		return &RupturaCadena{Pos: e.Pos, Motivo: "el contenido de la fila no coincide con su hash"}
+	}
+	if c, ok := v.checkpoints[e.Pos]; ok && !bytes.Equal(c.Hash, e.Hash) {
+		return &RupturaCadena{Pos: e.Pos,
+			Motivo: fmt.Sprintf("el hash no coincide con el checkpoint firmado %d", c.ID)}
+	}
+
+	v.prev = e.Hash
+	v.esperada = e.Pos + 1
+	v.res.Eslabones++
+	v.res.UltimaPos = e.Pos
+	return nil
+}
+
+// terminar comprueba que la cadena llega hasta la cabeza y los checkpoints.
+func (v *verificadorCadena) terminar(cabezaPos int64, cabezaHash []byte) *RupturaCadena {
+	if v.firmaMala != nil {
+		return v.firmaMala
+	}
+	if v.res.Eslabones == 0 && cabezaPos > 0 {
+		// Cadena podada entera: la cabeza debe coincidir con un checkpoint firmado
+		if c, ok := v.checkpoints[cabezaPos]; ok && bytes.Equal(c.Hash, cabezaHash) {
+			v.res.PrimeraPos, v.res.UltimaPos = cabezaPos+1, cabezaPos
+			return nil
+		}
+	}
+	if v.ultimoCkPos > v.res.UltimaPos {
+		return &RupturaCadena{Pos: v.res.UltimaPos + 1,
+			Motivo: fmt.Sprintf("faltan eslabones hasta el checkpoint firmado en %d", v.ultimoCkPos)}
+	}
+	if cabezaPos != v.res.UltimaPos || (cabezaPos > 0 && !bytes.Equal(cabezaHash, v.prev)) {
+		return &RupturaCadena{Pos: v.res.UltimaPos + 1,
+			Motivo: fmt.Sprintf("la cadena termina en %d pero la cabeza registrada es %d", v.res.UltimaPos, cabezaPos)}
+	}
+	return nil
+}
+
+func validarTablaAuditoria(tabla string) error {
+	for _, t := range TablasAuditoria {
+		if t == tabla {
+			return nil
+		}
+	}
+	return fmt.Errorf("tabla de auditoría desconocida: %s", tabla)
+}
+
+func cargarCheckpoints(ctx context.Context, tabla string) ([]CheckpointAuditoria, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id_checkpoint, tabla, cadena_pos, hash, fecha, firma
+		  FROM cadena_auditoria_checkpoints
+		 WHERE tabla = $1
+		 ORDER BY cadena_pos
+	`, tabla)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cks []CheckpointAuditoria
+	for rows.Next() {
+		var c CheckpointAuditoria
+		if err := rows.Scan(&c.ID, &c.Tabla, &c.Pos, &c.Hash, &c.Fecha, &c.Firma); err != nil {
+			return nil, err
+		}
+		cks = append(cks, c)
+	}
+	return cks, rows.Err()
+}
+
+// VerificarCadenaAuditoria recorre la cadena de una tabla y devuelve la
+// primera ruptura, si la hay.
+func VerificarCadenaAuditoria(ctx context.Context, tabla string) (*ResultadoCadena, error) {
+	if err := validarTablaAuditoria(tabla); err != nil {
+		return nil, err
+	}
+	if claveFirmaAuditoria == nil {
+		return nil, fmt.Errorf("clave de firma de auditoría no cargada")
+	}
+
+	// La cabeza se lee primero: lo que se inserte durante el recorrido queda fuera
+	var cabezaPos int64
+	var cabezaHash []byte
+	if err := db.Pool.QueryRow(ctx, `
+		SELECT ultima_pos, ultimo_hash FROM cadena_auditoria_estado WHERE tabla = $1
+	`, tabla).Scan(&cabezaPos, &cabezaHash); err != nil {
+		return nil, fmt.Errorf("error leyendo la cabeza de %s: %w", tabla, err)
+	}
+	cks, err := cargarCheckpoints(ctx, tabla)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo checkpoints de %s: %w", tabla, err)
+	}
+	v := nuevoVerificador(tabla, claveFirmaAuditoria.Public().(ed25519.PublicKey), cks)
+
+	rows, err := db.Pool.Query(ctx, fmt.Sprintf(`
+		SELECT cadena_pos, hash_anterior, hash, auditoria_canonica(to_jsonb(t))
+		  FROM %s t
+		 WHERE cadena_pos <= $1
+		 ORDER BY cadena_pos
+	`, tabla), cabezaPos)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo %s: %w", tabla, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Eslabon
+		if err := rows.Scan(&e.Pos, &e.HashAnterior, &e.Hash, &e.Canonica); err != nil {
+			return nil, fmt.Errorf("error leyendo %s: %w", tabla, err)
+		}
+		if ruptura := v.siguiente(e); ruptura != nil {
+			v.res.Ruptura = ruptura
+			return &v.res, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error leyendo %s: %w", tabla, err)
+	}
+	v.res.Ruptura = v.terminar(cabezaPos, cabezaHash)
+	v.res.Integra = v.res.Ruptura == nil
+	return &v.res, nil
+}
+
+// CrearCheckpointsAuditoria firma la cabeza actual de cada cadena.
+func CrearCheckpointsAuditoria(ctx context.Context) error {
+	for _, tabla := range TablasAuditoria {
+		var pos int64
+		var hash []byte
+		if err := db.Pool.QueryRow(ctx, `
+			SELECT ultima_pos, ultimo_hash FROM cadena_auditoria_estado WHERE tabla = $1
+		`, tabla).Scan(&pos, &hash); err != nil {
+			return fmt.Errorf("error leyendo la cabeza de %s: %w", tabla, err)
+		}
+		if pos == 0 {
+			continue
+		}
+		if err := FirmarCheckpointAuditoria(ctx, db.Pool, tabla, pos, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ejecutorSQL interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// FirmarCheckpointAuditoria firma el eslabón (pos, hash) de una tabla. Con
+// ex = una transacción sirve para anclar la cadena antes de podar su inicio.
+func FirmarCheckpointAuditoria(ctx context.Context, ex ejecutorSQL, tabla string, pos int64, hash []byte) error {
+	if claveFirmaAuditoria == nil {
+		return fmt.Errorf("clave de firma de auditoría no cargada")
+	}
+	c := CheckpointAuditoria{Tabla: tabla, Pos: pos, Hash: hash, Fecha: time.Now().UTC().Truncate(time.Microsecond)}
+	firmarCheckpoint(claveFirmaAuditoria, &c)
+	if _, err := ex.Exec(ctx, `
+		INSERT INTO cadena_auditoria_checkpoints (tabla, cadena_pos, hash, fecha, firma)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tabla, cadena_pos) DO NOTHING
+	`, c.Tabla, c.Pos, c.Hash, c.Fecha, c.Firma); err != nil {
+		return fmt.Errorf("error guardando checkpoint de %s: %w", tabla, err)
+	}
+	return nil
+}
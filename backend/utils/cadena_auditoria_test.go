@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cadenaDePrueba(n int) []Eslabon {
+	var eslabones []Eslabon
+	prev := hashGenesis
+	for i := 1; i <= n; i++ {
+		e := Eslabon{Pos: int64(i), HashAnterior: prev, Canonica: `{"cadena_pos": ` + strconv.Itoa(i) + `, "motivo": "ok"}`}
+		e.Hash = HashEslabon(prev, e.Canonica)
+		eslabones = append(eslabones, e)
+		prev = e.Hash
+	}
+	return eslabones
+}
+
+func recorrer(v *verificadorCadena, eslabones []Eslabon, cabeza Eslabon) *RupturaCadena {
+	for _, e := range eslabones {
+		if r := v.siguiente(e); r != nil {
+			return r
+		}
+	}
+	return v.terminar(cabeza.Pos, cabeza.Hash)
+}
+
+func TestVerificadorCadena(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	cadena := cadenaDePrueba(5)
+	cabeza := cadena[4]
+	ck := CheckpointAuditoria{ID: 1, Tabla: "accesos", Pos: 3, Hash: cadena[2].Hash, Fecha: time.Now()}
+	firmarCheckpoint(priv, &ck)
+
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck}), cadena, cabeza); r != nil {
+		t.Fatalf("cadena íntegra rechazada: %+v", r)
+	}
+
+	alterada := cadenaDePrueba(5)
+	alterada[1].Canonica = strings.Replace(alterada[1].Canonica, "ok", "ko", 1)
+	if r := recorrer(nuevoVerificador("accesos", pub, nil), alterada, cabeza); r == nil || r.Pos != 2 {
+		t.Errorf("contenido alterado: ruptura = %+v, want pos 2", r)
+	}
+
+	sinTercero := append(append([]Eslabon{}, cadena[:2]...), cadena[3:]...)
+	if r := recorrer(nuevoVerificador("accesos", pub, nil), sinTercero, cabeza); r == nil || r.Pos != 3 {
+		t.Errorf("eslabón borrado: ruptura = %+v, want pos 3", r)
+	}
+
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck}), cadena[:2], cadena[1]); r == nil || r.Pos != 3 {
+		t.Errorf("cola truncada: ruptura = %+v, want pos 3", r)
+	}
+
+	// Reescribir la cadena entera desde el eslabón 2 no pasa el checkpoint
+	reescrita := cadenaDePrueba(5)
+	prev := reescrita[0].Hash
+	for i := 1; i < 5; i++ {
+		reescrita[i].Canonica += " "
+		reescrita[i].HashAnterior = prev
+		reescrita[i].Hash = HashEslabon(prev, reescrita[i].Canonica)
+		prev = reescrita[i].Hash
+	}
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck}), reescrita, reescrita[4]); r == nil || r.Pos != 3 {
+		t.Errorf("cadena reescrita: ruptura = %+v, want pos 3", r)
+	}
+
+	falso := ck
+	falso.Hash = reescrita[2].Hash
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{falso}), reescrita, reescrita[4]); r == nil || r.Pos != 3 {
+		t.Errorf("checkpoint falsificado: ruptura = %+v, want pos 3", r)
+	}
+
+	// Inicio podado anclado a un checkpoint
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck}), cadena[3:], cabeza); r != nil {
+		t.Errorf("inicio podado con checkpoint rechazado: %+v", r)
+	}
+	if r := recorrer(nuevoVerificador("accesos", pub, nil), cadena[3:], cabeza); r == nil || r.Pos != 4 {
+		t.Errorf("inicio podado sin checkpoint: ruptura = %+v, want pos 4", r)
+	}
+
+	// Cadena podada entera: sólo queda el checkpoint de la cabeza
+	ckCabeza := CheckpointAuditoria{ID: 2, Tabla: "accesos", Pos: 5, Hash: cabeza.Hash, Fecha: time.Now()}
+	firmarCheckpoint(priv, &ckCabeza)
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck, ckCabeza}), nil, cabeza); r != nil {
+		t.Errorf("cadena podada entera rechazada: %+v", r)
+	}
+	if r := recorrer(nuevoVerificador("accesos", pub, []CheckpointAuditoria{ck}), nil, cabeza); r == nil {
+		t.Errorf("cadena vacía sin checkpoint en la cabeza aceptada")
+	}
+}
@@ -0,0 +1,250 @@
+// backend/utils/claves_titular.go
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"backend/db"
+
+	"github.com/fentec-project/bn256"
+	"github.com/fentec-project/gofe/abe"
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+   Claves personales del titular
+   -----------------------------
+   Cada titular recibe al registrarse (o en su primer login) una clave FAME
+   para "owner:<id>". La clave se guarda en claves_titular envuelta con
+   AES-GCM bajo una clave derivada de su contraseña (Argon2id), así que el
+   servidor sólo puede usarla cuando el titular envía esa clave derivada
+   (cabecera X-Clave-Titular). La clave maestra sólo se usa para emitirla.
+*/
+
+// CopiasClaveTitular es cuántas copias "owner:<id>#n" se emiten, para que la
+// clave sirva aunque el atributo owner aparezca repetido en una política.
+const CopiasClaveTitular = 4
+
+// ErrClaveTitularInexistente indica que el titular todavía no tiene clave emitida.
+var ErrClaveTitularInexistente = errors.New("el titular no tiene clave personal emitida")
+
+// AtributoOwner devuelve el atributo de propietario de un titular.
+func AtributoOwner(idUsuario int) string {
+	return fmt.Sprintf("owner:%d", idUsuario)
+}
+
+// EmitirClaveTitular genera con la clave maestra la clave de atributos del titular.
+func EmitirClaveTitular(idUsuario int) (*abe.FAMEAttribKeys, error) {
+	owner := AtributoOwner(idUsuario)
+	atributos := []string{owner}
+	for i := 1; i < CopiasClaveTitular; i++ {
+		atributos = append(atributos, fmt.Sprintf("%s#%d", owner, i))
+	}
+	return abe.NewFAME().GenerateAttribKeys(atributos, secKey)
+}
+
+// DerivarClaveEnvoltura obtiene la clave AES-256 a partir de la contraseña.
+func DerivarClaveEnvoltura(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+}
+
+// GuardarClaveTitular emite la clave del titular, la envuelve con su
+// contraseña y la guarda. Devuelve la clave de envoltura para la sesión.
+func GuardarClaveTitular(ctx context.Context, idUsuario int, password string) ([]byte, error) {
+	claves, err := EmitirClaveTitular(idUsuario)
+	if err != nil {
+		return nil, fmt.Errorf("error emitiendo clave del titular: %w", err)
+	}
+	claro, err := serializarClaveAtributos(claves)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := GenerarSalt()
+	if err != nil {
+		return nil, err
+	}
+	kek := DerivarClaveEnvoltura(password, salt)
+	nonce, envuelta, err := envolverClave(kek, claro)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Pool.Exec(ctx, `
+		INSERT INTO claves_titular
+		  (id_usuario, clave_envuelta, nonce, salt_kdf, version_clave_abe, fecha_emision)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (id_usuario) DO UPDATE
+		  SET clave_envuelta    = EXCLUDED.clave_envuelta,
+		      nonce             = EXCLUDED.nonce,
+		      salt_kdf          = EXCLUDED.salt_kdf,
+		      version_clave_abe = EXCLUDED.version_clave_abe,
+		      fecha_emision     = EXCLUDED.fecha_emision
+	`, idUsuario, envuelta, nonce, salt, int(VersionClaveABE))
+	if err != nil {
+		return nil, fmt.Errorf("error guardando clave del titular: %w", err)
+	}
+	return kek, nil
+}
+
+// ClaveEnvolturaTitular deriva la clave de envoltura de un titular que ya
+// tiene clave emitida. Devuelve ErrClaveTitularInexistente si no la tiene.
+func ClaveEnvolturaTitular(ctx context.Context, idUsuario int, password string) ([]byte, error) {
+	var salt []byte
+	err := db.Pool.QueryRow(ctx,
+		`SELECT salt_kdf FROM claves_titular WHERE id_usuario = $1`, idUsuario,
+	).Scan(&salt)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, ErrClaveTitularInexistente
+		}
+		return nil, fmt.Errorf("error leyendo clave del titular: %w", err)
+	}
+	return DerivarClaveEnvoltura(password, salt), nil
+}
+
+// CargarClaveTitular desenvuelve la clave personal con la clave de envoltura
+// que presenta el titular. No usa la clave maestra.
+func CargarClaveTitular(ctx context.Context, idUsuario int, kek []byte) (*abe.FAMEAttribKeys, error) {
+	var envuelta, nonce []byte
+	err := db.Pool.QueryRow(ctx,
+		`SELECT clave_envuelta, nonce FROM claves_titular WHERE id_usuario = $1`, idUsuario,
+	).Scan(&envuelta, &nonce)
+	if err != nil {
+		if err.Error() == "no rows in result set" {
+			return nil, ErrClaveTitularInexistente
+		}
+		return nil, fmt.Errorf("error leyendo clave del titular: %w", err)
+	}
+	claro, err := desenvolverClave(kek, nonce, envuelta)
+	if err != nil {
+		return nil, fmt.Errorf("clave de titular inválida")
+	}
+	return deserializarClaveAtributos(claro)
+}
+
+// DescifrarDatoABEConClave descifra con una clave de atributos ya emitida.
+func DescifrarDatoABEConClave(cipher *abe.FAMECipher, claves *abe.FAMEAttribKeys) (string, error) {
+	texto, err := abe.NewFAME().Decrypt(cipher, claves, pubKey)
+	if err != nil {
+		return "", fmt.Errorf("error descifrando con clave del titular: %v", err)
+	}
+	return texto, nil
+}
+
+func envolverClave(kek, claro []byte) ([]byte, []byte, error) {
+	gcm, err := nuevoGCM(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, claro, nil), nil
+}
+
+func desenvolverClave(kek, nonce, envuelta []byte) ([]byte, error) {
+	gcm, err := nuevoGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, envuelta, nil)
+}
+
+func nuevoGCM(clave []byte) (cipher.AEAD, error) {
+	bloque, err := aes.NewCipher(clave)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloque)
+}
+
+// --------------------------
+// Codificación de abe.FAMEAttribKeys (mismo estilo que la carga del sobre)
+// --------------------------
+
+func serializarClaveAtributos(k *abe.FAMEAttribKeys) ([]byte, error) {
+	if k == nil {
+		return nil, errors.New("clave de atributos vacía")
+	}
+	var buf bytes.Buffer
+	for _, g := range k.K0 {
+		escribirBloque(&buf, g.Marshal())
+	}
+	escribirEntero(&buf, len(k.K))
+	for _, fila := range k.K {
+		for _, g := range fila {
+			escribirBloque(&buf, g.Marshal())
+		}
+	}
+	for _, g := range k.KPrime {
+		escribirBloque(&buf, g.Marshal())
+	}
+
+	nombres := make([]string, 0, len(k.AttribToI))
+	for a := range k.AttribToI {
+		nombres = append(nombres, a)
+	}
+	sort.Strings(nombres)
+	escribirEntero(&buf, len(nombres))
+	for _, a := range nombres {
+		escribirBloque(&buf, []byte(a))
+		escribirEntero(&buf, k.AttribToI[a])
+	}
+	return buf.Bytes(), nil
+}
+
+func deserializarClaveAtributos(b []byte) (*abe.FAMEAttribKeys, error) {
+	r := bytes.NewReader(b)
+	k := &abe.FAMEAttribKeys{AttribToI: map[string]int{}}
+	for i := range k.K0 {
+		k.K0[i] = new(bn256.G2)
+		if err := leerPunto(r, k.K0[i].Unmarshal); err != nil {
+			return nil, err
+		}
+	}
+	n, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	k.K = make([][3]*bn256.G1, n)
+	for i := range k.K {
+		for j := range k.K[i] {
+			k.K[i][j] = new(bn256.G1)
+			if err := leerPunto(r, k.K[i][j].Unmarshal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range k.KPrime {
+		k.KPrime[i] = new(bn256.G1)
+		if err := leerPunto(r, k.KPrime[i].Unmarshal); err != nil {
+			return nil, err
+		}
+	}
+	nAttr, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nAttr; i++ {
+		nombre, err := leerBloque(r)
+		if err != nil {
+			return nil, err
+		}
+		var idx uint32
+		if err := binary.Read(r, binary.BigEndian, &idx); err != nil {
+			return nil, err
+		}
+		k.AttribToI[string(nombre)] = int(idx)
+	}
+	return k, nil
+}
@@ -0,0 +1,51 @@
+// backend/utils/claves_titular_test.go
+package utils
+
+import (
+	"testing"
+)
+
+func TestClaveTitularEnvueltaDescifra(t *testing.T) {
+	clavesDePrueba(t)
+	cipher, err := CifrarDatoABE("Av. Siempre Viva 742", "owner:12 OR Marketing")
+	if err != nil {
+		t.Fatalf("error cifrando: %v", err)
+	}
+
+	claves, err := EmitirClaveTitular(12)
+	if err != nil {
+		t.Fatalf("error emitiendo clave: %v", err)
+	}
+	claro, err := serializarClaveAtributos(claves)
+	if err != nil {
+		t.Fatalf("error serializando clave: %v", err)
+	}
+
+	salt, _ := GenerarSalt()
+	kek := DerivarClaveEnvoltura("secreto", salt)
+	nonce, envuelta, err := envolverClave(kek, claro)
+	if err != nil {
+		t.Fatalf("error envolviendo clave: %v", err)
+	}
+	if _, err := desenvolverClave(DerivarClaveEnvoltura("otra", salt), nonce, envuelta); err == nil {
+		t.Fatalf("una contraseña distinta no debería abrir la clave")
+	}
+
+	abierta, err := desenvolverClave(kek, nonce, envuelta)
+	if err != nil {
+		t.Fatalf("error desenvolviendo clave: %v", err)
+	}
+	recuperada, err := deserializarClaveAtributos(abierta)
+	if err != nil {
+		t.Fatalf("error deserializando clave: %v", err)
+	}
+	texto, err := DescifrarDatoABEConClave(cipher, recuperada)
+	if err != nil || texto != "Av. Siempre Viva 742" {
+		t.Errorf("no se pudo descifrar con la clave del titular: %q, %v", texto, err)
+	}
+
+	ajena, _ := EmitirClaveTitular(13)
+	if _, err := DescifrarDatoABEConClave(cipher, ajena); err == nil {
+		t.Errorf("la clave de otro titular no debería descifrar")
+	}
+}
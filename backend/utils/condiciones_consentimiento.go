@@ -0,0 +1,173 @@
+// backend/utils/condiciones_consentimiento.go
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	_ "time/tzdata" // zonas IANA aunque el sistema no las tenga
+
+	"backend/db"
+	"backend/models"
+)
+
+// Condición del titular que deniega un acceso; se guarda en accesos.motivo.
+const (
+	CondicionProcesadorExcluido = "procesador_excluido"
+	CondicionDiaNoPermitido     = "dia_no_permitido"
+	CondicionFueraDeHorario     = "fuera_de_horario"
+	CondicionMaxAccesos         = "max_accesos_alcanzado"
+)
+
+// MotivoCondicion es el texto de accesos.motivo para una condición incumplida.
+func MotivoCondicion(condicion string) string {
+	return "condición del titular: " + condicion
+}
+
+// ZonaHorariaDefecto es la zona de las condiciones que no indican otra.
+const ZonaHorariaDefecto = "UTC"
+
+var zonasHorarias sync.Map // nombre → *time.Location
+
+// zonaCondiciones devuelve la zona en que se evalúan días y franja horaria.
+// Una zona ilegible (sólo posible en filas antiguas) se evalúa en UTC.
+func zonaCondiciones(nombre string) *time.Location {
+	if nombre == "" {
+		nombre = ZonaHorariaDefecto
+	}
+	if z, ok := zonasHorarias.Load(nombre); ok {
+		return z.(*time.Location)
+	}
+	z, err := time.LoadLocation(nombre)
+	if err != nil {
+		return time.UTC
+	}
+	zonasHorarias.Store(nombre, z)
+	return z
+}
+
+// ValidarCondiciones comprueba que las condiciones estén bien formadas.
+func ValidarCondiciones(c models.CondicionesConsentimiento) error {
+	for _, d := range c.DiasPermitidos {
+		if d < 1 || d > 7 {
+			return fmt.Errorf("día inválido %d (1 = lunes … 7 = domingo)", d)
+		}
+	}
+	if (c.HoraDesde == nil) != (c.HoraHasta == nil) {
+		return fmt.Errorf("hora_desde y hora_hasta van juntas")
+	}
+	if c.HoraDesde != nil {
+		desde, err := time.Parse("15:04", *c.HoraDesde)
+		if err != nil {
+			return fmt.Errorf("hora_desde inválida, use HH:MM")
+		}
+		hasta, err := time.Parse("15:04", *c.HoraHasta)
+		if err != nil {
+			return fmt.Errorf("hora_hasta inválida, use HH:MM")
+		}
+		// Una franja vacía denegaría todos los accesos para siempre
+		if desde.Equal(hasta) {
+			return fmt.Errorf("hora_desde y hora_hasta no pueden coincidir")
+		}
+	}
+	if c.ZonaHoraria != "" {
+		// "Local" dependería del servidor, que es justo lo que se evita
+		if _, err := time.LoadLocation(c.ZonaHoraria); err != nil || c.ZonaHoraria == "Local" {
+			return fmt.Errorf("zona_horaria inválida, use un nombre IANA (p. ej. America/Guayaquil)")
+		}
+	}
+	if c.MaxAccesos != nil && *c.MaxAccesos <= 0 {
+		return fmt.Errorf("max_accesos debe ser positivo")
+	}
+	return nil
+}
+
+// EvaluarCondiciones devuelve la primera condición que impide el acceso del
+// procesador en el instante indicado, o "" si todas se cumplen. Días y
+// franja se miden en la zona horaria de las condiciones, no en la del
+// servidor.
+func EvaluarCondiciones(c models.CondicionesConsentimiento, idProcesador int, ahora time.Time) string {
+	ahora = ahora.In(zonaCondiciones(c.ZonaHoraria))
+	for _, id := range c.ProcesadoresExcluidos {
+		if id == idProcesador {
+			return CondicionProcesadorExcluido
+		}
+	}
+	if len(c.DiasPermitidos) > 0 {
+		dia := int(ahora.Weekday())
+		if dia == 0 {
+			dia = 7
+		}
+		permitido := false
+		for _, d := range c.DiasPermitidos {
+			if d == dia {
+				permitido = true
+				break
+			}
+		}
+		if !permitido {
+			return CondicionDiaNoPermitido
+		}
+	}
+	if c.HoraDesde != nil && c.HoraHasta != nil {
+		desde, err1 := time.Parse("15:04", *c.HoraDesde)
+		hasta, err2 := time.Parse("15:04", *c.HoraHasta)
+		if err1 == nil && err2 == nil {
+			m := ahora.Hour()*60 + ahora.Minute()
+			d := desde.Hour()*60 + desde.Minute()
+			h := hasta.Hour()*60 + hasta.Minute()
+			dentro := m >= d && m < h
+			if d > h { // franja que cruza la medianoche, p. ej. 22:00–06:00
+				dentro = m >= d || m < h
+			}
+			if !dentro {
+				return CondicionFueraDeHorario
+			}
+		}
+	}
+	if c.MaxAccesos != nil && c.AccesosRealizados >= *c.MaxAccesos {
+		return CondicionMaxAccesos
+	}
+	return ""
+}
+
+// CargarCondiciones lee las condiciones de los consentimientos indicados,
+// con los accesos ordinarios concedidos hasta ahora bajo cada uno. Los
+// consentimientos sin condiciones no aparecen en el mapa.
+func CargarCondiciones(ctx context.Context, idsConsentimiento []int) (map[int]models.CondicionesConsentimiento, error) {
+	return CargarCondicionesEn(ctx, idsConsentimiento, nil)
+}
+
+// CargarCondicionesEn es CargarCondiciones en un instante pasado (nil =
+// ahora): las condiciones vigentes entonces y el contador de accesos de ese
+// momento. Los accesos se leen de contador_accesos_consentimiento (migración
+// 023) y no de la tabla accesos, que la retención poda.
+func CargarCondicionesEn(ctx context.Context, idsConsentimiento []int, en *time.Time) (map[int]models.CondicionesConsentimiento, error) {
+	args := []any{idsConsentimiento}
+	condicionesEn := TablaEn("condiciones_consentimiento", en, &args)
+	contadorEn := TablaEn("contador_accesos_consentimiento", en, &args)
+	rows, err := db.Pool.Query(ctx, `
+		SELECT cc.id_consentimiento, cc.procesadores_excluidos, cc.dias_permitidos::int[],
+		       to_char(cc.hora_desde, 'HH24:MI'), to_char(cc.hora_hasta, 'HH24:MI'),
+		       cc.max_accesos, COALESCE(k.accesos, 0), COALESCE(cc.zona_horaria, '')
+		  FROM `+condicionesEn+` cc
+		  LEFT JOIN `+contadorEn+` k ON k.id_consentimiento = cc.id_consentimiento
+		 WHERE cc.id_consentimiento = ANY($1)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo condiciones: %w", err)
+	}
+	defer rows.Close()
+
+	condiciones := map[int]models.CondicionesConsentimiento{}
+	for rows.Next() {
+		var c models.CondicionesConsentimiento
+		if err := rows.Scan(&c.IDConsentimiento, &c.ProcesadoresExcluidos, &c.DiasPermitidos,
+			&c.HoraDesde, &c.HoraHasta, &c.MaxAccesos, &c.AccesosRealizados, &c.ZonaHoraria); err != nil {
+			return nil, fmt.Errorf("error leyendo condiciones: %w", err)
+		}
+		condiciones[c.IDConsentimiento] = c
+	}
+	return condiciones, rows.Err()
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+func TestEvaluarCondiciones(t *testing.T) {
+	hora := func(s string) *string { return &s }
+	max := 3
+	// Miércoles 2024-05-15 23:30
+	miercolesNoche := time.Date(2024, 5, 15, 23, 30, 0, 0, time.UTC)
+
+	casos := []struct {
+		nombre string
+		c      models.CondicionesConsentimiento
+		proc   int
+		ahora  time.Time
+		want   string
+	}{
+		{"sin condiciones", models.CondicionesConsentimiento{}, 7, miercolesNoche, ""},
+		{"procesador excluido", models.CondicionesConsentimiento{ProcesadoresExcluidos: []int{3, 7}}, 7, miercolesNoche, CondicionProcesadorExcluido},
+		{"otro procesador", models.CondicionesConsentimiento{ProcesadoresExcluidos: []int{3}}, 7, miercolesNoche, ""},
+		{"sólo fines de semana", models.CondicionesConsentimiento{DiasPermitidos: []int{6, 7}}, 7, miercolesNoche, CondicionDiaNoPermitido},
+		{"días laborables", models.CondicionesConsentimiento{DiasPermitidos: []int{1, 2, 3, 4, 5}}, 7, miercolesNoche, ""},
+		{"fuera de horario", models.CondicionesConsentimiento{HoraDesde: hora("09:00"), HoraHasta: hora("18:00")}, 7, miercolesNoche, CondicionFueraDeHorario},
+		{"franja nocturna", models.CondicionesConsentimiento{HoraDesde: hora("22:00"), HoraHasta: hora("06:00")}, 7, miercolesNoche, ""},
+		{"máximo alcanzado", models.CondicionesConsentimiento{MaxAccesos: &max, AccesosRealizados: 3}, 7, miercolesNoche, CondicionMaxAccesos},
+		{"máximo no alcanzado", models.CondicionesConsentimiento{MaxAccesos: &max, AccesosRealizados: 2}, 7, miercolesNoche, ""},
+		// 23:30 UTC del miércoles son las 18:30 en Guayaquil (UTC-5)
+		{"horario en otra zona", models.CondicionesConsentimiento{HoraDesde: hora("09:00"), HoraHasta: hora("19:00"), ZonaHoraria: "America/Guayaquil"}, 7, miercolesNoche, ""},
+		{"día en otra zona", models.CondicionesConsentimiento{DiasPermitidos: []int{4}, ZonaHoraria: "Asia/Tokyo"}, 7, miercolesNoche, ""},
+	}
+	for _, c := range casos {
+		if got := EvaluarCondiciones(c.c, c.proc, c.ahora); got != c.want {
+			t.Errorf("%s: EvaluarCondiciones = %q, want %q", c.nombre, got, c.want)
+		}
+	}
+}
+
+func TestValidarCondiciones(t *testing.T) {
+	hora := func(s string) *string { return &s }
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{DiasPermitidos: []int{0}}); err == nil {
+		t.Error("día 0 debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{HoraDesde: hora("09:00")}); err == nil {
+		t.Error("hora_desde sin hora_hasta debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{HoraDesde: hora("9h"), HoraHasta: hora("18:00")}); err == nil {
+		t.Error("hora mal formada debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{HoraDesde: hora("09:00"), HoraHasta: hora("09:00")}); err == nil {
+		t.Error("una franja vacía debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{ZonaHoraria: "Local"}); err == nil {
+		t.Error("la zona del servidor debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{ZonaHoraria: "Marte/Olimpo"}); err == nil {
+		t.Error("una zona desconocida debería rechazarse")
+	}
+	if err := ValidarCondiciones(models.CondicionesConsentimiento{DiasPermitidos: []int{1, 7}, HoraDesde: hora("08:00"), HoraHasta: hora("20:00"), ZonaHoraria: "Europe/Madrid"}); err != nil {
+		t.Errorf("condiciones válidas rechazadas: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// backend/utils/historial_temporal.go
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// TablaEn devuelve la tabla tal cual o, si en no es nil, su estado en ese
+// instante según historial_temporal (migración 022). El instante se añade a
+// args y la expresión lo usa como parámetro; se usa con alias:
+//
+//	args := []any{idTitular}
+//	`SELECT ... FROM ` + TablaEn("consentimientos", en, &args) + ` c WHERE c.id_usuario = $1`
+func TablaEn(tabla string, en *time.Time, args *[]any) string {
+	if en == nil {
+		return tabla
+	}
+	*args = append(*args, *en)
+	return fmt.Sprintf("estado_en(NULL::%s, $%d::timestamptz)", tabla, len(*args))
+}
+
+// InstanteEn es NOW() o, si en no es nil, el parámetro con ese instante.
+func InstanteEn(en *time.Time, args *[]any) string {
+	if en == nil {
+		return "NOW()"
+	}
+	*args = append(*args, *en)
+	return fmt.Sprintf("$%d::timestamptz", len(*args))
+}
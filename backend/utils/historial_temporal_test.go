@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTablaEn(t *testing.T) {
+	args := []any{7}
+	if got := TablaEn("consentimientos", nil, &args); got != "consentimientos" || len(args) != 1 {
+		t.Errorf("sin instante = %q, %d args", got, len(args))
+	}
+	if got := InstanteEn(nil, &args); got != "NOW()" || len(args) != 1 {
+		t.Errorf("InstanteEn sin instante = %q, %d args", got, len(args))
+	}
+
+	en := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if got := TablaEn("consentimientos", &en, &args); got != "estado_en(NULL::consentimientos, $2::timestamptz)" {
+		t.Errorf("TablaEn = %q", got)
+	}
+	if got := InstanteEn(&en, &args); got != "$3::timestamptz" {
+		t.Errorf("InstanteEn = %q", got)
+	}
+	if len(args) != 3 || args[2] != en {
+		t.Errorf("args = %v", args)
+	}
+}
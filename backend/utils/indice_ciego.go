@@ -0,0 +1,177 @@
+// backend/utils/indice_ciego.go
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+   Índices ciegos
+   --------------
+   Para buscar por atributos marcados como "buscable" en el catálogo sin
+   descifrar, se guarda junto al valor cifrado un HMAC-SHA256 del valor
+   normalizado. Las claves HMAC se guardan versionadas en indice_ciego.keys:
+   al rotar, las escrituras nuevas usan la versión activa y las búsquedas
+   prueban todas las versiones hasta que cmd/rotar-indice-ciego reindexa y
+   retira las anteriores.
+*/
+
+const indiceKeyFile = "indice_ciego.keys"
+
+// ClavesIndice es el contenido del archivo de claves de índice ciego.
+type ClavesIndice struct {
+	Activa uint16
+	Claves map[uint16][]byte
+}
+
+var (
+	clavesIndice   *ClavesIndice
+	clavesIndiceMu sync.RWMutex
+)
+
+// InicializarIndiceCiego carga las claves de índice o genera la primera.
+func InicializarIndiceCiego() {
+	if err := CargarClavesIndice(); err == nil {
+		fmt.Println("Claves de índice ciego cargadas desde archivo.")
+		return
+	}
+	if _, err := RotarClaveIndice(); err != nil {
+		log.Fatalf("Error al generar clave de índice ciego: %v", err)
+	}
+	fmt.Println("Clave de índice ciego generada y guardada correctamente.")
+}
+
+// CargarClavesIndice lee indice_ciego.keys.
+func CargarClavesIndice() error {
+	leido, err := cargarArchivoGob(indiceKeyFile)
+	if err != nil {
+		return err
+	}
+	c := leido.(*ClavesIndice)
+	if _, ok := c.Claves[c.Activa]; !ok {
+		return fmt.Errorf("la versión activa %d no tiene clave", c.Activa)
+	}
+	clavesIndiceMu.Lock()
+	clavesIndice = c
+	clavesIndiceMu.Unlock()
+	return nil
+}
+
+// RotarClaveIndice genera una clave nueva y la deja como activa. Las
+// anteriores se conservan para que las búsquedas sigan encontrando filas.
+func RotarClaveIndice() (uint16, error) {
+	clave := make([]byte, 32)
+	if _, err := rand.Read(clave); err != nil {
+		return 0, err
+	}
+
+	clavesIndiceMu.Lock()
+	defer clavesIndiceMu.Unlock()
+	nuevas := &ClavesIndice{Claves: map[uint16][]byte{}}
+	if clavesIndice != nil {
+		for v, k := range clavesIndice.Claves {
+			nuevas.Claves[v] = k
+			if v > nuevas.Activa {
+				nuevas.Activa = v
+			}
+		}
+	}
+	nuevas.Activa++
+	nuevas.Claves[nuevas.Activa] = clave
+	if err := guardarArchivoGob(indiceKeyFile, nuevas); err != nil {
+		return 0, err
+	}
+	clavesIndice = nuevas
+	return nuevas.Activa, nil
+}
+
+// RetirarClavesIndice borra las versiones anteriores a la activa. Sólo debe
+// llamarse después de reindexar todas las filas con la versión activa.
+func RetirarClavesIndice() error {
+	clavesIndiceMu.Lock()
+	defer clavesIndiceMu.Unlock()
+	if clavesIndice == nil {
+		return fmt.Errorf("claves de índice no inicializadas")
+	}
+	nuevas := &ClavesIndice{
+		Activa: clavesIndice.Activa,
+		Claves: map[uint16][]byte{clavesIndice.Activa: clavesIndice.Claves[clavesIndice.Activa]},
+	}
+	if err := guardarArchivoGob(indiceKeyFile, nuevas); err != nil {
+		return err
+	}
+	clavesIndice = nuevas
+	return nil
+}
+
+// NormalizarValorIndice hace que "  Quito", "QUITO" y "quitó" den el mismo índice.
+func NormalizarValorIndice(valor string) string {
+	sinAcentos, _, err := transform.String(
+		transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), valor)
+	if err != nil {
+		sinAcentos = valor
+	}
+	return strings.Join(strings.Fields(strings.ToLower(sinAcentos)), " ")
+}
+
+// IndiceCiego calcula el índice de un valor con la clave activa.
+func IndiceCiego(atributo, valor string) (uint16, []byte, error) {
+	clavesIndiceMu.RLock()
+	defer clavesIndiceMu.RUnlock()
+	if clavesIndice == nil {
+		return 0, nil, fmt.Errorf("claves de índice no inicializadas")
+	}
+	v := clavesIndice.Activa
+	return v, calcularIndice(clavesIndice.Claves[v], atributo, valor), nil
+}
+
+// IndicesBusqueda calcula el índice de un valor con cada versión de clave vigente.
+func IndicesBusqueda(atributo, valor string) (map[uint16][]byte, error) {
+	clavesIndiceMu.RLock()
+	defer clavesIndiceMu.RUnlock()
+	if clavesIndice == nil {
+		return nil, fmt.Errorf("claves de índice no inicializadas")
+	}
+	indices := make(map[uint16][]byte, len(clavesIndice.Claves))
+	for v, k := range clavesIndice.Claves {
+		indices[v] = calcularIndice(k, atributo, valor)
+	}
+	return indices, nil
+}
+
+// VersionesClaveIndice devuelve las versiones cargadas, de menor a mayor.
+func VersionesClaveIndice() []uint16 {
+	clavesIndiceMu.RLock()
+	defer clavesIndiceMu.RUnlock()
+	if clavesIndice == nil {
+		return nil
+	}
+	vs := make([]uint16, 0, len(clavesIndice.Claves))
+	for v := range clavesIndice.Claves {
+		vs = append(vs, v)
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	return vs
+}
+
+// El nombre del atributo entra en el HMAC para que el mismo valor en dos
+// atributos distintos ("Loja" ciudad / "Loja" provincia) no sea correlacionable.
+func calcularIndice(clave []byte, atributo, valor string) []byte {
+	mac := hmac.New(sha256.New, clave)
+	mac.Write([]byte(atributo))
+	mac.Write([]byte{0})
+	mac.Write([]byte(NormalizarValorIndice(valor)))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,41 @@
+// backend/utils/indice_ciego_test.go
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNormalizarValorIndice(t *testing.T) {
+	for _, v := range []string{"Santo Domingo", "  santo   DOMINGO ", "Santo Domíngo"} {
+		if got := NormalizarValorIndice(v); got != "santo domingo" {
+			t.Errorf("%q: normalizado = %q", v, got)
+		}
+	}
+}
+
+func TestIndiceCiegoPorVersionYAtributo(t *testing.T) {
+	clavesIndice = &ClavesIndice{Activa: 2, Claves: map[uint16][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}}
+	defer func() { clavesIndice = nil }()
+
+	version, ciudad, err := IndiceCiego("ciudad", "Loja")
+	if err != nil || version != 2 {
+		t.Fatalf("versión activa = %d, err = %v", version, err)
+	}
+	_, provincia, _ := IndiceCiego("provincia", "Loja")
+	if bytes.Equal(ciudad, provincia) {
+		t.Error("el mismo valor en dos atributos no debería dar el mismo índice")
+	}
+
+	// Tras una rotación las filas con la versión anterior se siguen encontrando
+	indices, _ := IndicesBusqueda("ciudad", " LOJA")
+	if len(indices) != 2 || !bytes.Equal(indices[2], ciudad) {
+		t.Fatalf("índices de búsqueda inesperados: %v", indices)
+	}
+	if !bytes.Equal(indices[1], calcularIndice(clavesIndice.Claves[1], "ciudad", "loja")) {
+		t.Error("falta el índice con la clave anterior")
+	}
+}
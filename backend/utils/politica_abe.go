@@ -0,0 +1,648 @@
+// backend/utils/politica_abe.go
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fentec-project/gofe/abe"
+)
+
+/*
+   Lenguaje de políticas ABE
+   -------------------------
+   Gramática aceptada por ParsearPolitica:
+
+     expr     := and (OR and)*
+     and      := primario (AND primario)*
+     primario := '(' expr ')'
+               | NUM (of|de) '(' expr (',' expr)* ')'
+               | atributo ['@' AAAA-MM-DD]
+
+   Ejemplos:
+     (Marketing AND Ecuador) OR owner:16
+     2 of (Auditoria, Legal, DPO)
+     owner:16 OR "Investigación de mercado"@2026-12-31
+
+   Los atributos con fecha de expiración se compilan por épocas (días UTC):
+   el rango [hoy, expiración] se descompone en bloques diádicos y cada bloque
+   se convierte en un atributo "nombre@nivel:indice". Las claves sólo reciben
+   los bloques que contienen el día actual (AtributosVigentes), así que el
+   acceso caduca criptográficamente al pasar la fecha.
+*/
+
+// NivelesEpoca es la cantidad de niveles diádicos usados para las épocas.
+// 2^16 días cubren cualquier vigencia razonable de un consentimiento.
+const NivelesEpoca = 16
+
+// MaxCopiasAtributo limita cuántas veces puede repetirse un mismo atributo
+// en la política compilada (FAME exige una fila por atributo, por eso las
+// repeticiones se renombran como "atributo#n").
+const MaxCopiasAtributo = 16
+
+// NodoPolitica es un nodo del árbol de la política.
+type NodoPolitica interface {
+	// String devuelve la forma canónica, que ParsearPolitica vuelve a aceptar.
+	String() string
+}
+
+// Atributo es una hoja de la política. Hasta es opcional.
+type Atributo struct {
+	Nombre string
+	Hasta  *time.Time
+}
+
+// Compuerta une sus hijos con AND u OR.
+type Compuerta struct {
+	Operador string // "AND" u "OR"
+	Hijos    []NodoPolitica
+}
+
+// Umbral se satisface cuando al menos K de sus hijos se satisfacen.
+type Umbral struct {
+	K     int
+	Hijos []NodoPolitica
+}
+
+// ErrorPolitica indica el token que provocó el error y su posición (1-based, en runas).
+type ErrorPolitica struct {
+	Posicion int
+	Token    string
+	Mensaje  string
+}
+
+func (e *ErrorPolitica) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("posición %d: %s", e.Posicion, e.Mensaje)
+	}
+	return fmt.Sprintf("posición %d (%q): %s", e.Posicion, e.Token, e.Mensaje)
+}
+
+func (a Atributo) String() string {
+	nombre := a.Nombre
+	if necesitaComillas(nombre) {
+		nombre = strconv.Quote(nombre)
+	}
+	if a.Hasta != nil {
+		nombre += "@" + a.Hasta.UTC().Format("2006-01-02")
+	}
+	return nombre
+}
+
+func (c Compuerta) String() string {
+	partes := make([]string, len(c.Hijos))
+	for i, h := range c.Hijos {
+		partes[i] = envolver(h)
+	}
+	return strings.Join(partes, " "+c.Operador+" ")
+}
+
+func (u Umbral) String() string {
+	partes := make([]string, len(u.Hijos))
+	for i, h := range u.Hijos {
+		partes[i] = h.String()
+	}
+	return fmt.Sprintf("%d of (%s)", u.K, strings.Join(partes, ", "))
+}
+
+func envolver(n NodoPolitica) string {
+	if _, ok := n.(Compuerta); ok {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+func necesitaComillas(s string) bool {
+	if s == "" || esPalabraReservada(s) {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune(`(),"@`, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func esPalabraReservada(s string) bool {
+	return strings.EqualFold(s, "AND") || strings.EqualFold(s, "OR")
+}
+
+// --------------------------
+// Analizador léxico
+// --------------------------
+
+type tipoToken int
+
+const (
+	tokFin tipoToken = iota
+	tokPalabra
+	tokCadena
+	tokAnd
+	tokOr
+	tokAbre
+	tokCierra
+	tokComa
+	tokArroba
+)
+
+type token struct {
+	tipo  tipoToken
+	texto string
+	pos   int
+}
+
+func tokenizar(s string) ([]token, error) {
+	var toks []token
+	runas := []rune(s)
+	for i := 0; i < len(runas); {
+		r := runas[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{tokAbre, "(", i + 1})
+			i++
+		case r == ')':
+			toks = append(toks, token{tokCierra, ")", i + 1})
+			i++
+		case r == ',':
+			toks = append(toks, token{tokComa, ",", i + 1})
+			i++
+		case r == '@':
+			toks = append(toks, token{tokArroba, "@", i + 1})
+			i++
+		case r == '"':
+			inicio := i
+			i++
+			var b strings.Builder
+			for i < len(runas) && runas[i] != '"' {
+				if runas[i] == '\\' && i+1 < len(runas) {
+					i++
+				}
+				b.WriteRune(runas[i])
+				i++
+			}
+			if i >= len(runas) {
+				return nil, &ErrorPolitica{inicio + 1, string(runas[inicio:]), "comillas sin cerrar"}
+			}
+			i++
+			toks = append(toks, token{tokCadena, b.String(), inicio + 1})
+		default:
+			inicio := i
+			for i < len(runas) && !unicode.IsSpace(runas[i]) && !strings.ContainsRune(`(),"@`, runas[i]) {
+				i++
+			}
+			texto := string(runas[inicio:i])
+			t := token{tokPalabra, texto, inicio + 1}
+			if strings.EqualFold(texto, "AND") {
+				t.tipo = tokAnd
+			} else if strings.EqualFold(texto, "OR") {
+				t.tipo = tokOr
+			}
+			toks = append(toks, t)
+		}
+	}
+	toks = append(toks, token{tokFin, "", len(runas) + 1})
+	return toks, nil
+}
+
+// --------------------------
+// Analizador sintáctico
+// --------------------------
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) actual() token    { return p.toks[p.i] }
+func (p *parser) siguiente() token { t := p.toks[p.i]; p.i++; return t }
+
+func (p *parser) error(t token, msg string) error {
+	return &ErrorPolitica{Posicion: t.pos, Token: t.texto, Mensaje: msg}
+}
+
+// ParsearPolitica convierte el texto de una política en su árbol.
+func ParsearPolitica(s string) (NodoPolitica, error) {
+	toks, err := tokenizar(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if p.actual().tipo == tokFin {
+		return nil, p.error(p.actual(), "política vacía")
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.actual(); t.tipo != tokFin {
+		return nil, p.error(t, "se esperaba AND, OR o el fin de la política")
+	}
+	return n, nil
+}
+
+func (p *parser) expr() (NodoPolitica, error) {
+	return p.binaria(tokOr, "OR", p.and)
+}
+
+func (p *parser) and() (NodoPolitica, error) {
+	return p.binaria(tokAnd, "AND", p.primario)
+}
+
+func (p *parser) binaria(tipo tipoToken, op string, sub func() (NodoPolitica, error)) (NodoPolitica, error) {
+	primero, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	if p.actual().tipo != tipo {
+		return primero, nil
+	}
+	var hijos []NodoPolitica
+	agregar := func(h NodoPolitica) {
+		// Aplanar (A OR B) OR C en una sola compuerta
+		if c, ok := h.(Compuerta); ok && c.Operador == op {
+			hijos = append(hijos, c.Hijos...)
+		} else {
+			hijos = append(hijos, h)
+		}
+	}
+	agregar(primero)
+	for p.actual().tipo == tipo {
+		p.siguiente()
+		h, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		agregar(h)
+	}
+	return Compuerta{Operador: op, Hijos: hijos}, nil
+}
+
+func (p *parser) primario() (NodoPolitica, error) {
+	t := p.actual()
+	switch t.tipo {
+	case tokAbre:
+		p.siguiente()
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.actual(); c.tipo != tokCierra {
+			return nil, p.error(c, "se esperaba ')'")
+		}
+		p.siguiente()
+		return n, nil
+	case tokPalabra:
+		if k, err := strconv.Atoi(t.texto); err == nil && p.esUmbral() {
+			return p.umbral(t, k)
+		}
+		return p.atributo()
+	case tokCadena:
+		return p.atributo()
+	case tokFin:
+		return nil, p.error(t, "la política termina de forma inesperada")
+	default:
+		return nil, p.error(t, "se esperaba un atributo o '('")
+	}
+}
+
+func (p *parser) esUmbral() bool {
+	if p.i+2 >= len(p.toks) {
+		return false
+	}
+	of := p.toks[p.i+1]
+	return of.tipo == tokPalabra &&
+		(strings.EqualFold(of.texto, "of") || strings.EqualFold(of.texto, "de")) &&
+		p.toks[p.i+2].tipo == tokAbre
+}
+
+func (p *parser) umbral(tk token, k int) (NodoPolitica, error) {
+	p.siguiente() // número
+	p.siguiente() // of / de
+	p.siguiente() // (
+	var hijos []NodoPolitica
+	for {
+		h, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		hijos = append(hijos, h)
+		t := p.siguiente()
+		if t.tipo == tokCierra {
+			break
+		}
+		if t.tipo != tokComa {
+			return nil, p.error(t, "se esperaba ',' o ')' en el umbral")
+		}
+	}
+	if k < 1 || k > len(hijos) {
+		return nil, p.error(tk, fmt.Sprintf("el umbral debe estar entre 1 y %d", len(hijos)))
+	}
+	return Umbral{K: k, Hijos: hijos}, nil
+}
+
+func (p *parser) atributo() (NodoPolitica, error) {
+	inicio := p.actual()
+	var nombre string
+	if inicio.tipo == tokCadena {
+		nombre = p.siguiente().texto
+	} else {
+		// Las palabras consecutivas forman un solo atributo ("Investigación de mercado")
+		var partes []string
+		for p.actual().tipo == tokPalabra {
+			partes = append(partes, p.siguiente().texto)
+		}
+		nombre = strings.Join(partes, " ")
+	}
+	if err := ValidarNombreAtributo(nombre); err != nil {
+		return nil, p.error(inicio, err.Error())
+	}
+
+	a := Atributo{Nombre: nombre}
+	if p.actual().tipo == tokArroba {
+		p.siguiente()
+		f := p.siguiente()
+		if f.tipo != tokPalabra {
+			return nil, p.error(f, "se esperaba una fecha AAAA-MM-DD después de '@'")
+		}
+		hasta, err := time.Parse("2006-01-02", f.texto)
+		if err != nil {
+			return nil, p.error(f, "fecha inválida, use AAAA-MM-DD")
+		}
+		a.Hasta = &hasta
+	}
+	return a, nil
+}
+
+// ValidarNombreAtributo rechaza nombres que abe.BooleanToMSP no puede
+// distinguir de los operadores (busca "AND"/"OR" como subcadenas).
+func ValidarNombreAtributo(nombre string) error {
+	switch {
+	case strings.TrimSpace(nombre) == "":
+		return fmt.Errorf("nombre de atributo vacío")
+	case strings.Contains(nombre, "AND"), strings.Contains(nombre, "OR"):
+		return fmt.Errorf("el nombre del atributo no puede contener AND u OR en mayúsculas")
+	case strings.ContainsAny(nombre, "()#"):
+		return fmt.Errorf("el nombre del atributo no puede contener '(', ')' ni '#'")
+	}
+	return nil
+}
+
+// --------------------------
+// Compilación a abe.BooleanToMSP
+// --------------------------
+
+type compilador struct {
+	ahora  time.Time
+	copias map[string]int
+}
+
+// CompilarPolitica produce la expresión que acepta abe.BooleanToMSP:
+// sólo AND/OR, umbrales expandidos, fechas convertidas a épocas y
+// atributos repetidos renombrados como "atributo#n".
+func CompilarPolitica(n NodoPolitica, ahora time.Time) (string, error) {
+	c := &compilador{ahora: ahora, copias: map[string]int{}}
+	out, vigente, err := c.compilar(n)
+	if err != nil {
+		return "", err
+	}
+	if !vigente {
+		return "", fmt.Errorf("la política no tiene atributos vigentes")
+	}
+	return out, nil
+}
+
+func (c *compilador) compilar(n NodoPolitica) (string, bool, error) {
+	switch v := n.(type) {
+	case Atributo:
+		return c.hoja(v)
+	case Compuerta:
+		var partes []string
+		for _, h := range v.Hijos {
+			s, vigente, err := c.compilar(h)
+			if err != nil {
+				return "", false, err
+			}
+			if !vigente {
+				if v.Operador == "AND" {
+					return "", false, nil
+				}
+				continue
+			}
+			partes = append(partes, s)
+		}
+		return unir(partes, v.Operador)
+	case Umbral:
+		return c.compilar(expandirUmbral(v.K, v.Hijos))
+	default:
+		return "", false, fmt.Errorf("nodo de política desconocido %T", n)
+	}
+}
+
+func (c *compilador) hoja(a Atributo) (string, bool, error) {
+	if a.Hasta == nil {
+		s, err := c.copia(a.Nombre)
+		return s, err == nil, err
+	}
+	desde, hasta := EpocaDe(c.ahora), EpocaDe(*a.Hasta)
+	if hasta < desde {
+		return "", false, nil
+	}
+	var partes []string
+	for _, b := range bloquesDiadicos(desde, hasta) {
+		s, err := c.copia(nombreEpoca(a.Nombre, b.nivel, b.indice))
+		if err != nil {
+			return "", false, err
+		}
+		partes = append(partes, s)
+	}
+	return unir(partes, "OR")
+}
+
+func (c *compilador) copia(nombre string) (string, error) {
+	n := c.copias[nombre]
+	if n >= MaxCopiasAtributo {
+		return "", fmt.Errorf("el atributo %q se repite más de %d veces", nombre, MaxCopiasAtributo)
+	}
+	c.copias[nombre] = n + 1
+	if n == 0 {
+		return nombre, nil
+	}
+	return fmt.Sprintf("%s#%d", nombre, n), nil
+}
+
+func unir(partes []string, op string) (string, bool, error) {
+	switch len(partes) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return partes[0], true, nil
+	}
+	for i, p := range partes {
+		if strings.Contains(p, " AND ") || strings.Contains(p, " OR ") {
+			partes[i] = "(" + p + ")"
+		}
+	}
+	return strings.Join(partes, " "+op+" "), true, nil
+}
+
+// expandirUmbral reescribe "k de n" como compuertas AND/OR:
+// T(k, [h1..hn]) = (h1 AND T(k-1, resto)) OR T(k, resto)
+func expandirUmbral(k int, hijos []NodoPolitica) NodoPolitica {
+	switch {
+	case k <= 1:
+		if len(hijos) == 1 {
+			return hijos[0]
+		}
+		return Compuerta{Operador: "OR", Hijos: hijos}
+	case k >= len(hijos):
+		return Compuerta{Operador: "AND", Hijos: hijos}
+	}
+	con := Compuerta{Operador: "AND", Hijos: []NodoPolitica{hijos[0], expandirUmbral(k-1, hijos[1:])}}
+	return Compuerta{Operador: "OR", Hijos: []NodoPolitica{con, expandirUmbral(k, hijos[1:])}}
+}
+
+// --------------------------
+// Épocas
+// --------------------------
+
+// EpocaDe devuelve el número de día UTC desde 1970-01-01.
+func EpocaDe(t time.Time) int64 {
+	return t.UTC().Unix() / 86400
+}
+
+type bloque struct {
+	nivel  int
+	indice int64
+}
+
+// bloquesDiadicos cubre [desde, hasta] con bloques alineados de tamaño 2^nivel.
+func bloquesDiadicos(desde, hasta int64) []bloque {
+	var out []bloque
+	for desde <= hasta {
+		nivel := 0
+		for nivel+1 < NivelesEpoca {
+			tam := int64(1) << (nivel + 1)
+			if desde%tam != 0 || desde+tam-1 > hasta {
+				break
+			}
+			nivel++
+		}
+		out = append(out, bloque{nivel, desde >> nivel})
+		desde += int64(1) << nivel
+	}
+	return out
+}
+
+func nombreEpoca(nombre string, nivel int, indice int64) string {
+	return fmt.Sprintf("%s@%d:%d", nombre, nivel, indice)
+}
+
+// AtributosVigentes amplía los atributos de una clave con los bloques de
+// época que contienen el día de "ahora".
+func AtributosVigentes(atributos []string, ahora time.Time) []string {
+	dia := EpocaDe(ahora)
+	out := make([]string, 0, len(atributos)*(NivelesEpoca+1))
+	for _, a := range atributos {
+		out = append(out, a)
+		for nivel := 0; nivel < NivelesEpoca; nivel++ {
+			out = append(out, nombreEpoca(a, nivel, dia>>nivel))
+		}
+	}
+	return out
+}
+
+// AtributoBase quita el sufijo de copia "#n" de un atributo compilado.
+func AtributoBase(fila string) string {
+	if i := strings.LastIndexByte(fila, '#'); i >= 0 {
+		if _, err := strconv.Atoi(fila[i+1:]); err == nil {
+			return fila[:i]
+		}
+	}
+	return fila
+}
+
+// filasAutorizadas devuelve los atributos del MSP que pueden derivarse de
+// los atributos vigentes; son los únicos para los que hace falta generar clave.
+func filasAutorizadas(msp *abe.MSP, vigentes []string) []string {
+	permitidos := make(map[string]bool, len(vigentes))
+	for _, a := range vigentes {
+		permitidos[a] = true
+	}
+	var filas []string
+	for _, fila := range msp.RowToAttrib {
+		if permitidos[AtributoBase(fila)] {
+			filas = append(filas, fila)
+		}
+	}
+	return filas
+}
+
+// AtributosDePolitica lista los nombres (sin fecha) de las hojas, ordenados y sin repetir.
+func AtributosDePolitica(n NodoPolitica) []string {
+	vistos := map[string]bool{}
+	var recorrer func(NodoPolitica)
+	recorrer = func(n NodoPolitica) {
+		switch v := n.(type) {
+		case Atributo:
+			vistos[v.Nombre] = true
+		case Compuerta:
+			for _, h := range v.Hijos {
+				recorrer(h)
+			}
+		case Umbral:
+			for _, h := range v.Hijos {
+				recorrer(h)
+			}
+		}
+	}
+	recorrer(n)
+	out := make([]string, 0, len(vistos))
+	for a := range vistos {
+		out = append(out, a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SatisfacePolitica indica si una clave con esos atributos descifraría en
+// "ahora" un valor cifrado con la política, sin tocar el cifrado: los
+// atributos con fecha sólo cuentan hasta el día de su expiración.
+func SatisfacePolitica(n NodoPolitica, atributos []string, ahora time.Time) bool {
+	tiene := make(map[string]bool, len(atributos))
+	for _, a := range atributos {
+		tiene[a] = true
+	}
+	var evaluar func(NodoPolitica) bool
+	evaluar = func(n NodoPolitica) bool {
+		switch v := n.(type) {
+		case Atributo:
+			return tiene[v.Nombre] && (v.Hasta == nil || EpocaDe(ahora) <= EpocaDe(*v.Hasta))
+		case Compuerta:
+			todos := v.Operador == "AND"
+			for _, h := range v.Hijos {
+				if evaluar(h) != todos {
+					// un hijo falso decide un AND; uno verdadero, un OR
+					return !todos
+				}
+			}
+			return todos
+		case Umbral:
+			cumplen := 0
+			for _, h := range v.Hijos {
+				if evaluar(h) {
+					cumplen++
+				}
+			}
+			return cumplen >= v.K
+		}
+		return false
+	}
+	return evaluar(n)
+}
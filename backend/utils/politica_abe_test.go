@@ -0,0 +1,152 @@
+// backend/utils/politica_abe_test.go
+package utils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fentec-project/gofe/abe"
+)
+
+func TestParsearPoliticaCanonica(t *testing.T) {
+	casos := map[string]string{
+		"(Marketing AND Ecuador) OR owner:16":      "(Marketing AND Ecuador) OR owner:16",
+		"2 of (Auditoria, Legal, DPO)":             "2 of (Auditoria, Legal, DPO)",
+		"owner:16 OR Investigación de mercado":     `owner:16 OR "Investigación de mercado"`,
+		`owner:16 OR "Salud pública"@2026-12-31`:   `owner:16 OR "Salud pública"@2026-12-31`,
+		"(A OR B) OR C":                            "A OR B OR C",
+		"a and (b or c)":                           "a AND (b OR c)",
+		"2 de (Auditoria AND Legal, DPO, owner:3)": "2 of (Auditoria AND Legal, DPO, owner:3)",
+	}
+	for entrada, esperada := range casos {
+		arbol, err := ParsearPolitica(entrada)
+		if err != nil {
+			t.Fatalf("%q: error inesperado: %v", entrada, err)
+		}
+		if got := arbol.String(); got != esperada {
+			t.Errorf("%q: canónica = %q, se esperaba %q", entrada, got, esperada)
+		}
+		if _, err := ParsearPolitica(arbol.String()); err != nil {
+			t.Errorf("%q: la forma canónica no se vuelve a parsear: %v", entrada, err)
+		}
+	}
+}
+
+func TestParsearPoliticaErrores(t *testing.T) {
+	casos := []struct {
+		entrada  string
+		posicion int
+		token    string
+	}{
+		{"Marketing AND", 14, ""},
+		{"(Marketing OR Legal", 20, ""},
+		{"Marketing OR ) Legal", 14, ")"},
+		{"4 of (A, B, C)", 1, "4"},
+		{"CORREO OR Legal", 1, "CORREO"},
+		{"Legal@2026-13-01", 7, "2026-13-01"},
+	}
+	for _, c := range casos {
+		_, err := ParsearPolitica(c.entrada)
+		var errPol *ErrorPolitica
+		if !errors.As(err, &errPol) {
+			t.Fatalf("%q: se esperaba ErrorPolitica, se obtuvo %v", c.entrada, err)
+		}
+		if errPol.Posicion != c.posicion || errPol.Token != c.token {
+			t.Errorf("%q: error en (%d, %q), se esperaba (%d, %q)",
+				c.entrada, errPol.Posicion, errPol.Token, c.posicion, c.token)
+		}
+	}
+}
+
+func TestSatisfacePolitica(t *testing.T) {
+	ahora := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	casos := []struct {
+		politica  string
+		atributos []string
+		esperado  bool
+	}{
+		{"owner:16 OR Marketing", []string{"Marketing"}, true},
+		{"owner:16 OR Marketing", []string{"Legal"}, false},
+		{"Marketing AND Ecuador", []string{"Marketing"}, false},
+		{"(Marketing AND Ecuador) OR Legal", []string{"Ecuador", "Marketing"}, true},
+		{"2 of (Auditoria, Legal, DPO)", []string{"DPO", "Legal"}, true},
+		{"2 of (Auditoria, Legal, DPO)", []string{"DPO"}, false},
+		{"owner:16 OR Marketing@2026-03-10", []string{"Marketing"}, true},
+		{"owner:16 OR Marketing@2026-03-09", []string{"Marketing"}, false},
+	}
+	for _, c := range casos {
+		arbol, err := ParsearPolitica(c.politica)
+		if err != nil {
+			t.Fatalf("%q: %v", c.politica, err)
+		}
+		if got := SatisfacePolitica(arbol, c.atributos, ahora); got != c.esperado {
+			t.Errorf("%q con %v = %v, se esperaba %v", c.politica, c.atributos, got, c.esperado)
+		}
+	}
+}
+
+func TestBloquesDiadicosCubrenRango(t *testing.T) {
+	desde, hasta := int64(20001), int64(20400)
+	cubiertos := map[int64]bool{}
+	for _, b := range bloquesDiadicos(desde, hasta) {
+		inicio := b.indice << b.nivel
+		for d := inicio; d < inicio+(int64(1)<<b.nivel); d++ {
+			if cubiertos[d] {
+				t.Fatalf("día %d cubierto dos veces", d)
+			}
+			cubiertos[d] = true
+		}
+	}
+	if int64(len(cubiertos)) != hasta-desde+1 || !cubiertos[desde] || !cubiertos[hasta] {
+		t.Fatalf("los bloques no cubren exactamente [%d, %d]", desde, hasta)
+	}
+}
+
+func TestCifradoConUmbralYVigencia(t *testing.T) {
+	clavesDePrueba(t)
+
+	ahora := time.Now()
+	expira := ahora.Add(72 * time.Hour).Format("2006-01-02")
+	politica := "owner:7 OR 2 of (Auditoria, Legal, DPO) OR (Marketing@" + expira + " AND Ecuador)"
+	cipher, err := CifrarDatoABE("0998123456", politica)
+	if err != nil {
+		t.Fatalf("error cifrando: %v", err)
+	}
+
+	permitidos := [][]string{{"owner:7"}, {"Legal", "DPO"}, {"Auditoria", "DPO"}, {"Marketing", "Ecuador"}}
+	for _, atributos := range permitidos {
+		texto, err := DescifrarDatoABEConMaster(cipher, atributos)
+		if err != nil || texto != "0998123456" {
+			t.Errorf("%v: se esperaba descifrar, err=%v", atributos, err)
+		}
+	}
+
+	denegados := [][]string{{"owner:8"}, {"Legal"}, {"Marketing"}}
+	for _, atributos := range denegados {
+		if _, err := DescifrarDatoABEConMaster(cipher, atributos); err == nil {
+			t.Errorf("%v: no debería poder descifrar", atributos)
+		}
+	}
+
+	// Una vez pasada la fecha, la clave de época de Marketing ya no coincide
+	vencidos := AtributosVigentes([]string{"Marketing", "Ecuador"}, ahora.Add(30*24*time.Hour))
+	if len(filasAutorizadas(cipher.Msp, vencidos)) != 1 {
+		t.Errorf("tras la expiración sólo debería coincidir Ecuador")
+	}
+}
+
+var clavesOnce sync.Once
+
+// clavesDePrueba genera una sola vez un par de claves maestras en memoria.
+func clavesDePrueba(t *testing.T) {
+	t.Helper()
+	var err error
+	clavesOnce.Do(func() {
+		pubKey, secKey, err = abe.NewFAME().GenerateMasterKeys()
+	})
+	if err != nil {
+		t.Fatalf("error generando claves maestras: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// backend/utils/redaccion.go
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+   Niveles de divulgación
+   ----------------------
+   politica_atributo.nivel_divulgacion decide cuánto de un valor ya descifrado
+   ve el procesador:
+
+     completo      el valor tal cual
+     enmascarado   sólo una parte ("******3456", "j***@correo.com")
+     generalizado  una versión menos precisa (fecha → edad, número → rango)
+     presencia     sólo si el dato existe (true/false)
+
+   Los tipos sin generalización natural (texto, opción) se entregan como
+   presencia cuando se pide "generalizado".
+*/
+
+const (
+	NivelCompleto     = "completo"
+	NivelEnmascarado  = "enmascarado"
+	NivelGeneralizado = "generalizado"
+	NivelPresencia    = "presencia"
+
+	// NivelSeudonimo sólo se registra como nivel aplicado (modo seudonimizado);
+	// no se puede asignar en politica_atributo.
+	NivelSeudonimo = "seudonimo"
+)
+
+// NivelValido indica si el nivel es uno de los admitidos.
+func NivelValido(nivel string) bool {
+	switch nivel {
+	case NivelCompleto, NivelEnmascarado, NivelGeneralizado, NivelPresencia:
+		return true
+	}
+	return false
+}
+
+// AplicarNivel redacta un valor descifrado según el nivel y el tipo del
+// atributo en el catálogo. Devuelve el valor a entregar y el nivel que
+// realmente se aplicó (puede degradarse a presencia).
+func AplicarNivel(nivel, tipo, valor string, ahora time.Time) (interface{}, string) {
+	switch nivel {
+	case NivelCompleto, "":
+		return valor, NivelCompleto
+	case NivelEnmascarado:
+		return enmascarar(tipo, valor), NivelEnmascarado
+	case NivelGeneralizado:
+		if g, ok := generalizar(tipo, valor, ahora); ok {
+			return g, NivelGeneralizado
+		}
+	}
+	return strings.TrimSpace(valor) != "", NivelPresencia
+}
+
+func enmascarar(tipo, valor string) string {
+	switch tipo {
+	case "email":
+		at := strings.LastIndex(valor, "@")
+		if at < 1 {
+			return strings.Repeat("*", len([]rune(valor)))
+		}
+		return string([]rune(valor)[:1]) + "***" + valor[at:]
+	case "telefono":
+		digitos := []rune{}
+		for _, r := range valor {
+			if unicode.IsDigit(r) {
+				digitos = append(digitos, r)
+			}
+		}
+		if len(digitos) <= 4 {
+			return strings.Repeat("*", len(digitos))
+		}
+		return strings.Repeat("*", len(digitos)-4) + string(digitos[len(digitos)-4:])
+	}
+	// Resto de tipos: primera letra y longitud aproximada
+	runas := []rune(valor)
+	if len(runas) <= 2 {
+		return strings.Repeat("*", len(runas))
+	}
+	return string(runas[:1]) + strings.Repeat("*", len(runas)-1)
+}
+
+func generalizar(tipo, valor string, ahora time.Time) (string, bool) {
+	switch tipo {
+	case "fecha":
+		// Edad en años en lugar de la fecha de nacimiento
+		fecha, err := time.Parse("2006-01-02", strings.TrimSpace(valor))
+		if err != nil || edadEn(fecha, ahora) < 0 {
+			return "", false
+		}
+		return strconv.Itoa(edadEn(fecha, ahora)), true
+	case "numero":
+		n, err := strconv.ParseFloat(strings.TrimSpace(valor), 64)
+		if err != nil {
+			return "", false
+		}
+		desde := int(n) / 10 * 10
+		return fmt.Sprintf("%d-%d", desde, desde+9), true
+	case "telefono":
+		// Prefijo (operadora/área) sin el número de abonado
+		digitos := strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, valor)
+		if len(digitos) < 7 {
+			return "", false
+		}
+		return digitos[:3] + strings.Repeat("*", len(digitos)-3), true
+	}
+	return "", false
+}
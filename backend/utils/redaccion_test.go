@@ -0,0 +1,31 @@
+// backend/utils/redaccion_test.go
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAplicarNivel(t *testing.T) {
+	ahora := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	casos := []struct {
+		nivel, tipo, valor string
+		esperado           interface{}
+		aplicado           string
+	}{
+		{NivelCompleto, "texto", "Quito", "Quito", NivelCompleto},
+		{NivelEnmascarado, "telefono", "099 812 3456", "******3456", NivelEnmascarado},
+		{NivelEnmascarado, "email", "juana@correo.ec", "j***@correo.ec", NivelEnmascarado},
+		{NivelGeneralizado, "fecha", "1990-05-20", "35", NivelGeneralizado},
+		{NivelGeneralizado, "numero", "42", "40-49", NivelGeneralizado},
+		{NivelGeneralizado, "texto", "Av. Siempre Viva 742", true, NivelPresencia},
+		{NivelPresencia, "texto", "", false, NivelPresencia},
+	}
+	for _, c := range casos {
+		got, aplicado := AplicarNivel(c.nivel, c.tipo, c.valor, ahora)
+		if got != c.esperado || aplicado != c.aplicado {
+			t.Errorf("%s/%s %q = (%v, %s), se esperaba (%v, %s)",
+				c.nivel, c.tipo, c.valor, got, aplicado, c.esperado, c.aplicado)
+		}
+	}
+}
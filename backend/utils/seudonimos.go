@@ -0,0 +1,95 @@
+// backend/utils/seudonimos.go
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+   Seudonimización
+   ---------------
+   En las políticas con modo_acceso = 'seudonimizado' el procesador no recibe
+   el email ni identificadores en claro, sino seudónimos HMAC estables por
+   procesador: el mismo titular da siempre el mismo seudónimo al mismo
+   procesador y seudónimos distintos a procesadores distintos. La clave vive
+   en seudonimos.key y no se rota (cambiarla rompería la correlación).
+*/
+
+const seudonimoKeyFile = "seudonimos.key"
+
+var claveSeudonimos []byte
+
+// InicializarSeudonimos carga la clave de seudónimos o genera una nueva.
+func InicializarSeudonimos() {
+	if leida, err := cargarArchivoGob(seudonimoKeyFile); err == nil {
+		claveSeudonimos = *leida.(*[]byte)
+		fmt.Println("Clave de seudónimos cargada desde archivo.")
+		return
+	}
+	clave := make([]byte, 32)
+	if _, err := rand.Read(clave); err != nil {
+		log.Fatalf("Error al generar clave de seudónimos: %v", err)
+	}
+	if err := guardarArchivoGob(seudonimoKeyFile, clave); err != nil {
+		log.Fatalf("Error al guardar clave de seudónimos: %v", err)
+	}
+	claveSeudonimos = clave
+	fmt.Println("Clave de seudónimos generada y guardada correctamente.")
+}
+
+// SeudonimoTitular identifica a un titular ante un procesador concreto.
+func SeudonimoTitular(idProcesador, idTitular int) string {
+	return "sd_" + seudonimo("titular", strconv.Itoa(idProcesador), strconv.Itoa(idTitular))
+}
+
+// SeudonimoValor reemplaza un identificador (teléfono, dirección…) de forma
+// que el procesador pueda correlacionar registros sin conocer el valor.
+func SeudonimoValor(idProcesador int, atributo, valor string) string {
+	return seudonimo("valor", strconv.Itoa(idProcesador), atributo, NormalizarValorIndice(valor))
+}
+
+func seudonimo(partes ...string) string {
+	mac := hmac.New(sha256.New, claveSeudonimos)
+	for _, p := range partes {
+		mac.Write([]byte(p))
+		mac.Write([]byte{0})
+	}
+	suma := mac.Sum(nil)[:15]
+	return strings.ToLower(base32.StdEncoding.EncodeToString(suma))
+}
+
+// GeneralizarFecha reduce una fecha AAAA-MM-DD a su año ("1990") o a un
+// rango de edad de diez años ("30-39"). Los valores no reconocidos se ocultan.
+func GeneralizarFecha(valor, modo string, ahora time.Time) string {
+	fecha, err := time.Parse("2006-01-02", strings.TrimSpace(valor))
+	if err != nil {
+		return "no disponible"
+	}
+	if modo != "rango_edad" {
+		return strconv.Itoa(fecha.Year())
+	}
+	edad := edadEn(fecha, ahora)
+	if edad < 0 {
+		return "no disponible"
+	}
+	desde := edad / 10 * 10
+	return fmt.Sprintf("%d-%d", desde, desde+9)
+}
+
+// edadEn devuelve los años cumplidos en la fecha dada.
+func edadEn(nacimiento, ahora time.Time) int {
+	edad := ahora.Year() - nacimiento.Year()
+	if ahora.Month() < nacimiento.Month() ||
+		(ahora.Month() == nacimiento.Month() && ahora.Day() < nacimiento.Day()) {
+		edad--
+	}
+	return edad
+}
@@ -0,0 +1,38 @@
+// backend/utils/seudonimos_test.go
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeudonimosPorProcesador(t *testing.T) {
+	claveSeudonimos = []byte("clave-de-prueba-de-32-bytes-----")
+
+	a := SeudonimoTitular(5, 16)
+	if a != SeudonimoTitular(5, 16) {
+		t.Fatal("el seudónimo no es estable")
+	}
+	if a == SeudonimoTitular(6, 16) || a == SeudonimoTitular(5, 17) {
+		t.Error("seudónimos repetidos entre procesadores o titulares")
+	}
+	if SeudonimoValor(5, "telefono", "099 812 3456") != SeudonimoValor(5, "telefono", "099 812  3456") {
+		t.Error("el seudónimo de valor debería ignorar espacios repetidos")
+	}
+}
+
+func TestGeneralizarFecha(t *testing.T) {
+	ahora := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	casos := []struct{ valor, modo, esperado string }{
+		{"1990-05-20", "anio", "1990"},
+		{"1990-05-20", "rango_edad", "30-39"},
+		{"1996-03-10", "rango_edad", "30-39"},
+		{"1996-03-11", "rango_edad", "20-29"},
+		{"20/05/1990", "anio", "no disponible"},
+	}
+	for _, c := range casos {
+		if got := GeneralizarFecha(c.valor, c.modo, ahora); got != c.esperado {
+			t.Errorf("%s (%s) = %q, se esperaba %q", c.valor, c.modo, got, c.esperado)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// backend/utils/sobre_cifrado.go
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/fentec-project/bn256"
+	"github.com/fentec-project/gofe/abe"
+	"github.com/fentec-project/gofe/data"
+)
+
+/*
+   Sobre de cifrado (formato 1)
+   ----------------------------
+   Todo valor cifrado que se guarda en la BD lleva esta cabecera, de modo que
+   un cambio en las estructuras de gofe no vuelva ilegibles los datos:
+
+     "SGCE"            magia (4 bytes)
+     formato           uint8   (FormatoSobreActual)
+     esquema           uint8   (EsquemaFAME)
+     versión de clave  uint16  (VersionClaveABE)
+     política          uint16 longitud + UTF-8
+     carga             uint32 longitud + bytes
+
+   La carga FAME se codifica campo a campo con los Marshal de bn256, nunca con gob.
+   Los valores antiguos (gob directo de abe.FAMECipher) se siguen leyendo.
+*/
+
+var magiaSobre = []byte("SGCE")
+
+const (
+	FormatoSobreActual uint8 = 1
+	EsquemaFAME        uint8 = 1
+)
+
+// VersionClaveABE identifica el par de claves maestras con el que se cifra.
+// Se guarda en abe_version.key junto a abe_public.key / abe_secret.key e
+// InicializarABE la incrementa cada vez que regenera el par.
+var VersionClaveABE uint16 = 1
+
+// ErrVersionClave indica que el valor se cifró con un par de claves maestras
+// distinto del cargado (p. ej. tras una rotación sin volver a cifrar).
+var ErrVersionClave = errors.New("versión de clave no disponible")
+
+// SobreCifrado es la vista decodificada de un valor cifrado.
+type SobreCifrado struct {
+	Formato      uint8
+	Esquema      uint8
+	VersionClave uint16
+	Politica     string
+	Cipher       *abe.FAMECipher
+	Legado       bool // true si venía en gob sin cabecera
+}
+
+// SerializarCipher envuelve el cifrado junto con la política usada.
+func SerializarCipher(cipher *abe.FAMECipher, politica string) ([]byte, error) {
+	if len(politica) > 0xFFFF {
+		return nil, fmt.Errorf("política demasiado larga para el sobre (%d bytes)", len(politica))
+	}
+	carga, err := codificarFAME(cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(magiaSobre)
+	buf.WriteByte(FormatoSobreActual)
+	buf.WriteByte(EsquemaFAME)
+	binary.Write(&buf, binary.BigEndian, VersionClaveABE)
+	binary.Write(&buf, binary.BigEndian, uint16(len(politica)))
+	buf.WriteString(politica)
+	binary.Write(&buf, binary.BigEndian, uint32(len(carga)))
+	buf.Write(carga)
+	return buf.Bytes(), nil
+}
+
+// DeserializarCipher acepta tanto sobres como valores gob antiguos.
+func DeserializarCipher(data []byte) (*abe.FAMECipher, error) {
+	sobre, err := AbrirSobre(data)
+	if err != nil {
+		return nil, err
+	}
+	return sobre.Cipher, nil
+}
+
+// EsSobre indica si el valor ya tiene la cabecera del sobre.
+func EsSobre(data []byte) bool {
+	return bytes.HasPrefix(data, magiaSobre)
+}
+
+// AbrirSobre decodifica un valor cifrado guardado en la BD.
+func AbrirSobre(data []byte) (*SobreCifrado, error) {
+	if !EsSobre(data) {
+		cipher, err := deserializarGobLegado(data)
+		if err != nil {
+			return nil, fmt.Errorf("valor cifrado no reconocido: %w", err)
+		}
+		return &SobreCifrado{Esquema: EsquemaFAME, Cipher: cipher, Legado: true, Politica: politicaLegado(cipher)}, nil
+	}
+
+	r := bytes.NewReader(data[len(magiaSobre):])
+	var s SobreCifrado
+	var lenPol uint16
+	var lenCarga uint32
+	if err := leerBinario(r, &s.Formato, &s.Esquema, &s.VersionClave, &lenPol); err != nil {
+		return nil, fmt.Errorf("cabecera de sobre incompleta: %w", err)
+	}
+	if s.Formato != FormatoSobreActual {
+		return nil, fmt.Errorf("formato de sobre %d no soportado", s.Formato)
+	}
+	if s.Esquema != EsquemaFAME {
+		return nil, fmt.Errorf("esquema %d no soportado", s.Esquema)
+	}
+	if s.VersionClave != VersionClaveABE {
+		return nil, fmt.Errorf("%w: el sobre usa la versión %d y la actual es %d", ErrVersionClave, s.VersionClave, VersionClaveABE)
+	}
+	pol := make([]byte, lenPol)
+	if _, err := io.ReadFull(r, pol); err != nil {
+		return nil, fmt.Errorf("política del sobre incompleta: %w", err)
+	}
+	s.Politica = string(pol)
+	if err := binary.Read(r, binary.BigEndian, &lenCarga); err != nil {
+		return nil, fmt.Errorf("longitud de carga incompleta: %w", err)
+	}
+	if int64(lenCarga) != int64(r.Len()) {
+		return nil, fmt.Errorf("carga del sobre con longitud inconsistente")
+	}
+	carga := make([]byte, lenCarga)
+	io.ReadFull(r, carga)
+
+	cipher, err := decodificarFAME(carga)
+	if err != nil {
+		return nil, fmt.Errorf("carga FAME inválida: %w", err)
+	}
+	s.Cipher = cipher
+	return &s, nil
+}
+
+func deserializarGobLegado(data []byte) (*abe.FAMECipher, error) {
+	var cipher abe.FAMECipher
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cipher); err != nil {
+		return nil, err
+	}
+	return &cipher, nil
+}
+
+// politicaLegado reconstruye la política de un cifrado antiguo. Antes sólo se
+// cifraba con listas OR planas, cuyo MSP tiene una única columna; para
+// cualquier otra forma la política no es recuperable y se devuelve "".
+func politicaLegado(cipher *abe.FAMECipher) string {
+	if cipher.Msp == nil || len(cipher.Msp.RowToAttrib) == 0 {
+		return ""
+	}
+	hijos := make([]NodoPolitica, 0, len(cipher.Msp.RowToAttrib))
+	for _, fila := range cipher.Msp.Mat {
+		if len(fila) != 1 {
+			return ""
+		}
+	}
+	for _, a := range cipher.Msp.RowToAttrib {
+		hijos = append(hijos, Atributo{Nombre: a})
+	}
+	if len(hijos) == 1 {
+		return hijos[0].String()
+	}
+	return Compuerta{Operador: "OR", Hijos: hijos}.String()
+}
+
+// --------------------------
+// Carga FAME
+// --------------------------
+
+func codificarFAME(c *abe.FAMECipher) ([]byte, error) {
+	if c == nil || c.Msp == nil {
+		return nil, errors.New("cifrado FAME incompleto")
+	}
+	var buf bytes.Buffer
+	for _, g := range c.Ct0 {
+		escribirBloque(&buf, g.Marshal())
+	}
+	escribirEntero(&buf, len(c.Ct))
+	for _, fila := range c.Ct {
+		for _, g := range fila {
+			escribirBloque(&buf, g.Marshal())
+		}
+	}
+	escribirBloque(&buf, c.CtPrime.Marshal())
+
+	escribirGrande(&buf, c.Msp.P)
+	escribirEntero(&buf, len(c.Msp.Mat))
+	for _, fila := range c.Msp.Mat {
+		escribirEntero(&buf, len(fila))
+		for _, v := range fila {
+			escribirGrande(&buf, v)
+		}
+	}
+	escribirEntero(&buf, len(c.Msp.RowToAttrib))
+	for _, a := range c.Msp.RowToAttrib {
+		escribirBloque(&buf, []byte(a))
+	}
+	escribirBloque(&buf, c.SymEnc)
+	escribirBloque(&buf, c.Iv)
+	return buf.Bytes(), nil
+}
+
+func decodificarFAME(carga []byte) (*abe.FAMECipher, error) {
+	r := bytes.NewReader(carga)
+	c := &abe.FAMECipher{Msp: &abe.MSP{}}
+
+	for i := range c.Ct0 {
+		c.Ct0[i] = new(bn256.G2)
+		if err := leerPunto(r, c.Ct0[i].Unmarshal); err != nil {
+			return nil, err
+		}
+	}
+	n, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	c.Ct = make([][3]*bn256.G1, n)
+	for i := range c.Ct {
+		for j := range c.Ct[i] {
+			c.Ct[i][j] = new(bn256.G1)
+			if err := leerPunto(r, c.Ct[i][j].Unmarshal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.CtPrime = new(bn256.GT)
+	if err := leerPunto(r, c.CtPrime.Unmarshal); err != nil {
+		return nil, err
+	}
+
+	if c.Msp.P, err = leerGrande(r); err != nil {
+		return nil, err
+	}
+	filas, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	c.Msp.Mat = make(data.Matrix, filas)
+	for i := range c.Msp.Mat {
+		cols, err := leerEntero(r)
+		if err != nil {
+			return nil, err
+		}
+		c.Msp.Mat[i] = make(data.Vector, cols)
+		for j := range c.Msp.Mat[i] {
+			if c.Msp.Mat[i][j], err = leerGrande(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	nAttr, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	c.Msp.RowToAttrib = make([]string, nAttr)
+	for i := range c.Msp.RowToAttrib {
+		b, err := leerBloque(r)
+		if err != nil {
+			return nil, err
+		}
+		c.Msp.RowToAttrib[i] = string(b)
+	}
+	if c.SymEnc, err = leerBloque(r); err != nil {
+		return nil, err
+	}
+	if c.Iv, err = leerBloque(r); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("bytes sobrantes en la carga")
+	}
+	return c, nil
+}
+
+func escribirEntero(buf *bytes.Buffer, n int) {
+	binary.Write(buf, binary.BigEndian, uint32(n))
+}
+
+func escribirBloque(buf *bytes.Buffer, b []byte) {
+	escribirEntero(buf, len(b))
+	buf.Write(b)
+}
+
+// escribirGrande guarda el signo en un byte (0 = nil, 1 = positivo, 2 = negativo).
+func escribirGrande(buf *bytes.Buffer, v *big.Int) {
+	switch {
+	case v == nil:
+		buf.WriteByte(0)
+		return
+	case v.Sign() < 0:
+		buf.WriteByte(2)
+	default:
+		buf.WriteByte(1)
+	}
+	escribirBloque(buf, v.Bytes())
+}
+
+func leerBinario(r io.Reader, destinos ...interface{}) error {
+	for _, d := range destinos {
+		if err := binary.Read(r, binary.BigEndian, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func leerEntero(r *bytes.Reader) (int, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return 0, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return 0, fmt.Errorf("longitud %d fuera de rango", n)
+	}
+	return int(n), nil
+}
+
+func leerBloque(r *bytes.Reader) ([]byte, error) {
+	n, err := leerEntero(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func leerPunto(r *bytes.Reader, unmarshal func([]byte) ([]byte, error)) error {
+	b, err := leerBloque(r)
+	if err != nil {
+		return err
+	}
+	_, err = unmarshal(b)
+	return err
+}
+
+func leerGrande(r *bytes.Reader) (*big.Int, error) {
+	signo, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if signo == 0 {
+		return nil, nil
+	}
+	b, err := leerBloque(r)
+	if err != nil {
+		return nil, err
+	}
+	v := new(big.Int).SetBytes(b)
+	if signo == 2 {
+		v.Neg(v)
+	}
+	return v, nil
+}
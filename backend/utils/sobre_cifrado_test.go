@@ -0,0 +1,74 @@
+// backend/utils/sobre_cifrado_test.go
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"testing"
+)
+
+func TestSobreIdaYVuelta(t *testing.T) {
+	clavesDePrueba(t)
+	politica := "owner:5 OR (Marketing AND Ecuador)"
+	cipher, err := CifrarDatoABE("Quito", politica)
+	if err != nil {
+		t.Fatalf("error cifrando: %v", err)
+	}
+
+	datos, err := SerializarCipher(cipher, politica)
+	if err != nil {
+		t.Fatalf("error serializando: %v", err)
+	}
+	if !EsSobre(datos) {
+		t.Fatalf("el valor serializado no tiene cabecera de sobre")
+	}
+
+	sobre, err := AbrirSobre(datos)
+	if err != nil {
+		t.Fatalf("error abriendo sobre: %v", err)
+	}
+	if sobre.Legado || sobre.Politica != politica || sobre.VersionClave != VersionClaveABE {
+		t.Errorf("metadatos inesperados: %+v", sobre)
+	}
+	texto, err := DescifrarDatoABEConMaster(sobre.Cipher, []string{"Marketing", "Ecuador"})
+	if err != nil || texto != "Quito" {
+		t.Errorf("no se pudo descifrar tras la ida y vuelta: %q, %v", texto, err)
+	}
+
+	if _, err := AbrirSobre(datos[:len(datos)-3]); err == nil {
+		t.Errorf("un sobre truncado debería fallar")
+	}
+
+	// Tras rotar las claves maestras el sobre antiguo se rechaza con un error explícito
+	anterior := VersionClaveABE
+	VersionClaveABE++
+	defer func() { VersionClaveABE = anterior }()
+	if _, err := AbrirSobre(datos); !errors.Is(err, ErrVersionClave) {
+		t.Errorf("se esperaba ErrVersionClave, se obtuvo %v", err)
+	}
+}
+
+func TestSobreLeeGobLegado(t *testing.T) {
+	clavesDePrueba(t)
+	cipher, err := CifrarDatoABE("Pichincha", "owner:9 OR Investigación")
+	if err != nil {
+		t.Fatalf("error cifrando: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cipher); err != nil {
+		t.Fatalf("error codificando gob: %v", err)
+	}
+
+	sobre, err := AbrirSobre(buf.Bytes())
+	if err != nil {
+		t.Fatalf("no se pudo leer el valor gob: %v", err)
+	}
+	if !sobre.Legado || sobre.Politica != "owner:9 OR Investigación" {
+		t.Errorf("metadatos legado inesperados: %+v", sobre)
+	}
+	texto, err := DescifrarDatoABEConMaster(sobre.Cipher, []string{"owner:9"})
+	if err != nil || texto != "Pichincha" {
+		t.Errorf("no se pudo descifrar el valor legado: %q, %v", texto, err)
+	}
+}